@@ -106,7 +106,9 @@ func pkiWalkers(writer *zip.Writer, configDir string) {
 //Main internal function to perform encryption, compression and archive
 //takes in a destination location as an argument.
 func encryptedArchiver(destination string) {
-	openssl.SetBackupRestorePassword()
+	if err := openssl.SetBackupRestorePassword(); err != nil {
+		log.Fatal(err)
+	}
 	baseConfigDir := openssl.GetPkiConfigDir() + "/"
 
 	// Create a new encrypted config archive
@@ -120,7 +122,11 @@ func encryptedArchiver(destination string) {
 	configWalkers(configArchive, baseConfigDir)
 
 	// Create a new encrypted pki archive
-	basePkiDir := openssl.GetPkiPath() + "/"
+	pkiPath, err := openssl.GetPkiPath()
+	if err != nil {
+		log.Fatal(err)
+	}
+	basePkiDir := pkiPath + "/"
 	pkiOutputFile, err := os.Create(destination + "sfcert_pki.dat")
 	if err != nil {
 		log.Fatal(err)
@@ -0,0 +1,186 @@
+package cmd
+
+import (
+	log "github.com/sirupsen/logrus"
+	"github.com/spf13/cobra"
+	"sfcert/openssl"
+	"sfcert/pkg/ca"
+)
+
+// ceremonyCmd groups the offline Root CA ceremony subcommands
+var ceremonyCmd = &cobra.Command{
+	Use:   "ceremony",
+	Short: "ceremony subcommand is used to create A1s with a Root CA (A0) kept offline",
+	Long: `You can use ceremony subcommand when the Root CA (A0) lives on an
+air-gapped host. The A1 key is generated on the online host, only
+self describing bundles travel between the hosts, for example on a USB drive.
+
+online-host> privki ceremony request --org="XYZ Department" --name-restrict="chat.alpha.com" --out=/media/usbdrive/xyz.request.json
+offline-host> privki ceremony sign --request=/media/usbdrive/xyz.request.json --out=/media/usbdrive/xyz.response.json
+online-host> privki ceremony import --response=/media/usbdrive/xyz.response.json --trust=./alpha-trust.pem
+
+you can find more help, by using the --help flag after there subcommands.
+example> privki ceremony request --help
+`,
+	Run: func(cmd *cobra.Command, args []string) {
+		cmd.Help()
+	},
+}
+
+// ceremonyRequestCmd represents the ceremony request command
+var ceremonyRequestCmd = &cobra.Command{
+	Use:   "request",
+	Short: "Generates an A1 key on this online host and a request bundle for the offline Root CA (A0)",
+	Long: `
+Use request subcommand on the online host to generate the key of a new A1
+and a request bundle signed with it. The key stays pending on this host
+until the response bundle of the offline Root CA is imported.
+
+example> privki ceremony request --org="XYZ Department" --name-restrict="chat.alpha.com" \
+			--passphrase="myNewSecretPassword" --out=/media/usbdrive/xyz.request.json
+
+The class OID of the vault on this host is used. A host without a vault
+uses the default OID, pass --oid when the Root CA was created with a custom one
+
+example> privki ceremony request --org="XYZ Department" --oid="1.9.6.1.4.4.7.8.5" --out=./xyz.request.json
+`,
+	Annotations: mutatesVault,
+	Run: func(cmd *cobra.Command, args []string) {
+		orgName, _ := cmd.Flags().GetString("org")
+		nameRestriction, _ := cmd.Flags().GetString("name-restrict")
+		oid, _ := cmd.Flags().GetString("oid")
+		passphrase, _ := cmd.Flags().GetString("passphrase")
+		out, _ := cmd.Flags().GetString("out")
+		if orgName == "NA" || out == "NA" {
+			log.Fatal("arguments --org and --out are required")
+		}
+		if nameRestriction == "NA" {
+			nameRestriction = ""
+		}
+		if oid == "NA" {
+			oid = ""
+		}
+
+		passphrase = promptPassphrase(passphrase, "\n\tEnter a new passphrase for this Intermediary CA (A1): ")
+		request, err := ca.RequestIntermediate(commandContext(cmd), ca.CeremonyRequestOptions{
+			Organization:    orgName,
+			NameRestriction: nameRestriction,
+			OID:             oid,
+			Passphrase:      passphrase,
+			OutFile:         out,
+		})
+		if err != nil {
+			log.Fatal(err)
+		}
+		log.Printf("Request for A1 %v written to %v, have it signed on the offline Root CA host with privki ceremony sign", request.Intermediate, out)
+	},
+}
+
+// ceremonySignCmd represents the ceremony sign command
+var ceremonySignCmd = &cobra.Command{
+	Use:   "sign",
+	Short: "Signs an A1 request bundle on the offline Root CA (A0) host",
+	Long: `
+Use sign subcommand on the offline Root CA host to verify a request bundle,
+sign the A1 with the Root CA (A0), cross sign it with the DR Root CA when DR
+is enabled, and write the response bundle to carry back to the online host.
+--dr-passphrase unlocks a DR Root CA key that has a passphrase of its own.
+
+example> privki ceremony sign --request=/media/usbdrive/xyz.request.json --out=/media/usbdrive/xyz.response.json
+`,
+	Annotations: mutatesVault,
+	Run: func(cmd *cobra.Command, args []string) {
+		requestFile, _ := cmd.Flags().GetString("request")
+		rootPassphrase, _ := cmd.Flags().GetString("root-passphrase")
+		drRootPassphrase, _ := cmd.Flags().GetString("dr-passphrase")
+		out, _ := cmd.Flags().GetString("out")
+		if requestFile == "NA" || out == "NA" {
+			log.Fatal("arguments --request and --out are required")
+		}
+		request, err := openssl.ReadCeremonyRequest(requestFile)
+		if err != nil {
+			log.Fatal(err)
+		}
+		nameRestriction := request.NameRestriction
+		if nameRestriction == "" {
+			nameRestriction = "none"
+		}
+		log.Printf("Signing A1 %v for %q, name restriction %v, requested %v", request.Intermediate, request.Organization, nameRestriction, request.Created)
+
+		vault := openRootVault()
+		rootPassphrase = promptPassphrase(rootPassphrase, "\n\tRoot CA (A0) Passphrase: ")
+		if _, err := vault.SignRequest(commandContext(cmd), ca.CeremonySignOptions{
+			RequestFile:    requestFile,
+			RootPassphrase: rootPassphrase,
+			DRPassphrase:   drPassphrase(vault, drRootPassphrase),
+			OutFile:        out,
+		}); err != nil {
+			log.Fatal(err)
+		}
+		log.Printf("Response for A1 %v written to %v, import it on the online host with privki ceremony import", request.Intermediate, out)
+	},
+}
+
+// ceremonyImportCmd represents the ceremony import command
+var ceremonyImportCmd = &cobra.Command{
+	Use:   "import",
+	Short: "Imports the signed A1 certificates of a response bundle on the online host",
+	Long: `
+Use import subcommand on the online host to verify a response bundle against
+the Root CA certificates given with --trust, and complete the pending A1 with
+its certificates and chain bundles. A host without a vault gets a subordinate
+vault for the Root CA of the response.
+
+example> privki ceremony import --response=/media/usbdrive/xyz.response.json --trust=./alpha-trust.pem
+`,
+	Annotations: mutatesVault,
+	Run: func(cmd *cobra.Command, args []string) {
+		responseFile, _ := cmd.Flags().GetString("response")
+		trust, _ := cmd.Flags().GetString("trust")
+		if responseFile == "NA" || trust == "NA" {
+			log.Fatal("arguments --response and --trust are required")
+		}
+		trusted, err := openssl.ReadCertificates(trust)
+		if err != nil {
+			log.Fatal(err)
+		}
+		intermediate, err := ca.ImportResponse(commandContext(cmd), ca.CeremonyImportOptions{
+			ResponseFile: responseFile,
+			Trusted:      trusted,
+		})
+		if err != nil {
+			log.Fatal(err)
+		}
+		log.Printf("Intermediary CA (A1) %v imported, use privki issue --a1=%v to issue certificates from it", intermediate.ID, intermediate.ID)
+	},
+}
+
+func init() {
+	var orgName string
+	var nameRestriction string
+	var oid string
+	var passphrase string
+	var requestOut string
+	var requestFile string
+	var rootPassphrase string
+	var drRootPassphrase string
+	var responseOut string
+	var responseFile string
+	var trust string
+
+	rootCmd.AddCommand(ceremonyCmd)
+	ceremonyCmd.AddCommand(ceremonyRequestCmd)
+	ceremonyCmd.AddCommand(ceremonySignCmd)
+	ceremonyCmd.AddCommand(ceremonyImportCmd)
+	ceremonyRequestCmd.Flags().StringVar(&orgName, "org", "NA", "flag --org=<name> sets the organization or project of the A1")
+	ceremonyRequestCmd.Flags().StringVar(&nameRestriction, "name-restrict", "NA", "flag --name-restrict=<domain> limits the A1 to a DNS domain")
+	ceremonyRequestCmd.Flags().StringVar(&oid, "oid", "NA", "flag --oid=<oid> sets the class OID when this host has no vault")
+	ceremonyRequestCmd.Flags().StringVar(&passphrase, "passphrase", "NA", "flag --passphrase=<secret> protects the new A1 key")
+	ceremonyRequestCmd.Flags().StringVar(&requestOut, "out", "NA", "flag --out=<file> sets the request bundle file")
+	ceremonySignCmd.Flags().StringVar(&requestFile, "request", "NA", "flag --request=<file> sets the request bundle to sign")
+	ceremonySignCmd.Flags().StringVar(&rootPassphrase, "root-passphrase", "NA", "flag --root-passphrase=<secret> unlocks the Root CA (A0) key")
+	ceremonySignCmd.Flags().StringVar(&drRootPassphrase, "dr-passphrase", "NA", "flag --dr-passphrase=<secret> unlocks the DR Root CA (DR A0) key when it differs from the A0 one")
+	ceremonySignCmd.Flags().StringVar(&responseOut, "out", "NA", "flag --out=<file> sets the response bundle file")
+	ceremonyImportCmd.Flags().StringVar(&responseFile, "response", "NA", "flag --response=<file> sets the response bundle to import")
+	ceremonyImportCmd.Flags().StringVar(&trust, "trust", "NA", "flag --trust=<file> sets the PEM Root CA certificates the response must be signed by")
+}
@@ -1,9 +1,11 @@
 package cmd
 
 import (
+	"fmt"
 	log "github.com/sirupsen/logrus"
 	"github.com/spf13/cobra"
 	"sfcert/openssl"
+	"sfcert/pkg/ca"
 )
 
 // rootCertCmd represents the rootCert command
@@ -41,35 +43,42 @@ and related configuration before you can establish Certificate
 Authority. Hence, If you have not done so, please run init_pki
 before running any of the create_cert subcommands.
 `,
+	Annotations: mutatesVault,
 	Run: func(cmd *cobra.Command, args []string) {
 
 		customOID, _ := cmd.Flags().GetString("custom-oid")
-		openssl.SetCustomOid(customOID)
 		organizationName, _ := cmd.Flags().GetString("org")
 		if organizationName == "NA" {
 			log.Printf("\nmissing organization name from the arguments")
 			log.Fatal("argument --org is required")
 		}
-		openssl.SetOrganizationName(organizationName)
 		organizationCommonName, _ := cmd.Flags().GetString("common-name")
 		if organizationCommonName == "NA" {
 			log.Printf("\nmissing common name from the arguments")
 			log.Fatal("argument --common-name is required")
 		}
-		openssl.SetOrganizationCommonName(organizationCommonName)
-		passphrase, _ := cmd.Flags().GetString("passphrase")
 
 		rootDrStatus, _ := cmd.Flags().GetString("with-dr")
-		if rootDrStatus == "true" {
-			openssl.CreateRootCA(passphrase)
-			openssl.CreateDRRootCA()
-
-		} else if rootDrStatus == "false" {
-			openssl.CreateRootCA(passphrase)
-		} else {
+		if rootDrStatus != "true" && rootDrStatus != "false" {
 			log.Printf("\nUnrecognized value %v for flag --with-dr. can only be <true/false>", rootDrStatus)
 			log.Fatal("Unrecognized value for --with-dr")
 		}
+
+		vault := openRootVault()
+		passphrase, _ := cmd.Flags().GetString("passphrase")
+		passphrase = promptPassphrase(passphrase, "\n\tEnter passphrase for A0 : ")
+		fmt.Printf("\n\n\t*************************************\n\tIMPORTANT: Please remember and note this Passphrase somewhere safe. \n\tYou will loose access to  your vault without this passphrase.\n\t*************************************\n")
+
+		err := vault.CreateRoot(commandContext(cmd), ca.RootOptions{
+			Organization: organizationName,
+			CommonName:   organizationCommonName,
+			CustomOID:    customOID,
+			Passphrase:   passphrase,
+			WithDR:       rootDrStatus == "true",
+		})
+		if err != nil {
+			log.Fatal(err)
+		}
 	},
 }
 
@@ -84,7 +93,7 @@ func init() {
 	createCertCmd.AddCommand(rootCertCmd)
 	// Add and Process flags to check if DR certs are needed
 	rootCertCmd.Flags().StringVar(&withDR, "with-dr", "false", "setting this option to true enables Root DR")
-	rootCertCmd.Flags().StringVar(&customOID, "custom-oid", "1.3.6.1.5.5.7.8.5", "flag --custom-oid=<your_chosen_oid> sets your custom oid for Class Definition")
+	rootCertCmd.Flags().StringVar(&customOID, "custom-oid", openssl.DefaultOID, "flag --custom-oid=<your_chosen_oid> sets your custom oid for Class Definition")
 	rootCertCmd.Flags().StringVar(&organizationName, "org", "NA", "flag --org=<organization legal name> sets your organization")
 	rootCertCmd.Flags().StringVar(&organizationCommonName, "common-name", "NA", "flag --common-name=<organization common name> sets your organization's common/functional name")
 	rootCertCmd.Flags().StringVar(&passphrase, "passphrase", "NA", "flag --passphrase=<my_secret_passphrase> sets passphrase for your Root CA and Root CA DR Certificates")
@@ -1,8 +1,11 @@
 package cmd
 
 import (
+	log "github.com/sirupsen/logrus"
 	"github.com/spf13/cobra"
+	"io/ioutil"
 	"sfcert/openssl"
+	"sfcert/pkg/ca"
 )
 
 // intermediaryCertCmd represents the intermediaryCert command
@@ -29,22 +32,104 @@ non interactive execution.
 
 example> privki create A1 --org="XYZ Department" --name-restrict="chat.alpha.com" --root-passphrase="mySecretRootPassword" --passphrase="myNewSecretPassword"
 
+When the DR Root CA (DR A0) has a passphrase of its own, see privki passphrase
+rotate, --dr-passphrase gives it for the DR cross signature.
+
 Please note that, there should be an existing PKI repository 
 and related configuration along with an established Root CA
 before you can establish an Intermediate Certificate Authority. 
 Hence, If you have not done so, please run init
 and create A0 subcommands to establish these pre-requisite
 
+The A1 repository is also saved as an AES-256 encrypted zip archive under
+output/, protected by the A1 passphrase unless --archive-passphrase is given.
+Chain bundles only contain certificates, to hand over the A1 key on its
+own use privki export --format=pkcs8.
+
+An A1 may sign one level of issuing CAs (A2) below it, see privki create A2.
+Use --pathlen=0 for an A1 that only issues end entity certificates.
+
+example> privki create A1 --org="XYZ Department" --pathlen=0
+
+An A1 is valid for 18 years with an RSA 3072 key unless --days and --key-algo
+say otherwise, key algorithms are rsa:2048, rsa:3072, rsa:4096, ec:P-256,
+ec:P-384 and ec:P-521.
+
+example> privki create A1 --org="XYZ Department" --days=3650 --key-algo="ec:P-384"
+
+Partner teams that keep their A1 key in their own HSM can send a CSR
+instead, use --csr to sign it with the Root CA (A0), and the DR Root CA when
+DR is enabled. The A1 gets our extensions, name restrictions and class OID,
+the CSR subject needs an organization (O) and a common name (CN). Its key is
+never generated nor stored by privki, so issue, revoke and export of the key
+are refused for it. Hand the chain back to the partner with privki export.
+
+example> privki create A1 --csr=./partner-a1.req.pem --name-restrict="partner.alpha.com"
+example> privki export --a1=<A1 ID> --format=pem --out=./partner-a1.chain.pem
+
 run --help for those respective subcommands for more information on
 how to use them
 `,
+	Annotations: mutatesVault,
 	Run: func(cmd *cobra.Command, args []string) {
 
 		nameRestriction, _ := cmd.Flags().GetString("name-restrict")
 		orgName, _ := cmd.Flags().GetString("org")
 		rootPassphrase, _ := cmd.Flags().GetString("root-passphrase")
+		drRootPassphrase, _ := cmd.Flags().GetString("dr-passphrase")
 		passphrase, _ := cmd.Flags().GetString("passphrase")
-		openssl.CreateIntermediateCA(nameRestriction, orgName, passphrase, rootPassphrase)
+		archivePassphrase, _ := cmd.Flags().GetString("archive-passphrase")
+		csrFile, _ := cmd.Flags().GetString("csr")
+		pathLen, _ := cmd.Flags().GetInt("pathlen")
+		days, _ := cmd.Flags().GetInt("days")
+		keyAlgorithm, _ := cmd.Flags().GetString("key-algo")
+		if keyAlgorithm == "NA" {
+			keyAlgorithm = ""
+		}
+		if nameRestriction == "NA" {
+			nameRestriction = ""
+		}
+		if csrFile != "NA" {
+			csr, err := ioutil.ReadFile(csrFile)
+			if err != nil {
+				log.Fatal(err)
+			}
+			vault := openRootVault()
+			rootPassphrase = promptPassphrase(rootPassphrase, "\n\tRoot CA (A0) Passphrase: ")
+			intermediate, err := vault.CreateIntermediateFromCSR(commandContext(cmd), csr, nameRestriction, pathLen, rootPassphrase, drPassphrase(vault, drRootPassphrase))
+			if err != nil {
+				log.Fatal(err)
+			}
+			log.Printf("Intermediary CA (A1) %v signed for %v, use privki export --a1=%v --format=pem to hand its chain back", intermediate.ID, intermediate.Subject, intermediate.ID)
+			return
+		}
+		if orgName == "NA" || orgName == "" {
+			log.Warnf("\nPlease specify an organization or a project name for this Intermediate Certifying Authority")
+			log.Fatalf("\nProgram Exit, try again with suggested corrections\n")
+		}
+
+		vault := openRootVault()
+		rootPassphrase = promptPassphrase(rootPassphrase, "\n\tRoot CA (A0) Passphrase: ")
+		drRootPassphrase = drPassphrase(vault, drRootPassphrase)
+		passphrase = promptPassphrase(passphrase, "\n\tEnter a new passphrase for this Intermediary CA (A1) \n\tPlease make sure this is different from Root CA (A0):  ")
+		if archivePassphrase == "NA" {
+			archivePassphrase = ""
+		}
+		intermediate, err := vault.CreateIntermediate(commandContext(cmd), ca.IntermediateOptions{
+			Organization:      orgName,
+			NameRestriction:   nameRestriction,
+			Passphrase:        passphrase,
+			RootPassphrase:    rootPassphrase,
+			DRPassphrase:      drRootPassphrase,
+			ArchivePassphrase: archivePassphrase,
+			PathLen:           &pathLen,
+			Days:              days,
+			KeyAlgorithm:      keyAlgorithm,
+		})
+		if err != nil {
+			log.Fatal(err)
+		}
+		log.Printf("Intermediary CA (A1) %v created, use privki issue --a1=%v to issue certificates from it", intermediate.ID, intermediate.ID)
 	},
 }
 
@@ -54,10 +139,22 @@ func init() {
 	var org string
 	var a1Passphrase string
 	var rootPassphrase string
+	var drRootPassphrase string
+	var archivePassphrase string
+	var csrFile string
+	var pathLen int
+	var days int
+	var keyAlgorithm string
 
 	createCertCmd.AddCommand(intermediaryCertCmd)
 	intermediaryCertCmd.Flags().StringVar(&name_restrict, "name-restrict", "NA", "set --name-restrict=<DomainName> to restrict issuance to DomainName")
 	intermediaryCertCmd.Flags().StringVar(&org, "org", "NA", "set --org=<organization/project name>")
 	intermediaryCertCmd.Flags().StringVar(&a1Passphrase, "passphrase", "NA", "flag --passphrase=<my_secret_passphrase> sets passphrase for your Intermediary CA Certificates")
 	intermediaryCertCmd.Flags().StringVar(&rootPassphrase, "root-passphrase", "NA", "use --root-passphrase=<A0_secret_passphrase> to provide your A0 passphrase")
+	intermediaryCertCmd.Flags().StringVar(&drRootPassphrase, "dr-passphrase", "NA", "use --dr-passphrase=<DR_A0_secret_passphrase> to provide the DR A0 passphrase when it differs from the A0 one")
+	intermediaryCertCmd.Flags().StringVar(&archivePassphrase, "archive-passphrase", "NA", "use --archive-passphrase=<secret> to encrypt the A1 archive with a passphrase other than the A1 one")
+	intermediaryCertCmd.Flags().StringVar(&csrFile, "csr", "NA", "use --csr=<file> to sign a partner's PEM A1 request, its key is not stored in the vault")
+	intermediaryCertCmd.Flags().IntVar(&pathLen, "pathlen", openssl.DefaultA1PathLen, "use --pathlen=<n> to set how many levels of issuing CAs (A2) may be created below the A1")
+	intermediaryCertCmd.Flags().IntVar(&days, "days", 0, "use --days=<n> to set the validity of the A1, 18 years by default")
+	intermediaryCertCmd.Flags().StringVar(&keyAlgorithm, "key-algo", "NA", "use --key-algo=<rsa:bits|ec:curve> to set the A1 key algorithm, rsa:3072 by default")
 }
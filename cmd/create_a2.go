@@ -0,0 +1,90 @@
+package cmd
+
+import (
+	log "github.com/sirupsen/logrus"
+	"github.com/spf13/cobra"
+	"sfcert/pkg/ca"
+	"strings"
+)
+
+// issuingCertCmd represents the create A2 command
+var issuingCertCmd = &cobra.Command{
+	Use:   "A2",
+	Short: "Creates Issuing CAs (A2) signed by an Intermediary CA (A1)",
+	Long: `
+Use A2 subcommand to create an issuing CA below one of your A1s, for example
+one per environment of a business unit. The A2 is signed with the A1 key, so
+the A1 passphrase is needed, and it has a passphrase of its own.
+
+example> privki create A2 --parent=20200722174505Z --org="XYZ Production"
+
+The name restrictions of an A2 must be within the ones of its A1, an A2 created
+without --name-restrict inherits them. Several domains are separated by commas.
+
+example> privki create A2 --parent=20200722174505Z --org="XYZ Staging" --name-restrict="staging.chat.alpha.com"
+
+By default an A2 only issues end entity certificates, --pathlen raises its
+pathLenConstraint, which must stay below the one of the A1 (see privki create A1 --pathlen).
+
+example> privki create A2 --parent=20200722174505Z --org="XYZ Production" \
+			--parent-passphrase="myA1SecretPassword" --passphrase="myNewSecretPassword"
+
+A2s are listed along the A1s by privki list, with level 2 and their parent.
+Use their ID with --a1 to issue, sign, revoke and export, the chain bundles
+include the A2, its A1 and the Root CA, or the DR Root CA when the A1 is cross signed.
+
+example> privki issue --a1=<A2 ID> --common-name="db01.staging.chat.alpha.com" --dns="db01.staging.chat.alpha.com"
+`,
+	Annotations: mutatesVault,
+	Run: func(cmd *cobra.Command, args []string) {
+		parent, _ := cmd.Flags().GetString("parent")
+		orgName, _ := cmd.Flags().GetString("org")
+		nameRestriction, _ := cmd.Flags().GetString("name-restrict")
+		pathLen, _ := cmd.Flags().GetInt("pathlen")
+		passphrase, _ := cmd.Flags().GetString("passphrase")
+		parentPassphrase, _ := cmd.Flags().GetString("parent-passphrase")
+		if parent == "NA" || orgName == "NA" {
+			log.Fatal("arguments --parent and --org are required, use privki list to find your A1 IDs")
+		}
+		var nameRestrictions []string
+		if nameRestriction != "NA" {
+			for _, domain := range strings.Split(nameRestriction, ",") {
+				nameRestrictions = append(nameRestrictions, strings.TrimSpace(domain))
+			}
+		}
+
+		vault := openVault()
+		findIntermediate(commandContext(cmd), vault, parent)
+		parentPassphrase = intermediatePassphrase(parentPassphrase)
+		passphrase = promptPassphrase(passphrase, "\n\tEnter a new passphrase for this Issuing CA (A2) \n\tPlease make sure this is different from its A1:  ")
+		issuing, err := vault.CreateIssuingCA(commandContext(cmd), ca.IssuingCAOptions{
+			Parent:           parent,
+			Organization:     orgName,
+			NameRestrictions: nameRestrictions,
+			PathLen:          pathLen,
+			Passphrase:       passphrase,
+			ParentPassphrase: parentPassphrase,
+		})
+		if err != nil {
+			log.Fatal(err)
+		}
+		log.Printf("Issuing CA (A2) %v created below A1 %v, use privki issue --a1=%v to issue certificates from it", issuing.ID, issuing.Parent, issuing.ID)
+	},
+}
+
+func init() {
+	var parent string
+	var org string
+	var nameRestriction string
+	var pathLen int
+	var passphrase string
+	var parentPassphrase string
+
+	createCertCmd.AddCommand(issuingCertCmd)
+	issuingCertCmd.Flags().StringVar(&parent, "parent", "NA", "flag --parent=<A1 ID> sets the A1 that signs the A2")
+	issuingCertCmd.Flags().StringVar(&org, "org", "NA", "flag --org=<name> sets the organization, project or environment of the A2")
+	issuingCertCmd.Flags().StringVar(&nameRestriction, "name-restrict", "NA", "flag --name-restrict=<domain,...> limits the A2 to DNS domains within the ones of its A1")
+	issuingCertCmd.Flags().IntVar(&pathLen, "pathlen", 0, "flag --pathlen=<n> sets the pathLenConstraint of the A2")
+	issuingCertCmd.Flags().StringVar(&passphrase, "passphrase", "NA", "flag --passphrase=<secret> protects the new A2 key")
+	issuingCertCmd.Flags().StringVar(&parentPassphrase, "parent-passphrase", "NA", "flag --parent-passphrase=<secret> unlocks the key of the A1")
+}
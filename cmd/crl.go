@@ -0,0 +1,134 @@
+package cmd
+
+import (
+	log "github.com/sirupsen/logrus"
+	"github.com/spf13/cobra"
+	"sfcert/pkg/ca"
+)
+
+// crlCmd groups the CRL subcommands
+var crlCmd = &cobra.Command{
+	Use:   "crl",
+	Short: "crl subcommand is used to generate and publish Certificate Revocation Lists",
+	Long: `You can use crl subcommand to generate the full and delta CRLs of a CA, and
+publish the CRLs and certificates of every CA into a directory served by a web server.
+
+example> privki crl generate --ca=20200722174505Z --days=7
+example> privki crl publish --dir=/var/www/pki
+
+you can find more help, by using the --help flag after there subcommands.
+example> privki crl generate --help
+`,
+	Run: func(cmd *cobra.Command, args []string) {
+		cmd.Help()
+	},
+}
+
+// crlGenerateCmd represents the crl generate command
+var crlGenerateCmd = &cobra.Command{
+	Use:   "generate",
+	Short: "Generates the full or delta CRL of a CA",
+	Long: `
+Use generate subcommand to sign a new CRL for a CA, an A1 or A2 ID, or a0 and
+dr-a0 for the Root CAs. --days and --hours set its nextUpdate, without them the
+default_crl_days of the CA configuration applies. The CRL number is taken from
+the crlnum file of the CA.
+
+example> privki crl generate --ca=20200722174505Z --days=7
+example> privki crl generate --ca=a0 --days=90 --passphrase="mySecretRootPassword"
+
+--delta generates a delta CRL of the revocations since the current full CRL,
+with the Delta CRL Indicator set to its number. Without a period a delta CRL is
+valid for 24 hours, a new full CRL supersedes it.
+
+example> privki crl generate --ca=20200722174505Z --delta --hours=6
+
+To publish on a schedule, run generate and publish from cron, for example
+example> 0 * * * * privki crl generate --ca=20200722174505Z --delta --hours=2 --passphrase=... && privki crl publish --dir=/var/www/pki
+`,
+	Annotations: mutatesVault,
+	Run: func(cmd *cobra.Command, args []string) {
+		id, _ := cmd.Flags().GetString("ca")
+		passphrase, _ := cmd.Flags().GetString("passphrase")
+		days, _ := cmd.Flags().GetInt("days")
+		hours, _ := cmd.Flags().GetInt("hours")
+		delta, _ := cmd.Flags().GetBool("delta")
+		if id == "NA" {
+			log.Fatal("argument --ca is required, an A1 or A2 ID, a0 or dr-a0")
+		}
+
+		vault := openVault()
+		if id == "a0" || id == "dr-a0" {
+			passphrase = promptPassphrase(passphrase, "\n\tRoot CA (A0) Passphrase: ")
+		} else {
+			findIntermediate(commandContext(cmd), vault, id)
+			passphrase = intermediatePassphrase(passphrase)
+		}
+		info, err := vault.GenerateCRL(commandContext(cmd), id, passphrase, ca.CRLOptions{
+			Days:  days,
+			Hours: hours,
+			Delta: delta,
+		})
+		if err != nil {
+			log.Fatal(err)
+		}
+		printJSON(info)
+		kind := "CRL"
+		if delta {
+			kind = "Delta CRL"
+		}
+		log.Printf("%v %v of %v generated with %v revoked certificates, next update %v", kind, info.Number, id, info.Revoked, info.NextUpdate.UTC())
+	},
+}
+
+// crlPublishCmd represents the crl publish command
+var crlPublishCmd = &cobra.Command{
+	Use:   "publish",
+	Short: "Writes the CRLs and certificates of every CA into a directory for a web server",
+	Long: `
+Use publish subcommand to copy the current CRLs and CA certificates of the vault,
+DER and PEM, into a static directory layout that any web server can serve:
+
+	certs/<ca>.cert.der   certs/<ca>.cert.pem
+	crl/<ca>.crl          crl/<ca>.crl.pem
+	crl/<ca>.delta.crl    crl/<ca>.delta.crl.pem
+	index.json
+
+where <ca> is a0, dr-a0 or an A1 or A2 ID. Files are replaced atomically, no
+passphrase is needed.
+
+example> privki crl publish --dir=/var/www/pki
+`,
+	Annotations: mutatesVault,
+	Run: func(cmd *cobra.Command, args []string) {
+		dir, _ := cmd.Flags().GetString("dir")
+		if dir == "NA" {
+			log.Fatal("argument --dir is required")
+		}
+		vault := openVault()
+		published, err := vault.PublishCRLs(commandContext(cmd), dir)
+		if err != nil {
+			log.Fatal(err)
+		}
+		log.Printf("Published the CRLs and certificates of %v CAs to %v", len(published), dir)
+	},
+}
+
+func init() {
+	var id string
+	var passphrase string
+	var days int
+	var hours int
+	var delta bool
+	var dir string
+
+	rootCmd.AddCommand(crlCmd)
+	crlCmd.AddCommand(crlGenerateCmd)
+	crlCmd.AddCommand(crlPublishCmd)
+	crlGenerateCmd.Flags().StringVar(&id, "ca", "NA", "flag --ca=<A1 or A2 ID|a0|dr-a0> selects the CA")
+	crlGenerateCmd.Flags().StringVar(&passphrase, "passphrase", "NA", "flag --passphrase=<secret> unlocks the key of the CA")
+	crlGenerateCmd.Flags().IntVar(&days, "days", 0, "flag --days=<n> sets the nextUpdate n days ahead")
+	crlGenerateCmd.Flags().IntVar(&hours, "hours", 0, "flag --hours=<n> sets the nextUpdate n hours ahead, added to --days")
+	crlGenerateCmd.Flags().BoolVar(&delta, "delta", false, "flag --delta generates a delta CRL of the revocations since the full CRL")
+	crlPublishCmd.Flags().StringVar(&dir, "dir", "NA", "flag --dir=<dir> sets the directory the CRLs and certificates are published to")
+}
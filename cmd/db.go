@@ -0,0 +1,115 @@
+package cmd
+
+import (
+	log "github.com/sirupsen/logrus"
+	"github.com/spf13/cobra"
+)
+
+// dbCmd groups the certificate database subcommands
+var dbCmd = &cobra.Command{
+	Use:   "db",
+	Short: "db subcommand is used to manage the embedded certificate database",
+	Long: `You can use db subcommand to migrate a vault to the embedded certificate
+database (privki.db in the PKI dir), list the CAs it holds and export the
+openssl index, serial and crlnum files of a CA back from it.
+
+example> privki db migrate
+example> privki db list
+example> privki db export --ca=20200722174505Z --out=./a1-db
+
+you can find more help, by using the --help flag after there subcommands.
+example> privki db export --help
+`,
+	Run: func(cmd *cobra.Command, args []string) {
+		cmd.Help()
+	},
+}
+
+// dbMigrateCmd represents the db migrate command
+var dbMigrateCmd = &cobra.Command{
+	Use:   "migrate",
+	Short: "Records the openssl index files of every CA into the embedded certificate database",
+	Long: `
+Use migrate subcommand once on vaults created before the embedded certificate
+database, vaults created since have one from the start. Every certificate of the
+Root CA, DR Root CA, retired roots, A1s and A2s is recorded with its serial,
+status, revocation date and reason and its PEM, along with the serial and CRL
+numbers of each CA. Migrating again only adds or updates records.
+
+example> privki db migrate
+
+Once migrated, privki list --a1 and certificate lookups read the database, and
+every openssl ca operation of privki is recorded in it as a single transaction.
+`,
+	Annotations: mutatesVault,
+	Run: func(cmd *cobra.Command, args []string) {
+		vault := openVault()
+		migrated, err := vault.MigrateDatabase(commandContext(cmd))
+		if err != nil {
+			log.Fatal(err)
+		}
+		printJSON(migrated)
+		log.Printf("%v CAs recorded in the certificate database", len(migrated))
+	},
+}
+
+// dbListCmd represents the db list command
+var dbListCmd = &cobra.Command{
+	Use:   "list",
+	Short: "Lists the CAs of the embedded certificate database",
+	Long: `
+Use list subcommand to show the CAs recorded in the certificate database,
+with their directory, number of certificates and last synchronization.
+
+example> privki db list
+`,
+	Run: func(cmd *cobra.Command, args []string) {
+		vault := openVault()
+		cas, err := vault.DatabaseCAs(commandContext(cmd))
+		if err != nil {
+			log.Fatal(err)
+		}
+		printJSON(cas)
+	},
+}
+
+// dbExportCmd represents the db export command
+var dbExportCmd = &cobra.Command{
+	Use:   "export",
+	Short: "Writes the openssl database files of a CA from the embedded certificate database",
+	Long: `
+Use export subcommand to get the openssl index, index.attr, serial and crlnum
+files of a CA, and its issued certificates under newcerts/, out of the
+certificate database. Use --ca=a0 or --ca=dr-a0 for the Root CAs.
+
+example> privki db export --ca=20200722174505Z --out=./a1-db
+
+To repair a damaged index of a CA, export it and copy the files back into the
+CA directory shown by privki db list.
+`,
+	Run: func(cmd *cobra.Command, args []string) {
+		id, _ := cmd.Flags().GetString("ca")
+		out, _ := cmd.Flags().GetString("out")
+		if id == "NA" || out == "NA" {
+			log.Fatal("arguments --ca and --out are required")
+		}
+		vault := openVault()
+		exported, err := vault.ExportDatabase(commandContext(cmd), id, out)
+		if err != nil {
+			log.Fatal(err)
+		}
+		log.Printf("Exported %v certificates of %v to %v", exported.Entries, exported.Dir, out)
+	},
+}
+
+func init() {
+	var id string
+	var out string
+
+	rootCmd.AddCommand(dbCmd)
+	dbCmd.AddCommand(dbMigrateCmd)
+	dbCmd.AddCommand(dbListCmd)
+	dbCmd.AddCommand(dbExportCmd)
+	dbExportCmd.Flags().StringVar(&id, "ca", "NA", "flag --ca=<A1 or A2 ID|a0|dr-a0> selects the CA to export")
+	dbExportCmd.Flags().StringVar(&out, "out", "NA", "flag --out=<dir> sets the directory the openssl database files are written to")
+}
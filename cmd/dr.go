@@ -0,0 +1,220 @@
+package cmd
+
+import (
+	"encoding/json"
+	log "github.com/sirupsen/logrus"
+	"github.com/spf13/cobra"
+	"io/ioutil"
+	"sfcert/openssl"
+	"sfcert/pkg/ca"
+)
+
+// drCmd groups the DR Root CA subcommands
+var drCmd = &cobra.Command{
+	Use:   "dr",
+	Short: "dr subcommand is used to enable and fail over to the DR Root CA (DR A0)",
+	Long: `You can use dr subcommand to operate the DR Root CA created
+with privki create A0 --with-dr=true, or to add one to an existing vault.
+
+example> privki dr enable
+example> privki dr drill --out=./dr-drill.json
+example> privki dr promote --new-dr=true
+
+you can find more help, by using the --help flag after there subcommands.
+example> privki dr promote --help
+`,
+	Run: func(cmd *cobra.Command, args []string) {
+		cmd.Help()
+	},
+}
+
+// drPromoteCmd represents the dr promote command
+var drPromoteCmd = &cobra.Command{
+	Use:   "promote",
+	Short: "Makes the DR Root CA (DR A0) the primary Root CA of the vault",
+	Long: `
+Use promote subcommand when the Root CA (A0) is lost or compromised. The DR
+Root CA becomes the primary signer, privki create A1, revocations and CRLs run
+against it from now on, and the former A0 is kept in a retired directory.
+The cross signed certificates of the A1s become their primary certificates,
+the switch is recorded in the primary_root config of the vault.
+
+example> privki dr promote
+
+Only the DR A0 key is used, --root-passphrase is its passphrase for a non
+interactive execution, the A0 one unless the DR A0 was given its own with
+privki passphrase rotate. It is the root passphrase from then on. To restore
+redundancy, --new-dr=true creates a fresh DR Root CA sharing it and cross
+signs every A1 with it.
+
+example> privki dr promote --new-dr=true --root-passphrase="mySecretRootPassword"
+
+The report lists the A1s whose chain bundles, and A1 packages, must be
+redistributed, and the A1s that were never cross signed and stay with the
+retired A0. Export the new trust bundle with privki export --format=pem.
+`,
+	Annotations: mutatesVault,
+	Run: func(cmd *cobra.Command, args []string) {
+		rootPassphrase, _ := cmd.Flags().GetString("root-passphrase")
+		newDR, _ := cmd.Flags().GetBool("new-dr")
+
+		vault := openRootVault()
+		if !vault.DREnabled() {
+			log.Fatal("DR is not enabled on this vault, there is no DR Root CA to promote")
+		}
+		rootPassphrase = promptPassphrase(rootPassphrase, "\n\tDR Root CA (DR A0) Passphrase: ")
+		report, err := vault.PromoteDR(commandContext(cmd), ca.PromoteOptions{
+			RootPassphrase: rootPassphrase,
+			DRPassphrase:   rootPassphrase,
+			ProvisionDR:    newDR,
+		})
+		if report != nil {
+			printJSON(report)
+		}
+		if err != nil {
+			log.Fatal(err)
+		}
+		for _, orphaned := range report.Orphaned {
+			log.Warnf("A1 %v was never cross signed and only chains to the retired Root CA", orphaned)
+		}
+		log.Printf("DR Root CA promoted, redistribute the chain bundles of %v A1s and the new trust bundle", len(report.Intermediates))
+	},
+}
+
+// drEnableCmd represents the dr enable command
+var drEnableCmd = &cobra.Command{
+	Use:   "enable",
+	Short: "Adds a DR Root CA (DR A0) to a vault created without one",
+	Long: `
+Use enable subcommand to create the DR Root CA of a vault created without
+--with-dr=true, or after privki dr promote without --new-dr. The DR A0 shares
+the passphrase of the A0, and every active A1 is cross signed with it.
+
+example> privki dr enable --root-passphrase="mySecretRootPassword"
+
+Revoked and expired A1s are skipped. The report lists the A1s that got a DR
+certificate and chain bundle, repackage and redistribute them with
+privki a1 package, and export the new trust bundle with privki export --format=pem.
+`,
+	Annotations: mutatesVault,
+	Run: func(cmd *cobra.Command, args []string) {
+		rootPassphrase, _ := cmd.Flags().GetString("root-passphrase")
+
+		vault := openRootVault()
+		if vault.DREnabled() {
+			log.Fatal("DR is already enabled on this vault")
+		}
+		rootPassphrase = promptPassphrase(rootPassphrase, "\n\tRoot CA (A0) Passphrase: ")
+		report, err := vault.EnableDR(commandContext(cmd), rootPassphrase)
+		if report != nil {
+			printJSON(report)
+		}
+		if err != nil {
+			log.Fatal(err)
+		}
+		for id, reason := range report.Skipped {
+			log.Warnf("A1 %v was not cross signed: %v", id, reason)
+		}
+		log.Printf("DR enabled, redistribute the A1 packages of %v cross signed A1s and the new trust bundle", len(report.CrossSigned))
+	},
+}
+
+// drDrillCmd represents the dr drill command
+var drDrillCmd = &cobra.Command{
+	Use:   "drill",
+	Short: "Checks that the DR Root CA (DR A0) could take over, and signs a report",
+	Long: `
+Use drill subcommand to prove DR readiness, the vault is not modified. The
+drill checks that the DR A0 key unlocks with the root passphrase, or with
+--dr-passphrase when the DR A0 has a passphrase of its own, that the DR
+cross certificate of every active A1 and the DR chain bundles of the A1s and
+A2s validate against the DR A0, that the configs of both roots match the reset
+Root CA config (OID, organization, no leftover name constraints or path length)
+and that the CRLs of both roots are not due for an update within 30 days.
+
+example> privki dr drill --out=./dr-drill.json
+
+The report is signed with the Root CA (A0), a failed check makes the command
+exit with an error once the report is written. Auditors verify a report with
+the trust bundle exported by privki export --format=pem.
+
+example> privki dr drill --verify=./dr-drill.json --trust=./alpha-trust.pem
+`,
+	Run: func(cmd *cobra.Command, args []string) {
+		rootPassphrase, _ := cmd.Flags().GetString("root-passphrase")
+		drRootPassphrase, _ := cmd.Flags().GetString("dr-passphrase")
+		out, _ := cmd.Flags().GetString("out")
+		verify, _ := cmd.Flags().GetString("verify")
+		trust, _ := cmd.Flags().GetString("trust")
+
+		if verify != "NA" {
+			if trust == "NA" {
+				log.Fatal("argument --trust is required with --verify")
+			}
+			trusted, err := openssl.ReadCertificates(trust)
+			if err != nil {
+				log.Fatal(err)
+			}
+			report, err := ca.VerifyDrillReport(commandContext(cmd), verify, trusted)
+			if err != nil {
+				log.Fatal(err)
+			}
+			log.Printf("DR drill report of %v for vault %v is signed by a trusted Root CA, passed: %v", report.Drilled, report.RootUID, report.Passed)
+			return
+		}
+
+		vault := openRootVault()
+		if !vault.DREnabled() {
+			log.Fatal("DR is not enabled on this vault, see privki dr enable")
+		}
+		rootPassphrase = promptPassphrase(rootPassphrase, "\n\tRoot CA (A0) Passphrase: ")
+		report, err := vault.DrillDR(commandContext(cmd), rootPassphrase, drPassphrase(vault, drRootPassphrase))
+		if err != nil {
+			log.Fatal(err)
+		}
+		if out != "NA" {
+			reportBytes, err := json.MarshalIndent(report, "", "  ")
+			if err != nil {
+				log.Fatal(err)
+			}
+			if err := ioutil.WriteFile(out, reportBytes, 0644); err != nil {
+				log.Fatal(err)
+			}
+		} else {
+			printJSON(report)
+		}
+		for _, check := range report.Checks {
+			if !check.Passed {
+				log.Warnf("%v of %v failed: %v", check.Check, check.Target, check.Detail)
+			}
+		}
+		if !report.Passed {
+			log.Fatal("DR drill failed")
+		}
+		log.Printf("DR drill passed, %v checks", len(report.Checks))
+	},
+}
+
+func init() {
+	var rootPassphrase string
+	var newDR bool
+	var enableRootPassphrase string
+	var drillRootPassphrase string
+	var drillDRPassphrase string
+	var out string
+	var verify string
+	var trust string
+
+	rootCmd.AddCommand(drCmd)
+	drCmd.AddCommand(drPromoteCmd)
+	drCmd.AddCommand(drEnableCmd)
+	drCmd.AddCommand(drDrillCmd)
+	drPromoteCmd.Flags().StringVar(&rootPassphrase, "root-passphrase", "NA", "flag --root-passphrase=<secret> unlocks the DR Root CA (DR A0) key")
+	drPromoteCmd.Flags().BoolVar(&newDR, "new-dr", false, "flag --new-dr=true provisions a fresh DR Root CA and cross signs the A1s with it")
+	drEnableCmd.Flags().StringVar(&enableRootPassphrase, "root-passphrase", "NA", "flag --root-passphrase=<secret> unlocks the Root CA (A0) key and protects the DR Root CA key")
+	drDrillCmd.Flags().StringVar(&drillRootPassphrase, "root-passphrase", "NA", "flag --root-passphrase=<secret> unlocks the Root CA (A0) and DR Root CA keys")
+	drDrillCmd.Flags().StringVar(&drillDRPassphrase, "dr-passphrase", "NA", "flag --dr-passphrase=<secret> unlocks the DR Root CA key when it differs from the A0 one")
+	drDrillCmd.Flags().StringVar(&out, "out", "NA", "flag --out=<file> writes the signed report to a file instead of the standard output")
+	drDrillCmd.Flags().StringVar(&verify, "verify", "NA", "flag --verify=<file> checks the signature of a report instead of running a drill")
+	drDrillCmd.Flags().StringVar(&trust, "trust", "NA", "flag --trust=<file> sets the PEM Root CA certificates a --verify report must be signed by")
+}
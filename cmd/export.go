@@ -0,0 +1,109 @@
+package cmd
+
+import (
+	log "github.com/sirupsen/logrus"
+	"github.com/spf13/cobra"
+	"sfcert/pkg/ca"
+)
+
+// exportCmd represents the export command
+var exportCmd = &cobra.Command{
+	Use:   "export",
+	Short: "Exports A1s, issued certificates and trust bundles as PEM, DER, PKCS#7, PKCS#8, PKCS#12 or Java keystores",
+	Long: `
+Use export subcommand to convert an Intermediary CA (A1), a certificate
+it issued, or the vault trust bundle into the format your platform needs.
+Supported formats are pem, der, pkcs7, pkcs8, pkcs12, jks and truststore.
+
+To export the trust bundle with the Root CA (A0) and DR Root CA (DR A0), leave out --a1
+
+example> privki export --format=pkcs7 --out=./alpha-trust.p7b
+example> privki export --format=truststore --out=./alpha-trust.jks --export-passphrase="changeit"
+
+To export an A1 with its chain up to A0, or up to DR A0 with --dr=true
+
+example> privki export --a1=20200722174505Z --format=der --out=./a1.der
+example> privki export --a1=20200722174505Z --format=pkcs12 --include-key=true --out=./a1.p12
+
+To hand over the A1 private key on its own, export it as PKCS#8 encrypted
+under a new passphrase, the key never leaves the vault unencrypted
+
+example> privki export --a1=20200722174505Z --format=pkcs8 --out=./a1.key.p8
+
+To export a certificate issued by an A1 along with the key privki issue wrote for it
+
+example> privki export --a1=20200722174505Z --serial=7E508DE26FAFF941DBAD044EB1E9FDA7 \
+			--key=./db01.chat.alpha.com.key.pem --format=jks --out=./db01.jks
+
+PKCS#12 files are encrypted with AES-256 by default, use --encryption=3des
+for consumers that predate it, such as Java 8 and older Windows releases.
+jks and truststore exports need keytool from a Java runtime.
+`,
+	Annotations: mutatesVault,
+	Run: func(cmd *cobra.Command, args []string) {
+		options := ca.ExportOptions{}
+		options.Format, _ = cmd.Flags().GetString("format")
+		options.Intermediate, _ = cmd.Flags().GetString("a1")
+		options.Serial, _ = cmd.Flags().GetString("serial")
+		options.DR, _ = cmd.Flags().GetBool("dr")
+		options.IncludeKey, _ = cmd.Flags().GetBool("include-key")
+		options.KeyFile, _ = cmd.Flags().GetString("key")
+		options.Encryption, _ = cmd.Flags().GetString("encryption")
+		options.OutFile, _ = cmd.Flags().GetString("out")
+		keyPassphrase, _ := cmd.Flags().GetString("passphrase")
+		exportPassphrase, _ := cmd.Flags().GetString("export-passphrase")
+		if options.OutFile == "NA" {
+			log.Fatal("argument --out is required")
+		}
+		if options.Intermediate == "NA" {
+			options.Intermediate = ""
+		}
+		if options.Serial == "NA" {
+			options.Serial = ""
+		}
+		if options.KeyFile == "NA" {
+			options.KeyFile = ""
+		}
+
+		switch options.Format {
+		case "pkcs8", "pkcs12", "jks", "truststore":
+			options.Passphrase = promptPassphrase(exportPassphrase, "\n\tEnter a passphrase to protect the exported file: ")
+		}
+		if options.IncludeKey || (options.Format == "pkcs8" && options.KeyFile == "" && options.Serial == "") {
+			options.KeyPassphrase = intermediatePassphrase(keyPassphrase)
+		} else if keyPassphrase != "NA" {
+			options.KeyPassphrase = keyPassphrase
+		}
+
+		vault := openVault()
+		if err := vault.Export(commandContext(cmd), options); err != nil {
+			log.Fatal(err)
+		}
+		log.Printf("Exported %v to %v", options.Format, options.OutFile)
+	},
+}
+
+func init() {
+	var format string
+	var a1 string
+	var serial string
+	var dr bool
+	var includeKey bool
+	var key string
+	var passphrase string
+	var exportPassphrase string
+	var encryption string
+	var out string
+
+	rootCmd.AddCommand(exportCmd)
+	exportCmd.Flags().StringVar(&format, "format", "pem", "flag --format=<pem|der|pkcs7|pkcs8|pkcs12|jks|truststore> selects the output format")
+	exportCmd.Flags().StringVar(&a1, "a1", "NA", "flag --a1=<A1 ID> exports this Intermediary CA, leave out for the trust bundle")
+	exportCmd.Flags().StringVar(&serial, "serial", "NA", "flag --serial=<hex serial> exports a certificate issued by the A1")
+	exportCmd.Flags().BoolVar(&dr, "dr", false, "flag --dr=true exports the chain up to the DR Root CA")
+	exportCmd.Flags().BoolVar(&includeKey, "include-key", false, "flag --include-key=true adds the A1 private key to pkcs12 and jks exports")
+	exportCmd.Flags().StringVar(&key, "key", "NA", "flag --key=<file> sets the private key of the issued certificate for pkcs8, pkcs12 and jks exports")
+	exportCmd.Flags().StringVar(&passphrase, "passphrase", "NA", "flag --passphrase=<secret> unlocks the A1 key, or the --key file if it is encrypted")
+	exportCmd.Flags().StringVar(&exportPassphrase, "export-passphrase", "NA", "flag --export-passphrase=<secret> protects pkcs8, pkcs12, jks and truststore output")
+	exportCmd.Flags().StringVar(&encryption, "encryption", "aes256", "flag --encryption=<aes256|3des> selects the pkcs12 encryption")
+	exportCmd.Flags().StringVar(&out, "out", "NA", "flag --out=<file> sets the output file")
+}
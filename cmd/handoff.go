@@ -0,0 +1,141 @@
+package cmd
+
+import (
+	log "github.com/sirupsen/logrus"
+	"github.com/spf13/cobra"
+	"sfcert/openssl"
+	"sfcert/pkg/ca"
+)
+
+// intermediateCmd groups the subcommands that operate on an existing A1
+var intermediateCmd = &cobra.Command{
+	Use:   "a1",
+	Short: "a1 subcommand is used to hand over Intermediary CAs (A1) to the teams operating them",
+	Long: `You can use a1 subcommand to package an A1 for the team that will
+operate it, and to unpack such a package on the receiving host.
+
+example> privki a1 package --a1=20200722174505Z --recipient=./chat-team.pub.pem --out=./chat.a1pkg
+example> privki a1 unpack --package=./chat.a1pkg --key=./chat-team.key.pem --trust=./alpha-trust.pem
+
+you can find more help, by using the --help flag after there subcommands.
+example> privki a1 package --help
+`,
+	Run: func(cmd *cobra.Command, args []string) {
+		cmd.Help()
+	},
+}
+
+// packageIntermediateCmd represents the a1 package command
+var packageIntermediateCmd = &cobra.Command{
+	Use:   "package",
+	Short: "Encrypts an A1 to the receiving team's public key and signs it with the Root CA (A0)",
+	Long: `
+Use package subcommand to hand over an Intermediary CA (A1) to the team
+that will operate it. The package holds the A1 key, its certificate, the
+DR cross certificate, its openssl configuration, the Root CA certificates
+and an empty certificate database.
+
+The package is encrypted to the RSA public key, or certificate, of the
+receiving team given with --recipient, so only the holder of the matching
+private key can open it. It is signed with the Root CA (A0) so the receiving
+host can check where it came from.
+
+example> openssl genpkey -algorithm RSA -pkeyopt rsa_keygen_bits:4096 -out chat-team.key.pem
+example> openssl pkey -in chat-team.key.pem -pubout -out chat-team.pub.pem
+example> privki a1 package --a1=20200722174505Z --recipient=./chat-team.pub.pem --out=./chat.a1pkg
+
+The receiving team also needs the Root CA certificates to verify the package,
+hand them over separately, for example with privki export --out=./alpha-trust.pem
+`,
+	Run: func(cmd *cobra.Command, args []string) {
+		id, _ := cmd.Flags().GetString("a1")
+		recipient, _ := cmd.Flags().GetString("recipient")
+		rootPassphrase, _ := cmd.Flags().GetString("root-passphrase")
+		out, _ := cmd.Flags().GetString("out")
+		if id == "NA" || recipient == "NA" || out == "NA" {
+			log.Fatal("arguments --a1, --recipient and --out are required")
+		}
+
+		vault := openRootVault()
+		rootPassphrase = promptPassphrase(rootPassphrase, "\n\tRoot CA (A0) Passphrase: ")
+		err := vault.PackageIntermediate(commandContext(cmd), id, ca.PackageOptions{
+			Recipient:      recipient,
+			RootPassphrase: rootPassphrase,
+			OutFile:        out,
+		})
+		if err != nil {
+			log.Fatal(err)
+		}
+		log.Printf("A1 %v packaged for %v to %v", id, recipient, out)
+	},
+}
+
+// unpackIntermediateCmd represents the a1 unpack command
+var unpackIntermediateCmd = &cobra.Command{
+	Use:   "unpack",
+	Short: "Verifies and decrypts an A1 package and sets it up on this host",
+	Long: `
+Use unpack subcommand on the host of the team receiving an A1 package.
+The package signature is checked against the Root CA certificates given
+with --trust, then it is decrypted with the team's private key.
+
+If this host has no vault yet, a subordinate vault for the Root CA of the
+package is set up, holding the Root CA certificates but not their keys.
+The A1 is then ready to issue certificates with privki issue.
+
+example> privki a1 unpack --package=./chat.a1pkg --key=./chat-team.key.pem --trust=./alpha-trust.pem
+
+If the team's private key is encrypted, pass its passphrase with --key-passphrase
+`,
+	Annotations: mutatesVault,
+	Run: func(cmd *cobra.Command, args []string) {
+		packageFile, _ := cmd.Flags().GetString("package")
+		key, _ := cmd.Flags().GetString("key")
+		keyPassphrase, _ := cmd.Flags().GetString("key-passphrase")
+		trust, _ := cmd.Flags().GetString("trust")
+		if packageFile == "NA" || key == "NA" || trust == "NA" {
+			log.Fatal("arguments --package, --key and --trust are required")
+		}
+		if keyPassphrase == "NA" {
+			keyPassphrase = ""
+		}
+		trusted, err := openssl.ReadCertificates(trust)
+		if err != nil {
+			log.Fatal(err)
+		}
+
+		intermediate, err := ca.Unpack(commandContext(cmd), ca.UnpackOptions{
+			PackageFile:   packageFile,
+			Trusted:       trusted,
+			KeyFile:       key,
+			KeyPassphrase: keyPassphrase,
+		})
+		if err != nil {
+			log.Fatal(err)
+		}
+		log.Printf("Intermediary CA (A1) %v unpacked, use privki issue --a1=%v to issue certificates from it", intermediate.ID, intermediate.ID)
+	},
+}
+
+func init() {
+	var a1 string
+	var recipient string
+	var rootPassphrase string
+	var out string
+	var packageFile string
+	var key string
+	var keyPassphrase string
+	var trust string
+
+	rootCmd.AddCommand(intermediateCmd)
+	intermediateCmd.AddCommand(packageIntermediateCmd)
+	intermediateCmd.AddCommand(unpackIntermediateCmd)
+	packageIntermediateCmd.Flags().StringVar(&a1, "a1", "NA", "flag --a1=<A1 ID> selects the Intermediary CA to package")
+	packageIntermediateCmd.Flags().StringVar(&recipient, "recipient", "NA", "flag --recipient=<file> sets the receiving team's RSA public key or certificate")
+	packageIntermediateCmd.Flags().StringVar(&rootPassphrase, "root-passphrase", "NA", "flag --root-passphrase=<secret> unlocks the Root CA (A0) key to sign the package")
+	packageIntermediateCmd.Flags().StringVar(&out, "out", "NA", "flag --out=<file> sets the package file")
+	unpackIntermediateCmd.Flags().StringVar(&packageFile, "package", "NA", "flag --package=<file> sets the A1 package to unpack")
+	unpackIntermediateCmd.Flags().StringVar(&key, "key", "NA", "flag --key=<file> sets the receiving team's private key")
+	unpackIntermediateCmd.Flags().StringVar(&keyPassphrase, "key-passphrase", "NA", "flag --key-passphrase=<secret> unlocks the --key file if it is encrypted")
+	unpackIntermediateCmd.Flags().StringVar(&trust, "trust", "NA", "flag --trust=<file> sets the PEM Root CA certificates the package must be signed by")
+}
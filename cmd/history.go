@@ -0,0 +1,104 @@
+package cmd
+
+import (
+	"fmt"
+	log "github.com/sirupsen/logrus"
+	"github.com/spf13/cobra"
+	"sfcert/pkg/ca"
+	"strings"
+)
+
+// historyCmd represents the history command
+var historyCmd = &cobra.Command{
+	Use:   "history",
+	Short: "Shows the operations that changed the vault",
+	Long: `
+Use history subcommand to browse the change log of the vault, newest first.
+Every command changing the vault commits its public state, the certificates,
+CRLs, databases, configs and audit trail, to a git repository in the PKI dir
+with the command, its flags, the user and the host. Private keys and
+passphrases are never recorded. --ca narrows it to the changes of a CA.
+
+example> privki history
+example> privki history --limit=5 --ca=20200722174505Z
+`,
+	Run: func(cmd *cobra.Command, args []string) {
+		limit, _ := cmd.Flags().GetInt("limit")
+		id, _ := cmd.Flags().GetString("ca")
+		vault := openVault()
+		entries, err := vault.History(commandContext(cmd), 0)
+		if err != nil {
+			log.Fatal(err)
+		}
+		selected := []ca.HistoryEntry{}
+		for _, entry := range entries {
+			if limit > 0 && len(selected) == limit {
+				break
+			}
+			if id == "NA" || historyTouches(entry, id) {
+				selected = append(selected, entry)
+			}
+		}
+		printJSON(selected)
+	},
+}
+
+// historyTouches tells whether an operation changed a file of the CA id
+func historyTouches(entry ca.HistoryEntry, id string) bool {
+	for _, file := range entry.Files {
+		if strings.Contains(strings.SplitN(file, "/", 2)[0], id) {
+			return true
+		}
+	}
+	return false
+}
+
+// diffCmd represents the diff command
+var diffCmd = &cobra.Command{
+	Use:   "diff",
+	Short: "Shows the changes of the vault between two points of its history",
+	Long: `
+Use diff subcommand to review what changed in the vault between two points of
+its history, each one a commit listed by privki history, a date or a time. A date
+or a time stands for the last operation before it. Without --from, the changes of
+the --to operation are shown, the latest one by default. --stat lists the changed
+files only.
+
+example> privki diff
+example> privki diff --from=2026-10-01 --stat
+example> privki diff --from=3f2a9c1 --to=2026-10-18T17:45:00
+`,
+	Run: func(cmd *cobra.Command, args []string) {
+		from, _ := cmd.Flags().GetString("from")
+		to, _ := cmd.Flags().GetString("to")
+		stat, _ := cmd.Flags().GetBool("stat")
+		if from == "NA" {
+			from = ""
+		}
+		if to == "NA" {
+			to = ""
+		}
+		vault := openVault()
+		diff, err := vault.Diff(commandContext(cmd), ca.DiffOptions{From: from, To: to, Stat: stat})
+		if err != nil {
+			log.Fatal(err)
+		}
+		fmt.Println(diff)
+	},
+}
+
+func init() {
+	var limit int
+	var id string
+	var from string
+	var to string
+	var stat bool
+
+	rootCmd.AddCommand(historyCmd)
+	rootCmd.AddCommand(diffCmd)
+	historyCmd.Flags().IntVar(&limit, "limit", 20, "flag --limit=<n> shows the last n operations, 0 shows them all")
+	historyCmd.Flags().StringVar(&id, "ca", "NA", "flag --ca=<A1 or A2 ID> shows the operations that changed a CA")
+	diffCmd.Flags().StringVar(&from, "from", "NA", "flag --from=<commit|date|time> sets the point the changes are shown from")
+	diffCmd.Flags().StringVar(&to, "to", "NA", "flag --to=<commit|date|time> sets the point the changes are shown up to, the latest by default")
+	diffCmd.Flags().BoolVar(&stat, "stat", false, "flag --stat lists the changed files instead of showing the changes")
+}
@@ -0,0 +1,131 @@
+package cmd
+
+import (
+	"bytes"
+	log "github.com/sirupsen/logrus"
+	"github.com/spf13/cobra"
+	"io/ioutil"
+	"sfcert/pkg/ca"
+)
+
+// importCmd groups the import subcommands
+var importCmd = &cobra.Command{
+	Use:   "import",
+	Short: "import subcommand is used to adopt existing CAs into the vault",
+	Long: `You can use import subcommand to take over a CA created with plain openssl,
+or another tool keeping an openssl ca database, so that privki operates it.
+
+example> privki import ca --cert=./ca.cert.pem --key=./ca.key.pem --index=./index.txt
+
+you can find more help, by using the --help flag after there subcommands.
+example> privki import ca --help
+`,
+	Run: func(cmd *cobra.Command, args []string) {
+		cmd.Help()
+	},
+}
+
+// importCACmd represents the import ca command
+var importCACmd = &cobra.Command{
+	Use:   "ca",
+	Short: "Adopts an existing root or intermediate CA with its key and openssl database",
+	Long: `
+Use ca subcommand to adopt an existing CA. A self signed certificate becomes the
+Root CA (A0) of a vault initialized with privki init and no create A0, its O and
+CN become the organization and common name of the vault.
+
+example> privki init
+example> privki import ca --cert=./root.cert.pem --key=./root.key.pem --index=./index.txt \
+			--custom-oid="1.3.6.1.4.1.99999.1" --passphrase="myRootSecretPassword"
+
+A certificate issued by the Root CA becomes an A1, one issued by an A1 becomes an
+A2, with the ID of its notBefore date. When DR is enabled, --root-passphrase cross
+signs an imported A1 with the DR Root CA, or --dr-passphrase when the DR Root CA
+has a passphrase of its own.
+
+example> privki import ca --cert=./sub.cert.pem --key=./sub.key.pem --index=./sub/index.txt \
+			--serial=./sub/serial --crlnum=./sub/crlnumber --crl=./sub/sub.crl --newcerts=./sub/newcerts
+
+The key must match the certificate, --key-passphrase unlocks it and --passphrase
+protects it in the vault. The serial and CRL numbers continue after the imported
+index and CRL unless --serial and --crlnum are given, and the issued certificates
+of --newcerts are needed to revoke them later. Once imported the CA is operated
+like any other, with privki issue, revoke, list, export and dr.
+`,
+	Annotations: mutatesVault,
+	Run: func(cmd *cobra.Command, args []string) {
+		certFile, _ := cmd.Flags().GetString("cert")
+		keyFile, _ := cmd.Flags().GetString("key")
+		keyPassphrase, _ := cmd.Flags().GetString("key-passphrase")
+		passphrase, _ := cmd.Flags().GetString("passphrase")
+		rootPassphrase, _ := cmd.Flags().GetString("root-passphrase")
+		drRootPassphrase, _ := cmd.Flags().GetString("dr-passphrase")
+		oid, _ := cmd.Flags().GetString("custom-oid")
+		request := ca.ImportRequest{CertFile: certFile, KeyFile: keyFile}
+		for flag, value := range map[string]*string{
+			"index":    &request.IndexFile,
+			"serial":   &request.SerialFile,
+			"crlnum":   &request.CRLNumberFile,
+			"crl":      &request.CRLFile,
+			"newcerts": &request.NewcertsDir,
+		} {
+			if flagValue, _ := cmd.Flags().GetString(flag); flagValue != "NA" {
+				*value = flagValue
+			}
+		}
+		if certFile == "NA" || keyFile == "NA" {
+			log.Fatal("arguments --cert and --key are required")
+		}
+		key, err := ioutil.ReadFile(keyFile)
+		if err != nil {
+			log.Fatal(err)
+		}
+		if keyPassphrase != "NA" {
+			request.KeyPassphrase = keyPassphrase
+		} else if bytes.Contains(key, []byte("ENCRYPTED")) {
+			request.KeyPassphrase = promptPassphrase(keyPassphrase, "\n\tPassphrase of the imported key: ")
+		}
+		if oid != "NA" {
+			request.OID = oid
+		}
+
+		vault := openRootVault()
+		request.Passphrase = promptPassphrase(passphrase, "\n\tEnter the vault passphrase for the imported CA: ")
+		if rootPassphrase != "NA" {
+			request.RootPassphrase = rootPassphrase
+		}
+		if drRootPassphrase != "NA" {
+			request.DRPassphrase = drRootPassphrase
+		}
+		imported, err := vault.ImportCA(commandContext(cmd), request)
+		if err != nil {
+			log.Fatal(err)
+		}
+		printJSON(imported)
+		if imported.Kind == "A0" {
+			log.Printf("Root CA (A0) %v imported with %v certificates, create A1s with privki create A1", imported.Subject, imported.Entries)
+			return
+		}
+		log.Printf("%v %v imported with %v certificates, use privki issue --a1=%v to issue certificates from it", imported.Kind, imported.ID, imported.Entries, imported.ID)
+	},
+}
+
+func init() {
+	var certFile, keyFile, indexFile, serialFile, crlNumberFile, crlFile, newcertsDir string
+	var keyPassphrase, passphrase, rootPassphrase, drRootPassphrase, oid string
+
+	rootCmd.AddCommand(importCmd)
+	importCmd.AddCommand(importCACmd)
+	importCACmd.Flags().StringVar(&certFile, "cert", "NA", "flag --cert=<file> is the PEM certificate of the CA")
+	importCACmd.Flags().StringVar(&keyFile, "key", "NA", "flag --key=<file> is the PEM private key of the CA")
+	importCACmd.Flags().StringVar(&indexFile, "index", "NA", "flag --index=<file> is the openssl ca database (index.txt) of the CA")
+	importCACmd.Flags().StringVar(&serialFile, "serial", "NA", "flag --serial=<file> is the openssl serial file of the CA")
+	importCACmd.Flags().StringVar(&crlNumberFile, "crlnum", "NA", "flag --crlnum=<file> is the openssl crlnumber file of the CA")
+	importCACmd.Flags().StringVar(&crlFile, "crl", "NA", "flag --crl=<file> is the latest CRL of the CA, PEM or DER")
+	importCACmd.Flags().StringVar(&newcertsDir, "newcerts", "NA", "flag --newcerts=<dir> holds the certificates issued by the CA, named <serial>.pem")
+	importCACmd.Flags().StringVar(&keyPassphrase, "key-passphrase", "NA", "flag --key-passphrase=<secret> unlocks the imported key")
+	importCACmd.Flags().StringVar(&passphrase, "passphrase", "NA", "flag --passphrase=<secret> protects the key in the vault")
+	importCACmd.Flags().StringVar(&rootPassphrase, "root-passphrase", "NA", "flag --root-passphrase=<secret> cross signs an imported A1 with the DR Root CA")
+	importCACmd.Flags().StringVar(&drRootPassphrase, "dr-passphrase", "NA", "flag --dr-passphrase=<secret> cross signs an imported A1 with a DR Root CA that has a passphrase of its own")
+	importCACmd.Flags().StringVar(&oid, "custom-oid", "NA", "flag --custom-oid=<oid> sets the class OID of a vault adopting a root")
+}
@@ -0,0 +1,161 @@
+package cmd
+
+import (
+	"context"
+	log "github.com/sirupsen/logrus"
+	"github.com/spf13/cobra"
+	"io/ioutil"
+	"sfcert/pkg/ca"
+)
+
+// issueCmd represents the issue command
+var issueCmd = &cobra.Command{
+	Use:   "issue",
+	Short: "Issues a leaf certificate and key from an Intermediary CA (A1)",
+	Long: `
+Use issue subcommand to generate a new key pair and a certificate
+signed by one of the Intermediary CAs (A1) in this vault. Use
+privki list to find the ID of your A1.
+
+example> privki issue --a1=20200722174505Z --common-name="db01.chat.alpha.com" --dns="db01.chat.alpha.com"
+
+Profiles server (default), client and user select the certificate extensions.
+Profiles applied from a manifest with privki apply also set default validity,
+organization and organizational unit.
+
+example> privki issue --a1=20200722174505Z --common-name="deploy-bot" --ou="platform" --profile=client --out=./deploy-bot
+
+the certificate and key are written to <out>.cert.pem and <out>.key.pem
+the key is not retained in the vault, so keep it safe.
+`,
+	Annotations: mutatesVault,
+	Run: func(cmd *cobra.Command, args []string) {
+		a1, _ := cmd.Flags().GetString("a1")
+		out, _ := cmd.Flags().GetString("out")
+		request := ca.IssueRequest{}
+		request.CommonName, _ = cmd.Flags().GetString("common-name")
+		request.Organization, _ = cmd.Flags().GetString("org")
+		request.OrganizationalUnit, _ = cmd.Flags().GetString("ou")
+		request.DNSNames, _ = cmd.Flags().GetStringSlice("dns")
+		request.IPAddresses, _ = cmd.Flags().GetStringSlice("ip")
+		request.Profile, _ = cmd.Flags().GetString("profile")
+		request.Days, _ = cmd.Flags().GetInt("days")
+		if out == "NA" {
+			out = request.CommonName
+		}
+
+		vault := openVault()
+		intermediate := findIntermediate(commandContext(cmd), vault, a1)
+		passphrase, _ := cmd.Flags().GetString("passphrase")
+		issued, err := vault.Issue(commandContext(cmd), intermediate.ID, ca.IssueOptions{
+			IssueRequest: request,
+			Passphrase:   intermediatePassphrase(passphrase),
+		})
+		if err != nil {
+			log.Fatal(err)
+		}
+		if err := ioutil.WriteFile(out+".key.pem", []byte(issued.PrivateKeyPEM), 0600); err != nil {
+			log.Fatal(err)
+		}
+		if err := ioutil.WriteFile(out+".cert.pem", []byte(issued.CertificatePEM), 0644); err != nil {
+			log.Fatal(err)
+		}
+		log.Printf("\n\n\t*************************************\n\tCertificate %v issued by A1 %v\n\tCertificate : %v.cert.pem\n\tPrivate Key : %v.key.pem\n\t*************************************\n", issued.Serial, intermediate.ID, out, out)
+	},
+}
+
+// signCmd represents the sign command
+var signCmd = &cobra.Command{
+	Use:   "sign",
+	Short: "Signs a certificate signing request with an Intermediary CA (A1)",
+	Long: `
+Use sign subcommand to sign a PEM certificate signing request
+with one of the Intermediary CAs (A1) in this vault.
+
+example> privki sign --a1=20200722174505Z --csr=./web01.req.pem --profile=server --out=./web01.cert.pem
+`,
+	Annotations: mutatesVault,
+	Run: func(cmd *cobra.Command, args []string) {
+		a1, _ := cmd.Flags().GetString("a1")
+		csrFile, _ := cmd.Flags().GetString("csr")
+		out, _ := cmd.Flags().GetString("out")
+		profile, _ := cmd.Flags().GetString("profile")
+		days, _ := cmd.Flags().GetInt("days")
+		if csrFile == "NA" || out == "NA" {
+			log.Fatal("arguments --csr and --out are required")
+		}
+		csrPEM, err := ioutil.ReadFile(csrFile)
+		if err != nil {
+			log.Fatal(err)
+		}
+
+		vault := openVault()
+		intermediate := findIntermediate(commandContext(cmd), vault, a1)
+		passphrase, _ := cmd.Flags().GetString("passphrase")
+		issued, err := vault.SignCSR(commandContext(cmd), intermediate.ID, ca.SignOptions{
+			CSR:        csrPEM,
+			Profile:    profile,
+			Days:       days,
+			Passphrase: intermediatePassphrase(passphrase),
+		})
+		if err != nil {
+			log.Fatal(err)
+		}
+		if err := ioutil.WriteFile(out, []byte(issued.CertificatePEM), 0644); err != nil {
+			log.Fatal(err)
+		}
+		log.Printf("Certificate %v signed by A1 %v and saved as %v", issued.Serial, intermediate.ID, out)
+	},
+}
+
+// findIntermediate resolves an A1 ID given on the command line, or exits
+func findIntermediate(ctx context.Context, vault *ca.Vault, id string) *ca.Intermediate {
+	if id == "NA" || id == "" {
+		log.Fatal("argument --a1 is required, use privki list to find your A1 IDs")
+	}
+	intermediate, err := vault.Intermediate(ctx, id)
+	if err != nil {
+		log.Fatalf("A1 %v: %v", id, err)
+	}
+	return intermediate
+}
+
+func init() {
+	var a1 string
+	var commonName string
+	var org string
+	var ou string
+	var dnsNames []string
+	var ipAddresses []string
+	var profile string
+	var days int
+	var out string
+	var passphrase string
+
+	rootCmd.AddCommand(issueCmd)
+	issueCmd.Flags().StringVar(&a1, "a1", "NA", "flag --a1=<A1 ID> selects the issuing Intermediary CA")
+	issueCmd.Flags().StringVar(&commonName, "common-name", "", "flag --common-name=<name> sets the certificate common name")
+	issueCmd.Flags().StringVar(&org, "org", "", "flag --org=<organization> sets the certificate organization")
+	issueCmd.Flags().StringVar(&ou, "ou", "", "flag --ou=<unit> sets the certificate organizational unit")
+	issueCmd.Flags().StringSliceVar(&dnsNames, "dns", nil, "flag --dns=<name>[,<name>] adds DNS subject alternative names")
+	issueCmd.Flags().StringSliceVar(&ipAddresses, "ip", nil, "flag --ip=<address>[,<address>] adds IP subject alternative names")
+	issueCmd.Flags().StringVar(&profile, "profile", "server", "flag --profile=<server|client|user|name> selects the certificate extensions")
+	issueCmd.Flags().IntVar(&days, "days", 0, "flag --days=<n> sets the certificate validity in days, 365 or the days of the profile by default")
+	issueCmd.Flags().StringVar(&out, "out", "NA", "flag --out=<path prefix> sets where certificate and key are written (default is the common name)")
+	issueCmd.Flags().StringVar(&passphrase, "passphrase", "NA", "flag --passphrase=<A1_secret_passphrase> provides the A1 passphrase")
+
+	var signA1 string
+	var csr string
+	var signProfile string
+	var signDays int
+	var signOut string
+	var signPassphrase string
+
+	rootCmd.AddCommand(signCmd)
+	signCmd.Flags().StringVar(&signA1, "a1", "NA", "flag --a1=<A1 ID> selects the signing Intermediary CA")
+	signCmd.Flags().StringVar(&csr, "csr", "NA", "flag --csr=<file> sets the PEM certificate signing request")
+	signCmd.Flags().StringVar(&signProfile, "profile", "server", "flag --profile=<server|client|user|name> selects the certificate extensions")
+	signCmd.Flags().IntVar(&signDays, "days", 0, "flag --days=<n> sets the certificate validity in days, 365 or the days of the profile by default")
+	signCmd.Flags().StringVar(&signOut, "out", "NA", "flag --out=<file> sets where the signed certificate is written")
+	signCmd.Flags().StringVar(&signPassphrase, "passphrase", "NA", "flag --passphrase=<A1_secret_passphrase> provides the A1 passphrase")
+}
@@ -0,0 +1,98 @@
+package cmd
+
+import (
+	"encoding/json"
+	"fmt"
+	log "github.com/sirupsen/logrus"
+	"github.com/spf13/cobra"
+	"os"
+)
+
+// listCmd represents the list command
+var listCmd = &cobra.Command{
+	Use:   "list",
+	Short: "Lists Intermediary CAs (A1), or the certificates issued by one of them",
+	Long: `
+Use list subcommand to show the Intermediary CAs (A1) in this vault
+
+example> privki list
+
+or the certificates issued by a specific A1
+
+example> privki list --a1=20200722174505Z
+`,
+	Run: func(cmd *cobra.Command, args []string) {
+		a1, _ := cmd.Flags().GetString("a1")
+		vault := openVault()
+		if a1 == "NA" {
+			intermediates, err := vault.Intermediates(commandContext(cmd))
+			if err != nil {
+				log.Fatal(err)
+			}
+			printJSON(intermediates)
+			return
+		}
+		intermediate := findIntermediate(commandContext(cmd), vault, a1)
+		entries, err := vault.Certificates(commandContext(cmd), intermediate.ID)
+		if err != nil {
+			log.Fatal(err)
+		}
+		printJSON(entries)
+	},
+}
+
+// inspectCmd represents the inspect command
+var inspectCmd = &cobra.Command{
+	Use:   "inspect",
+	Short: "Shows the details of an Intermediary CA (A1) or a certificate it issued",
+	Long: `
+Use inspect subcommand to show the certificate details of an A1
+
+example> privki inspect --a1=20200722174505Z
+
+or of a certificate issued by it
+
+example> privki inspect --a1=20200722174505Z --serial=7E508DE26FAFF941DBAD044EB1E9FDA7
+`,
+	Run: func(cmd *cobra.Command, args []string) {
+		a1, _ := cmd.Flags().GetString("a1")
+		serial, _ := cmd.Flags().GetString("serial")
+		vault := openVault()
+		intermediate := findIntermediate(commandContext(cmd), vault, a1)
+		if serial == "NA" {
+			info, err := vault.InspectIntermediate(commandContext(cmd), intermediate.ID)
+			if err != nil {
+				log.Fatal(err)
+			}
+			printJSON(info)
+			return
+		}
+		info, err := vault.InspectCertificate(commandContext(cmd), intermediate.ID, serial)
+		if err != nil {
+			log.Fatal(err)
+		}
+		printJSON(info)
+	},
+}
+
+// printJSON writes value to stdout as indented json
+func printJSON(value interface{}) {
+	encoder := json.NewEncoder(os.Stdout)
+	encoder.SetIndent("", "  ")
+	if err := encoder.Encode(value); err != nil {
+		log.Fatal(fmt.Errorf("unable to format output: %v", err))
+	}
+}
+
+func init() {
+	var a1 string
+	var inspectA1 string
+	var serial string
+
+	rootCmd.AddCommand(listCmd)
+	listCmd.Flags().StringVar(&a1, "a1", "NA", "flag --a1=<A1 ID> lists the certificates issued by this A1")
+
+	rootCmd.AddCommand(inspectCmd)
+	inspectCmd.Flags().StringVar(&inspectA1, "a1", "NA", "flag --a1=<A1 ID> selects the Intermediary CA")
+	inspectCmd.Flags().StringVar(&serial, "serial", "NA", "flag --serial=<hex serial> selects a certificate issued by the A1")
+}
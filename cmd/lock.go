@@ -0,0 +1,82 @@
+package cmd
+
+import (
+	"context"
+	log "github.com/sirupsen/logrus"
+	"github.com/spf13/cobra"
+	"github.com/spf13/pflag"
+	"os"
+	"sfcert/openssl"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// mutatesVault annotates the commands that hold the vault lock for their whole run
+var mutatesVault = map[string]string{"vault": "mutating"}
+
+var lockTimeout time.Duration
+
+// unlockCommand releases the vault lock taken by lockCommand, a command that fails exits
+// through log.Fatal and its lock is released as failed
+var unlockCommand openssl.VaultUnlock = func(*error) {}
+
+// lockedContext is the context of the command holding the vault lock, the operations it
+// runs share the lock through it
+var lockedContext context.Context
+
+// lockCommand takes the vault lock before a mutating command runs, so that two privki
+// invocations never work on the same vault at once
+func lockCommand(cmd *cobra.Command, args []string) {
+	openssl.LockTimeout = lockTimeout
+	if cmd.Annotations["vault"] != mutatesVault["vault"] {
+		return
+	}
+	ctx, unlock, err := openssl.LockVault(cmd.Context(), describeCommand(cmd))
+	if err != nil {
+		log.Fatal(err)
+	}
+	lockedContext = ctx
+	unlockCommand = unlock
+}
+
+// commandContext is the context the operations of cmd run with
+func commandContext(cmd *cobra.Command) context.Context {
+	if lockedContext != nil {
+		return lockedContext
+	}
+	return cmd.Context()
+}
+
+// describeCommand names a command with the flags it was given, for the lock holder and
+// the vault history, secrets are left out
+func describeCommand(cmd *cobra.Command) string {
+	description := cmd.CommandPath()
+	cmd.Flags().Visit(func(flag *pflag.Flag) {
+		if strings.Contains(flag.Name, "passphrase") || strings.Contains(flag.Name, "password") || flag.Name == "lock-timeout" {
+			return
+		}
+		value := flag.Value.String()
+		if strings.ContainsAny(value, " \t\"'") {
+			value = strconv.Quote(value)
+		}
+		description += " --" + flag.Name + "=" + value
+	})
+	return description
+}
+
+func releaseCommand(cmd *cobra.Command, args []string) {
+	unlockCommand(nil)
+}
+
+func init() {
+	rootCmd.PersistentPreRun = func(cmd *cobra.Command, args []string) {
+		// secrets are read before the vault lock is taken, stdin may wait on an operator
+		if err := readPassphrases(cmd, os.Stdin); err != nil {
+			log.Fatal(err)
+		}
+		lockCommand(cmd, args)
+	}
+	rootCmd.PersistentPostRun = releaseCommand
+	rootCmd.PersistentFlags().DurationVar(&lockTimeout, "lock-timeout", openssl.LockTimeout, "flag --lock-timeout=<duration> sets how long to wait for another privki process to release the vault, 0 fails at once")
+}
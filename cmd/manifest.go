@@ -0,0 +1,133 @@
+package cmd
+
+import (
+	"fmt"
+	log "github.com/sirupsen/logrus"
+	"github.com/spf13/cobra"
+	"sfcert/manifest"
+)
+
+const manifestHelp = `
+The manifest (yaml or json) describes the Root CA (A0) and vault settings, the
+A1s and the named leaf profiles. An A1 without an id stands for the A1 of the
+vault with the same org and name_restrict, pathlen, validity_days and key_algo
+keep the privki defaults when left out. Passphrases are never written in the
+manifest, the passphrase of a new A1 is read from its passphrase_env variable
+or prompted for, like the Root CA passphrase.
+
+  vault:
+    org: alpha corp
+    common_name: alpha certifying authority
+    oid: 1.9.6.1.4.4.7.8.5
+    dr: true
+  intermediates:
+    - org: Alpha Chat Engineering Team
+      name_restrict: dbsvc.chat.alpha.com
+      pathlen: 0
+      validity_days: 3650
+      key_algo: ec:P-384
+      passphrase_env: DBSVC_A1_PASSPHRASE
+    - org: Alpha Chat Engineering Team
+      name_restrict: mqsvc.chat.alpha.com
+  profiles:
+    web:
+      usage: server
+      days: 90
+      organizational_unit: web
+
+Profile names are lowercase, each one picks the extensions of the server, client
+or user profile and sets the defaults of privki issue --profile=<name>.
+`
+
+// planCmd represents the plan command
+var planCmd = &cobra.Command{
+	Use:   "plan",
+	Short: "Shows the changes that bring the vault to a manifest",
+	Long: `
+Use plan subcommand to compare the vault with a manifest, without modifying
+it. Each change is a creation or an update that privki apply performs, or
+drift that apply leaves alone, as existing CAs are never modified nor removed.
+
+example> privki plan --manifest=/etc/privki/vault.yaml
+` + manifestHelp,
+	Run: func(cmd *cobra.Command, args []string) {
+		manifestFile, _ := cmd.Flags().GetString("manifest")
+		vaultManifest := loadManifest(manifestFile)
+
+		changes, err := manifest.Plan(commandContext(cmd), openRootVault(), vaultManifest)
+		if err != nil {
+			log.Fatal(err)
+		}
+		if changes == nil {
+			changes = []manifest.Change{}
+		}
+		printJSON(changes)
+	},
+}
+
+// applyCmd represents the apply command
+var applyCmd = &cobra.Command{
+	Use:   "apply",
+	Short: "Creates what a manifest describes and the vault misses",
+	Long: `
+Use apply subcommand to create the Root CA, the DR Root CA and the A1s of a
+manifest that are missing from the vault, and to save its leaf profiles. A
+vault that already matches the manifest is left untouched, so apply can run
+again after a failure, or after each edit of the manifest. Run privki init first.
+
+example> privki init
+example> privki plan --manifest=/etc/privki/vault.yaml
+example> privki apply --manifest=/etc/privki/vault.yaml --root-passphrase="A0_Password"
+` + manifestHelp,
+	Annotations: mutatesVault,
+	Run: func(cmd *cobra.Command, args []string) {
+		manifestFile, _ := cmd.Flags().GetString("manifest")
+		rootPassphrase, _ := cmd.Flags().GetString("root-passphrase")
+		drRootPassphrase, _ := cmd.Flags().GetString("dr-passphrase")
+		vaultManifest := loadManifest(manifestFile)
+
+		applied, err := manifest.Apply(commandContext(cmd), openRootVault(), vaultManifest, manifest.Passphrases{
+			Root: func() string {
+				return promptPassphrase(rootPassphrase, "\n\tRoot CA (A0) Passphrase: ")
+			},
+			DR: func() string {
+				return promptPassphrase(drRootPassphrase, "\n\tDR Root CA (DR A0) Passphrase: ")
+			},
+			Intermediate: func(name string) string {
+				return promptPassphrase("NA", fmt.Sprintf("\n\tEnter a new passphrase for the Intermediary CA (A1) %v: ", name))
+			},
+		})
+		if applied == nil {
+			applied = []manifest.Change{}
+		}
+		printJSON(applied)
+		if err != nil {
+			log.Fatal(err)
+		}
+	},
+}
+
+func loadManifest(manifestFile string) *manifest.Manifest {
+	if manifestFile == "NA" {
+		log.Fatal("argument --manifest is required")
+	}
+	vaultManifest, err := manifest.Load(manifestFile)
+	if err != nil {
+		log.Fatal(err)
+	}
+	return vaultManifest
+}
+
+func init() {
+	var planManifest string
+	var applyManifest string
+	var rootPassphrase string
+	var drRootPassphrase string
+
+	rootCmd.AddCommand(planCmd)
+	rootCmd.AddCommand(applyCmd)
+	planCmd.Flags().StringVar(&planManifest, "manifest", "NA", "flag --manifest=<file> sets the yaml or json manifest of the vault")
+	applyCmd.Flags().StringVar(&applyManifest, "manifest", "NA", "flag --manifest=<file> sets the yaml or json manifest of the vault")
+	applyCmd.Flags().StringVar(&rootPassphrase, "root-passphrase", "NA", "use --root-passphrase=<A0_secret_passphrase> to provide your A0 passphrase, it is prompted for otherwise")
+	applyCmd.Flags().StringVar(&drRootPassphrase, "dr-passphrase", "NA", "use --dr-passphrase=<DR_A0_secret_passphrase> to provide the DR A0 passphrase when it differs from the A0 one")
+}
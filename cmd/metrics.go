@@ -0,0 +1,71 @@
+package cmd
+
+import (
+	log "github.com/sirupsen/logrus"
+	"github.com/spf13/cobra"
+	"net/http"
+	"sfcert/metrics"
+)
+
+// metricsCmd represents the metrics command
+var metricsCmd = &cobra.Command{
+	Use:   "metrics",
+	Short: "Exports the health of the vault as Prometheus metrics",
+	Long: `
+Use metrics subcommand to monitor the vault from Prometheus.
+
+example> privki metrics serve --listen="localhost:9469"
+`,
+}
+
+// metricsServeCmd represents the metrics serve command
+var metricsServeCmd = &cobra.Command{
+	Use:   "serve",
+	Short: "Serves the vault gauges on /metrics for Prometheus to scrape",
+	Long: `
+Use metrics serve subcommand to expose the vault health on /metrics. The vault
+is read on every scrape, no passphrase is needed.
+
+example> privki metrics serve --listen="localhost:9469"
+
+The following gauges are exported
+
+  privki_ca_expiry_seconds{ca,level,subject}              seconds until each CA certificate expires
+  privki_certificate_expiry_seconds{ca,serial,subject}    seconds until each valid issued certificate expires
+  privki_crl_next_update_timestamp_seconds{ca,crl_number} nextUpdate of the current CRL of each CA
+  privki_certificates_issued{ca}                          certificates issued by each A1 and A2
+  privki_certificates_revoked{ca}                         certificates revoked, holds included
+  privki_certificates_held{ca}                            certificates on hold
+  privki_last_backup_timestamp_seconds                    last privki backup, 0 when none was recorded
+  privki_dr_enabled                                       1 when the vault has a DR Root CA
+  privki_dr_cross_signed{ca}                              1 when an A1 is cross signed by the DR Root CA
+  privki_vault_up                                         0 when the vault could not be read
+
+example alert rule> privki_certificate_expiry_seconds < 30 * 86400
+example alert rule> privki_crl_next_update_timestamp_seconds - time() < 86400
+example alert rule> time() - privki_last_backup_timestamp_seconds > 7 * 86400
+`,
+	Run: func(cmd *cobra.Command, args []string) {
+		listen, _ := cmd.Flags().GetString("listen")
+
+		mux := http.NewServeMux()
+		mux.Handle("/metrics", metrics.Handler(openVault()))
+		metricsServer := &http.Server{Addr: listen, Handler: mux}
+		go func() {
+			<-commandContext(cmd).Done()
+			metricsServer.Close()
+		}()
+		log.Infof("Serving the vault metrics on http://%v/metrics", listen)
+		if err := metricsServer.ListenAndServe(); err != http.ErrServerClosed {
+			log.Fatal(err)
+		}
+	},
+}
+
+func init() {
+	var listen string
+
+	rootCmd.AddCommand(metricsCmd)
+	metricsCmd.AddCommand(metricsServeCmd)
+	metricsServeCmd.Flags().StringVar(&listen, "listen", "localhost:9469", "flag --listen=<address:port> sets the address the metrics are served on")
+}
@@ -0,0 +1,127 @@
+package cmd
+
+import (
+	"context"
+	log "github.com/sirupsen/logrus"
+	"github.com/spf13/cobra"
+	"os"
+	"sfcert/notify"
+	"sfcert/openssl"
+	"time"
+)
+
+// notifyCmd represents the notify command
+var notifyCmd = &cobra.Command{
+	Use:   "notify",
+	Short: "Sends certificate expiry and CRL staleness alerts to webhooks and by email",
+	Long: `
+Use notify subcommand to alert the owners of the vault CAs of certificates
+about to expire, CA certificates included, and of CRLs due for an update.
+Alerts escalate at thresholds before the deadline, 90, 30 and 7 days unless
+the notify file says otherwise, and each alert is delivered once per threshold
+and destination, so notify can run from cron as often as needed.
+
+example> privki notify --config=/etc/privki/notify.yaml
+
+The notify file (yaml or json) sets the thresholds and their escalation
+contacts, the recipients of every alert, the owners of each A1 (also notified
+for its A2s) and the smtp server
+
+  thresholds:
+    - days: 90
+    - days: 30
+    - days: 7
+      email: [security@alpha.com]
+  crl_days: 7
+  email: [pki-team@alpha.com]
+  webhooks: [https://chat.alpha.com/hooks/pki]
+  owners:
+    20200722174505Z:
+      email: [chat-team@alpha.com]
+      webhooks: [https://chat.alpha.com/hooks/chat-team]
+  smtp:
+    host: mail.alpha.com
+    port: 25
+    from: privki@alpha.com
+
+Webhooks receive a JSON document {"source", "vault", "generated", "alerts"}.
+--dry-run lists the alerts and their destinations without sending them, and
+--interval keeps notify running, checking the vault at that interval.
+
+example> privki notify --config=/etc/privki/notify.yaml --dry-run
+example> privki notify --config=/etc/privki/notify.yaml --interval=6h
+`,
+	Run: func(cmd *cobra.Command, args []string) {
+		configFile, _ := cmd.Flags().GetString("config")
+		dryRun, _ := cmd.Flags().GetBool("dry-run")
+		interval, _ := cmd.Flags().GetString("interval")
+		if configFile == "NA" {
+			log.Fatal("argument --config is required")
+		}
+		config, err := notify.LoadConfig(configFile)
+		if err != nil {
+			log.Fatal(err)
+		}
+		notifier := &notify.Notifier{Vault: openVault(), Config: config, DryRun: dryRun}
+
+		if interval == "NA" {
+			if !runNotify(commandContext(cmd), notifier) {
+				os.Exit(1)
+			}
+			return
+		}
+		every, err := time.ParseDuration(interval)
+		if err != nil || every < time.Minute {
+			log.Fatal("--interval must be a duration of a minute or more, for example 6h")
+		}
+		for {
+			runNotify(commandContext(cmd), notifier)
+			select {
+			case <-commandContext(cmd).Done():
+				return
+			case <-time.After(every):
+			}
+		}
+	},
+}
+
+// runNotify delivers the alerts of the vault once, it reports whether every delivery succeeded
+func runNotify(ctx context.Context, notifier *notify.Notifier) bool {
+	// notify records what it sent in the vault, a round waits for the commands changing it
+	ctx, unlock, err := openssl.LockVault(ctx, "privki notify")
+	if err != nil {
+		log.Error(err)
+		return false
+	}
+	defer unlock(&err)
+	alerts, deliveries, err := notifier.Run(ctx)
+	if err != nil {
+		log.Error(err)
+		return false
+	}
+	if notifier.DryRun {
+		printJSON(map[string]interface{}{"alerts": alerts, "deliveries": deliveries})
+		return true
+	}
+	succeeded := true
+	for _, delivery := range deliveries {
+		if delivery.Error != "" {
+			succeeded = false
+			continue
+		}
+		log.Printf("Notified %v of %v alerts", delivery.Destination, delivery.Alerts)
+	}
+	log.Printf("%v alerts raised, %v destinations notified", len(alerts), len(deliveries))
+	return succeeded
+}
+
+func init() {
+	var configFile string
+	var dryRun bool
+	var interval string
+
+	rootCmd.AddCommand(notifyCmd)
+	notifyCmd.Flags().StringVar(&configFile, "config", "NA", "flag --config=<file> sets the yaml or json notify file")
+	notifyCmd.Flags().BoolVar(&dryRun, "dry-run", false, "flag --dry-run lists the alerts and destinations without sending them")
+	notifyCmd.Flags().StringVar(&interval, "interval", "NA", "flag --interval=<duration> keeps checking the vault, for example 6h")
+}
@@ -0,0 +1,270 @@
+package cmd
+
+import (
+	"fmt"
+	"github.com/howeyc/gopass"
+	log "github.com/sirupsen/logrus"
+	"github.com/spf13/cobra"
+	"github.com/spf13/pflag"
+	"io"
+	"os"
+	"sfcert/pkg/ca"
+	"strings"
+)
+
+// promptPassphrase returns the passphrase given on the command line,
+// or prompts for it when the flag was not set or is too short.
+func promptPassphrase(passphrase string, prompt string) string {
+	if passphrase != "NA" && len(passphrase) > 5 {
+		return passphrase
+	}
+	fmt.Printf(prompt)
+	typedPassphrase, _ := gopass.GetPasswdMasked()
+	return string(typedPassphrase)
+}
+
+// intermediatePassphrase prompts for the A1 passphrase unless one was given on the command line
+func intermediatePassphrase(passphrase string) string {
+	return promptPassphrase(passphrase, "\n\tIntermediary CA (A1) Passphrase: ")
+}
+
+// openVault opens the vault of this host, or exits
+func openVault() *ca.Vault {
+	vault, err := ca.Open()
+	if err != nil {
+		log.Fatal(err)
+	}
+	return vault
+}
+
+// openRootVault opens the vault of this host, or exits when it does not hold the Root CA (A0)
+func openRootVault() *ca.Vault {
+	vault := openVault()
+	if vault.Subordinate() {
+		log.Fatal(ca.ErrSubordinateVault)
+	}
+	return vault
+}
+
+// drPassphrase returns the DR A0 passphrase given on the command line, prompting for it when the
+// DR Root CA has a passphrase of its own, empty when the root passphrase unlocks it
+func drPassphrase(vault *ca.Vault, passphrase string) string {
+	if passphrase == "NA" && !(vault.DREnabled() && vault.DRPassphraseDistinct()) {
+		return ""
+	}
+	return promptPassphrase(passphrase, "\n\tDR Root CA (DR A0) Passphrase: ")
+}
+
+// passphraseCmd represents the passphrase command
+var passphraseCmd = &cobra.Command{
+	Use:   "passphrase",
+	Short: "Manages the passphrases of the CA keys",
+	Long: `
+Use passphrase subcommand to manage the passphrases protecting the private
+keys of the vault, see privki passphrase rotate.
+`,
+}
+
+// passphraseRotateCmd represents the passphrase rotate command
+var passphraseRotateCmd = &cobra.Command{
+	Use:   "rotate",
+	Short: "Re-encrypts the private key of a CA under a new passphrase",
+	Long: `
+Use rotate subcommand to change the passphrase of a CA key, --ca is a0, dr-a0
+or an A1 or A2 ID. The current passphrase is verified first, every key of the
+CA is re-encrypted aside and only then replaces the former one.
+
+example> privki passphrase rotate --ca=20200722174505Z --passphrase="myOldSecret" --new-passphrase="myNewSecret"
+
+The A0 and DR A0 are created with the same passphrase, rotating a0 rotates
+both while they share it. Rotating dr-a0 alone gives the DR A0 a passphrase
+of its own, then commands signing with it, privki create A1, ceremony sign,
+import ca, apply and dr drill, take --dr-passphrase. Rotating dr-a0 back to
+the A0 passphrase makes them share it again.
+
+example> privki passphrase rotate --ca=a0 --passphrase="A0_Password" --new-passphrase="New_A0_Password"
+example> privki passphrase rotate --ca=dr-a0 --passphrase="New_A0_Password" --new-passphrase="DR_A0_Password"
+
+The A1 archive in output/ holds the A1 key under the former passphrase, it is
+rebuilt from the A1 and encrypted with the new passphrase, or with
+--archive-passphrase. A cleartext intermed-ca-pkcs1.key.pem left by an older
+privki is removed. Keys already handed over, exported or packaged keep the
+passphrase they were exported with.
+`,
+	Annotations: mutatesVault,
+	Run: func(cmd *cobra.Command, args []string) {
+		id, _ := cmd.Flags().GetString("ca")
+		passphrase, _ := cmd.Flags().GetString("passphrase")
+		newPassphrase, _ := cmd.Flags().GetString("new-passphrase")
+		archivePassphrase, _ := cmd.Flags().GetString("archive-passphrase")
+		if id == "NA" {
+			log.Fatal("argument --ca is required, an A1 or A2 ID, a0 or dr-a0")
+		}
+
+		vault := openVault()
+		if id != "a0" && id != "dr-a0" {
+			findIntermediate(commandContext(cmd), vault, id)
+		}
+		passphrase = promptPassphrase(passphrase, fmt.Sprintf("\n\tCurrent passphrase of %v: ", id))
+		if newPassphrase == "NA" || len(newPassphrase) < 6 {
+			newPassphrase = promptPassphrase("NA", fmt.Sprintf("\n\tNew passphrase of %v: ", id))
+			if promptPassphrase("NA", "\n\tRepeat the new passphrase: ") != newPassphrase {
+				log.Fatal("the new passphrases do not match")
+			}
+		}
+		if archivePassphrase == "NA" {
+			archivePassphrase = ""
+		}
+		rotation, err := vault.RotatePassphrase(commandContext(cmd), ca.RotateRequest{
+			CA:                id,
+			Passphrase:        passphrase,
+			NewPassphrase:     newPassphrase,
+			ArchivePassphrase: archivePassphrase,
+		})
+		if rotation != nil {
+			printJSON(rotation)
+		}
+		if err != nil {
+			log.Fatal(err)
+		}
+		fmt.Printf("\n\n\t*************************************\n\tIMPORTANT: Please remember and note this Passphrase somewhere safe. \n\tYou will loose access to  your vault without this passphrase.\n\t*************************************\n")
+		if rotation.DRPassphraseDistinct {
+			log.Printf("The DR Root CA (DR A0) has a passphrase of its own, give it with --dr-passphrase")
+		}
+	},
+}
+
+func init() {
+	var id string
+	var passphrase string
+	var newPassphrase string
+	var archivePassphrase string
+
+	rootCmd.AddCommand(passphraseCmd)
+	passphraseCmd.AddCommand(passphraseRotateCmd)
+	passphraseRotateCmd.Flags().StringVar(&id, "ca", "NA", "flag --ca=<A1 or A2 ID|a0|dr-a0> selects the CA")
+	passphraseRotateCmd.Flags().StringVar(&passphrase, "passphrase", "NA", "flag --passphrase=<secret> is the current passphrase of the CA key")
+	passphraseRotateCmd.Flags().StringVar(&newPassphrase, "new-passphrase", "NA", "flag --new-passphrase=<secret> is the new passphrase of the CA key")
+	passphraseRotateCmd.Flags().StringVar(&archivePassphrase, "archive-passphrase", "NA", "flag --archive-passphrase=<secret> encrypts the rebuilt A1 archive with a passphrase other than the new one")
+}
+
+// addPassphraseSources gives every passphrase flag of cmd and its subcommands the
+// --<flag>-file, --<flag>-env and --<flag>-stdin sources, keeping secrets out of the
+// shell history and the process list
+func addPassphraseSources(cmd *cobra.Command) {
+	var names []string
+	cmd.Flags().VisitAll(func(flag *pflag.Flag) {
+		if strings.HasSuffix(flag.Name, "passphrase") && flag.Value.Type() == "string" {
+			names = append(names, flag.Name)
+		}
+	})
+	for _, name := range names {
+		cmd.Flags().String(name+"-file", "NA", fmt.Sprintf("flag --%v-file=<file> reads --%v from the first line of a file", name, name))
+		cmd.Flags().String(name+"-env", "NA", fmt.Sprintf("flag --%v-env=<variable> reads --%v from an environment variable", name, name))
+		cmd.Flags().Bool(name+"-stdin", false, fmt.Sprintf("flag --%v-stdin reads --%v from the first line of stdin", name, name))
+	}
+	for _, child := range cmd.Commands() {
+		addPassphraseSources(child)
+	}
+}
+
+// readPassphrases sets the passphrase flags of cmd given through a file, an environment
+// variable or stdin, before the command runs. A passphrase given on the command line is
+// still taken, with a warning, it shows in the process list and the shell history.
+func readPassphrases(cmd *cobra.Command, stdin io.Reader) error {
+	flags := cmd.Flags()
+	passphrases := map[string]string{}
+	stdinFlag := ""
+	var err error
+	flags.VisitAll(func(flag *pflag.Flag) {
+		if err != nil || flags.Lookup(flag.Name+"-stdin") == nil {
+			return
+		}
+		file, _ := flags.GetString(flag.Name + "-file")
+		variable, _ := flags.GetString(flag.Name + "-env")
+		fromStdin, _ := flags.GetBool(flag.Name + "-stdin")
+		sources := 0
+		for _, given := range []bool{flag.Changed, file != "NA", variable != "NA", fromStdin} {
+			if given {
+				sources++
+			}
+		}
+		if sources > 1 {
+			err = fmt.Errorf("give only one of --%v, --%v-file, --%v-env and --%v-stdin", flag.Name, flag.Name, flag.Name, flag.Name)
+			return
+		}
+		switch {
+		case flag.Changed:
+			log.Warnf("--%v on the command line shows in the process list and the shell history, give it with --%v-file, --%v-env or --%v-stdin", flag.Name, flag.Name, flag.Name, flag.Name)
+		case file != "NA":
+			passphrases[flag.Name], err = readPassphraseFile(file)
+		case variable != "NA":
+			value, found := os.LookupEnv(variable)
+			if !found || value == "" {
+				err = fmt.Errorf("the environment variable %v of --%v-env is not set", variable, flag.Name)
+				return
+			}
+			passphrases[flag.Name] = value
+		case fromStdin:
+			if stdinFlag != "" {
+				err = fmt.Errorf("stdin holds a single passphrase, --%v-stdin and --%v-stdin are both given", stdinFlag, flag.Name)
+				return
+			}
+			stdinFlag = flag.Name
+		}
+	})
+	if err != nil {
+		return err
+	}
+	if stdinFlag != "" {
+		if passphrases[stdinFlag], err = readPassphraseLine(stdin, "stdin"); err != nil {
+			return err
+		}
+	}
+	for name, passphrase := range passphrases {
+		if err := flags.Set(name, passphrase); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// readPassphraseFile reads a passphrase from the first line of file
+func readPassphraseFile(file string) (string, error) {
+	passphraseFile, err := os.Open(file)
+	if err != nil {
+		return "", err
+	}
+	defer passphraseFile.Close()
+	if info, err := passphraseFile.Stat(); err == nil && info.Mode().Perm()&0077 != 0 {
+		log.Warnf("The passphrase file %v can be read by other users, chmod 600 it", file)
+	}
+	return readPassphraseLine(passphraseFile, file)
+}
+
+// readPassphraseLine reads the first line of reader byte by byte, leaving the rest unread
+func readPassphraseLine(reader io.Reader, source string) (string, error) {
+	var line []byte
+	b := make([]byte, 1)
+	for {
+		n, err := reader.Read(b)
+		if n == 1 {
+			if b[0] == '\n' {
+				break
+			}
+			line = append(line, b[0])
+			continue
+		}
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return "", fmt.Errorf("unable to read the passphrase from %v: %v", source, err)
+		}
+	}
+	passphrase := strings.TrimSuffix(string(line), "\r")
+	if passphrase == "" {
+		return "", fmt.Errorf("no passphrase in %v", source)
+	}
+	return passphrase, nil
+}
@@ -0,0 +1,102 @@
+package cmd
+
+import (
+	logtest "github.com/sirupsen/logrus/hooks/test"
+	"github.com/spf13/cobra"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// passphraseCommand returns a command with two passphrase flags and their sources, parsed from args
+func passphraseCommand(t *testing.T, args ...string) *cobra.Command {
+	t.Helper()
+	cmd := &cobra.Command{Use: "sign"}
+	cmd.Flags().String("passphrase", "NA", "")
+	cmd.Flags().String("root-passphrase", "NA", "")
+	addPassphraseSources(cmd)
+	if err := cmd.ParseFlags(args); err != nil {
+		t.Fatal(err)
+	}
+	return cmd
+}
+
+func TestReadPassphrases(t *testing.T) {
+	dir, err := ioutil.TempDir("", "privki-passphrase")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	passphraseFile := filepath.Join(dir, "a0")
+	if err := ioutil.WriteFile(passphraseFile, []byte("root-secret\nleft alone\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	os.Setenv("PRIVKI_TEST_A1", "a1-secret")
+	defer os.Unsetenv("PRIVKI_TEST_A1")
+
+	cmd := passphraseCommand(t, "--root-passphrase-file="+passphraseFile, "--passphrase-env=PRIVKI_TEST_A1")
+	if err := readPassphrases(cmd, strings.NewReader("")); err != nil {
+		t.Fatal(err)
+	}
+	for name, want := range map[string]string{"root-passphrase": "root-secret", "passphrase": "a1-secret"} {
+		if got, _ := cmd.Flags().GetString(name); got != want {
+			t.Errorf("--%v is %q, want %q", name, got, want)
+		}
+	}
+
+	stdin := strings.NewReader("stdin-secret\r\nnext line")
+	cmd = passphraseCommand(t, "--passphrase-stdin")
+	if err := readPassphrases(cmd, stdin); err != nil {
+		t.Fatal(err)
+	}
+	if got, _ := cmd.Flags().GetString("passphrase"); got != "stdin-secret" {
+		t.Errorf("--passphrase-stdin read %q", got)
+	}
+	if rest, _ := ioutil.ReadAll(stdin); string(rest) != "next line" {
+		t.Errorf("stdin was read past the passphrase, %q is left", rest)
+	}
+
+	for _, failing := range []struct {
+		name  string
+		args  []string
+		stdin string
+	}{
+		{"argv and env", []string{"--passphrase=a1-secret", "--passphrase-env=PRIVKI_TEST_A1"}, ""},
+		{"file and stdin", []string{"--passphrase-file=" + passphraseFile, "--passphrase-stdin"}, "a1-secret\n"},
+		{"two flags on stdin", []string{"--passphrase-stdin", "--root-passphrase-stdin"}, "a1-secret\nroot-secret\n"},
+		{"unset variable", []string{"--passphrase-env=PRIVKI_TEST_UNSET"}, ""},
+		{"missing file", []string{"--passphrase-file=" + filepath.Join(dir, "missing")}, ""},
+		{"empty stdin", []string{"--passphrase-stdin"}, "\n"},
+	} {
+		cmd := passphraseCommand(t, failing.args...)
+		if err := readPassphrases(cmd, strings.NewReader(failing.stdin)); err == nil {
+			t.Errorf("%v: readPassphrases accepted %v", failing.name, failing.args)
+		}
+		if got, _ := cmd.Flags().GetString("root-passphrase"); got != "NA" {
+			t.Errorf("%v: --root-passphrase was set to %q", failing.name, got)
+		}
+	}
+}
+
+func TestReadPassphrasesArgvWarning(t *testing.T) {
+	hook := logtest.NewGlobal()
+	defer hook.Reset()
+	cmd := passphraseCommand(t, "--passphrase=a1-secret", "--root-passphrase-env=PRIVKI_TEST_A0")
+	os.Setenv("PRIVKI_TEST_A0", "root-secret")
+	defer os.Unsetenv("PRIVKI_TEST_A0")
+	if err := readPassphrases(cmd, strings.NewReader("")); err != nil {
+		t.Fatal(err)
+	}
+	if got, _ := cmd.Flags().GetString("passphrase"); got != "a1-secret" {
+		t.Errorf("--passphrase given on the command line is %q", got)
+	}
+	var warnings []string
+	for _, entry := range hook.AllEntries() {
+		warnings = append(warnings, entry.Message)
+	}
+	if len(warnings) != 1 || !strings.Contains(warnings[0], "--passphrase on the command line") {
+		t.Errorf("readPassphrases logged %q, want a single warning about --passphrase", warnings)
+	}
+}
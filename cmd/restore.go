@@ -23,6 +23,10 @@ func removeContents(dir string) error {
 		return err
 	}
 	for _, name := range names {
+		// the lock of the running restore stays in place
+		if name == openssl.VaultLockFileName {
+			continue
+		}
 		err = os.RemoveAll(filepath.Join(dir, name))
 		if err != nil {
 			return err
@@ -89,7 +93,10 @@ func traverseAndExtractConfig(configArchiveReader *zip.ReadCloser) {
 
 //internal function to traverse the encrypted archive, decrypt and extract the PKI archive
 func traverseAndExtractPki(pkiArchiveReader *zip.ReadCloser) {
-	pkiPath := openssl.GetPkiPath()
+	pkiPath, err := openssl.GetPkiPath()
+	if err != nil {
+		log.Fatal(err)
+	}
 	os.Mkdir(openssl.GetPkiBaseDir(),openssl.DefaultDirPerms)
 
 	// Iterate through each file/dir found in
@@ -163,7 +170,9 @@ func decryptAndRestore(sourcePath string) {
 	if err != nil {
 		log.Fatal(err)
 	}
-	openssl.SetBackupRestorePassword()
+	if err := openssl.SetBackupRestorePassword(); err != nil {
+		log.Fatal(err)
+	}
 	os.Mkdir(openssl.GetPkiBaseDir(),openssl.DefaultDirPerms)
 
 	// Create a reader out of the encrypted config archive
@@ -0,0 +1,77 @@
+package cmd
+
+import (
+	log "github.com/sirupsen/logrus"
+	"github.com/spf13/cobra"
+	"sfcert/pkg/ca"
+)
+
+// revokeCmd represents the revoke command
+var revokeCmd = &cobra.Command{
+	Use:   "revoke",
+	Short: "Revokes a certificate issued by an Intermediary CA (A1)",
+	Long: `
+Use revoke subcommand to revoke a certificate issued by one of the
+Intermediary CAs (A1) in this vault. The A1 revocation list is
+regenerated right away.
+
+example> privki revoke --a1=20200722174505Z --serial=7E508DE26FAFF941DBAD044EB1E9FDA7 --reason=keyCompromise
+
+supported reasons are unspecified, keyCompromise, CACompromise,
+affiliationChanged, superseded and cessationOfOperation
+
+certificateHold suspends a certificate, for example during an investigation,
+until it is released with privki unhold or revoked for good with another
+reason. A hold needs a --comment, which the audit trail records along with
+the user and host.
+
+example> privki revoke --a1=20200722174505Z --serial=7E508DE26FAFF941DBAD044EB1E9FDA7 --reason=certificateHold \
+			--comment="INC-1234 suspicious logins"
+`,
+	Annotations: mutatesVault,
+	Run: func(cmd *cobra.Command, args []string) {
+		a1, _ := cmd.Flags().GetString("a1")
+		serial, _ := cmd.Flags().GetString("serial")
+		reason, _ := cmd.Flags().GetString("reason")
+		if serial == "NA" {
+			log.Fatal("argument --serial is required")
+		}
+
+		vault := openVault()
+		intermediate := findIntermediate(commandContext(cmd), vault, a1)
+		passphrase, _ := cmd.Flags().GetString("passphrase")
+		comment, _ := cmd.Flags().GetString("comment")
+		if comment == "NA" {
+			comment = ""
+		}
+		err := vault.Revoke(commandContext(cmd), intermediate.ID, ca.RevokeOptions{
+			Serial:     serial,
+			Reason:     reason,
+			Passphrase: intermediatePassphrase(passphrase),
+			Comment:    comment,
+		})
+		if err != nil {
+			log.Fatal(err)
+		}
+		if reason == "certificateHold" {
+			log.Printf("Certificate %v put on hold, revocation list updated at %v/crl/intermed-ca.crl, release it with privki unhold", serial, intermediate.Dir)
+			return
+		}
+		log.Printf("Certificate %v revoked (%v), revocation list updated at %v/crl/intermed-ca.crl", serial, reason, intermediate.Dir)
+	},
+}
+
+func init() {
+	var a1 string
+	var serial string
+	var reason string
+	var passphrase string
+	var comment string
+
+	rootCmd.AddCommand(revokeCmd)
+	revokeCmd.Flags().StringVar(&a1, "a1", "NA", "flag --a1=<A1 ID> selects the issuing Intermediary CA")
+	revokeCmd.Flags().StringVar(&serial, "serial", "NA", "flag --serial=<hex serial> selects the certificate to revoke")
+	revokeCmd.Flags().StringVar(&reason, "reason", "unspecified", "flag --reason=<reason> sets the revocation reason")
+	revokeCmd.Flags().StringVar(&passphrase, "passphrase", "NA", "flag --passphrase=<A1_secret_passphrase> provides the A1 passphrase")
+	revokeCmd.Flags().StringVar(&comment, "comment", "NA", "flag --comment=<text> says why, recorded in the audit trail")
+}
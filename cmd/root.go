@@ -1,12 +1,16 @@
 package cmd
 
 import (
+	"context"
 	homedir "github.com/mitchellh/go-homedir"
 	log "github.com/sirupsen/logrus"
 	"github.com/spf13/cobra"
 	"github.com/spf13/viper"
 	"os"
+	"os/signal"
 	"sfcert/openssl"
+	"sfcert/pkg/ca"
+	"syscall"
 )
 
 var cfgFile string
@@ -55,6 +59,7 @@ example> privki backup --destination="/media/usbdrive1/"
 you can find more help, by using the --help flag after there subcommands.
 example> privki backup --help
 `,
+	Annotations: mutatesVault,
 	Run: func(cmd *cobra.Command, args []string) {
 		destination, _ := cmd.Flags().GetString("destination")
 		if destination == "NA" || destination == "" || destination == " " {
@@ -62,6 +67,9 @@ example> privki backup --help
 			log.Fatal("argument --destination is required")
 		}
 		encryptedArchiver(destination)
+		if err := openssl.RecordBackup(); err != nil {
+			log.Warnf("Unable to record the backup time: %v", err)
+		}
 	},
 }
 
@@ -78,6 +86,7 @@ you can find more help, by using the --help flag after there subcommands.
 
 example> privki restore --help
 `,
+	Annotations: mutatesVault,
 	Run: func(cmd *cobra.Command, args []string) {
 		source, _ := cmd.Flags().GetString("source")
 		if source == "NA" || source == "" || source == " " {
@@ -91,9 +100,9 @@ example> privki restore --help
 // createCertCmd represent sub command for PKI cert object creation
 var createCertCmd = &cobra.Command{
 	Use:   "create",
-	Short: "create subcommand is used to create A0 and A1 CA Objects",
-	Long: `You can use create subcommand to create A0 or A1 CA Object
-by using respective subcommands A0 and A1.
+	Short: "create subcommand is used to create A0, A1 and A2 CA Objects",
+	Long: `You can use create subcommand to create A0, A1 or A2 CA Object
+by using respective subcommands A0, A1 and A2.
 
 the following example shows how to restore an active PKI root config to a directory of choice
 example> privki restore --source="/media/usbdrive1/"
@@ -109,12 +118,28 @@ example> privki restore --help
 // Execute adds all child commands to the root command and sets flags appropriately.
 // This is called by main.main(). It only needs to happen once to the rootCmd.
 func Execute() {
-	if err := rootCmd.Execute(); err != nil {
+	addPassphraseSources(rootCmd)
+	if err := rootCmd.ExecuteContext(interruptContext()); err != nil {
 		log.Error(err)
 		os.Exit(1)
 	}
 }
 
+// interruptContext is cancelled on SIGINT or SIGTERM, which stops the openssl command of the
+// running operation and the servers. A second signal exits at once.
+func interruptContext() context.Context {
+	ctx, cancel := context.WithCancel(context.Background())
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, os.Interrupt, syscall.SIGTERM)
+	go func() {
+		received := <-signals
+		signal.Stop(signals)
+		log.Warnf("Received %v, stopping, send it again to exit at once", received)
+		cancel()
+	}()
+	return ctx
+}
+
 func init() {
 	cobra.OnInitialize(initConfig)
 	cobra.OnInitialize(checkJson)
@@ -142,19 +167,64 @@ default location. If you want the repository under a specific location,
 then you can use --pki-path option as shown below
 
 example> privki init_pki --pki-path=<full path where you want the PKI respository to be located>
+
+Teams operating an A1 handed over with privki a1 package can initialize
+a subordinate vault from the package instead. It holds the A1 and the public
+Root CA certificates only, so issue, revoke, CRL, list and export work, while
+commands that need the Root CA (A0) keys, such as create A0 and create A1, refuse.
+
+example> privki init --subordinate=./chat.a1pkg --key=./chat-team.key.pem --trust=./alpha-trust.pem
+
+init --subordinate refuses on a host that already has a vault, use privki a1 unpack
+to add an A1 to it.
 `,
+		Annotations: mutatesVault,
 		Run: func(cmd *cobra.Command, args []string) {
 
 			log.Printf("initPki\n")
-			// check if OpenSSL is installed on the system
-			openssl.CheckOpenSSL()
-			// check if the installed openssl supports aes-256-cbc chiper
-			openssl.CheckAES256Cipher()
-			// Initialize PKI repository
-			openssl.InitPki()
+			subordinate, _ := cmd.Flags().GetString("subordinate")
+			if subordinate != "NA" {
+				key, _ := cmd.Flags().GetString("key")
+				keyPassphrase, _ := cmd.Flags().GetString("key-passphrase")
+				trust, _ := cmd.Flags().GetString("trust")
+				if key == "NA" || trust == "NA" {
+					log.Fatal("arguments --key and --trust are required with --subordinate")
+				}
+				if keyPassphrase == "NA" {
+					keyPassphrase = ""
+				}
+				trusted, err := openssl.ReadCertificates(trust)
+				if err != nil {
+					log.Fatal(err)
+				}
+				_, intermediate, err := ca.InitSubordinate(commandContext(cmd), ca.UnpackOptions{
+					PackageFile:   subordinate,
+					Trusted:       trusted,
+					KeyFile:       key,
+					KeyPassphrase: keyPassphrase,
+				})
+				if err != nil {
+					log.Fatal(err)
+				}
+				log.Printf("Subordinate vault initialized with Intermediary CA (A1) %v", intermediate.ID)
+				return
+			}
+			// check the installed openssl, and initialize the PKI repository
+			if _, err := ca.Init(commandContext(cmd)); err != nil {
+				log.Fatal(err)
+			}
 		},
 	}
 
+	var subordinate string
+	var key string
+	var keyPassphrase string
+	var trust string
+	initPkiCmd.Flags().StringVar(&subordinate, "subordinate", "NA", "flag --subordinate=<file> initializes a subordinate vault from an A1 package")
+	initPkiCmd.Flags().StringVar(&key, "key", "NA", "flag --key=<file> sets the private key the A1 package is addressed to")
+	initPkiCmd.Flags().StringVar(&keyPassphrase, "key-passphrase", "NA", "flag --key-passphrase=<secret> unlocks the --key file if it is encrypted")
+	initPkiCmd.Flags().StringVar(&trust, "trust", "NA", "flag --trust=<file> sets the PEM Root CA certificates the package must be signed by")
+
 	rootCmd.AddCommand(initPkiCmd)
 	rootCmd.AddCommand(createCertCmd)
 	rootCmd.AddCommand(backupConfigCmd)
@@ -184,7 +254,7 @@ func initConfig() {
 
 	// If a config file is found, read it in.
 	if err := viper.ReadInConfig(); err == nil {
-		log.Printf("Using config file:", viper.ConfigFileUsed())
+		log.Printf("Using config file: %v", viper.ConfigFileUsed())
 	}
 }
 
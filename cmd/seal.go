@@ -0,0 +1,87 @@
+package cmd
+
+import (
+	"fmt"
+	log "github.com/sirupsen/logrus"
+	"github.com/spf13/cobra"
+	"sfcert/pkg/ca"
+)
+
+// lockVaultCmd represents the lock command
+var lockVaultCmd = &cobra.Command{
+	Use:   "lock",
+	Short: "Encrypts the vault at rest",
+	Long: `
+Use lock subcommand to seal the PKI dir of an encrypted vault once you are
+done with it. The whole vault, keys, certificates, databases, audit trail and
+history, is written to ~/.privki/<root_cert_uid>.sealed with AES-256-GCM under
+a master key derived from the vault passphrase, and the PKI dir is removed.
+Every command but privki unlock then fails until the vault is unlocked.
+
+--encrypt turns the encryption of a vault on, or changes its passphrase, and
+locks it.
+
+example> privki lock --encrypt --vault-passphrase="myVaultPassphrase"
+example> privki lock
+`,
+	Annotations: mutatesVault,
+	Run: func(cmd *cobra.Command, args []string) {
+		encrypt, _ := cmd.Flags().GetBool("encrypt")
+		if !encrypt {
+			if err := ca.Lock(commandContext(cmd)); err != nil {
+				log.Fatal(err)
+			}
+			return
+		}
+		passphrase, _ := cmd.Flags().GetString("vault-passphrase")
+		if passphrase == "NA" || len(passphrase) < 6 {
+			passphrase = promptPassphrase("NA", "\n\tNew Vault Passphrase: ")
+			if promptPassphrase("NA", "\n\tRepeat the Vault Passphrase: ") != passphrase {
+				log.Fatal("the vault passphrases do not match")
+			}
+		}
+		fmt.Printf("\n\n\t*************************************\n\tIMPORTANT: Please remember and note this Passphrase somewhere safe. \n\tYou will loose access to  your vault without this passphrase.\n\t*************************************\n")
+		if err := ca.Encrypt(commandContext(cmd), passphrase); err != nil {
+			log.Fatal(err)
+		}
+	},
+}
+
+// unlockVaultCmd represents the unlock command
+var unlockVaultCmd = &cobra.Command{
+	Use:   "unlock",
+	Short: "Decrypts an encrypted vault for use",
+	Long: `
+Use unlock subcommand to decrypt an encrypted vault into its PKI dir, where
+every privki command works on it as usual until privki lock. The master key is
+kept in ~/.privki/unsealed.key while the vault is unlocked, so that privki lock
+needs no passphrase.
+
+--decrypt turns the encryption of the vault off and leaves it unlocked for good.
+
+example> privki unlock --vault-passphrase="myVaultPassphrase"
+example> privki unlock --decrypt
+`,
+	Annotations: mutatesVault,
+	Run: func(cmd *cobra.Command, args []string) {
+		decrypt, _ := cmd.Flags().GetBool("decrypt")
+		passphrase, _ := cmd.Flags().GetString("vault-passphrase")
+		passphrase = promptPassphrase(passphrase, "\n\tVault Passphrase: ")
+		if err := ca.Unlock(commandContext(cmd), passphrase, decrypt); err != nil {
+			log.Fatal(err)
+		}
+	},
+}
+
+func init() {
+	var vaultPassphrase string
+	var encrypt bool
+	var decrypt bool
+
+	rootCmd.AddCommand(lockVaultCmd)
+	rootCmd.AddCommand(unlockVaultCmd)
+	lockVaultCmd.Flags().BoolVar(&encrypt, "encrypt", false, "flag --encrypt encrypts the vault under a new passphrase, turning encryption on or changing its passphrase")
+	lockVaultCmd.Flags().StringVar(&vaultPassphrase, "vault-passphrase", "NA", "flag --vault-passphrase=<my_secret_passphrase> sets the new vault passphrase with --encrypt")
+	unlockVaultCmd.Flags().BoolVar(&decrypt, "decrypt", false, "flag --decrypt turns the encryption of the vault off")
+	unlockVaultCmd.Flags().StringVar(&vaultPassphrase, "vault-passphrase", "NA", "flag --vault-passphrase=<my_secret_passphrase> sets the vault passphrase")
+}
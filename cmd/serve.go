@@ -0,0 +1,99 @@
+package cmd
+
+import (
+	log "github.com/sirupsen/logrus"
+	"github.com/spf13/cobra"
+	"sfcert/server"
+)
+
+// serveCmd represents the serve command
+var serveCmd = &cobra.Command{
+	Use:   "serve",
+	Short: "Serves vault operations as a JSON HTTP API with mTLS client authentication",
+	Long: `
+Use serve subcommand to expose vault operations (list, inspect, issue,
+sign CSR, revoke, fetch CRL and chain) as a JSON HTTP API. Clients
+authenticate with certificates issued from this vault, and each
+operation is authorized by the client certificate subject.
+
+First issue a server certificate and a client certificate from one of your A1s
+
+example> privki issue --a1=20200722174505Z --common-name="pki.alpha.com" --dns="pki.alpha.com" --profile=server --out=/etc/privki/server
+example> privki issue --a1=20200722174505Z --common-name="deploy-bot" --ou="platform" --profile=client --out=./deploy-bot
+
+then describe who may do what in a policy file (yaml or json)
+
+  authorization:
+    - operations: [list, inspect, crl, chain]
+      subjects:
+        - ou: platform
+    - operations: [issue, sign, revoke]
+      intermediates: [20200722174505Z]
+      subjects:
+        - cn: deploy-bot
+          ou: platform
+
+and start the server
+
+example> privki serve --listen=":8443" --tls-cert=/etc/privki/server.cert.pem --tls-key=/etc/privki/server.key.pem --policy=/etc/privki/policy.yaml
+
+The API offers the following endpoints
+
+  GET  /v1/intermediates                                      (list)
+  GET  /v1/intermediates/{id}                                 (inspect)
+  GET  /v1/intermediates/{id}/crl                             (crl)
+  GET  /v1/intermediates/{id}/chain?dr=true                   (chain)
+  GET  /v1/intermediates/{id}/certificates                    (list)
+  POST /v1/intermediates/{id}/certificates                    (issue)
+  GET  /v1/intermediates/{id}/certificates/{serial}           (inspect)
+  POST /v1/intermediates/{id}/certificates/{serial}/revoke    (revoke)
+  POST /v1/intermediates/{id}/certificates/{serial}/unhold    (revoke)
+  POST /v1/intermediates/{id}/sign                            (sign)
+
+Signing operations need the A1 passphrase in the "passphrase" field of the request body.
+Holds and releases also need a "comment", the audit trail records it with the client
+certificate subject.
+`,
+	Run: func(cmd *cobra.Command, args []string) {
+		listen, _ := cmd.Flags().GetString("listen")
+		tlsCert, _ := cmd.Flags().GetString("tls-cert")
+		tlsKey, _ := cmd.Flags().GetString("tls-key")
+		policyFile, _ := cmd.Flags().GetString("policy")
+		if tlsCert == "NA" || tlsKey == "NA" {
+			log.Fatal("arguments --tls-cert and --tls-key are required")
+		}
+		if policyFile == "NA" {
+			log.Fatal("argument --policy is required")
+		}
+
+		policy, err := server.LoadPolicy(policyFile)
+		if err != nil {
+			log.Fatal(err)
+		}
+
+		apiServer := server.New(server.Config{
+			Listen:  listen,
+			TLSCert: tlsCert,
+			TLSKey:  tlsKey,
+			Vault:   openVault(),
+			Policy:  policy,
+		})
+		if err := apiServer.ListenAndServe(commandContext(cmd)); err != nil {
+			log.Fatal(err)
+		}
+		log.Printf("privki API stopped")
+	},
+}
+
+func init() {
+	var listen string
+	var tlsCert string
+	var tlsKey string
+	var policyFile string
+
+	rootCmd.AddCommand(serveCmd)
+	serveCmd.Flags().StringVar(&listen, "listen", ":8443", "flag --listen=<address:port> sets the address the API listens on")
+	serveCmd.Flags().StringVar(&tlsCert, "tls-cert", "NA", "flag --tls-cert=<file> sets the PEM server certificate, ideally issued from this vault")
+	serveCmd.Flags().StringVar(&tlsKey, "tls-key", "NA", "flag --tls-key=<file> sets the PEM private key of the server certificate")
+	serveCmd.Flags().StringVar(&policyFile, "policy", "NA", "flag --policy=<file> sets the yaml or json authorization policy")
+}
@@ -0,0 +1,90 @@
+package cmd
+
+import (
+	log "github.com/sirupsen/logrus"
+	"github.com/spf13/cobra"
+	"sfcert/pkg/ca"
+	"strings"
+)
+
+// unholdCmd represents the unhold command
+var unholdCmd = &cobra.Command{
+	Use:   "unhold",
+	Short: "Releases a certificate put on hold (certificateHold)",
+	Long: `
+Use unhold subcommand to release a certificate suspended with
+privki revoke --reason=certificateHold. It is valid again and the A1
+revocation list is regenerated without it. The --comment is recorded
+in the audit trail along with the user and host.
+
+example> privki unhold --a1=20200722174505Z --serial=7E508DE26FAFF941DBAD044EB1E9FDA7 --comment="INC-1234 closed, false positive"
+
+The audit trail of holds, releases and revocations is shown by privki audit.
+`,
+	Annotations: mutatesVault,
+	Run: func(cmd *cobra.Command, args []string) {
+		a1, _ := cmd.Flags().GetString("a1")
+		serial, _ := cmd.Flags().GetString("serial")
+		comment, _ := cmd.Flags().GetString("comment")
+		passphrase, _ := cmd.Flags().GetString("passphrase")
+		if serial == "NA" || comment == "NA" {
+			log.Fatal("arguments --serial and --comment are required")
+		}
+
+		vault := openVault()
+		intermediate := findIntermediate(commandContext(cmd), vault, a1)
+		err := vault.Unhold(commandContext(cmd), intermediate.ID, ca.RevokeOptions{
+			Serial:     serial,
+			Passphrase: intermediatePassphrase(passphrase),
+			Comment:    comment,
+		})
+		if err != nil {
+			log.Fatal(err)
+		}
+		log.Printf("Certificate %v released from hold, revocation list updated at %v/crl/intermed-ca.crl", serial, intermediate.Dir)
+	},
+}
+
+// auditCmd represents the audit command
+var auditCmd = &cobra.Command{
+	Use:   "audit",
+	Short: "Shows the audit trail of holds, releases and revocations",
+	Long: `
+Use audit subcommand to show who put certificates on hold, released or
+revoked them, when and why, oldest first. --serial narrows it to a certificate.
+
+example> privki audit
+example> privki audit --serial=7E508DE26FAFF941DBAD044EB1E9FDA7
+`,
+	Run: func(cmd *cobra.Command, args []string) {
+		serial, _ := cmd.Flags().GetString("serial")
+		vault := openVault()
+		entries, err := vault.AuditLog(commandContext(cmd))
+		if err != nil {
+			log.Fatal(err)
+		}
+		selected := []ca.AuditEntry{}
+		for _, entry := range entries {
+			if serial == "NA" || strings.EqualFold(entry.Serial, serial) {
+				selected = append(selected, entry)
+			}
+		}
+		printJSON(selected)
+	},
+}
+
+func init() {
+	var a1 string
+	var serial string
+	var comment string
+	var passphrase string
+	var auditSerial string
+
+	rootCmd.AddCommand(unholdCmd)
+	rootCmd.AddCommand(auditCmd)
+	unholdCmd.Flags().StringVar(&a1, "a1", "NA", "flag --a1=<A1 ID> selects the issuing Intermediary CA")
+	unholdCmd.Flags().StringVar(&serial, "serial", "NA", "flag --serial=<hex serial> selects the certificate to release")
+	unholdCmd.Flags().StringVar(&comment, "comment", "NA", "flag --comment=<text> says why, recorded in the audit trail")
+	unholdCmd.Flags().StringVar(&passphrase, "passphrase", "NA", "flag --passphrase=<A1_secret_passphrase> provides the A1 passphrase")
+	auditCmd.Flags().StringVar(&auditSerial, "serial", "NA", "flag --serial=<hex serial> shows the entries of a certificate")
+}
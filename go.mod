@@ -12,11 +12,15 @@ require (
 	github.com/kisielk/errcheck v1.6.2 // indirect
 	github.com/markbates/pkger v0.17.0
 	github.com/mitchellh/go-homedir v1.1.0
+	github.com/prometheus/client_golang v1.10.0
 	github.com/rs/xid v1.2.1
 	github.com/sirupsen/logrus v1.8.0
 	github.com/spf13/cobra v1.1.3
+	github.com/spf13/pflag v1.0.5
 	github.com/spf13/viper v1.7.1
 	github.com/stretchr/testify v1.7.0 // indirect
 	github.com/yeka/zip v0.0.0-20180914125537-d046722c6feb
+	go.etcd.io/bbolt v1.3.6
+	golang.org/x/crypto v0.0.0-20210921155107-089bfa567519
 	honnef.co/go/tools v0.3.3 // indirect
 )
@@ -0,0 +1,118 @@
+// Package vaulttest builds throwaway vaults for the tests of the privki packages.
+// The vaults live in a temporary HOME and are removed with the test.
+package vaulttest
+
+import (
+	"context"
+	"github.com/markbates/pkger"
+	"github.com/markbates/pkger/pkging/mem"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"runtime"
+	"sfcert/pkg/ca"
+	"strings"
+	"sync"
+	"testing"
+)
+
+// Passphrases of the CAs created by New
+const (
+	RootPassphrase = "root-passphrase"
+	A1Passphrase   = "a1-passphrase"
+)
+
+// Organization is the organization name of the vaults created by New
+const Organization = "Vault Test"
+
+// ClassOID is the class definition OID of the vaults created by New, OpenSSL 3
+// already defines openssl.DefaultOID
+const ClassOID = "1.3.6.1.4.1.55555.1"
+
+var templates struct {
+	sync.Once
+	err error
+}
+
+// loadTemplates applies the CA configuration templates embedded in the privki binary,
+// the packages under test are not linked with it.
+func loadTemplates() error {
+	templates.Do(func() {
+		_, source, _, _ := runtime.Caller(0)
+		pkged, err := ioutil.ReadFile(filepath.Join(filepath.Dir(source), "..", "..", "pkged.go"))
+		if err != nil {
+			templates.err = err
+			return
+		}
+		embedded := string(pkged)
+		embedded = embedded[strings.Index(embedded, "`")+1:]
+		embedded = embedded[:strings.Index(embedded, "`")]
+		templates.err = pkger.Apply(mem.UnmarshalEmbed([]byte(embedded)))
+	})
+	return templates.err
+}
+
+// Home points HOME at an empty temporary directory for the rest of the test
+func Home(t *testing.T) string {
+	t.Helper()
+	if _, err := exec.LookPath("openssl"); err != nil {
+		t.Skip("openssl is not installed")
+	}
+	if err := loadTemplates(); err != nil {
+		t.Fatalf("unable to load the CA configuration templates: %v", err)
+	}
+	home, err := ioutil.TempDir("", "privki-test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	previous, wasSet := os.LookupEnv("HOME")
+	os.Setenv("HOME", home)
+	t.Cleanup(func() {
+		if wasSet {
+			os.Setenv("HOME", previous)
+		} else {
+			os.Unsetenv("HOME")
+		}
+		os.RemoveAll(home)
+	})
+	return home
+}
+
+// New initializes a vault with a Root CA (A0) and a single Intermediary CA (A1)
+// in a temporary home
+func New(t *testing.T) (*ca.Vault, *ca.Intermediate) {
+	t.Helper()
+	vault := NewRoot(t, false)
+	intermediate, err := vault.CreateIntermediate(context.Background(), ca.IntermediateOptions{
+		Organization:   Organization,
+		Passphrase:     A1Passphrase,
+		RootPassphrase: RootPassphrase,
+	})
+	if err != nil {
+		t.Fatalf("unable to create the A1: %v", err)
+	}
+	return vault, intermediate
+}
+
+// NewRoot initializes a vault with a Root CA (A0) only, and the DR Root CA (DR A0)
+// when withDR is set, in a temporary home
+func NewRoot(t *testing.T, withDR bool) *ca.Vault {
+	t.Helper()
+	Home(t)
+	ctx := context.Background()
+	vault, err := ca.Init(ctx)
+	if err != nil {
+		t.Fatalf("unable to initialize the vault: %v", err)
+	}
+	if err := vault.CreateRoot(ctx, ca.RootOptions{
+		Organization: Organization,
+		CommonName:   "vaulttest",
+		CustomOID:    ClassOID,
+		Passphrase:   RootPassphrase,
+		WithDR:       withDR,
+	}); err != nil {
+		t.Fatalf("unable to create the Root CA: %v", err)
+	}
+	return vault
+}
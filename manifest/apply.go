@@ -0,0 +1,112 @@
+package manifest
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"sfcert/pkg/ca"
+	"sort"
+)
+
+// Passphrases are asked for by apply when a change needs them
+type Passphrases struct {
+	// Root returns the passphrase of the Root CA (A0), or of the new one
+	Root func() string
+	// DR returns the passphrase of the DR Root CA (DR A0) when it has its own, nil leaves it to Root
+	DR func() string
+	// Intermediate returns the passphrase of a new A1 without a passphrase_env
+	Intermediate func(name string) string
+}
+
+// Apply creates what the manifest describes and the vault misses, and saves the
+// profiles that changed. It returns the changes made, drift is left as it is.
+// Apply stops at the first failure, running it again resumes from there.
+func Apply(ctx context.Context, vault *ca.Vault, manifest *Manifest, passphrases Passphrases) ([]Change, error) {
+	changes, err := Plan(ctx, vault, manifest)
+	if err != nil {
+		return nil, err
+	}
+	rootPassphrase := ""
+	root := func() string {
+		if rootPassphrase == "" {
+			rootPassphrase = passphrases.Root()
+		}
+		return rootPassphrase
+	}
+	// the DR Root CA passphrase is asked for once too
+	if dr := passphrases.DR; dr != nil {
+		drPassphrase := ""
+		passphrases.DR = func() string {
+			if drPassphrase == "" {
+				drPassphrase = dr()
+			}
+			return drPassphrase
+		}
+	}
+
+	var applied []Change
+	for _, change := range changes {
+		switch {
+		case change.Action == ActionDrift:
+			continue
+		case change.Kind == KindRoot:
+			err = vault.CreateRoot(ctx, ca.RootOptions{
+				Organization: manifest.Root.Organization,
+				CommonName:   manifest.Root.CommonName,
+				CustomOID:    manifest.Root.CustomOID,
+				Passphrase:   root(),
+				WithDR:       manifest.Root.DR,
+			})
+		case change.Kind == KindDRRoot:
+			_, err = vault.EnableDR(ctx, root())
+		case change.Kind == KindIntermediate:
+			change.ID, err = applyIntermediate(ctx, vault, change.intermediate, root, passphrases)
+		case change.Kind == KindProfile:
+			err = vault.SaveProfile(ctx, *change.profile)
+		}
+		if err != nil {
+			return applied, fmt.Errorf("%v %v %v: %v", change.Action, change.Kind, change.Name, err)
+		}
+		applied = append(applied, change)
+	}
+	return applied, nil
+}
+
+func applyIntermediate(ctx context.Context, vault *ca.Vault, desired *Intermediate, root func() string, passphrases Passphrases) (string, error) {
+	passphrase := ""
+	if desired.PassphraseEnv != "" {
+		passphrase = os.Getenv(desired.PassphraseEnv)
+		if passphrase == "" {
+			return "", fmt.Errorf("$%v is not set", desired.PassphraseEnv)
+		}
+	} else {
+		passphrase = passphrases.Intermediate(desired.Name())
+	}
+	drPassphrase := ""
+	if passphrases.DR != nil && vault.DREnabled() && vault.DRPassphraseDistinct() {
+		drPassphrase = passphrases.DR()
+	}
+	intermediate, err := vault.CreateIntermediate(ctx, ca.IntermediateOptions{
+		Organization:    desired.Organization,
+		NameRestriction: desired.NameRestriction,
+		Passphrase:      passphrase,
+		RootPassphrase:  root(),
+		DRPassphrase:    drPassphrase,
+		PathLen:         desired.PathLen,
+		Days:            desired.Days,
+		KeyAlgorithm:    desired.KeyAlgorithm,
+	})
+	if err != nil {
+		return "", err
+	}
+	return intermediate.ID, nil
+}
+
+func sortedProfileNames(profiles map[string]ca.Profile) []string {
+	var names []string
+	for name := range profiles {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
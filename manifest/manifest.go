@@ -0,0 +1,136 @@
+// Package manifest describes a vault declaratively, its Root CA, A1s and leaf
+// profiles, plans the changes that bring the vault to it and applies them.
+package manifest
+
+import (
+	"errors"
+	"fmt"
+	"github.com/spf13/viper"
+	"sfcert/pkg/ca"
+	"strings"
+)
+
+// Root describes the Root CA (A0) and the vault wide settings
+type Root struct {
+	Organization string `mapstructure:"org"`
+	CommonName   string `mapstructure:"common_name"`
+	// CustomOID defaults to the privki OID when empty
+	CustomOID string `mapstructure:"oid"`
+	DR        bool   `mapstructure:"dr"`
+}
+
+// Intermediate describes an A1. Without an ID it stands for the A1 of the vault with
+// the same organization and name restriction.
+type Intermediate struct {
+	ID              string `mapstructure:"id"`
+	Organization    string `mapstructure:"org"`
+	NameRestriction string `mapstructure:"name_restrict"`
+	// PathLen, Days and KeyAlgorithm keep the privki defaults when unset
+	PathLen      *int   `mapstructure:"pathlen"`
+	Days         int    `mapstructure:"validity_days"`
+	KeyAlgorithm string `mapstructure:"key_algo"`
+	// PassphraseEnv names the environment variable holding the passphrase of a new A1,
+	// it is prompted for otherwise
+	PassphraseEnv string `mapstructure:"passphrase_env"`
+}
+
+// Name identifies the A1 in plans
+func (intermediate Intermediate) Name() string {
+	if intermediate.ID != "" {
+		return intermediate.ID
+	}
+	if intermediate.NameRestriction == "" {
+		return intermediate.Organization
+	}
+	return intermediate.Organization + " (" + intermediate.NameRestriction + ")"
+}
+
+// Manifest is the desired state of a vault
+type Manifest struct {
+	Root          Root                  `mapstructure:"vault"`
+	Intermediates []Intermediate        `mapstructure:"intermediates"`
+	Profiles      map[string]ca.Profile `mapstructure:"profiles"`
+}
+
+// Load reads a manifest from a yaml or json file
+//
+// example>
+//
+//	vault:
+//	  org: alpha corp
+//	  common_name: alpha certifying authority
+//	  oid: 1.9.6.1.4.4.7.8.5
+//	  dr: true
+//	intermediates:
+//	  - org: Alpha Chat Engineering Team
+//	    name_restrict: dbsvc.chat.alpha.com
+//	    pathlen: 0
+//	    validity_days: 3650
+//	    key_algo: ec:P-384
+//	    passphrase_env: DBSVC_A1_PASSPHRASE
+//	profiles:
+//	  web:
+//	    usage: server
+//	    days: 90
+//	    organizational_unit: web
+func Load(manifestFile string) (*Manifest, error) {
+	manifestReader := viper.New()
+	manifestReader.SetConfigFile(manifestFile)
+	if err := manifestReader.ReadInConfig(); err != nil {
+		return nil, err
+	}
+	manifest := new(Manifest)
+	if err := manifestReader.Unmarshal(manifest); err != nil {
+		return nil, err
+	}
+	profiles := map[string]ca.Profile{}
+	for name, profile := range manifest.Profiles {
+		profile.Name = name
+		profiles[name] = profile
+	}
+	manifest.Profiles = profiles
+	// viper lowercases keys only, A1 IDs end with an uppercase Z
+	for i := range manifest.Intermediates {
+		manifest.Intermediates[i].ID = strings.ToUpper(manifest.Intermediates[i].ID)
+	}
+	return manifest, manifest.Validate()
+}
+
+// Validate checks the manifest before it is planned
+func (manifest *Manifest) Validate() error {
+	if manifest.Root.Organization == "" {
+		return errors.New("vault: an org is required")
+	}
+	if manifest.Root.CommonName == "" {
+		return errors.New("vault: a common_name is required")
+	}
+	seen := map[string]bool{}
+	for _, intermediate := range manifest.Intermediates {
+		if intermediate.Organization == "" && intermediate.ID == "" {
+			return errors.New("intermediates: an org is required")
+		}
+		key := intermediate.Organization + "|" + intermediate.NameRestriction
+		if intermediate.ID != "" {
+			key = intermediate.ID
+		}
+		if seen[key] {
+			return fmt.Errorf("intermediates: %v is listed twice, give each one an id", intermediate.Name())
+		}
+		seen[key] = true
+		if intermediate.PathLen != nil && *intermediate.PathLen < 0 {
+			return fmt.Errorf("intermediates: %v has a negative pathlen", intermediate.Name())
+		}
+		if intermediate.Days < 0 {
+			return fmt.Errorf("intermediates: %v has a negative validity_days", intermediate.Name())
+		}
+		if err := ca.ValidateKeyAlgorithm(intermediate.KeyAlgorithm); err != nil {
+			return fmt.Errorf("intermediates: %v: %v", intermediate.Name(), err)
+		}
+	}
+	for _, profile := range manifest.Profiles {
+		if err := profile.Validate(); err != nil {
+			return fmt.Errorf("profiles: %v", err)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,173 @@
+package manifest_test
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sfcert/internal/vaulttest"
+	"sfcert/manifest"
+	"sfcert/pkg/ca"
+	"strings"
+	"testing"
+)
+
+const vaultManifest = `
+vault:
+  org: Vault Test
+  common_name: manifest root
+  oid: ` + vaulttest.ClassOID + `
+intermediates:
+  - org: Vault Test
+    pathlen: 0
+  - org: Vault Test
+    name_restrict: db.vault.test
+    passphrase_env: MANIFEST_TEST_DB_PASSPHRASE
+profiles:
+  web:
+    usage: server
+    days: 90
+`
+
+func loadManifest(t *testing.T, content string) *manifest.Manifest {
+	t.Helper()
+	manifestFile := filepath.Join(t.TempDir(), "vault.yaml")
+	if err := ioutil.WriteFile(manifestFile, []byte(content), 0644); err != nil {
+		t.Fatal(err)
+	}
+	loaded, err := manifest.Load(manifestFile)
+	if err != nil {
+		t.Fatalf("Load: %v", err)
+	}
+	return loaded
+}
+
+// summary lists changes as "action kind name"
+func summary(changes []manifest.Change) string {
+	var lines []string
+	for _, change := range changes {
+		lines = append(lines, change.Action+" "+change.Kind+" "+change.Name)
+	}
+	return strings.Join(lines, "\n")
+}
+
+func requireChanges(t *testing.T, changes []manifest.Change, want ...string) {
+	t.Helper()
+	if got := summary(changes); got != strings.Join(want, "\n") {
+		t.Fatalf("changes are\n%v\nwant\n%v", got, strings.Join(want, "\n"))
+	}
+}
+
+func TestLoadInvalid(t *testing.T) {
+	for name, content := range map[string]string{
+		"no org":        "vault:\n  common_name: manifest root\n",
+		"listed twice":  "vault:\n  org: Vault Test\n  common_name: manifest root\nintermediates:\n  - org: Vault Test\n  - org: Vault Test\n",
+		"negative days": "vault:\n  org: Vault Test\n  common_name: manifest root\nintermediates:\n  - org: Vault Test\n    validity_days: -1\n",
+		"bad usage":     "vault:\n  org: Vault Test\n  common_name: manifest root\nprofiles:\n  web:\n    usage: codesign\n",
+	} {
+		manifestFile := filepath.Join(t.TempDir(), "vault.yaml")
+		if err := ioutil.WriteFile(manifestFile, []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := manifest.Load(manifestFile); err == nil {
+			t.Errorf("%v: the manifest loaded", name)
+		}
+	}
+}
+
+func TestApply(t *testing.T) {
+	vaulttest.Home(t)
+	ctx := context.Background()
+	vault, err := ca.Init(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	os.Unsetenv("MANIFEST_TEST_DB_PASSPHRASE")
+	defer os.Unsetenv("MANIFEST_TEST_DB_PASSPHRASE")
+	passphrases := manifest.Passphrases{
+		Root:         func() string { return vaulttest.RootPassphrase },
+		Intermediate: func(string) string { return vaulttest.A1Passphrase },
+	}
+	desired := loadManifest(t, vaultManifest)
+
+	changes, err := manifest.Plan(ctx, vault, desired)
+	if err != nil {
+		t.Fatalf("Plan: %v", err)
+	}
+	requireChanges(t, changes,
+		"create A0 manifest root",
+		"create A1 Vault Test",
+		"create A1 Vault Test (db.vault.test)",
+		"create profile web")
+	if !strings.Contains(changes[2].Detail, "$MANIFEST_TEST_DB_PASSPHRASE is not set") {
+		t.Errorf("the plan does not warn of the missing passphrase: %v", changes[2].Detail)
+	}
+
+	// apply stops at the A1 without its passphrase, what it created stays
+	applied, err := manifest.Apply(ctx, vault, desired, passphrases)
+	if err == nil || !strings.Contains(err.Error(), "MANIFEST_TEST_DB_PASSPHRASE") {
+		t.Fatalf("Apply without the A1 passphrase returned %v", err)
+	}
+	requireChanges(t, applied, "create A0 manifest root", "create A1 Vault Test")
+	if applied[1].ID == "" {
+		t.Error("the created A1 has no ID in the applied changes")
+	}
+
+	// running it again resumes from the failure
+	os.Setenv("MANIFEST_TEST_DB_PASSPHRASE", "db-a1-passphrase")
+	applied, err = manifest.Apply(ctx, vault, desired, passphrases)
+	if err != nil {
+		t.Fatalf("Apply: %v", err)
+	}
+	requireChanges(t, applied, "create A1 Vault Test (db.vault.test)", "create profile web")
+	intermediates, err := vault.Intermediates(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(intermediates) != 2 {
+		t.Fatalf("the vault has %d A1s, want 2", len(intermediates))
+	}
+	for _, intermediate := range intermediates {
+		if len(intermediate.NameRestrict) == 0 && intermediate.PathLen != 0 {
+			t.Errorf("A1 %v has pathlen %d, want 0", intermediate.ID, intermediate.PathLen)
+		}
+	}
+
+	// applying a manifest the vault matches changes nothing
+	changes, err = manifest.Plan(ctx, vault, desired)
+	if err != nil {
+		t.Fatalf("Plan: %v", err)
+	}
+	requireChanges(t, changes)
+	applied, err = manifest.Apply(ctx, vault, desired, passphrases)
+	if err != nil {
+		t.Fatalf("Apply: %v", err)
+	}
+	requireChanges(t, applied)
+
+	// profiles are updated, drift of a CA is only reported
+	edited := strings.Replace(vaultManifest, "days: 90", "days: 30", 1)
+	edited = strings.Replace(edited, "pathlen: 0", "pathlen: 1", 1)
+	desired = loadManifest(t, edited)
+	changes, err = manifest.Plan(ctx, vault, desired)
+	if err != nil {
+		t.Fatalf("Plan: %v", err)
+	}
+	requireChanges(t, changes, "drift A1 Vault Test", "update profile web")
+	applied, err = manifest.Apply(ctx, vault, desired, passphrases)
+	if err != nil {
+		t.Fatalf("Apply: %v", err)
+	}
+	requireChanges(t, applied, "update profile web")
+	changes, err = manifest.Plan(ctx, vault, desired)
+	if err != nil {
+		t.Fatalf("Plan: %v", err)
+	}
+	requireChanges(t, changes, "drift A1 Vault Test")
+
+	// an id the vault does not have cannot be planned
+	pinned := strings.Replace(vaultManifest, "  - org: Vault Test\n    pathlen: 0", "  - id: 20200722174505Z", 1)
+	if _, err := manifest.Plan(ctx, vault, loadManifest(t, pinned)); err == nil {
+		t.Error("a manifest pinning an unknown A1 was planned")
+	}
+}
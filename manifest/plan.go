@@ -0,0 +1,256 @@
+package manifest
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"os"
+	"sfcert/pkg/ca"
+	"strings"
+)
+
+// Change actions, apply performs creations and updates, drift is only reported
+// as privki never modifies nor removes an existing CA
+const (
+	ActionCreate = "create"
+	ActionUpdate = "update"
+	ActionDrift  = "drift"
+)
+
+// Change kinds
+const (
+	KindRoot         = "A0"
+	KindDRRoot       = "DR A0"
+	KindIntermediate = "A1"
+	KindProfile      = "profile"
+)
+
+// Change is a difference between the manifest and the vault
+type Change struct {
+	Action string `json:"action"`
+	Kind   string `json:"kind"`
+	Name   string `json:"name"`
+	Detail string `json:"detail,omitempty"`
+	// ID is the A1 a change applies to, set once apply created it
+	ID string `json:"id,omitempty"`
+
+	intermediate *Intermediate
+	profile      *ca.Profile
+}
+
+// Plan lists the changes that bring vault to the manifest, nothing when they match
+func Plan(ctx context.Context, vault *ca.Vault, manifest *Manifest) ([]Change, error) {
+	if vault.Subordinate() {
+		return nil, ca.ErrSubordinateVault
+	}
+	var changes []Change
+	root := manifest.Root
+	if _, err := vault.Roots(); err != nil {
+		detail := "org " + root.Organization + ", common name " + root.CommonName
+		if root.DR {
+			detail += ", with a DR Root CA"
+		}
+		changes = append(changes, Change{Action: ActionCreate, Kind: KindRoot, Name: root.CommonName, Detail: detail})
+	} else {
+		settings, err := vault.Settings(ctx)
+		if err != nil {
+			return nil, err
+		}
+		drift := func(setting string, current string, desired string) {
+			if desired != "" && current != desired {
+				changes = append(changes, Change{Action: ActionDrift, Kind: KindRoot, Name: settings.CommonName,
+					Detail: fmt.Sprintf("%v is %q in the vault, %q in the manifest", setting, current, desired)})
+			}
+		}
+		drift("org", settings.Organization, root.Organization)
+		drift("common_name", settings.CommonName, root.CommonName)
+		drift("oid", settings.CustomOID, root.CustomOID)
+		switch {
+		case root.DR && !settings.WithDR:
+			changes = append(changes, Change{Action: ActionCreate, Kind: KindDRRoot, Name: settings.CommonName,
+				Detail: "and cross sign the A1s with it"})
+		case !root.DR && settings.WithDR:
+			changes = append(changes, Change{Action: ActionDrift, Kind: KindDRRoot, Name: settings.CommonName,
+				Detail: "DR is enabled in the vault, not in the manifest"})
+		}
+	}
+
+	intermediateChanges, err := planIntermediates(ctx, vault, manifest)
+	if err != nil {
+		return nil, err
+	}
+	changes = append(changes, intermediateChanges...)
+
+	profileChanges, err := planProfiles(ctx, vault, manifest)
+	if err != nil {
+		return nil, err
+	}
+	return append(changes, profileChanges...), nil
+}
+
+func planIntermediates(ctx context.Context, vault *ca.Vault, manifest *Manifest) ([]Change, error) {
+	var existing []ca.Intermediate
+	if _, err := vault.Roots(); err == nil {
+		intermediates, err := vault.Intermediates(ctx)
+		if err != nil {
+			return nil, err
+		}
+		for _, intermediate := range intermediates {
+			if intermediate.Level == 1 {
+				existing = append(existing, intermediate)
+			}
+		}
+	}
+
+	// entries with an ID claim their A1 before the others are matched
+	matched := map[string]bool{}
+	currents := make([]*ca.Intermediate, len(manifest.Intermediates))
+	for _, pinned := range []bool{true, false} {
+		for i := range manifest.Intermediates {
+			desired := &manifest.Intermediates[i]
+			if (desired.ID != "") != pinned {
+				continue
+			}
+			currents[i] = matchIntermediate(desired, existing, matched)
+			if currents[i] == nil && pinned {
+				return nil, fmt.Errorf("intermediates: A1 %v is not in the vault, remove its id to create a new A1", desired.ID)
+			}
+			if currents[i] != nil {
+				matched[currents[i].ID] = true
+			}
+		}
+	}
+
+	var changes []Change
+	for i := range manifest.Intermediates {
+		desired := &manifest.Intermediates[i]
+		current := currents[i]
+		if current == nil {
+			changes = append(changes, Change{Action: ActionCreate, Kind: KindIntermediate, Name: desired.Name(),
+				Detail: describeIntermediate(desired), intermediate: desired})
+			continue
+		}
+		for _, detail := range intermediateDrift(desired, current) {
+			changes = append(changes, Change{Action: ActionDrift, Kind: KindIntermediate, Name: desired.Name(), Detail: detail, ID: current.ID})
+		}
+	}
+	for _, intermediate := range existing {
+		if !matched[intermediate.ID] {
+			changes = append(changes, Change{Action: ActionDrift, Kind: KindIntermediate, Name: intermediate.ID,
+				Detail: intermediate.Subject + " is in the vault, not in the manifest", ID: intermediate.ID})
+		}
+	}
+	return changes, nil
+}
+
+// matchIntermediate finds the A1 of the vault a manifest entry stands for, an A1 of
+// the vault stands for one entry only
+func matchIntermediate(desired *Intermediate, existing []ca.Intermediate, matched map[string]bool) *ca.Intermediate {
+	for i := range existing {
+		current := &existing[i]
+		if matched[current.ID] {
+			continue
+		}
+		if desired.ID != "" {
+			if current.ID == desired.ID {
+				return current
+			}
+			continue
+		}
+		if current.Organization == desired.Organization && strings.Join(current.NameRestrict, ",") == desired.NameRestriction {
+			return current
+		}
+	}
+	return nil
+}
+
+func intermediateDrift(desired *Intermediate, current *ca.Intermediate) []string {
+	var drift []string
+	if desired.ID != "" && desired.Organization != "" && desired.Organization != current.Organization {
+		drift = append(drift, fmt.Sprintf("org is %q in the vault, %q in the manifest", current.Organization, desired.Organization))
+	}
+	if desired.ID != "" && strings.Join(current.NameRestrict, ",") != desired.NameRestriction {
+		drift = append(drift, fmt.Sprintf("name_restrict is %q in the vault, %q in the manifest", strings.Join(current.NameRestrict, ","), desired.NameRestriction))
+	}
+	if desired.PathLen != nil && *desired.PathLen != current.PathLen {
+		drift = append(drift, fmt.Sprintf("pathlen is %d in the vault, %d in the manifest", current.PathLen, *desired.PathLen))
+	}
+	if desired.KeyAlgorithm != "" && desired.KeyAlgorithm != current.KeyAlgorithm {
+		drift = append(drift, fmt.Sprintf("key_algo is %v in the vault, %v in the manifest", current.KeyAlgorithm, desired.KeyAlgorithm))
+	}
+	if desired.Days > 0 {
+		// A1s are valid from the day before their creation
+		days := int(math.Round(current.NotAfter.Sub(current.NotBefore).Hours()/24)) - 1
+		if math.Abs(float64(days-desired.Days)) > 1 {
+			drift = append(drift, fmt.Sprintf("validity_days is %d in the vault, %d in the manifest", days, desired.Days))
+		}
+	}
+	return drift
+}
+
+func describeIntermediate(intermediate *Intermediate) string {
+	details := []string{"org " + intermediate.Organization}
+	if intermediate.NameRestriction != "" {
+		details = append(details, "restricted to "+intermediate.NameRestriction)
+	}
+	if intermediate.PathLen != nil {
+		details = append(details, fmt.Sprintf("pathlen %d", *intermediate.PathLen))
+	}
+	if intermediate.Days > 0 {
+		details = append(details, fmt.Sprintf("valid %d days", intermediate.Days))
+	}
+	if intermediate.KeyAlgorithm != "" {
+		details = append(details, intermediate.KeyAlgorithm+" key")
+	}
+	if intermediate.PassphraseEnv != "" {
+		if _, set := os.LookupEnv(intermediate.PassphraseEnv); !set {
+			details = append(details, "$"+intermediate.PassphraseEnv+" is not set")
+		}
+	}
+	return strings.Join(details, ", ")
+}
+
+func planProfiles(ctx context.Context, vault *ca.Vault, manifest *Manifest) ([]Change, error) {
+	profiles, err := vault.Profiles(ctx)
+	if err != nil {
+		return nil, err
+	}
+	current := map[string]ca.Profile{}
+	for _, profile := range profiles {
+		current[profile.Name] = profile
+	}
+
+	var changes []Change
+	for _, name := range sortedProfileNames(manifest.Profiles) {
+		desired := manifest.Profiles[name]
+		existing, found := current[name]
+		switch {
+		case !found:
+			changes = append(changes, Change{Action: ActionCreate, Kind: KindProfile, Name: name, Detail: describeProfile(desired), profile: &desired})
+		case existing != desired:
+			changes = append(changes, Change{Action: ActionUpdate, Kind: KindProfile, Name: name,
+				Detail: describeProfile(desired) + ", was " + describeProfile(existing), profile: &desired})
+		}
+	}
+	for _, profile := range profiles {
+		if _, found := manifest.Profiles[profile.Name]; !found {
+			changes = append(changes, Change{Action: ActionDrift, Kind: KindProfile, Name: profile.Name,
+				Detail: "the profile is in the vault, not in the manifest"})
+		}
+	}
+	return changes, nil
+}
+
+func describeProfile(profile ca.Profile) string {
+	details := []string{"usage " + profile.Usage}
+	if profile.Days > 0 {
+		details = append(details, fmt.Sprintf("days %d", profile.Days))
+	}
+	if profile.Organization != "" {
+		details = append(details, "org "+profile.Organization)
+	}
+	if profile.OrganizationalUnit != "" {
+		details = append(details, "ou "+profile.OrganizationalUnit)
+	}
+	return strings.Join(details, ", ")
+}
@@ -0,0 +1,162 @@
+// Package metrics exports the health of a vault as Prometheus gauges: expiry of the
+// CAs and the certificates they issued, CRL updates, issuance and revocation counts,
+// the last backup and the DR cross signing status.
+package metrics
+
+import (
+	"context"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+	log "github.com/sirupsen/logrus"
+	"net/http"
+	"os"
+	"sfcert/pkg/ca"
+	"strconv"
+	"time"
+)
+
+var (
+	vaultUp = prometheus.NewDesc("privki_vault_up",
+		"Whether the vault could be read during the last scrape.", nil, nil)
+	caExpiry = prometheus.NewDesc("privki_ca_expiry_seconds",
+		"Seconds until the CA certificate expires.", []string{"ca", "level", "subject"}, nil)
+	certificateExpiry = prometheus.NewDesc("privki_certificate_expiry_seconds",
+		"Seconds until a valid certificate issued by a CA expires.", []string{"ca", "serial", "subject"}, nil)
+	crlNextUpdate = prometheus.NewDesc("privki_crl_next_update_timestamp_seconds",
+		"nextUpdate of the current CRL of a CA, as a Unix timestamp.", []string{"ca", "crl_number"}, nil)
+	certificatesIssued = prometheus.NewDesc("privki_certificates_issued",
+		"Certificates issued by a CA, whatever their status.", []string{"ca"}, nil)
+	certificatesRevoked = prometheus.NewDesc("privki_certificates_revoked",
+		"Certificates revoked by a CA, holds included.", []string{"ca"}, nil)
+	certificatesHeld = prometheus.NewDesc("privki_certificates_held",
+		"Certificates of a CA on hold (certificateHold).", []string{"ca"}, nil)
+	lastBackup = prometheus.NewDesc("privki_last_backup_timestamp_seconds",
+		"Time of the last privki backup as a Unix timestamp, 0 when none was recorded.", nil, nil)
+	drEnabled = prometheus.NewDesc("privki_dr_enabled",
+		"Whether the vault has a DR Root CA.", nil, nil)
+	drCrossSigned = prometheus.NewDesc("privki_dr_cross_signed",
+		"Whether an A1 is cross signed by the DR Root CA.", []string{"ca"}, nil)
+)
+
+// Collector reads the vault on every scrape
+type Collector struct {
+	Vault *ca.Vault
+}
+
+// Describe sends the descriptors of the vault gauges
+func (collector *Collector) Describe(descs chan<- *prometheus.Desc) {
+	for _, desc := range []*prometheus.Desc{vaultUp, caExpiry, certificateExpiry, crlNextUpdate, certificatesIssued,
+		certificatesRevoked, certificatesHeld, lastBackup, drEnabled, drCrossSigned} {
+		descs <- desc
+	}
+}
+
+// Collect sends the current gauges of the vault, privki_vault_up is 0 when it could not be read
+func (collector *Collector) Collect(metrics chan<- prometheus.Metric) {
+	if err := collector.collect(context.Background(), metrics); err != nil {
+		log.Warnf("Unable to collect the vault metrics: %v", err)
+		metrics <- prometheus.MustNewConstMetric(vaultUp, prometheus.GaugeValue, 0)
+		return
+	}
+	metrics <- prometheus.MustNewConstMetric(vaultUp, prometheus.GaugeValue, 1)
+}
+
+func (collector *Collector) collect(ctx context.Context, metrics chan<- prometheus.Metric) error {
+	vault := collector.Vault
+	now := time.Now()
+	gauge := func(desc *prometheus.Desc, value float64, labels ...string) {
+		metrics <- prometheus.MustNewConstMetric(desc, prometheus.GaugeValue, value, labels...)
+	}
+
+	backup, err := vault.LastBackup()
+	if err != nil {
+		return err
+	}
+	if backup.IsZero() {
+		gauge(lastBackup, 0)
+	} else {
+		gauge(lastBackup, float64(backup.Unix()))
+	}
+	gauge(drEnabled, boolValue(vault.DREnabled()))
+
+	if !vault.Subordinate() {
+		roots, err := vault.Roots()
+		if err != nil {
+			return err
+		}
+		for i, root := range roots {
+			id := "a0"
+			if i == 1 {
+				id = "dr-a0"
+			}
+			gauge(caExpiry, root.NotAfter.Sub(now).Seconds(), id, "0", root.Subject.String())
+			if err := collector.collectCRL(ctx, id, gauge); err != nil {
+				return err
+			}
+		}
+	}
+
+	intermediates, err := vault.Intermediates(ctx)
+	if err != nil {
+		return err
+	}
+	for _, intermediate := range intermediates {
+		gauge(caExpiry, intermediate.NotAfter.Sub(now).Seconds(), intermediate.ID, strconv.Itoa(intermediate.Level), intermediate.Subject)
+		if intermediate.Level == 1 {
+			gauge(drCrossSigned, boolValue(intermediate.CrossSigned), intermediate.ID)
+		}
+		if intermediate.ExternalKey {
+			continue
+		}
+		if err := collector.collectCRL(ctx, intermediate.ID, gauge); err != nil {
+			return err
+		}
+
+		entries, err := vault.Certificates(ctx, intermediate.ID)
+		if err != nil {
+			return err
+		}
+		var revoked, held int
+		for _, entry := range entries {
+			switch {
+			case entry.Status == "R" && entry.RevocationReason == "certificateHold":
+				revoked++
+				held++
+			case entry.Status == "R":
+				revoked++
+			case entry.Status == "V":
+				gauge(certificateExpiry, entry.Expiry.Sub(now).Seconds(), intermediate.ID, entry.Serial, entry.Subject)
+			}
+		}
+		gauge(certificatesIssued, float64(len(entries)), intermediate.ID)
+		gauge(certificatesRevoked, float64(revoked), intermediate.ID)
+		gauge(certificatesHeld, float64(held), intermediate.ID)
+	}
+	return nil
+}
+
+func (collector *Collector) collectCRL(ctx context.Context, id string, gauge func(*prometheus.Desc, float64, ...string)) error {
+	info, err := collector.Vault.CRLInfo(ctx, id)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	gauge(crlNextUpdate, float64(info.NextUpdate.Unix()), id, info.Number)
+	return nil
+}
+
+// Handler serves the vault gauges in the Prometheus exposition format
+func Handler(vault *ca.Vault) http.Handler {
+	registry := prometheus.NewRegistry()
+	registry.MustRegister(&Collector{Vault: vault})
+	return promhttp.HandlerFor(registry, promhttp.HandlerOpts{})
+}
+
+func boolValue(value bool) float64 {
+	if value {
+		return 1
+	}
+	return 0
+}
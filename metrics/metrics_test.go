@@ -0,0 +1,134 @@
+package metrics_test
+
+import (
+	"bufio"
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sfcert/internal/vaulttest"
+	"sfcert/metrics"
+	"sfcert/openssl"
+	"sfcert/pkg/ca"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+// scrape reads the series served by handler, keyed by name and labels
+func scrape(t *testing.T, handler http.Handler) map[string]float64 {
+	t.Helper()
+	recorder := httptest.NewRecorder()
+	handler.ServeHTTP(recorder, httptest.NewRequest("GET", "/metrics", nil))
+	if recorder.Code != http.StatusOK {
+		t.Fatalf("scrape returned %v", recorder.Code)
+	}
+	series := map[string]float64{}
+	scanner := bufio.NewScanner(recorder.Body)
+	for scanner.Scan() {
+		line := scanner.Text()
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		separator := strings.LastIndex(line, " ")
+		value, err := strconv.ParseFloat(line[separator+1:], 64)
+		if err != nil {
+			t.Fatalf("series %q: %v", line, err)
+		}
+		series[line[:separator]] = value
+	}
+	return series
+}
+
+// gauge returns the value of the single series of name carrying every label, labels are
+// given as they are served, e.g. ca="20200722174505Z"
+func gauge(t *testing.T, series map[string]float64, name string, labels ...string) float64 {
+	t.Helper()
+	var values []float64
+	for key, value := range series {
+		if key != name && !strings.HasPrefix(key, name+"{") {
+			continue
+		}
+		matches := true
+		for _, label := range labels {
+			matches = matches && strings.Contains(key, label)
+		}
+		if matches {
+			values = append(values, value)
+		}
+	}
+	if len(values) != 1 {
+		t.Fatalf("%v%v has %d series, want 1", name, labels, len(values))
+	}
+	return values[0]
+}
+
+func TestHandler(t *testing.T) {
+	vault, intermediate := vaulttest.New(t)
+	ctx := context.Background()
+	var serials []string
+	for _, commonName := range []string{"held.cluster.internal", "valid.cluster.internal"} {
+		issued, err := vault.Issue(ctx, intermediate.ID, ca.IssueOptions{
+			IssueRequest: ca.IssueRequest{CommonName: commonName, Profile: "server", Days: 30},
+			Passphrase:   vaulttest.A1Passphrase,
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		serials = append(serials, issued.Serial)
+	}
+	if err := vault.Revoke(ctx, intermediate.ID, ca.RevokeOptions{Serial: serials[0], Reason: "certificateHold", Comment: "key under investigation", Passphrase: vaulttest.A1Passphrase}); err != nil {
+		t.Fatal(err)
+	}
+
+	handler := metrics.Handler(vault)
+	series := scrape(t, handler)
+	a1 := `ca="` + intermediate.ID + `"`
+	for name, want := range map[string]float64{
+		"privki_vault_up":                      1,
+		"privki_dr_enabled":                    0,
+		"privki_last_backup_timestamp_seconds": 0,
+	} {
+		if got := gauge(t, series, name); got != want {
+			t.Errorf("%v is %v, want %v", name, got, want)
+		}
+	}
+	for name, want := range map[string]float64{
+		"privki_certificates_issued":  2,
+		"privki_certificates_revoked": 1,
+		"privki_certificates_held":    1,
+		"privki_dr_cross_signed":      0,
+	} {
+		if got := gauge(t, series, name, a1); got != want {
+			t.Errorf("%v of the A1 is %v, want %v", name, got, want)
+		}
+	}
+	if expiry := gauge(t, series, "privki_certificate_expiry_seconds", a1, `serial="`+serials[1]+`"`); expiry <= 29*86400 || expiry > 30*86400 {
+		t.Errorf("the valid certificate expires in %v seconds, want 30 days", expiry)
+	}
+	for key := range series {
+		if strings.Contains(key, `serial="`+serials[0]+`"`) {
+			t.Errorf("the certificate on hold is served as %v", key)
+		}
+	}
+	for _, id := range []string{"a0", intermediate.ID} {
+		if expiry := gauge(t, series, "privki_ca_expiry_seconds", `ca="`+id+`"`); expiry <= 0 {
+			t.Errorf("%v expires in %v seconds", id, expiry)
+		}
+	}
+	if nextUpdate := gauge(t, series, "privki_crl_next_update_timestamp_seconds", a1); nextUpdate <= 0 {
+		t.Errorf("the CRL of the A1 is next updated at %v", nextUpdate)
+	}
+
+	// a vault that cannot be read is reported down instead of failing the scrape
+	rootCertificate := filepath.Join(openssl.RootCADir(vault.Path, vault.RootUID), "root-ca.cert.pem")
+	if err := os.Rename(rootCertificate, rootCertificate+".moved"); err != nil {
+		t.Fatal(err)
+	}
+	defer os.Rename(rootCertificate+".moved", rootCertificate)
+	series = scrape(t, handler)
+	if up := gauge(t, series, "privki_vault_up"); up != 0 {
+		t.Errorf("privki_vault_up is %v for a vault without its Root CA certificate", up)
+	}
+}
@@ -0,0 +1,133 @@
+// Package notify sends certificate expiry and CRL staleness alerts of a vault
+// to webhooks and by email, escalating as the deadlines come closer.
+package notify
+
+import (
+	"errors"
+	"fmt"
+	"github.com/spf13/viper"
+	"sort"
+	"strings"
+)
+
+// Threshold is an escalation level, alerts are raised once a deadline is Days away.
+// Email and Webhooks are escalation contacts, notified at this level and the closer ones.
+type Threshold struct {
+	Days     int      `mapstructure:"days"`
+	Email    []string `mapstructure:"email"`
+	Webhooks []string `mapstructure:"webhooks"`
+}
+
+// Contacts are the owners of an A1, notified of the alerts of the A1, its A2s and their certificates
+type Contacts struct {
+	Email    []string `mapstructure:"email"`
+	Webhooks []string `mapstructure:"webhooks"`
+}
+
+// SMTP is the mail server used for email alerts
+type SMTP struct {
+	Host     string `mapstructure:"host"`
+	Port     int    `mapstructure:"port"`
+	From     string `mapstructure:"from"`
+	Username string `mapstructure:"username"`
+	Password string `mapstructure:"password"`
+}
+
+// Config describes who is notified of what, loaded from the notify file
+type Config struct {
+	Thresholds []Threshold `mapstructure:"thresholds"`
+	// CRLDays raises a staleness alert once a CRL is due for an update within that many days
+	CRLDays int `mapstructure:"crl_days"`
+	// Email and Webhooks receive every alert
+	Email    []string            `mapstructure:"email"`
+	Webhooks []string            `mapstructure:"webhooks"`
+	Owners   map[string]Contacts `mapstructure:"owners"`
+	SMTP     SMTP                `mapstructure:"smtp"`
+}
+
+// default escalation levels, in days before the deadline
+var defaultThresholds = []int{90, 30, 7}
+
+const defaultCRLDays = 7
+
+// LoadConfig reads the notification settings from a yaml or json file
+//
+// example>
+//
+//	thresholds:
+//	  - days: 90
+//	  - days: 30
+//	  - days: 7
+//	    email: [security@alpha.com]
+//	crl_days: 7
+//	email: [pki-team@alpha.com]
+//	webhooks: [https://chat.alpha.com/hooks/pki]
+//	owners:
+//	  20200722174505Z:
+//	    email: [chat-team@alpha.com]
+//	smtp:
+//	  host: mail.alpha.com
+//	  port: 25
+//	  from: privki@alpha.com
+func LoadConfig(configFile string) (*Config, error) {
+	configReader := viper.New()
+	configReader.SetConfigFile(configFile)
+	if err := configReader.ReadInConfig(); err != nil {
+		return nil, err
+	}
+	config := new(Config)
+	if err := configReader.Unmarshal(config); err != nil {
+		return nil, err
+	}
+
+	if len(config.Thresholds) == 0 {
+		for _, days := range defaultThresholds {
+			config.Thresholds = append(config.Thresholds, Threshold{Days: days})
+		}
+	}
+	for _, threshold := range config.Thresholds {
+		if threshold.Days <= 0 {
+			return nil, fmt.Errorf("threshold of %d days, thresholds must be positive", threshold.Days)
+		}
+	}
+	// most urgent first
+	sort.Slice(config.Thresholds, func(i, j int) bool { return config.Thresholds[i].Days < config.Thresholds[j].Days })
+	if config.CRLDays == 0 {
+		config.CRLDays = defaultCRLDays
+	}
+
+	// viper lowercases keys, A1 IDs end with an uppercase Z
+	owners := map[string]Contacts{}
+	for id, contacts := range config.Owners {
+		owners[strings.ToUpper(id)] = contacts
+	}
+	config.Owners = owners
+
+	if config.SMTP.Port == 0 {
+		config.SMTP.Port = 25
+	}
+	if config.SMTP.Host == "" && config.usesEmail() {
+		return nil, errors.New("email recipients are configured without an smtp host")
+	}
+	if config.SMTP.From == "" {
+		config.SMTP.From = "privki@localhost"
+	}
+	return config, nil
+}
+
+func (config *Config) usesEmail() bool {
+	if len(config.Email) > 0 {
+		return true
+	}
+	for _, threshold := range config.Thresholds {
+		if len(threshold.Email) > 0 {
+			return true
+		}
+	}
+	for _, contacts := range config.Owners {
+		if len(contacts.Email) > 0 {
+			return true
+		}
+	}
+	return false
+}
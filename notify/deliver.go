@@ -0,0 +1,78 @@
+package notify
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/http"
+	"net/smtp"
+	"strconv"
+	"time"
+)
+
+const webhookTimeout = 10 * time.Second
+
+// WebhookPayload is the JSON body posted to webhooks
+type WebhookPayload struct {
+	Source    string    `json:"source"`
+	Vault     string    `json:"vault"`
+	Generated time.Time `json:"generated"`
+	Alerts    []Alert   `json:"alerts"`
+}
+
+func (notifier *Notifier) postWebhook(ctx context.Context, url string, alerts []Alert) error {
+	payload, err := json.Marshal(WebhookPayload{
+		Source:    "privki",
+		Vault:     notifier.Vault.RootUID,
+		Generated: time.Now().UTC(),
+		Alerts:    alerts,
+	})
+	if err != nil {
+		return err
+	}
+	ctx, cancel := context.WithTimeout(ctx, webhookTimeout)
+	defer cancel()
+	request, err := http.NewRequest(http.MethodPost, url, bytes.NewReader(payload))
+	if err != nil {
+		return err
+	}
+	request.Header.Set("Content-Type", "application/json")
+	response, err := http.DefaultClient.Do(request.WithContext(ctx))
+	if err != nil {
+		return err
+	}
+	defer response.Body.Close()
+	if response.StatusCode < 200 || response.StatusCode > 299 {
+		return fmt.Errorf("webhook answered %v", response.Status)
+	}
+	return nil
+}
+
+func (notifier *Notifier) sendEmail(address string, alerts []Alert) error {
+	config := notifier.Config.SMTP
+	urgent := alerts[0]
+	for _, alert := range alerts {
+		if alert.DaysLeft < urgent.DaysLeft {
+			urgent = alert
+		}
+	}
+
+	var message bytes.Buffer
+	fmt.Fprintf(&message, "From: %v\r\n", config.From)
+	fmt.Fprintf(&message, "To: %v\r\n", address)
+	fmt.Fprintf(&message, "Subject: privki: %d alerts for vault %v, %d days left\r\n", len(alerts), notifier.Vault.RootUID, urgent.DaysLeft)
+	fmt.Fprintf(&message, "Date: %v\r\n", time.Now().Format(time.RFC1123Z))
+	message.WriteString("Content-Type: text/plain; charset=utf-8\r\n\r\n")
+	for _, alert := range alerts {
+		message.WriteString(alert.String() + "\r\n")
+	}
+
+	var auth smtp.Auth
+	if config.Username != "" {
+		auth = smtp.PlainAuth("", config.Username, config.Password, config.Host)
+	}
+	server := net.JoinHostPort(config.Host, strconv.Itoa(config.Port))
+	return smtp.SendMail(server, auth, config.From, []string{address}, message.Bytes())
+}
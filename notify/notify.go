@@ -0,0 +1,336 @@
+package notify
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	log "github.com/sirupsen/logrus"
+	"io/ioutil"
+	"math"
+	"os"
+	"path/filepath"
+	"sfcert/openssl"
+	"sfcert/pkg/ca"
+	"sort"
+	"strings"
+	"time"
+)
+
+// stateFileName remembers the alerts already delivered, inside the notify dir of the
+// privki config dir. It is kept out of the PKI dir and of the vault history.
+const stateFileName = "notify.state"
+
+// Alert kinds
+const (
+	KindExpiry = "expiry"
+	KindCRL    = "crl"
+)
+
+// Alert is a certificate close to expiry, or a CRL close to its nextUpdate
+type Alert struct {
+	Kind string `json:"kind"`
+	// CA is a0, dr-a0 or the A1 or A2 ID the alert belongs to
+	CA string `json:"ca"`
+	// Serial is set for certificates issued by CA, it is empty for the CA certificate itself
+	Serial    string    `json:"serial,omitempty"`
+	Subject   string    `json:"subject"`
+	CRLNumber string    `json:"crl_number,omitempty"`
+	Deadline  time.Time `json:"deadline"`
+	DaysLeft  int       `json:"days_left"`
+	// Threshold is the escalation level reached, in days
+	Threshold int `json:"threshold_days"`
+}
+
+// key identifies an alert at its escalation level, so that it is delivered once per level
+func (alert Alert) key() string {
+	return strings.Join([]string{alert.Kind, alert.CA, alert.Serial, alert.CRLNumber, fmt.Sprint(alert.Threshold)}, "/")
+}
+
+func (alert Alert) String() string {
+	target := alert.Subject
+	if alert.Serial != "" {
+		target += " (" + alert.Serial + ")"
+	}
+	if alert.Kind == KindCRL {
+		if alert.DaysLeft < 0 {
+			return fmt.Sprintf("CRL %v of %v is stale since %v", alert.CRLNumber, alert.Subject, alert.Deadline.Format(time.RFC3339))
+		}
+		return fmt.Sprintf("CRL %v of %v is due for an update in %d days, on %v", alert.CRLNumber, alert.Subject, alert.DaysLeft, alert.Deadline.Format(time.RFC3339))
+	}
+	if alert.DaysLeft < 0 {
+		return fmt.Sprintf("%v of CA %v expired on %v", target, alert.CA, alert.Deadline.Format(time.RFC3339))
+	}
+	return fmt.Sprintf("%v of CA %v expires in %d days, on %v", target, alert.CA, alert.DaysLeft, alert.Deadline.Format(time.RFC3339))
+}
+
+// Delivery is the outcome of notifying a destination, a webhook URL or mailto: address
+type Delivery struct {
+	Destination string `json:"destination"`
+	Alerts      int    `json:"alerts"`
+	Error       string `json:"error,omitempty"`
+}
+
+// Notifier collects the alerts of a vault and delivers the new ones
+type Notifier struct {
+	Vault  *ca.Vault
+	Config *Config
+	// DryRun collects alerts without delivering them or recording them as delivered
+	DryRun bool
+}
+
+// Run collects the current alerts and delivers each one once per escalation level and
+// destination. Deliveries that fail are retried on the next run.
+func (notifier *Notifier) Run(ctx context.Context) ([]Alert, []Delivery, error) {
+	alerts, err := notifier.Collect(ctx, time.Now())
+	if err != nil {
+		return nil, nil, err
+	}
+	state, err := notifier.readState()
+	if err != nil {
+		return nil, nil, err
+	}
+
+	pending := map[string][]Alert{}
+	var destinations []string
+	delivered := map[string]time.Time{}
+	for _, alert := range alerts {
+		recipients, err := notifier.recipients(ctx, alert)
+		if err != nil {
+			return nil, nil, err
+		}
+		for _, destination := range recipients {
+			stateKey := alert.key() + "|" + destination
+			if sent, ok := state[stateKey]; ok {
+				delivered[stateKey] = sent
+				continue
+			}
+			if _, ok := pending[destination]; !ok {
+				destinations = append(destinations, destination)
+			}
+			pending[destination] = append(pending[destination], alert)
+		}
+	}
+	if notifier.DryRun {
+		var deliveries []Delivery
+		for _, destination := range destinations {
+			deliveries = append(deliveries, Delivery{Destination: destination, Alerts: len(pending[destination])})
+		}
+		return alerts, deliveries, nil
+	}
+
+	var deliveries []Delivery
+	for _, destination := range destinations {
+		delivery := Delivery{Destination: destination, Alerts: len(pending[destination])}
+		var sendErr error
+		if strings.HasPrefix(destination, "mailto:") {
+			sendErr = notifier.sendEmail(strings.TrimPrefix(destination, "mailto:"), pending[destination])
+		} else {
+			sendErr = notifier.postWebhook(ctx, destination, pending[destination])
+		}
+		if sendErr != nil {
+			log.Warnf("Unable to notify %v: %v", destination, sendErr)
+			delivery.Error = sendErr.Error()
+		} else {
+			for _, alert := range pending[destination] {
+				delivered[alert.key()+"|"+destination] = time.Now().UTC()
+			}
+		}
+		deliveries = append(deliveries, delivery)
+	}
+	// alerts that are no longer raised are forgotten, the state stays small
+	if err := notifier.writeState(delivered); err != nil {
+		return alerts, deliveries, err
+	}
+	return alerts, deliveries, nil
+}
+
+// Collect returns the alerts of the vault at time now, the CA certificates, the
+// valid certificates they issued and their CRLs
+func (notifier *Notifier) Collect(ctx context.Context, now time.Time) ([]Alert, error) {
+	vault := notifier.Vault
+	var alerts []Alert
+	if !vault.Subordinate() {
+		roots, err := vault.Roots()
+		if err != nil {
+			return nil, err
+		}
+		for i, root := range roots {
+			id := "a0"
+			if i == 1 {
+				id = "dr-a0"
+			}
+			if alert, ok := notifier.expiryAlert(id, "", root.Subject.String(), root.NotAfter, now); ok {
+				alerts = append(alerts, alert)
+			}
+			crlAlert, ok, err := notifier.crlAlert(ctx, id, root.Subject.String(), now)
+			if err != nil {
+				return nil, err
+			}
+			if ok {
+				alerts = append(alerts, crlAlert)
+			}
+		}
+	}
+
+	intermediates, err := vault.Intermediates(ctx)
+	if err != nil {
+		return nil, err
+	}
+	for _, intermediate := range intermediates {
+		if alert, ok := notifier.expiryAlert(intermediate.ID, "", intermediate.Subject, intermediate.NotAfter, now); ok {
+			alerts = append(alerts, alert)
+		}
+		if intermediate.ExternalKey {
+			continue
+		}
+		crlAlert, ok, err := notifier.crlAlert(ctx, intermediate.ID, intermediate.Subject, now)
+		if err != nil {
+			return nil, err
+		}
+		if ok {
+			alerts = append(alerts, crlAlert)
+		}
+		entries, err := vault.Certificates(ctx, intermediate.ID)
+		if err != nil {
+			return nil, err
+		}
+		for _, entry := range entries {
+			if entry.Status != "V" {
+				continue
+			}
+			if alert, ok := notifier.expiryAlert(intermediate.ID, entry.Serial, entry.Subject, entry.Expiry, now); ok {
+				alerts = append(alerts, alert)
+			}
+		}
+	}
+	sort.SliceStable(alerts, func(i, j int) bool { return alerts[i].Deadline.Before(alerts[j].Deadline) })
+	return alerts, nil
+}
+
+func (notifier *Notifier) expiryAlert(id string, serial string, subject string, notAfter time.Time, now time.Time) (Alert, bool) {
+	daysLeft := daysUntil(notAfter, now)
+	threshold, ok := notifier.level(daysLeft)
+	if !ok {
+		return Alert{}, false
+	}
+	return Alert{Kind: KindExpiry, CA: id, Serial: serial, Subject: subject, Deadline: notAfter.UTC(), DaysLeft: daysLeft, Threshold: threshold}, true
+}
+
+func (notifier *Notifier) crlAlert(ctx context.Context, id string, subject string, now time.Time) (Alert, bool, error) {
+	info, err := notifier.Vault.CRLInfo(ctx, id)
+	if os.IsNotExist(err) {
+		return Alert{}, false, nil
+	}
+	if err != nil {
+		return Alert{}, false, err
+	}
+	daysLeft := daysUntil(info.NextUpdate, now)
+	if daysLeft > notifier.Config.CRLDays {
+		return Alert{}, false, nil
+	}
+	return Alert{Kind: KindCRL, CA: id, Subject: subject, CRLNumber: info.Number, Deadline: info.NextUpdate.UTC(), DaysLeft: daysLeft, Threshold: notifier.Config.CRLDays}, true, nil
+}
+
+// level returns the most urgent threshold reached daysLeft before a deadline
+func (notifier *Notifier) level(daysLeft int) (int, bool) {
+	for _, threshold := range notifier.Config.Thresholds {
+		if daysLeft <= threshold.Days {
+			return threshold.Days, true
+		}
+	}
+	return 0, false
+}
+
+// recipients are the destinations of an alert: everyone, the owners of its A1 and the
+// escalation contacts of its level and the less urgent ones
+func (notifier *Notifier) recipients(ctx context.Context, alert Alert) ([]string, error) {
+	config := notifier.Config
+	var recipients []string
+	add := func(email []string, webhooks []string) {
+		for _, address := range email {
+			recipients = appendUnique(recipients, "mailto:"+address)
+		}
+		for _, webhook := range webhooks {
+			recipients = appendUnique(recipients, webhook)
+		}
+	}
+	add(config.Email, config.Webhooks)
+	if owners, ok := config.Owners[alert.CA]; ok {
+		add(owners.Email, owners.Webhooks)
+	}
+	if alert.CA != "a0" && alert.CA != "dr-a0" {
+		intermediate, err := notifier.Vault.Intermediate(ctx, alert.CA)
+		if err != nil {
+			return nil, err
+		}
+		if owners, ok := config.Owners[intermediate.Parent]; ok && intermediate.Parent != "" {
+			add(owners.Email, owners.Webhooks)
+		}
+	}
+	if alert.Kind == KindExpiry {
+		for _, threshold := range config.Thresholds {
+			if threshold.Days >= alert.Threshold {
+				add(threshold.Email, threshold.Webhooks)
+			}
+		}
+	}
+	return recipients, nil
+}
+
+// stateFile returns the file that remembers the alerts delivered
+func stateFile() string {
+	return filepath.Join(openssl.GetPkiConfigDir(), "notify", stateFileName)
+}
+
+func (notifier *Notifier) readState() (map[string]time.Time, error) {
+	state := map[string]time.Time{}
+	stateBytes, err := ioutil.ReadFile(stateFile())
+	if os.IsNotExist(err) {
+		// an older privki kept the state in the PKI dir
+		stateBytes, err = ioutil.ReadFile(filepath.Join(notifier.Vault.Path, stateFileName))
+	}
+	if os.IsNotExist(err) {
+		return state, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(stateBytes, &state); err != nil {
+		return nil, fmt.Errorf("%v: %v", stateFileName, err)
+	}
+	return state, nil
+}
+
+func (notifier *Notifier) writeState(state map[string]time.Time) error {
+	stateBytes, err := json.MarshalIndent(state, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(stateFile()), 0700); err != nil {
+		return err
+	}
+	if err := ioutil.WriteFile(stateFile()+".tmp", stateBytes, 0600); err != nil {
+		return err
+	}
+	if err := os.Rename(stateFile()+".tmp", stateFile()); err != nil {
+		return err
+	}
+	if err := os.Remove(filepath.Join(notifier.Vault.Path, stateFileName)); err != nil && !os.IsNotExist(err) {
+		log.Warnf("Unable to remove the former notify state in the PKI dir: %v", err)
+	}
+	return nil
+}
+
+// daysUntil counts the whole days left before deadline, negative once it passed
+func daysUntil(deadline time.Time, now time.Time) int {
+	return int(math.Floor(deadline.Sub(now).Hours() / 24))
+}
+
+func appendUnique(values []string, value string) []string {
+	for _, existing := range values {
+		if existing == value {
+			return values
+		}
+	}
+	return append(values, value)
+}
@@ -0,0 +1,226 @@
+package notify
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sfcert/internal/vaulttest"
+	"sfcert/pkg/ca"
+	"strings"
+	"sync"
+	"testing"
+)
+
+// expiringVault returns a vault whose A1 issued a certificate expiring in 30 days,
+// and a config raising alerts 60 days before a deadline
+func expiringVault(t *testing.T) (*ca.Vault, *Config, string) {
+	t.Helper()
+	vault, intermediate := vaulttest.New(t)
+	issued, err := vault.Issue(context.Background(), intermediate.ID, ca.IssueOptions{
+		IssueRequest: ca.IssueRequest{CommonName: "db01.cluster.internal", Profile: "client", Days: 30},
+		Passphrase:   vaulttest.A1Passphrase,
+	})
+	if err != nil {
+		t.Fatalf("Issue: %v", err)
+	}
+	config := &Config{Thresholds: []Threshold{{Days: 60}}, CRLDays: 7, SMTP: SMTP{Host: "127.0.0.1", Port: 25, From: "privki@localhost"}}
+	return vault, config, issued.Serial
+}
+
+func TestRunWebhook(t *testing.T) {
+	vault, config, serial := expiringVault(t)
+	var lock sync.Mutex
+	var payloads []WebhookPayload
+	status := http.StatusServiceUnavailable
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		lock.Lock()
+		defer lock.Unlock()
+		var payload WebhookPayload
+		if err := json.NewDecoder(r.Body).Decode(&payload); err != nil {
+			t.Errorf("webhook payload: %v", err)
+		}
+		payloads = append(payloads, payload)
+		w.WriteHeader(status)
+	}))
+	defer server.Close()
+	config.Webhooks = []string{server.URL}
+	notifier := &Notifier{Vault: vault, Config: config}
+	ctx := context.Background()
+
+	// the webhook is down, the alerts are kept for the next run
+	_, deliveries, err := notifier.Run(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(deliveries) != 1 || deliveries[0].Error == "" {
+		t.Fatalf("deliveries to a failing webhook %+v, want a failed one", deliveries)
+	}
+
+	lock.Lock()
+	status = http.StatusOK
+	lock.Unlock()
+	_, deliveries, err = notifier.Run(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(deliveries) != 1 || deliveries[0].Error != "" {
+		t.Fatalf("the retry delivered %+v", deliveries)
+	}
+	lock.Lock()
+	if len(payloads) != 2 || !hasAlert(payloads[1].Alerts, serial) || payloads[1].Vault != vault.RootUID {
+		t.Errorf("the webhook received %+v, want the alert of %v", payloads, serial)
+	}
+	lock.Unlock()
+
+	// delivered alerts are not sent again
+	_, deliveries, err = notifier.Run(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	lock.Lock()
+	if len(deliveries) != 0 || len(payloads) != 2 {
+		t.Errorf("a delivered alert was sent again: %+v", deliveries)
+	}
+	lock.Unlock()
+	if _, err := os.Stat(stateFile()); err != nil {
+		t.Errorf("the notify state is not in the config dir: %v", err)
+	}
+	if _, err := os.Stat(filepath.Join(vault.Path, stateFileName)); !os.IsNotExist(err) {
+		t.Error("the notify state was written into the PKI dir")
+	}
+}
+
+func TestRunEmail(t *testing.T) {
+	vault, config, serial := expiringVault(t)
+	// the mail server turns the first message away, it is sent again on the next run
+	sink := newSMTPSink(t, 1)
+	config.SMTP.Port = sink.port
+	config.Email = []string{"pki-team@alpha.com"}
+	notifier := &Notifier{Vault: vault, Config: config}
+	ctx := context.Background()
+
+	_, deliveries, err := notifier.Run(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(deliveries) != 1 || deliveries[0].Error == "" || len(sink.messages()) != 0 {
+		t.Fatalf("deliveries to a rejecting mail server %+v, want a failed one", deliveries)
+	}
+	if _, deliveries, err = notifier.Run(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if len(deliveries) != 1 || deliveries[0].Error != "" || deliveries[0].Destination != "mailto:pki-team@alpha.com" {
+		t.Fatalf("the retry delivered %+v", deliveries)
+	}
+	messages := sink.messages()
+	if len(messages) != 1 || !strings.Contains(messages[0], "To: pki-team@alpha.com") || !strings.Contains(messages[0], serial) {
+		t.Errorf("the mail server received %q, want the alert of %v", messages, serial)
+	}
+
+	if _, deliveries, err = notifier.Run(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if len(deliveries) != 0 || len(sink.messages()) != 1 {
+		t.Errorf("a delivered alert was mailed again: %+v", deliveries)
+	}
+}
+
+func hasAlert(alerts []Alert, serial string) bool {
+	for _, alert := range alerts {
+		if alert.Serial == serial {
+			return true
+		}
+	}
+	return false
+}
+
+// smtpSink is a local mail server keeping the messages it receives, it answers MAIL FROM
+// with a temporary failure for the first reject sessions
+type smtpSink struct {
+	port     int
+	reject   int
+	lock     sync.Mutex
+	received []string
+}
+
+func newSMTPSink(t *testing.T, reject int) *smtpSink {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { listener.Close() })
+	sink := &smtpSink{port: listener.Addr().(*net.TCPAddr).Port, reject: reject}
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			go sink.serve(conn)
+		}
+	}()
+	return sink
+}
+
+func (sink *smtpSink) serve(conn net.Conn) {
+	defer conn.Close()
+	reader := bufio.NewReader(conn)
+	reply := func(line string) { conn.Write([]byte(line + "\r\n")) }
+	reply("220 localhost sink")
+	for {
+		line, err := reader.ReadString('\n')
+		if err != nil {
+			return
+		}
+		command := strings.ToUpper(strings.TrimSpace(line))
+		switch {
+		case strings.HasPrefix(command, "EHLO"), strings.HasPrefix(command, "HELO"):
+			reply("250 localhost")
+		case strings.HasPrefix(command, "MAIL FROM"):
+			sink.lock.Lock()
+			rejected := sink.reject > 0
+			sink.reject--
+			sink.lock.Unlock()
+			if rejected {
+				reply("451 try again later")
+				continue
+			}
+			reply("250 OK")
+		case strings.HasPrefix(command, "RCPT TO"):
+			reply("250 OK")
+		case command == "DATA":
+			reply("354 end with .")
+			var message strings.Builder
+			for {
+				line, err := reader.ReadString('\n')
+				if err != nil {
+					return
+				}
+				if line == ".\r\n" {
+					break
+				}
+				message.WriteString(line)
+			}
+			sink.lock.Lock()
+			sink.received = append(sink.received, message.String())
+			sink.lock.Unlock()
+			reply("250 OK")
+		case command == "QUIT":
+			reply("221 bye")
+			return
+		default:
+			reply("250 OK")
+		}
+	}
+}
+
+func (sink *smtpSink) messages() []string {
+	sink.lock.Lock()
+	defer sink.lock.Unlock()
+	return append([]string{}, sink.received...)
+}
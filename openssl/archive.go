@@ -0,0 +1,49 @@
+package openssl
+
+import (
+	"github.com/yeka/zip"
+	"io"
+	"os"
+	"path/filepath"
+)
+
+// encryptedArchive writes every file under sourceDir into an AES-256 encrypted zip
+// at archiveFile, stored under archiveRoot instead of their absolute location.
+func encryptedArchive(sourceDir string, archiveRoot string, archiveFile string, password string) error {
+	archiveOutput, err := os.OpenFile(archiveFile, os.O_CREATE|os.O_TRUNC|os.O_WRONLY, 0600)
+	if err != nil {
+		return err
+	}
+	defer archiveOutput.Close()
+	writer := zip.NewWriter(archiveOutput)
+
+	walker := func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if info.IsDir() {
+			return nil
+		}
+		relativePath, err := filepath.Rel(sourceDir, path)
+		if err != nil {
+			return err
+		}
+		file, err := os.Open(path)
+		if err != nil {
+			return err
+		}
+		defer file.Close()
+
+		encryptedWriter, err := writer.Encrypt(filepath.ToSlash(filepath.Join(archiveRoot, relativePath)), password, zip.AES256Encryption)
+		if err != nil {
+			return err
+		}
+		_, err = io.Copy(encryptedWriter, file)
+		return err
+	}
+	if err := filepath.Walk(sourceDir, walker); err != nil {
+		writer.Close()
+		return err
+	}
+	return writer.Close()
+}
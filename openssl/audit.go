@@ -0,0 +1,80 @@
+package openssl
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"os"
+	"os/user"
+	"path/filepath"
+	"time"
+)
+
+// auditFileName is the audit trail of a PKI dir, one JSON entry per line
+const auditFileName = "audit.log"
+
+// AuditEntry records who changed the status of a certificate, and why
+type AuditEntry struct {
+	Time    time.Time `json:"time"`
+	Action  string    `json:"action"`
+	CA      string    `json:"ca"`
+	Serial  string    `json:"serial"`
+	Reason  string    `json:"reason,omitempty"`
+	Comment string    `json:"comment,omitempty"`
+	Actor   string    `json:"actor"`
+}
+
+// recordAudit appends entry to the audit trail of the vault, the actor defaults to
+// the user and host running privki
+func recordAudit(caDir string, entry AuditEntry) error {
+	entry.Time = time.Now().UTC()
+	entry.CA = filepath.Base(caDir)
+	if entry.Actor == "" {
+		entry.Actor = localActor()
+	}
+	line, err := json.Marshal(entry)
+	if err != nil {
+		return err
+	}
+	auditFile, err := os.OpenFile(filepath.Join(filepath.Dir(caDir), auditFileName), os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0600)
+	if err != nil {
+		return err
+	}
+	defer auditFile.Close()
+	_, err = auditFile.Write(append(line, '\n'))
+	return err
+}
+
+// ReadAuditLog returns the audit trail of the vault at pkiPath, oldest first
+func ReadAuditLog(pkiPath string) ([]AuditEntry, error) {
+	auditFile, err := os.Open(filepath.Join(pkiPath, auditFileName))
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer auditFile.Close()
+
+	var entries []AuditEntry
+	scanner := bufio.NewScanner(auditFile)
+	for line := 1; scanner.Scan(); line++ {
+		var entry AuditEntry
+		if err := json.Unmarshal(scanner.Bytes(), &entry); err != nil {
+			return nil, fmt.Errorf("%v line %d: %v", auditFileName, line, err)
+		}
+		entries = append(entries, entry)
+	}
+	return entries, scanner.Err()
+}
+
+func localActor() string {
+	actor := "unknown"
+	if currentUser, err := user.Current(); err == nil {
+		actor = currentUser.Username
+	}
+	if hostname, err := os.Hostname(); err == nil {
+		actor += "@" + hostname
+	}
+	return actor
+}
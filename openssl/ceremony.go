@@ -0,0 +1,529 @@
+package openssl
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/hex"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"github.com/chuckpreslar/gofer"
+	pkger "github.com/markbates/pkger"
+	log "github.com/sirupsen/logrus"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"time"
+)
+
+// ceremonyBundleVersion is bumped whenever the request or response layout changes
+const ceremonyBundleVersion = 1
+
+// pendingDir holds the A1s whose request is waiting for the offline Root CA
+const pendingDir = "pending"
+
+// CeremonyRequest is the bundle an online host hands to the offline Root CA (A0)
+// to have a new A1 signed. The A1 key never leaves the online host, the bundle
+// is signed with it so its fields can not be altered on the way.
+type CeremonyRequest struct {
+	Version         int    `json:"version"`
+	Kind            string `json:"kind"`
+	Intermediate    string `json:"a1"`
+	Organization    string `json:"organization"`
+	NameRestriction string `json:"name_restriction,omitempty"`
+	OID             string `json:"oid"`
+	Created         string `json:"created"`
+	CSR             string `json:"csr"`
+	Signature       []byte `json:"signature"`
+}
+
+// CeremonyResponse is the bundle the offline Root CA (A0) returns with the signed
+// A1 certificates, the Root CA certificates and the vault settings, signed by A0.
+type CeremonyResponse struct {
+	Version           int    `json:"version"`
+	Kind              string `json:"kind"`
+	Intermediate      string `json:"a1"`
+	RootUID           string `json:"root_uid"`
+	OID               string `json:"oid"`
+	Organization      string `json:"organization"`
+	CommonName        string `json:"common_name"`
+	Created           string `json:"created"`
+	Certificate       string `json:"certificate"`
+	DRCertificate     string `json:"dr_certificate,omitempty"`
+	RootCertificate   string `json:"root_certificate"`
+	DRRootCertificate string `json:"dr_root_certificate,omitempty"`
+	Signature         []byte `json:"signature"`
+}
+
+// Offline ceremony related task definitions
+var taskCeremonyRequest = gofer.Register(gofer.Task{
+	Namespace:   "Ceremony",
+	Label:       "Request",
+	Description: "Generate an Intermediary CA (A1) key and request for the offline Root CA (A0)",
+	Action: func(arguments ...string) error {
+
+		requestDir := arguments[0]
+		orgName := arguments[1]
+		oid := arguments[2]
+		opensslPassoutString := arguments[3]
+		opensslPassinString := arguments[4]
+
+		prepareCmd := "mkdir -p " + shellQuote(requestDir) + "/{certreqs,certs,crl,newcerts,private} && cd " + shellQuote(requestDir) +
+			" && chmod 700 private && touch intermed-ca.index && echo 00 > intermed-ca.crlnum && openssl rand -hex 16 > intermed-ca.serial"
+		shellOutput := execute(prepareCmd)
+		if shellOutput.CmdError != nil {
+			log.Printf("\nUnable to write to folder : %v\n", requestDir)
+			return shellError(shellOutput)
+		}
+
+		if err := writeRequestConfig(requestDir, orgName, "A1", oid); err != nil {
+			log.Printf("\nUnable to write config file : %v/intermed-ca.cnf\n", requestDir)
+			return err
+		}
+
+		requestCmd := "cd " + shellQuote(requestDir) + " && export OPENSSL_CONF=./intermed-ca.cnf && openssl req " + opensslPassoutString + opensslPassinString + "-new -out intermed-ca.req.pem" +
+			" && cp private/intermed-ca.key private/intermed-ca.key.pem && chmod 400 private/intermed-ca.key private/intermed-ca.key.pem"
+		shellOutput = execute(requestCmd)
+		if shellOutput.CmdError != nil {
+			log.Printf("\nUnable to generate the A1 key and request in %v\n", requestDir)
+			return shellError(shellOutput)
+		}
+
+		// like A1:BlankConfig, the A1 keeps the template configuration with only the OID set
+		if err := writeIntermediateConfig(requestDir); err != nil {
+			return err
+		}
+		blankConfigCmd := "cd " + shellQuote(requestDir) + " && sed -i \"s/#customOID/" + oid + "/g; s/" + DefaultOID + "/" + oid + "/g\" intermed-ca.cnf"
+		shellOutput = execute(blankConfigCmd)
+		if shellOutput.CmdError != nil {
+			log.Printf("\nUnable to save active OID in config at %v/intermed-ca.cnf\n", requestDir)
+			return shellError(shellOutput)
+		}
+		return nil
+	},
+})
+
+// writeIntermediateConfig writes the A1 configuration template into dir
+func writeIntermediateConfig(dir string) error {
+	intermediaryConfigResource, err := pkger.Open("/resources/intermediary_ca.cnf")
+	if err != nil {
+		return err
+	}
+	defer intermediaryConfigResource.Close()
+	intermediaryConfigResourceStats, err := intermediaryConfigResource.Stat()
+	if err != nil {
+		return err
+	}
+	readBuffer := make([]byte, intermediaryConfigResourceStats.Size())
+	intermediaryConfigResource.Read(readBuffer)
+	return ioutil.WriteFile(filepath.Join(dir, "intermed-ca.cnf"), readBuffer, 0644)
+}
+
+var organizationPattern = regexp.MustCompile(`^[A-Za-z0-9][A-Za-z0-9 ._,&()-]*$`)
+
+var oidPattern = regexp.MustCompile(`^[0-9]+(\.[0-9]+)+$`)
+
+// writeRequestConfig writes the configuration a CA key and request are generated with,
+// the template with the organization, common name and OID of the CA rendered in Go, so
+// that none of them reaches a shell or breaks out of its line
+func writeRequestConfig(dir string, orgName string, commonName string, oid string) error {
+	if !organizationPattern.MatchString(orgName) {
+		return fmt.Errorf("invalid organization name %q", orgName)
+	}
+	if !oidPattern.MatchString(oid) {
+		return fmt.Errorf("invalid OID %q", oid)
+	}
+	if err := writeIntermediateConfig(dir); err != nil {
+		return err
+	}
+	configFile := filepath.Join(dir, "intermed-ca.cnf")
+	template, err := ioutil.ReadFile(configFile)
+	if err != nil {
+		return err
+	}
+	replacer := strings.NewReplacer("sampledom", orgName, "sfcc.tech", "cluster.internal", "subjectAltName", "#subjectAltName",
+		"Class_A1", "Class_"+commonName, "#customOID", oid, DefaultOID, oid)
+	lines := strings.Split(string(template), "\n")
+	for i, line := range lines {
+		if at := strings.Index(line, "organizationName        ="); at >= 0 {
+			line = line[:at] + "organizationName        =" + orgName
+		} else if at := strings.Index(line, "commonName              ="); at >= 0 {
+			line = line[:at] + "commonName              =" + commonName
+		}
+		lines[i] = replacer.Replace(line)
+	}
+	return ioutil.WriteFile(configFile, []byte(strings.Join(lines, "\n")), 0644)
+}
+
+// CreateCeremonyRequest generates the key and request of a new A1 on this online host,
+// and returns the request bundle to carry to the offline Root CA (A0).
+// The OID defaults to the one of the vault on this host, or DefaultOID without a vault.
+func CreateCeremonyRequest(orgName string, nameRestriction string, oid string, passphrase string) (*CeremonyRequest, error) {
+	if orgName == "" {
+		return nil, errors.New("an organization or project name is required for an A1")
+	}
+	if !organizationPattern.MatchString(orgName) {
+		return nil, fmt.Errorf("invalid organization name %q", orgName)
+	}
+	if err := checkNameRestriction(nameRestriction); err != nil {
+		return nil, err
+	}
+	if len(passphrase) < 6 {
+		return nil, errors.New("the A1 passphrase must be at least 6 characters")
+	}
+	if oid == "" {
+		if oid, _ = GetOid(); oid == "" {
+			oid = DefaultOID
+		}
+	}
+
+	request := &CeremonyRequest{
+		Version:         ceremonyBundleVersion,
+		Kind:            "request",
+		Intermediate:    time.Now().UTC().AddDate(0, 0, -1).Format("20060102150405Z"),
+		Organization:    orgName,
+		NameRestriction: nameRestriction,
+		OID:             oid,
+		Created:         time.Now().UTC().Format(time.RFC3339),
+	}
+	requestDir := pendingRequestDir(request.Intermediate)
+	if dirExists(requestDir) {
+		return nil, fmt.Errorf("a request for A1 %v is already pending at %v", request.Intermediate, requestDir)
+	}
+	if err := gofer.Perform("Ceremony:Request", requestDir, orgName, oid, opensslPassout(passphrase), opensslPassin(passphrase)); err != nil {
+		os.RemoveAll(requestDir)
+		return nil, err
+	}
+
+	csrPEM, err := ioutil.ReadFile(filepath.Join(requestDir, "intermed-ca.req.pem"))
+	if err != nil {
+		return nil, err
+	}
+	request.CSR = string(csrPEM)
+	workDir, err := ioutil.TempDir("", "privki-ceremony")
+	if err != nil {
+		return nil, err
+	}
+	defer os.RemoveAll(workDir)
+	if request.Signature, err = signContent(workDir, filepath.Join(requestDir, "private", "intermed-ca.key.pem"), passphrase, request.signedContent()); err != nil {
+		return nil, err
+	}
+	log.Printf("A1 %v key and request saved at %v, awaiting the offline Root CA", request.Intermediate, requestDir)
+	return request, nil
+}
+
+// SignCeremonyRequest verifies a request bundle on the offline Root CA host, signs the
+// A1 with the Root CA (A0), cross signs it with the DR Root CA when DR is enabled,
+// and returns the response bundle to carry back to the online host.
+func SignCeremonyRequest(request *CeremonyRequest, rootPassphrase string, drPassphrase string) (*CeremonyResponse, error) {
+	if err := RequireRootVault(); err != nil {
+		return nil, err
+	}
+	pkiPath, err := GetPkiPath()
+	if err != nil {
+		return nil, err
+	}
+	rootCertUID, err := GetRootUID()
+	if err != nil {
+		return nil, err
+	}
+	settings, err := readVaultSettings()
+	if err != nil {
+		return nil, err
+	}
+	if err := request.verify(settings.oid); err != nil {
+		return nil, err
+	}
+	// the bundle comes from the online host, its name restriction is rendered into the Root CA config
+	if err := checkNameRestriction(request.NameRestriction); err != nil {
+		return nil, err
+	}
+	startTime, err := time.Parse("20060102150405Z", request.Intermediate)
+	if err != nil {
+		return nil, fmt.Errorf("request has an invalid A1 ID %q", request.Intermediate)
+	}
+	if _, err := FindIntermediate(pkiPath, rootCertUID, request.Intermediate); err == nil {
+		return nil, fmt.Errorf("A1 %v already exists in this vault", request.Intermediate)
+	}
+	transaction, err := signIntermediateRequest(pkiPath, rootCertUID, []byte(request.CSR), request.NameRestriction, DefaultA1PathLen, startTime, "", false, rootPassphrase, drPassphrase)
+	if err != nil {
+		return nil, err
+	}
+	// the A1 only stays in the Root CA databases once its response is signed
+	signed := false
+	defer func() {
+		if !signed {
+			transaction.rollback()
+		}
+	}()
+	stagingDir := transaction.stagingDir
+
+	response := &CeremonyResponse{
+		Version:      ceremonyBundleVersion,
+		Kind:         "response",
+		Intermediate: request.Intermediate,
+		RootUID:      rootCertUID,
+		OID:          settings.oid,
+		Organization: settings.organization,
+		CommonName:   settings.commonName,
+		Created:      time.Now().UTC().Format(time.RFC3339),
+	}
+	for target, certificateFile := range map[*string]string{
+		&response.Certificate:       filepath.Join(stagingDir, "intermed-ca.cert.pem"),
+		&response.DRCertificate:     filepath.Join(stagingDir, "intermed-ca.dr.cert.pem"),
+		&response.RootCertificate:   filepath.Join(RootCADir(pkiPath, rootCertUID), "root-ca.cert.pem"),
+		&response.DRRootCertificate: filepath.Join(DRRootCADir(pkiPath, rootCertUID), "root-ca.cert.pem"),
+	} {
+		certificatePEM, err := ioutil.ReadFile(certificateFile)
+		if os.IsNotExist(err) {
+			continue
+		} else if err != nil {
+			return nil, err
+		}
+		*target = string(certificatePEM)
+	}
+
+	workDir, err := ioutil.TempDir("", "privki-ceremony")
+	if err != nil {
+		return nil, err
+	}
+	defer os.RemoveAll(workDir)
+	rootKeyFile := filepath.Join(RootCADir(pkiPath, rootCertUID), "private", "root-ca.key.pem")
+	if response.Signature, err = signContent(workDir, rootKeyFile, rootPassphrase, response.signedContent()); err != nil {
+		return nil, err
+	}
+	if err := transaction.commit(""); err != nil {
+		return nil, err
+	}
+	signed = true
+	os.RemoveAll(stagingDir)
+	return response, nil
+}
+
+// ImportCeremonyResponse verifies a response bundle against the trusted Root CA certificates
+// and completes the pending A1 it answers, initializing a subordinate vault if this host has none.
+func ImportCeremonyResponse(response *CeremonyResponse, trusted []*x509.Certificate) (*Intermediate, error) {
+	requestDir := pendingRequestDir(response.Intermediate)
+	if !dirExists(requestDir) {
+		return nil, fmt.Errorf("no pending request for A1 %v on this host", response.Intermediate)
+	}
+	signer, err := response.verify(trusted)
+	if err != nil {
+		return nil, err
+	}
+	csrPEM, err := ioutil.ReadFile(filepath.Join(requestDir, "intermed-ca.req.pem"))
+	if err != nil {
+		return nil, err
+	}
+	if err := verifyCeremonyCertificates(response, signer, csrPEM); err != nil {
+		return nil, err
+	}
+
+	manifest := handoffManifest{
+		RootUID:      response.RootUID,
+		Intermediate: response.Intermediate,
+		OID:          response.OID,
+		Organization: response.Organization,
+		CommonName:   response.CommonName,
+	}
+	files := map[string][]byte{"roots/root-ca.cert.pem": []byte(response.RootCertificate)}
+	if response.DRRootCertificate != "" {
+		files["roots/dr-root-ca.cert.pem"] = []byte(response.DRRootCertificate)
+	}
+	pkiPath, err := vaultPathFor(manifest, files)
+	if err != nil {
+		return nil, err
+	}
+	intermediateDir := filepath.Join(pkiPath, response.RootUID+intermediateDirMarker+"-"+response.Intermediate)
+	if dirExists(intermediateDir) {
+		return nil, fmt.Errorf("A1 %v is already present at %v", response.Intermediate, intermediateDir)
+	}
+
+	certificateFiles := map[string]string{
+		"intermed-ca.cert.pem":              response.Certificate,
+		"intermed-ca-chain-bundle.cert.pem": response.Certificate + response.RootCertificate,
+	}
+	if response.DRCertificate != "" {
+		certificateFiles["intermed-ca.dr.cert.pem"] = response.DRCertificate
+		certificateFiles["intermed-ca-chain-bundle.dr.cert.pem"] = response.DRCertificate + response.DRRootCertificate
+	}
+	for name, content := range certificateFiles {
+		if err := ioutil.WriteFile(filepath.Join(requestDir, name), []byte(content), 0644); err != nil {
+			return nil, err
+		}
+	}
+	if err := os.Rename(requestDir, intermediateDir); err != nil {
+		return nil, err
+	}
+	log.Printf("A1 %v installed at %v", response.Intermediate, intermediateDir)
+	return FindIntermediate(pkiPath, response.RootUID, response.Intermediate)
+}
+
+// ReadCeremonyRequest reads a request bundle written by privki ceremony request
+func ReadCeremonyRequest(requestFile string) (*CeremonyRequest, error) {
+	request := new(CeremonyRequest)
+	if err := readCeremonyBundle(requestFile, "request", request, &request.Kind, &request.Version); err != nil {
+		return nil, err
+	}
+	return request, nil
+}
+
+// ReadCeremonyResponse reads a response bundle written by privki ceremony sign
+func ReadCeremonyResponse(responseFile string) (*CeremonyResponse, error) {
+	response := new(CeremonyResponse)
+	if err := readCeremonyBundle(responseFile, "response", response, &response.Kind, &response.Version); err != nil {
+		return nil, err
+	}
+	return response, nil
+}
+
+func readCeremonyBundle(bundleFile string, kind string, bundle interface{}, bundleKind *string, bundleVersion *int) error {
+	bundleBytes, err := ioutil.ReadFile(bundleFile)
+	if err != nil {
+		return err
+	}
+	if err := json.Unmarshal(bundleBytes, bundle); err != nil {
+		return fmt.Errorf("%v is not a privki ceremony %v: %v", bundleFile, kind, err)
+	}
+	if *bundleKind != kind {
+		return fmt.Errorf("%v is a ceremony %v, not a %v", bundleFile, *bundleKind, kind)
+	}
+	if *bundleVersion != ceremonyBundleVersion {
+		return fmt.Errorf("unsupported ceremony %v version %v", kind, *bundleVersion)
+	}
+	return nil
+}
+
+// pendingRequestDir is where an A1 waits on the online host for its certificates
+func pendingRequestDir(id string) string {
+	return filepath.Join(GetPkiBaseDir(), pendingDir, id)
+}
+
+// signedContent is the byte string the A1 key signs, every request field but the signature
+func (request *CeremonyRequest) signedContent() []byte {
+	return []byte(strings.Join([]string{
+		fmt.Sprintf("privki-ceremony-request-v%d", request.Version),
+		request.Intermediate,
+		request.Organization,
+		request.NameRestriction,
+		request.OID,
+		request.Created,
+		hex.EncodeToString(sha256Sum([]byte(request.CSR))),
+	}, "\n"))
+}
+
+// verify checks the request is signed by the key of its CSR and fits the vault OID
+func (request *CeremonyRequest) verify(oid string) error {
+	csr, err := parseCertificateRequest([]byte(request.CSR))
+	if err != nil {
+		return err
+	}
+	publicKey, isRSA := csr.PublicKey.(*rsa.PublicKey)
+	if !isRSA {
+		return errors.New("request key must be an RSA key")
+	}
+	if err := rsa.VerifyPKCS1v15(publicKey, crypto.SHA256, sha256Sum(request.signedContent()), request.Signature); err != nil {
+		return errors.New("request bundle signature does not verify, it was modified after it was created")
+	}
+	if request.OID != oid {
+		return fmt.Errorf("request was made for OID %v, this vault uses %v, create it again with --oid=%v", request.OID, oid, oid)
+	}
+	return nil
+}
+
+// signedContent is the byte string the Root CA signs, every response field but the signature
+func (response *CeremonyResponse) signedContent() []byte {
+	fields := []string{
+		fmt.Sprintf("privki-ceremony-response-v%d", response.Version),
+		response.Intermediate,
+		response.RootUID,
+		response.OID,
+		response.Organization,
+		response.CommonName,
+		response.Created,
+	}
+	for _, certificatePEM := range []string{response.Certificate, response.DRCertificate, response.RootCertificate, response.DRRootCertificate} {
+		fields = append(fields, hex.EncodeToString(sha256Sum([]byte(certificatePEM))))
+	}
+	return []byte(strings.Join(fields, "\n"))
+}
+
+// verify checks the response is signed by one of the trusted Root CAs
+func (response *CeremonyResponse) verify(trusted []*x509.Certificate) (*x509.Certificate, error) {
+	signers, err := ParseCertificates([]byte(response.RootCertificate))
+	if err != nil {
+		return nil, fmt.Errorf("response Root CA certificate: %v", err)
+	}
+	signer := signers[0]
+	isTrusted := false
+	for _, root := range trusted {
+		if bytes.Equal(root.Raw, signer.Raw) {
+			isTrusted = true
+		}
+	}
+	if !isTrusted {
+		return nil, fmt.Errorf("response is signed by %v, which is not a trusted Root CA", signer.Subject)
+	}
+	if err := signer.CheckSignature(x509.SHA256WithRSA, response.signedContent(), response.Signature); err != nil {
+		return nil, fmt.Errorf("response signature does not verify: %v", err)
+	}
+	return signer, nil
+}
+
+// verifyCeremonyCertificates checks the returned A1 certificates chain to their roots
+// and certify the key of the pending request.
+func verifyCeremonyCertificates(response *CeremonyResponse, signer *x509.Certificate, csrPEM []byte) error {
+	csr, err := parseCertificateRequest(csrPEM)
+	if err != nil {
+		return err
+	}
+	checks := []struct {
+		certificatePEM string
+		rootPEM        string
+	}{{response.Certificate, response.RootCertificate}}
+	if response.DRCertificate != "" {
+		checks = append(checks, struct {
+			certificatePEM string
+			rootPEM        string
+		}{response.DRCertificate, response.DRRootCertificate})
+	}
+	for _, check := range checks {
+		certificates, err := ParseCertificates([]byte(check.certificatePEM))
+		if err != nil {
+			return err
+		}
+		roots, err := ParseCertificates([]byte(check.rootPEM))
+		if err != nil {
+			return err
+		}
+		rootPool := x509.NewCertPool()
+		rootPool.AddCert(roots[0])
+		if _, err := certificates[0].Verify(x509.VerifyOptions{Roots: rootPool, KeyUsages: []x509.ExtKeyUsage{x509.ExtKeyUsageAny}}); err != nil {
+			return fmt.Errorf("returned A1 certificate does not chain to %v: %v", roots[0].Subject, err)
+		}
+		if !bytes.Equal(certificates[0].RawSubjectPublicKeyInfo, csr.RawSubjectPublicKeyInfo) {
+			return errors.New("returned A1 certificate is not for the key of the pending request")
+		}
+	}
+	return nil
+}
+
+func parseCertificateRequest(csrPEM []byte) (*x509.CertificateRequest, error) {
+	block, _ := pem.Decode(csrPEM)
+	if block == nil || block.Type != "CERTIFICATE REQUEST" {
+		return nil, errors.New("no PEM certificate request found")
+	}
+	csr, err := x509.ParseCertificateRequest(block.Bytes)
+	if err != nil {
+		return nil, err
+	}
+	if err := csr.CheckSignature(); err != nil {
+		return nil, fmt.Errorf("certificate request signature: %v", err)
+	}
+	return csr, nil
+}
@@ -18,9 +18,15 @@ const oidConfigFile string = "/.privki/config/oid"
 const primaryRootConfigFile string = "/.privki/config/primary_root"
 const pkiPathConfigFile string = "/.privki/config/pki_path"
 const rootCertUIDConfigFile = "/.privki/config/root_cert_uid"
+const modeConfigFile = "/.privki/config/mode"
+const lastBackupConfigFile = "/.privki/config/last_backup"
+const drPassphraseConfigFile = "/.privki/config/dr_passphrase"
 const pkiBaseDefault = "/.privki/"
 const DefaultDirPerms = 0755
 
+// DefaultOID is the Class Definition OID shipped in the CA configuration templates
+const DefaultOID = "1.3.6.1.5.5.7.8.5"
+
 var backupPassword string
 
 // Get the Host PKI Configuration Directory
@@ -63,6 +69,21 @@ func GetPrimaryRootConfigFile() string {
 	return GetUserHomeDir() + primaryRootConfigFile
 }
 
+// Gets config file that contains the vault mode, root or subordinate
+func GetModeConfigFile() string {
+	return GetUserHomeDir() + modeConfigFile
+}
+
+// Gets config file that contains the time of the last backup
+func GetLastBackupConfigFile() string {
+	return GetUserHomeDir() + lastBackupConfigFile
+}
+
+// Gets config file that records whether the DR Root CA has a passphrase of its own
+func GetDRPassphraseConfigFile() string {
+	return GetUserHomeDir() + drPassphraseConfigFile
+}
+
 // Gets config file that contains Dr status
 func GetDRStatusConfigFile() string {
 	return GetUserHomeDir() + drStatusConfigFile
@@ -71,36 +92,44 @@ func GetDRStatusConfigFile() string {
 // Gets use home directory where that is also users SSL & Certificate home
 // Note in Windows these both can be different, and SSL home will often be the roaming directory
 func GetUserHomeDir() string {
+	if homeDir, err := os.UserHomeDir(); err == nil {
+		return homeDir
+	}
 	currentUser, userError := user.Current()
 	if userError != nil {
-		log.Fatal(userError)
+		// neither $HOME nor a user entry, minimal containers
+		log.Warnf("unable to find the home directory of the current user: %v", userError)
+		return ""
 	}
 	return currentUser.HomeDir
 }
 
 // Set Custom OID if provided by the user
 // If not use default OID implemented by sample_org Cloud Corporation
-func SetCustomOid(customOID string) {
+func SetCustomOid(customOID string) error {
 	err := gofer.Perform("A0:SetCustomOID", customOID)
 	if err != nil {
 		log.Errorf("Error setting up custom OID.\n")
 	}
+	return err
 }
 
 // Sets vault wide Owner, and registers it as the Organization Name for A0
-func SetOrganizationName(organizationName string) {
+func SetOrganizationName(organizationName string) error {
 	setOrganizationNameErrors := gofer.Perform("A0:SetOrganizationName", organizationName)
 	if setOrganizationNameErrors != nil {
 		log.Errorf("Error setting Organization name. Task A0:SetOrganizationName\n")
 	}
+	return setOrganizationNameErrors
 }
 
 // Sets A0 Organization Common Name field.
-func SetOrganizationCommonName(organizationCommonName string) {
+func SetOrganizationCommonName(organizationCommonName string) error {
 	SetOrganizationCommonNameErrors := gofer.Perform("A0:SetOrganizationCommonName", organizationCommonName)
 	if SetOrganizationCommonNameErrors != nil {
 		log.Errorf("Error setting Organization Common name. Task A0:SetOrganizationCommonName\n")
 	}
+	return SetOrganizationCommonNameErrors
 }
 
 // fileExists checks if a file exists and is not a directory before we
@@ -113,14 +142,22 @@ func fileExists(filename string) bool {
 	return !info.IsDir()
 }
 
+func dirExists(dirname string) bool {
+	info, err := os.Stat(dirname)
+	if err != nil {
+		return false
+	}
+	return info.IsDir()
+}
+
 func GetBackupRestorePassword() string {
 	return backupPassword
 }
 
-func SetBackupRestorePassword() {
+func SetBackupRestorePassword() error {
 	selfBytes, err := os.Open(os.Args[0])
 	if err != nil {
-		log.Fatal(err)
+		return err
 	}
 	defer selfBytes.Close()
 	hasher := sha256.New()
@@ -128,9 +165,10 @@ func SetBackupRestorePassword() {
 	hasher.Write([]byte("SHA256_DIGMAC_CPSR"))
 	hasher.Write([]byte("HMAC_ID_CODEPSR"))
 	if _, err := io.Copy(hasher, selfBytes); err != nil {
-		log.Fatal(err)
+		return err
 	}
 	hasher.Write([]byte("AES256_CBC_CTR1"))
 
 	backupPassword = hex.EncodeToString(hasher.Sum(nil))
+	return nil
 }
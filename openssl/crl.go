@@ -0,0 +1,373 @@
+package openssl
+
+import (
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/asn1"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"github.com/chuckpreslar/gofer"
+	log "github.com/sirupsen/logrus"
+	"io/ioutil"
+	"math/big"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// oidDeltaCRLIndicator is the Delta CRL Indicator extension of RFC 5280
+var oidDeltaCRLIndicator = asn1.ObjectIdentifier{2, 5, 29, 27}
+
+// defaultDeltaCRLHours is the nextUpdate of a delta CRL generated without a period
+const defaultDeltaCRLHours = 24
+
+var databaseConfigLine = regexp.MustCompile(`(?m)^database\s*=.*$`)
+
+// CRLOptions describes a CRL to generate
+type CRLOptions struct {
+	// Days and Hours set the nextUpdate, the default_crl_days of the CA applies without them
+	Days  int
+	Hours int
+	// Delta generates a delta CRL of the revocations since the current full CRL
+	Delta bool
+}
+
+// CRLInfo describes a CRL of a CA
+type CRLInfo struct {
+	File       string    `json:"file"`
+	Delta      bool      `json:"delta"`
+	Number     string    `json:"crl_number"`
+	BaseNumber string    `json:"base_crl_number,omitempty"`
+	ThisUpdate time.Time `json:"this_update"`
+	NextUpdate time.Time `json:"next_update"`
+	Revoked    int       `json:"revoked"`
+}
+
+// PublishedCA is a CA of a published CRL directory, paths are relative to it
+type PublishedCA struct {
+	CA          string     `json:"ca"`
+	Subject     string     `json:"subject"`
+	Certificate string     `json:"certificate"`
+	CRL         string     `json:"crl,omitempty"`
+	DeltaCRL    string     `json:"delta_crl,omitempty"`
+	CRLNumber   string     `json:"crl_number,omitempty"`
+	NextUpdate  *time.Time `json:"next_update,omitempty"`
+}
+
+var taskCAGenerateDeltaCRL = gofer.Register(gofer.Task{
+	Namespace:   "CA",
+	Label:       "GenDeltaCRL",
+	Description: "Generate the delta Certificate Revocation List of a CA",
+	Action: func(arguments ...string) error {
+
+		caDir := arguments[0]
+		configFile := arguments[1]
+		opensslPassinString := arguments[2]
+		crlPeriod := arguments[3]
+
+		crlFile := "crl/" + strings.TrimSuffix(caConfigName(caDir), ".cnf") + ".delta.crl"
+		genDeltaCRLCmd := "cd " + shellQuote(caDir) + " && openssl ca -config " + shellQuote(configFile) + " " + opensslPassinString + "-gencrl -crlexts delta_crl_ext " + crlPeriod + "-out " + crlFile + ".tmp -batch && mv " + crlFile + ".tmp " + crlFile
+		shellOutput := execute(genDeltaCRLCmd)
+		if shellOutput.CmdError != nil {
+			log.Printf("\nDelta Revocation List generation error for CA at %v\n", caDir)
+			return shellError(shellOutput)
+		}
+		return nil
+	},
+})
+
+// IssueCRL generates a full or delta CRL of the CA at caDir. Both take their number from
+// the crlnum file of the CA, a delta CRL lists the revocations missing from the current
+// full CRL and carries its number in the Delta CRL Indicator.
+func IssueCRL(caDir string, passphrase string, options CRLOptions) (*CRLInfo, error) {
+	if options.Days < 0 || options.Hours < 0 {
+		return nil, errors.New("the CRL period must be positive")
+	}
+	if !options.Delta {
+		if err := gofer.Perform("CA:GenCRL", caDir, opensslPassin(passphrase), crlPeriod(options)); err != nil {
+			return nil, err
+		}
+		removeDeltaCRL(caDir)
+		syncDatabase(caDir)
+		return ReadCRLInfo(caDir, false)
+	}
+
+	base, err := ReadCRLInfo(caDir, false)
+	if os.IsNotExist(err) {
+		return nil, errors.New("a delta CRL needs a full CRL first, generate it without --delta")
+	}
+	if err != nil {
+		return nil, err
+	}
+	baseCRL, err := parseCRLFile(filepath.Join(caDir, base.File))
+	if err != nil {
+		return nil, err
+	}
+	inBase := map[string]bool{}
+	for _, revoked := range baseCRL.TBSCertList.RevokedCertificates {
+		inBase[SerialHex(revoked.SerialNumber)] = true
+	}
+
+	workDir, err := ioutil.TempDir("", "privki-delta-crl")
+	if err != nil {
+		return nil, err
+	}
+	defer os.RemoveAll(workDir)
+
+	name := strings.TrimSuffix(caConfigName(caDir), ".cnf")
+	index, err := ioutil.ReadFile(filepath.Join(caDir, name+".index"))
+	if err != nil {
+		return nil, err
+	}
+	var deltaIndex []string
+	for _, line := range strings.Split(string(index), "\n") {
+		fields := strings.Split(line, "\t")
+		if len(fields) < 6 || fields[0] != "R" {
+			continue
+		}
+		serial, ok := new(big.Int).SetString(fields[3], 16)
+		if ok && !inBase[SerialHex(serial)] {
+			deltaIndex = append(deltaIndex, line)
+		}
+	}
+	deltaIndexFile := filepath.Join(workDir, "delta.index")
+	content := strings.Join(deltaIndex, "\n")
+	if content != "" {
+		content += "\n"
+	}
+	if err := ioutil.WriteFile(deltaIndexFile, []byte(content), 0600); err != nil {
+		return nil, err
+	}
+	if err := ioutil.WriteFile(deltaIndexFile+".attr", []byte("unique_subject = no\n"), 0600); err != nil {
+		return nil, err
+	}
+
+	// the CA configuration, reading the delta index and adding the Delta CRL Indicator
+	config, err := ioutil.ReadFile(filepath.Join(caDir, caConfigName(caDir)))
+	if err != nil {
+		return nil, err
+	}
+	baseNumber, _ := new(big.Int).SetString(base.Number, 16)
+	config = databaseConfigLine.ReplaceAll(config, []byte("database                = "+deltaIndexFile))
+	config = append(config, []byte("\n[ delta_crl_ext ]\n"+
+		"authorityKeyIdentifier  = keyid:always\n"+
+		"issuerAltName           = issuer:copy\n"+
+		"2.5.29.27               = critical,ASN1:INTEGER:"+baseNumber.String()+"\n")...)
+	configFile := filepath.Join(workDir, "delta.cnf")
+	if err := ioutil.WriteFile(configFile, config, 0600); err != nil {
+		return nil, err
+	}
+
+	if err := gofer.Perform("CA:GenDeltaCRL", caDir, configFile, opensslPassin(passphrase), crlPeriod(options)); err != nil {
+		return nil, err
+	}
+	syncDatabase(caDir)
+	return ReadCRLInfo(caDir, true)
+}
+
+// ReadCRLInfo describes the current full CRL, or delta CRL, of the CA at caDir
+func ReadCRLInfo(caDir string, delta bool) (*CRLInfo, error) {
+	file := filepath.Join("crl", strings.TrimSuffix(caConfigName(caDir), ".cnf")+".crl")
+	if delta {
+		file = filepath.Join("crl", strings.TrimSuffix(caConfigName(caDir), ".cnf")+".delta.crl")
+	}
+	crl, err := parseCRLFile(filepath.Join(caDir, file))
+	if err != nil {
+		return nil, err
+	}
+	info := &CRLInfo{
+		File:       file,
+		Delta:      delta,
+		ThisUpdate: crl.TBSCertList.ThisUpdate,
+		NextUpdate: crl.TBSCertList.NextUpdate,
+		Revoked:    len(crl.TBSCertList.RevokedCertificates),
+	}
+	for _, extension := range crl.TBSCertList.Extensions {
+		number := new(big.Int)
+		if extension.Id.Equal(oidCRLNumber) || extension.Id.Equal(oidDeltaCRLIndicator) {
+			if _, err := asn1.Unmarshal(extension.Value, &number); err != nil {
+				return nil, fmt.Errorf("%v: %v", file, err)
+			}
+		}
+		if extension.Id.Equal(oidCRLNumber) {
+			info.Number = SerialHex(number)
+		}
+		if extension.Id.Equal(oidDeltaCRLIndicator) {
+			info.BaseNumber = SerialHex(number)
+		}
+	}
+	return info, nil
+}
+
+// PublishCRLs writes the certificates and the full and delta CRLs of every CA of the vault,
+// DER and PEM, into outDir: certs/<ca>.cert.der|pem, crl/<ca>.crl|crl.pem and
+// crl/<ca>.delta.crl|delta.crl.pem, with an index.json of the CAs. <ca> is a0, dr-a0 or
+// the A1 or A2 ID. Files are replaced atomically, so outDir can be served while publishing,
+// and the delta CRL of a CA that no longer has one is removed.
+func PublishCRLs(outDir string) ([]PublishedCA, error) {
+	pkiPath, err := GetPkiPath()
+	if err != nil {
+		return nil, err
+	}
+	rootCertUID, err := GetRootUID()
+	if err != nil {
+		return nil, err
+	}
+	caDirs := map[string]string{}
+	var names []string
+	for _, root := range []struct{ name, dir string }{
+		{"a0", RootCADir(pkiPath, rootCertUID)},
+		{"dr-a0", DRRootCADir(pkiPath, rootCertUID)},
+	} {
+		if fileExists(filepath.Join(root.dir, "root-ca.cert.pem")) {
+			caDirs[root.name] = root.dir
+			names = append(names, root.name)
+		}
+	}
+	intermediates, err := ListIntermediates(pkiPath, rootCertUID)
+	if err != nil {
+		return nil, err
+	}
+	for _, intermediate := range intermediates {
+		caDirs[intermediate.ID] = intermediate.Dir
+		names = append(names, intermediate.ID)
+	}
+
+	for _, dir := range []string{"certs", "crl"} {
+		if err := os.MkdirAll(filepath.Join(outDir, dir), 0755); err != nil {
+			return nil, err
+		}
+	}
+	var published []PublishedCA
+	for _, name := range names {
+		caDir := caDirs[name]
+		// partner A1s have no configuration, their certificate is still published
+		caName := "intermed-ca"
+		if name == "a0" || name == "dr-a0" {
+			caName = "root-ca"
+		}
+		cert, err := ReadCertificate(filepath.Join(caDir, caName+".cert.pem"))
+		if err != nil {
+			return nil, err
+		}
+		entry := PublishedCA{CA: name, Subject: cert.Subject.String(), Certificate: "certs/" + name + ".cert.pem"}
+		if err := publishPEM(outDir, "certs/"+name+".cert", filepath.Join(caDir, caName+".cert.pem")); err != nil {
+			return nil, err
+		}
+		for _, delta := range []bool{false, true} {
+			info, err := ReadCRLInfo(caDir, delta)
+			if os.IsNotExist(err) && delta {
+				// a full CRL superseded the delta CRL, relying parties must not fetch the stale one
+				if err := removePublished(outDir, "crl/"+name+".delta.crl"); err != nil {
+					return nil, err
+				}
+				continue
+			}
+			if os.IsNotExist(err) {
+				continue
+			}
+			if err != nil {
+				return nil, err
+			}
+			crlName := "crl/" + name + ".crl"
+			if delta {
+				crlName = "crl/" + name + ".delta.crl"
+				entry.DeltaCRL = crlName
+			} else {
+				entry.CRL = crlName
+				entry.CRLNumber = info.Number
+				entry.NextUpdate = &info.NextUpdate
+			}
+			if err := publishPEM(outDir, crlName, filepath.Join(caDir, info.File)); err != nil {
+				return nil, err
+			}
+		}
+		published = append(published, entry)
+	}
+
+	index, err := json.MarshalIndent(published, "", "  ")
+	if err != nil {
+		return nil, err
+	}
+	if err := writeFileAtomic(filepath.Join(outDir, "index.json"), append(index, '\n')); err != nil {
+		return nil, err
+	}
+	return published, nil
+}
+
+// publishPEM writes the PEM file source as <name>.der, or <name> for CRLs, and <name>.pem
+func publishPEM(outDir string, name string, source string) error {
+	pemBytes, err := ioutil.ReadFile(source)
+	if err != nil {
+		return err
+	}
+	block, _ := pem.Decode(pemBytes)
+	if block == nil {
+		return fmt.Errorf("%v is not PEM encoded", source)
+	}
+	derName := name
+	if strings.HasSuffix(name, ".cert") {
+		derName = name + ".der"
+	}
+	if err := writeFileAtomic(filepath.Join(outDir, derName), block.Bytes); err != nil {
+		return err
+	}
+	return writeFileAtomic(filepath.Join(outDir, name+".pem"), pem.EncodeToMemory(block))
+}
+
+// removePublished removes the DER and PEM files of a CRL published as name
+func removePublished(outDir string, name string) error {
+	for _, file := range []string{name, name + ".pem"} {
+		if err := os.Remove(filepath.Join(outDir, file)); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+	}
+	return nil
+}
+
+func writeFileAtomic(file string, content []byte) error {
+	if err := ioutil.WriteFile(file+".tmp", content, 0644); err != nil {
+		return err
+	}
+	return os.Rename(file+".tmp", file)
+}
+
+func parseCRLFile(file string) (*pkix.CertificateList, error) {
+	crlBytes, err := ioutil.ReadFile(file)
+	if err != nil {
+		return nil, err
+	}
+	crl, err := x509.ParseCRL(crlBytes)
+	if err != nil {
+		return nil, fmt.Errorf("%v: %v", file, err)
+	}
+	return crl, nil
+}
+
+// removeDeltaCRL drops the delta CRL of a CA once a newer full CRL supersedes its base
+func removeDeltaCRL(caDir string) {
+	deltaFile := filepath.Join(caDir, "crl", strings.TrimSuffix(caConfigName(caDir), ".cnf")+".delta.crl")
+	if err := os.Remove(deltaFile); err != nil && !os.IsNotExist(err) {
+		log.Warnf("Unable to remove the superseded delta CRL %v: %v", deltaFile, err)
+	}
+}
+
+func crlPeriod(options CRLOptions) string {
+	days, hours := options.Days, options.Hours
+	if options.Delta && days == 0 && hours == 0 {
+		hours = defaultDeltaCRLHours
+	}
+	period := ""
+	if days > 0 {
+		period += "-crldays " + strconv.Itoa(days) + " "
+	}
+	if hours > 0 {
+		period += "-crlhours " + strconv.Itoa(hours) + " "
+	}
+	return period
+}
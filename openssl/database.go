@@ -0,0 +1,426 @@
+package openssl
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	log "github.com/sirupsen/logrus"
+	bolt "go.etcd.io/bbolt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+// databaseFileName is the embedded certificate database inside the PKI dir of a vault
+const databaseFileName = "privki.db"
+
+// errCANotRecorded is returned for a CA that had no openssl ca operation since the migration
+var errCANotRecorded = errors.New("CA is not in the certificate database")
+
+// databaseOpenTimeout bounds the wait for another privki process holding the database
+const databaseOpenTimeout = 5 * time.Second
+
+// certificate authorities are keyed by the SHA-256 of their public key, so that
+// their records follow them through renames such as A1 creation or DR promotion
+var databaseCABucket = []byte("cas")
+var databaseCertBucket = []byte("certs")
+var databaseMetaKey = []byte("meta")
+
+// DatabaseCA describes a certificate authority of the embedded database, and the
+// openssl ca state files besides its index
+type DatabaseCA struct {
+	Key       string `json:"key"`
+	Dir       string `json:"dir"`
+	Name      string `json:"name"`
+	Subject   string `json:"subject"`
+	Serial    string `json:"serial"`
+	CRLNumber string `json:"crl_number,omitempty"`
+	Attr      string `json:"attr,omitempty"`
+	Synced    string `json:"synced"`
+	Entries   int    `json:"entries"`
+}
+
+// DatabaseRecord is a certificate issued by a CA of the embedded database. The raw
+// index fields are kept so that an export writes back the exact openssl database line.
+type DatabaseRecord struct {
+	IndexEntry
+	// Sequence keeps the order of the openssl index, the order certificates were issued in
+	Sequence        uint64 `json:"sequence"`
+	ExpiryField     string `json:"expiry_field"`
+	RevocationField string `json:"revocation_field,omitempty"`
+	FileField       string `json:"file_field"`
+	Certificate     string `json:"certificate,omitempty"`
+}
+
+// databaseFile returns the embedded database of the vault at pkiPath
+func databaseFile(pkiPath string) string {
+	return filepath.Join(pkiPath, databaseFileName)
+}
+
+// DatabaseEnabled reports whether the vault at pkiPath was migrated to the embedded database
+func DatabaseEnabled(pkiPath string) bool {
+	return fileExists(databaseFile(pkiPath))
+}
+
+// openDatabase opens the database of the vault, readers share it while a writer holds it alone
+func openDatabase(pkiPath string, readOnly bool) (*bolt.DB, error) {
+	db, err := bolt.Open(databaseFile(pkiPath), 0600, &bolt.Options{Timeout: databaseOpenTimeout, ReadOnly: readOnly})
+	if err != nil {
+		return nil, fmt.Errorf("certificate database %v: %v", databaseFile(pkiPath), err)
+	}
+	return db, nil
+}
+
+// MigrateDatabase creates the embedded database of the vault, or brings it up to date,
+// from the openssl index, serial and crlnum files of every CA directory, including
+// retired roots. The records of every CA end up matching its index.
+func MigrateDatabase() ([]DatabaseCA, error) {
+	pkiPath, err := GetPkiPath()
+	if err != nil {
+		return nil, err
+	}
+	caDirs, err := databaseCADirs(pkiPath)
+	if err != nil {
+		return nil, err
+	}
+	db, err := openDatabase(pkiPath, false)
+	if err != nil {
+		return nil, err
+	}
+	defer db.Close()
+
+	var migrated []DatabaseCA
+	err = db.Update(func(tx *bolt.Tx) error {
+		for _, caDir := range caDirs {
+			ca, err := recordCA(tx, caDir)
+			if err != nil {
+				return fmt.Errorf("%v: %v", filepath.Base(caDir), err)
+			}
+			migrated = append(migrated, *ca)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return migrated, nil
+}
+
+// syncDatabase records the openssl database of the CA at caDir into the embedded
+// database after openssl ca changed it, or after a rolled back transaction restored
+// it, the certificates the index no longer holds are removed. Vaults that were not migrated are left alone,
+// a failure is only logged since openssl already did its work, privki db migrate
+// catches up later.
+func syncDatabase(caDir string) {
+	pkiPath := filepath.Dir(caDir)
+	if !DatabaseEnabled(pkiPath) {
+		return
+	}
+	db, err := openDatabase(pkiPath, false)
+	if err == nil {
+		err = db.Update(func(tx *bolt.Tx) error {
+			_, err := recordCA(tx, caDir)
+			return err
+		})
+		db.Close()
+	}
+	if err != nil {
+		log.Warnf("Unable to record %v in the certificate database, run privki db migrate: %v", filepath.Base(caDir), err)
+	}
+}
+
+// DatabaseCAs lists the certificate authorities of the embedded database
+func DatabaseCAs(pkiPath string) ([]DatabaseCA, error) {
+	db, err := openDatabase(pkiPath, true)
+	if err != nil {
+		return nil, err
+	}
+	defer db.Close()
+
+	var cas []DatabaseCA
+	err = db.View(func(tx *bolt.Tx) error {
+		root := tx.Bucket(databaseCABucket)
+		if root == nil {
+			return nil
+		}
+		return root.ForEach(func(key []byte, _ []byte) error {
+			ca, err := readDatabaseCA(root.Bucket(key))
+			if err != nil {
+				return err
+			}
+			cas = append(cas, *ca)
+			return nil
+		})
+	})
+	sort.Slice(cas, func(i, j int) bool { return cas[i].Dir < cas[j].Dir })
+	return cas, err
+}
+
+// DatabaseRecords returns the certificates issued by the CA at caDir, in the order they were issued
+func DatabaseRecords(caDir string) (*DatabaseCA, []DatabaseRecord, error) {
+	key, err := databaseCAKey(caDir)
+	if err != nil {
+		return nil, nil, err
+	}
+	db, err := openDatabase(filepath.Dir(caDir), true)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer db.Close()
+
+	var ca *DatabaseCA
+	var records []DatabaseRecord
+	err = db.View(func(tx *bolt.Tx) error {
+		var bucket *bolt.Bucket
+		if root := tx.Bucket(databaseCABucket); root != nil {
+			bucket = root.Bucket([]byte(key))
+		}
+		if bucket == nil {
+			return errCANotRecorded
+		}
+		if ca, err = readDatabaseCA(bucket); err != nil {
+			return err
+		}
+		return bucket.Bucket(databaseCertBucket).ForEach(func(_ []byte, value []byte) error {
+			var record DatabaseRecord
+			if err := json.Unmarshal(value, &record); err != nil {
+				return err
+			}
+			records = append(records, record)
+			return nil
+		})
+	})
+	sort.Slice(records, func(i, j int) bool { return records[i].Sequence < records[j].Sequence })
+	return ca, records, err
+}
+
+// CertificateEntries returns the database entries of the CA at caDir, from the embedded
+// database once the vault is migrated, from the openssl index file otherwise. A CA created
+// after the migration is only recorded once openssl ca signed with it.
+func CertificateEntries(caDir string) ([]IndexEntry, error) {
+	if !DatabaseEnabled(filepath.Dir(caDir)) {
+		return ReadIndex(caIndexFile(caDir))
+	}
+	_, records, err := DatabaseRecords(caDir)
+	if err == errCANotRecorded {
+		return ReadIndex(caIndexFile(caDir))
+	}
+	if err != nil {
+		return nil, err
+	}
+	entries := make([]IndexEntry, 0, len(records))
+	for _, record := range records {
+		entries = append(entries, record.IndexEntry)
+	}
+	return entries, nil
+}
+
+// ExportDatabase writes the openssl database of the CA at caDir from the embedded
+// database into outDir: index, index.attr, serial and crlnum files named after the
+// CA config, and the issued certificates under newcerts/.
+func ExportDatabase(caDir string, outDir string) (*DatabaseCA, error) {
+	ca, records, err := DatabaseRecords(caDir)
+	if err != nil {
+		return nil, err
+	}
+	if err := os.MkdirAll(filepath.Join(outDir, "newcerts"), 0700); err != nil {
+		return nil, err
+	}
+
+	var index bytes.Buffer
+	for _, record := range records {
+		fmt.Fprintf(&index, "%v\t%v\t%v\t%v\t%v\t%v\n", record.Status, record.ExpiryField, record.RevocationField, record.Serial, record.FileField, record.Subject)
+		if record.Certificate == "" {
+			continue
+		}
+		if err := ioutil.WriteFile(filepath.Join(outDir, "newcerts", record.Serial+".pem"), []byte(record.Certificate), 0644); err != nil {
+			return nil, err
+		}
+	}
+	stateFiles := map[string]string{
+		".index":      index.String(),
+		".index.attr": ca.Attr,
+		".serial":     ca.Serial,
+		".crlnum":     ca.CRLNumber,
+	}
+	for suffix, content := range stateFiles {
+		if content == "" && suffix != ".index" {
+			continue
+		}
+		if err := ioutil.WriteFile(filepath.Join(outDir, ca.Name+suffix), []byte(content), 0644); err != nil {
+			return nil, err
+		}
+	}
+	return ca, nil
+}
+
+// recordCA upserts the CA at caDir and every line of its openssl index into the database,
+// and removes the records of serials that are not in the index
+func recordCA(tx *bolt.Tx, caDir string) (*DatabaseCA, error) {
+	key, err := databaseCAKey(caDir)
+	if err != nil {
+		return nil, err
+	}
+	root, err := tx.CreateBucketIfNotExists(databaseCABucket)
+	if err != nil {
+		return nil, err
+	}
+	bucket, err := root.CreateBucketIfNotExists([]byte(key))
+	if err != nil {
+		return nil, err
+	}
+	certs, err := bucket.CreateBucketIfNotExists(databaseCertBucket)
+	if err != nil {
+		return nil, err
+	}
+
+	name := strings.TrimSuffix(caConfigName(caDir), ".cnf")
+	ca := &DatabaseCA{
+		Key:    key,
+		Dir:    filepath.Base(caDir),
+		Name:   name,
+		Synced: time.Now().UTC().Format(time.RFC3339),
+	}
+	cert, err := ReadCertificate(filepath.Join(caDir, name+".cert.pem"))
+	if err != nil {
+		return nil, err
+	}
+	ca.Subject = cert.Subject.String()
+	ca.Serial = readStateFile(filepath.Join(caDir, name+".serial"))
+	ca.CRLNumber = readStateFile(filepath.Join(caDir, name+".crlnum"))
+	ca.Attr = readStateFile(filepath.Join(caDir, name+".index.attr"))
+
+	records, err := readIndexRecords(filepath.Join(caDir, name+".index"))
+	if err != nil {
+		return nil, err
+	}
+	indexed := map[string]bool{}
+	for _, record := range records {
+		indexed[record.Serial] = true
+		var recorded DatabaseRecord
+		if previous := certs.Get([]byte(record.Serial)); previous != nil {
+			if err := json.Unmarshal(previous, &recorded); err != nil {
+				return nil, err
+			}
+			record.Sequence = recorded.Sequence
+		} else if record.Sequence, err = certs.NextSequence(); err != nil {
+			return nil, err
+		}
+		if pemBytes, err := ioutil.ReadFile(filepath.Join(caDir, "newcerts", record.Serial+".pem")); err == nil {
+			record.Certificate = string(pemBytes)
+		} else {
+			// keep the certificate recorded earlier if newcerts/ lost it
+			record.Certificate = recorded.Certificate
+		}
+		value, err := json.Marshal(record)
+		if err != nil {
+			return nil, err
+		}
+		if err := certs.Put([]byte(record.Serial), value); err != nil {
+			return nil, err
+		}
+	}
+	// openssl never drops index lines, a serial missing from it was signed by a
+	// transaction that was rolled back since
+	var dropped [][]byte
+	err = certs.ForEach(func(serial []byte, _ []byte) error {
+		if !indexed[string(serial)] {
+			dropped = append(dropped, serial)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	for _, serial := range dropped {
+		if err := certs.Delete(serial); err != nil {
+			return nil, err
+		}
+	}
+	ca.Entries = certs.Stats().KeyN
+	meta, err := json.Marshal(ca)
+	if err != nil {
+		return nil, err
+	}
+	return ca, bucket.Put(databaseMetaKey, meta)
+}
+
+func readDatabaseCA(bucket *bolt.Bucket) (*DatabaseCA, error) {
+	ca := new(DatabaseCA)
+	if err := json.Unmarshal(bucket.Get(databaseMetaKey), ca); err != nil {
+		return nil, err
+	}
+	ca.Entries = bucket.Bucket(databaseCertBucket).Stats().KeyN
+	return ca, nil
+}
+
+// databaseCAKey returns the hex SHA-256 of the public key of the CA at caDir
+func databaseCAKey(caDir string) (string, error) {
+	cert, err := ReadCertificate(filepath.Join(caDir, strings.TrimSuffix(caConfigName(caDir), ".cnf")+".cert.pem"))
+	if err != nil {
+		return "", err
+	}
+	sum := sha256.Sum256(cert.RawSubjectPublicKeyInfo)
+	return hex.EncodeToString(sum[:]), nil
+}
+
+// databaseCADirs lists the directories of the vault holding an openssl CA database,
+// staging directories of an A1 or A2 creation are left out
+func databaseCADirs(pkiPath string) ([]string, error) {
+	files, err := ioutil.ReadDir(pkiPath)
+	if err != nil {
+		return nil, err
+	}
+	var caDirs []string
+	for _, file := range files {
+		caDir := filepath.Join(pkiPath, file.Name())
+		if !file.IsDir() || strings.HasSuffix(file.Name(), intermediateDirMarker) || strings.HasSuffix(file.Name(), issuingDirMarker) {
+			continue
+		}
+		name := strings.TrimSuffix(caConfigName(caDir), ".cnf")
+		if fileExists(filepath.Join(caDir, name+".index")) && fileExists(filepath.Join(caDir, name+".cert.pem")) {
+			caDirs = append(caDirs, caDir)
+		}
+	}
+	return caDirs, nil
+}
+
+// readIndexRecords parses an openssl index file, keeping its raw fields
+func readIndexRecords(indexPath string) ([]DatabaseRecord, error) {
+	indexFile, err := os.Open(indexPath)
+	if err != nil {
+		return nil, err
+	}
+	defer indexFile.Close()
+
+	var records []DatabaseRecord
+	scanner := bufio.NewScanner(indexFile)
+	for scanner.Scan() {
+		fields := strings.Split(scanner.Text(), "\t")
+		if len(fields) < 6 {
+			continue
+		}
+		records = append(records, DatabaseRecord{
+			IndexEntry:      parseIndexFields(fields),
+			ExpiryField:     fields[1],
+			RevocationField: fields[2],
+			FileField:       fields[4],
+		})
+	}
+	return records, scanner.Err()
+}
+
+func readStateFile(path string) string {
+	content, err := ioutil.ReadFile(path)
+	if err != nil {
+		return ""
+	}
+	return string(content)
+}
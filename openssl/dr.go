@@ -0,0 +1,406 @@
+package openssl
+
+import (
+	"errors"
+	"fmt"
+	"github.com/chuckpreslar/gofer"
+	log "github.com/sirupsen/logrus"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// retiredRootDirMarker names the directory a Root CA (A0) is kept in once the DR Root CA is promoted
+const retiredRootDirMarker = "-retired-root-ca"
+
+// PromotionReport describes the vault after its DR Root CA (DR A0) was promoted to primary
+type PromotionReport struct {
+	Promoted string `json:"promoted"`
+	// RetiredRootDir keeps the former Root CA, its key is no longer used by privki
+	RetiredRootDir string `json:"retired_root_dir"`
+	PrimaryRoot    string `json:"primary_root"`
+	// Intermediates now chain to the promoted root, their chain bundles and A1 packages need redistribution
+	Intermediates []string `json:"intermediates"`
+	// Orphaned A1s were never cross signed and only chain to the retired root
+	Orphaned []string `json:"orphaned,omitempty"`
+	// DRRoot is the fresh DR Root CA provisioned after the promotion, if any
+	DRRoot      string   `json:"dr_root,omitempty"`
+	CrossSigned []string `json:"cross_signed,omitempty"`
+}
+
+// DREnableReport describes a vault after a DR Root CA (DR A0) was added to it
+type DREnableReport struct {
+	Enabled string `json:"enabled"`
+	DRRoot  string `json:"dr_root"`
+	// CrossSigned A1s have a new DR chain bundle, their A1 packages need redistribution
+	CrossSigned []string `json:"cross_signed"`
+	// Skipped A1s, with the reason, are not cross signed
+	Skipped map[string]string `json:"skipped,omitempty"`
+}
+
+// DR related task definitions
+var taskRootCACheckKey = gofer.Register(gofer.Task{
+	Namespace:   "A0",
+	Label:       "CheckKey",
+	Description: "Check that a passphrase unlocks a Root CA key",
+	Action: func(arguments ...string) error {
+
+		rootCADir := arguments[0]
+		opensslPassinString := arguments[1]
+
+		checkKeyCmd := "cd " + shellQuote(rootCADir) + " && openssl pkey " + opensslPassinString + "-in private/root-ca.key.pem -noout"
+		shellOutput := execute(checkKeyCmd)
+		if shellOutput.CmdError != nil {
+			log.Printf("\nUnable to load the private key at %v, is this the right passphrase for it?\n", rootCADir)
+			return shellError(shellOutput)
+		}
+		return nil
+	},
+})
+
+var taskRecordRootPromotion = gofer.Register(gofer.Task{
+	Namespace:   "A0DR",
+	Label:       "RecordPromotion",
+	Description: "Task to record the promotion of the DR Root CA in PKI central configuration",
+	Action: func(arguments ...string) error {
+
+		promoted := arguments[0]
+		retiredRootDir := arguments[1]
+
+		// the DR Root CA is the primary one now, there is no DR until a new one is provisioned
+		recordCmd := "echo \"promoted=" + promoted + " retired=" + retiredRootDir + "\" >> " + GetPrimaryRootConfigFile() + " && echo false > " + GetDRStatusConfigFile()
+		shellOutput := execute(recordCmd)
+		if shellOutput.CmdError != nil {
+			log.Printf("\nUnable to write to folder : %v\n", GetPkiConfigDir())
+			return shellError(shellOutput)
+		}
+		return nil
+	},
+})
+
+// PromoteDRRoot makes the DR Root CA (DR A0) the primary Root CA of the vault. The former Root CA
+// is kept in a retired directory, the cross signed certificates of the A1s become their primary
+// ones, and the switch is recorded in the primary_root config. With provisionDR a fresh DR Root CA
+// is created, sharing the root passphrase, and cross signs every A1 to restore redundancy.
+// drPassphrase unlocks the DR A0 key when it has its own, it is the root passphrase from then on.
+func PromoteDRRoot(rootPassphrase string, drPassphrase string, provisionDR bool) (*PromotionReport, error) {
+	log.Printf("\nPromoting the DR Root CA (DR A0) to primary Root CA\n")
+	if err := RequireRootVault(); err != nil {
+		return nil, err
+	}
+	if !DREnabled() {
+		return nil, errors.New("DR is not enabled on this vault, there is no DR Root CA to promote")
+	}
+	pkiPath, err := GetPkiPath()
+	if err != nil {
+		return nil, err
+	}
+	rootCertUID, err := GetRootUID()
+	if err != nil {
+		return nil, err
+	}
+	if dirExists(filepath.Join(pkiPath, rootCertUID+intermediateDirMarker)) {
+		return nil, errors.New("an A1 creation is in progress or was interrupted, finish or remove it before promoting")
+	}
+	if rootPassphrase, err = resolveDRPassphrase(rootPassphrase, drPassphrase); err != nil {
+		return nil, err
+	}
+	if err := gofer.Perform("A0:CheckKey", DRRootCADir(pkiPath, rootCertUID), opensslPassin(rootPassphrase)); err != nil {
+		return nil, err
+	}
+	intermediates, err := ListIntermediates(pkiPath, rootCertUID)
+	if err != nil {
+		return nil, err
+	}
+
+	promoted := time.Now().UTC()
+	report := &PromotionReport{
+		Promoted:       promoted.Format(time.RFC3339),
+		RetiredRootDir: rootCertUID + retiredRootDirMarker + "-" + promoted.Format("20060102150405Z"),
+	}
+	if err := os.Rename(RootCADir(pkiPath, rootCertUID), filepath.Join(pkiPath, report.RetiredRootDir)); err != nil {
+		return nil, err
+	}
+	if err := os.Rename(DRRootCADir(pkiPath, rootCertUID), RootCADir(pkiPath, rootCertUID)); err != nil {
+		os.Rename(filepath.Join(pkiPath, report.RetiredRootDir), RootCADir(pkiPath, rootCertUID))
+		return nil, err
+	}
+	if err := gofer.Perform("A0DR:RecordPromotion", report.Promoted, report.RetiredRootDir); err != nil {
+		return nil, err
+	}
+	if err := recordDRPassphrase(false); err != nil {
+		return nil, err
+	}
+	// both roots keep their records, under their new directories
+	syncDatabase(filepath.Join(pkiPath, report.RetiredRootDir))
+	syncDatabase(RootCADir(pkiPath, rootCertUID))
+	primaryRoot, err := ReadCertificate(filepath.Join(RootCADir(pkiPath, rootCertUID), "root-ca.cert.pem"))
+	if err != nil {
+		return nil, err
+	}
+	report.PrimaryRoot = primaryRoot.Subject.String() + " " + SerialHex(primaryRoot.SerialNumber)
+
+	for _, intermediate := range intermediates {
+		if intermediate.Level != 1 {
+			continue
+		}
+		if !intermediate.CrossSigned {
+			report.Orphaned = append(report.Orphaned, intermediate.ID)
+			continue
+		}
+		if err := promoteCrossCertificate(intermediate.Dir); err != nil {
+			return report, fmt.Errorf("A1 %v: %v", intermediate.ID, err)
+		}
+		report.Intermediates = append(report.Intermediates, intermediate.ID)
+	}
+	if err := refreshIssuingBundles(pkiPath, rootCertUID); err != nil {
+		return report, err
+	}
+
+	// the promoted root signs with the Root CA config from now on
+	settings, err := readVaultSettings()
+	if err != nil {
+		return report, err
+	}
+	if err := writeRootConfig(RootCADir(pkiPath, rootCertUID), settings); err != nil {
+		return report, err
+	}
+	if err := GenerateCRL(RootCADir(pkiPath, rootCertUID), rootPassphrase); err != nil {
+		return report, err
+	}
+
+	if !provisionDR {
+		return report, nil
+	}
+	if err := CreateDRRootCA(rootPassphrase); err != nil {
+		return report, err
+	}
+	drRoot, err := ReadCertificate(filepath.Join(DRRootCADir(pkiPath, rootCertUID), "root-ca.cert.pem"))
+	if err != nil {
+		return report, err
+	}
+	report.DRRoot = drRoot.Subject.String() + " " + SerialHex(drRoot.SerialNumber)
+	// orphaned A1s are not in the index of the promoted root and are skipped
+	report.CrossSigned, _, err = crossSignActiveIntermediates(pkiPath, rootCertUID, rootPassphrase)
+	return report, err
+}
+
+// EnableDR provisions a DR Root CA (DR A0) for a vault created without one, sharing the
+// root passphrase, and cross signs every active A1 with it. The A1s listed in the report
+// have new DR certificates and chain bundles, their A1 packages need redistribution.
+func EnableDR(rootPassphrase string) (*DREnableReport, error) {
+	log.Printf("\nEnabling DR on this vault\n")
+	if err := RequireRootVault(); err != nil {
+		return nil, err
+	}
+	if DREnabled() {
+		return nil, errors.New("DR is already enabled on this vault")
+	}
+	pkiPath, err := GetPkiPath()
+	if err != nil {
+		return nil, err
+	}
+	rootCertUID, err := GetRootUID()
+	if err != nil {
+		return nil, err
+	}
+	if dirExists(DRRootCADir(pkiPath, rootCertUID)) {
+		return nil, fmt.Errorf("a DR Root CA directory already exists at %v, move it away before enabling DR", DRRootCADir(pkiPath, rootCertUID))
+	}
+	if dirExists(filepath.Join(pkiPath, rootCertUID+intermediateDirMarker)) {
+		return nil, errors.New("an A1 creation is in progress or was interrupted, finish or remove it before enabling DR")
+	}
+	// the DR A0 gets the passphrase of the A0, make sure it is the right one first
+	if err := gofer.Perform("A0:CheckKey", RootCADir(pkiPath, rootCertUID), opensslPassin(rootPassphrase)); err != nil {
+		return nil, err
+	}
+
+	if err := CreateDRRootCA(rootPassphrase); err != nil {
+		os.RemoveAll(DRRootCADir(pkiPath, rootCertUID))
+		return nil, err
+	}
+	if err := recordDRPassphrase(false); err != nil {
+		return nil, err
+	}
+	drRoot, err := ReadCertificate(filepath.Join(DRRootCADir(pkiPath, rootCertUID), "root-ca.cert.pem"))
+	if err != nil {
+		return nil, err
+	}
+	report := &DREnableReport{
+		Enabled: time.Now().UTC().Format(time.RFC3339),
+		DRRoot:  drRoot.Subject.String() + " " + SerialHex(drRoot.SerialNumber),
+	}
+	report.CrossSigned, report.Skipped, err = crossSignActiveIntermediates(pkiPath, rootCertUID, rootPassphrase)
+	return report, err
+}
+
+// crossSignActiveIntermediates cross signs with the DR Root CA every A1 that is valid in the
+// index of the Root CA, then refreshes the A2 chain bundles. It returns the cross signed A1s
+// and the reason each other A1 was skipped. drPassphrase unlocks the DR Root CA key.
+func crossSignActiveIntermediates(pkiPath string, rootCertUID string, drPassphrase string) ([]string, map[string]string, error) {
+	intermediates, err := ListIntermediates(pkiPath, rootCertUID)
+	if err != nil {
+		return nil, nil, err
+	}
+	var crossSigned []string
+	skipped := make(map[string]string)
+	for i := range intermediates {
+		intermediate := &intermediates[i]
+		if intermediate.Level != 1 {
+			continue
+		}
+		inactive, err := inactiveReason(pkiPath, rootCertUID, intermediate)
+		if err != nil {
+			return crossSigned, skipped, err
+		}
+		if inactive != "" {
+			skipped[intermediate.ID] = inactive
+			continue
+		}
+		if err := CrossSignIntermediate(pkiPath, rootCertUID, intermediate, drPassphrase); err != nil {
+			return crossSigned, skipped, fmt.Errorf("A1 %v: %v", intermediate.ID, err)
+		}
+		crossSigned = append(crossSigned, intermediate.ID)
+	}
+	return crossSigned, skipped, refreshIssuingBundles(pkiPath, rootCertUID)
+}
+
+// promoteCrossCertificate makes the DR cross signed certificate of an A1 its primary one,
+// the certificate of the retired root is kept as intermed-ca.retired.cert.pem
+func promoteCrossCertificate(intermediateDir string) error {
+	for _, move := range [][2]string{
+		{"intermed-ca.cert.pem", "intermed-ca.retired.cert.pem"},
+		{"intermed-ca.dr.cert.pem", "intermed-ca.cert.pem"},
+		{"intermed-ca-chain-bundle.dr.cert.pem", "intermed-ca-chain-bundle.cert.pem"},
+	} {
+		if err := os.Rename(filepath.Join(intermediateDir, move[0]), filepath.Join(intermediateDir, move[1])); err != nil {
+			return err
+		}
+	}
+
+	// the named copies follow, IA1_C_ ones were signed by the DR root
+	crossCopies, err := filepath.Glob(filepath.Join(intermediateDir, "*_IA1_C_*.pem"))
+	if err != nil {
+		return err
+	}
+	for _, crossCopy := range crossCopies {
+		primaryCopy := filepath.Join(intermediateDir, strings.Replace(filepath.Base(crossCopy), "_IA1_C_", "_IA1_", 1))
+		if err := os.Rename(crossCopy, primaryCopy); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// CrossSignIntermediate cross signs an existing A1 with the DR Root CA, keeping the validity,
+// path length and name restrictions of its primary certificate, and writes its DR chain bundle.
+// drPassphrase unlocks the DR Root CA key.
+func CrossSignIntermediate(pkiPath string, rootCertUID string, intermediate *Intermediate, drPassphrase string) error {
+	transaction, err := beginIntermediate(pkiPath, rootCertUID)
+	if err != nil {
+		return err
+	}
+	requestBytes, err := ioutil.ReadFile(filepath.Join(intermediate.Dir, "intermed-ca.req.pem"))
+	if err != nil {
+		transaction.rollback()
+		return err
+	}
+	if err := ioutil.WriteFile(filepath.Join(transaction.stagingDir, "intermed-ca.req.pem"), requestBytes, 0644); err != nil {
+		transaction.rollback()
+		return err
+	}
+	settings, err := readVaultSettings()
+	if err != nil {
+		transaction.rollback()
+		return err
+	}
+
+	// the request of a partner A1 doesn't carry our class, the one of our A1s has the same value
+	signing := intermediateSigning{
+		PathLen:    intermediate.PathLen,
+		ClassOID:   settings.oid,
+		StartDate:  intermediate.NotBefore.UTC().Format("20060102150405Z"),
+		ExpiryDate: intermediate.NotAfter.UTC().Format("20060102150405Z"),
+	}
+	if len(intermediate.NameRestrict) > 0 {
+		signing.NameRestriction = intermediate.NameRestrict[0]
+	}
+	if err := signIntermediate(transaction, DRRootCADir(pkiPath, rootCertUID), signing, drPassphrase, true); err != nil {
+		return err
+	}
+
+	stagedFiles, err := ioutil.ReadDir(transaction.stagingDir)
+	if err != nil {
+		transaction.rollback()
+		return err
+	}
+	for _, stagedFile := range stagedFiles {
+		if stagedFile.IsDir() || stagedFile.Name() == "intermed-ca.req.pem" {
+			continue
+		}
+		if err := os.Rename(filepath.Join(transaction.stagingDir, stagedFile.Name()), filepath.Join(intermediate.Dir, stagedFile.Name())); err != nil {
+			transaction.rollback()
+			return err
+		}
+	}
+	if err := transaction.commit(""); err != nil {
+		return err
+	}
+	os.RemoveAll(transaction.stagingDir)
+	intermediate.CrossSigned = true
+	return nil
+}
+
+// inactiveReason tells why an A1 is no longer active in the index of the Root CA,
+// it is empty for a valid A1.
+func inactiveReason(pkiPath string, rootCertUID string, intermediate *Intermediate) (string, error) {
+	entry, err := FindIndexEntry(RootCADir(pkiPath, rootCertUID), intermediate.Serial)
+	switch {
+	case err == ErrUnknownCertificate:
+		return "not signed by the current Root CA", nil
+	case err != nil:
+		return "", err
+	case entry.Status != "V":
+		return "revoked", nil
+	case time.Now().After(intermediate.NotAfter):
+		return "expired", nil
+	}
+	return "", nil
+}
+
+// refreshIssuingBundles rewrites the chain bundles of every A2 from the certificates of its A1
+func refreshIssuingBundles(pkiPath string, rootCertUID string) error {
+	intermediates, err := ListIntermediates(pkiPath, rootCertUID)
+	if err != nil {
+		return err
+	}
+	for i := range intermediates {
+		issuing := &intermediates[i]
+		if issuing.Level != 2 {
+			continue
+		}
+		chain, err := IntermediateChain(pkiPath, rootCertUID, issuing, false)
+		if err != nil {
+			return fmt.Errorf("A2 %v: %v", issuing.ID, err)
+		}
+		if err := ioutil.WriteFile(filepath.Join(issuing.Dir, "intermed-ca-chain-bundle.cert.pem"), chain, 0644); err != nil {
+			return err
+		}
+		drBundle := filepath.Join(issuing.Dir, "intermed-ca-chain-bundle.dr.cert.pem")
+		if !issuing.CrossSigned {
+			if err := os.Remove(drBundle); err != nil && !os.IsNotExist(err) {
+				return err
+			}
+			continue
+		}
+		if chain, err = IntermediateChain(pkiPath, rootCertUID, issuing, true); err != nil {
+			return fmt.Errorf("A2 %v: %v", issuing.ID, err)
+		}
+		if err := ioutil.WriteFile(drBundle, chain, 0644); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,395 @@
+package openssl
+
+import (
+	"bytes"
+	"crypto/x509"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/chuckpreslar/gofer"
+	log "github.com/sirupsen/logrus"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// drillReportVersion is bumped whenever the report layout or its signed content changes
+const drillReportVersion = 1
+
+// crlFreshnessMargin is how long before its nextUpdate a Root CA CRL is considered stale
+const crlFreshnessMargin = 30 * 24 * time.Hour
+
+// DrillCheck is the outcome of one DR drill check
+type DrillCheck struct {
+	Check  string `json:"check"`
+	Target string `json:"target"`
+	Passed bool   `json:"passed"`
+	Detail string `json:"detail,omitempty"`
+}
+
+// DrillReport records a DR drill, it is signed by the Root CA (A0) so that it can be handed to auditors
+type DrillReport struct {
+	Version int          `json:"version"`
+	RootUID string       `json:"root_uid"`
+	Drilled string       `json:"drilled"`
+	Passed  bool         `json:"passed"`
+	Checks  []DrillCheck `json:"checks"`
+	Signer  string       `json:"signer"`
+	// Signature is the SHA-256 RSA signature of the report without it
+	Signature []byte `json:"signature"`
+}
+
+// DrillDR checks, without modifying the vault, that the DR Root CA (DR A0) could take over:
+// its key unlocks with the root passphrase, or drPassphrase when it has its own, every active
+// A1 and A2 validates through it, its config matches the one of the Root CA (A0) and the CRLs
+// of both roots are fresh. The report is signed with the Root CA, a failed check does not make
+// DrillDR fail.
+func DrillDR(rootPassphrase string, drPassphrase string) (*DrillReport, error) {
+	log.Printf("\nRunning a DR drill\n")
+	if err := RequireRootVault(); err != nil {
+		return nil, err
+	}
+	if !DREnabled() {
+		return nil, errors.New("DR is not enabled on this vault, see privki dr enable")
+	}
+	pkiPath, err := GetPkiPath()
+	if err != nil {
+		return nil, err
+	}
+	rootCertUID, err := GetRootUID()
+	if err != nil {
+		return nil, err
+	}
+	settings, err := readVaultSettings()
+	if err != nil {
+		return nil, err
+	}
+	rootDir := RootCADir(pkiPath, rootCertUID)
+	drRootDir := DRRootCADir(pkiPath, rootCertUID)
+	root, err := ReadCertificate(filepath.Join(rootDir, "root-ca.cert.pem"))
+	if err != nil {
+		return nil, err
+	}
+
+	report := &DrillReport{
+		Version: drillReportVersion,
+		RootUID: rootCertUID,
+		Drilled: time.Now().UTC().Format(time.RFC3339),
+	}
+	report.add("root", "A0", drillRootError(root, nil, settings.oid))
+	drRoot, err := ReadCertificate(filepath.Join(drRootDir, "root-ca.cert.pem"))
+	report.add("root", "DR A0", drillRootError(drRoot, err, settings.oid))
+	if drRoot != nil {
+		report.add("subject", "DR A0", drillSubjectError(root, drRoot))
+		drRootPassphrase, err := resolveDRPassphrase(rootPassphrase, drPassphrase)
+		if err != nil {
+			return nil, err
+		}
+		report.add("root-key", "DR A0", drillKeyError(drRootDir, drRootPassphrase))
+		if err := report.checkIntermediates(pkiPath, rootCertUID, drRoot); err != nil {
+			return nil, err
+		}
+	}
+	if err := report.checkConfigs(rootDir, drRootDir, settings); err != nil {
+		return nil, err
+	}
+	report.add("crl-freshness", "A0", drillCRLError(rootDir, root))
+	if drRoot != nil {
+		report.add("crl-freshness", "DR A0", drillCRLError(drRootDir, drRoot))
+	}
+
+	report.Passed = true
+	for _, check := range report.Checks {
+		report.Passed = report.Passed && check.Passed
+	}
+
+	workDir, err := ioutil.TempDir("", "privki-drill")
+	if err != nil {
+		return nil, err
+	}
+	defer os.RemoveAll(workDir)
+	signerPEM, err := ioutil.ReadFile(filepath.Join(rootDir, "root-ca.cert.pem"))
+	if err != nil {
+		return nil, err
+	}
+	report.Signer = string(signerPEM)
+	if report.Signature, err = signContent(workDir, filepath.Join(rootDir, "private", "root-ca.key.pem"), rootPassphrase, report.signedContent()); err != nil {
+		return nil, err
+	}
+	return report, nil
+}
+
+// ReadDrillReport reads a report written from DrillDR
+func ReadDrillReport(reportFile string) (*DrillReport, error) {
+	reportBytes, err := ioutil.ReadFile(reportFile)
+	if err != nil {
+		return nil, err
+	}
+	report := new(DrillReport)
+	if err := json.Unmarshal(reportBytes, report); err != nil {
+		return nil, fmt.Errorf("%v is not a privki DR drill report: %v", reportFile, err)
+	}
+	if report.Version != drillReportVersion {
+		return nil, fmt.Errorf("unsupported DR drill report version %v", report.Version)
+	}
+	return report, nil
+}
+
+// Verify checks the report signature and that it was made by one of the trusted Root CAs
+func (report *DrillReport) Verify(trusted []*x509.Certificate) (*x509.Certificate, error) {
+	signers, err := ParseCertificates([]byte(report.Signer))
+	if err != nil {
+		return nil, fmt.Errorf("report signer: %v", err)
+	}
+	signer := signers[0]
+	isTrusted := false
+	for _, root := range trusted {
+		if bytes.Equal(root.Raw, signer.Raw) {
+			isTrusted = true
+		}
+	}
+	if !isTrusted {
+		return nil, fmt.Errorf("report is signed by %v, which is not a trusted Root CA", signer.Subject)
+	}
+	if err := signer.CheckSignature(x509.SHA256WithRSA, report.signedContent(), report.Signature); err != nil {
+		return nil, fmt.Errorf("report signature does not verify: %v", err)
+	}
+	return signer, nil
+}
+
+// signedContent is the byte string the Root CA signs, the JSON report without its signature
+func (report *DrillReport) signedContent() []byte {
+	unsigned := *report
+	unsigned.Signature = nil
+	content, _ := json.Marshal(unsigned)
+	return []byte(fmt.Sprintf("privki-dr-drill-v%d\n%v", report.Version, hex.EncodeToString(sha256Sum(content))))
+}
+
+func (report *DrillReport) add(check string, target string, err error) {
+	result := DrillCheck{Check: check, Target: target, Passed: err == nil}
+	if err != nil {
+		result.Detail = err.Error()
+	}
+	report.Checks = append(report.Checks, result)
+}
+
+// checkIntermediates verifies the DR cross certificate of every active A1, and the DR chain
+// bundle of every active A1 and A2, against the DR Root CA.
+func (report *DrillReport) checkIntermediates(pkiPath string, rootCertUID string, drRoot *x509.Certificate) error {
+	intermediates, err := ListIntermediates(pkiPath, rootCertUID)
+	if err != nil {
+		return err
+	}
+	drRoots := x509.NewCertPool()
+	drRoots.AddCert(drRoot)
+	inactive := make(map[string]bool)
+	for i := range intermediates {
+		intermediate := &intermediates[i]
+		if intermediate.Level != 1 {
+			continue
+		}
+		reason, err := inactiveReason(pkiPath, rootCertUID, intermediate)
+		if err != nil {
+			return err
+		}
+		if reason != "" {
+			inactive[intermediate.ID] = true
+			log.Printf("Skipping A1 %v, %v", intermediate.ID, reason)
+			continue
+		}
+		report.add("dr-cross-certificate", "A1 "+intermediate.ID, drillCrossCertificateError(intermediate, drRoots))
+		report.add("dr-chain-bundle", "A1 "+intermediate.ID, drillBundleError(intermediate, drRoots))
+	}
+	for i := range intermediates {
+		issuing := &intermediates[i]
+		if issuing.Level != 2 || inactive[issuing.Parent] {
+			continue
+		}
+		report.add("dr-chain-bundle", "A2 "+issuing.ID, drillBundleError(issuing, drRoots))
+	}
+	return nil
+}
+
+// checkConfigs compares the configs of both roots to the one A1 signings are rendered
+// from, so that neither carries the OID, organization, name constraints or path length
+// of a past or interrupted signing. The extensions A0:Config leaves out of the A0's own
+// certificate and CRLs are not compared.
+func (report *DrillReport) checkConfigs(rootDir string, drRootDir string, settings *vaultSettings) error {
+	expected, err := renderRootConfig(settings)
+	if err != nil {
+		return err
+	}
+	report.add("config", "A0", drillConfigError(filepath.Join(rootDir, "root-ca.cnf"), expected, settings.oid))
+	report.add("config", "DR A0", drillConfigError(filepath.Join(drRootDir, "root-ca.cnf"), expected, settings.oid))
+	return nil
+}
+
+func drillRootError(root *x509.Certificate, err error, oid string) error {
+	if err != nil {
+		return err
+	}
+	if err := root.CheckSignatureFrom(root); err != nil {
+		return fmt.Errorf("certificate is not self signed: %v", err)
+	}
+	if now := time.Now(); now.Before(root.NotBefore) || now.After(root.NotAfter) {
+		return fmt.Errorf("certificate is only valid from %v to %v", root.NotBefore, root.NotAfter)
+	}
+	for _, extension := range root.Extensions {
+		if extension.Id.String() == oid {
+			return nil
+		}
+	}
+	return fmt.Errorf("certificate does not carry the class OID %v of the vault", oid)
+}
+
+// drillSubjectError checks that relying parties see the same organization on both roots
+func drillSubjectError(root *x509.Certificate, drRoot *x509.Certificate) error {
+	if strings.Join(drRoot.Subject.Organization, ",") != strings.Join(root.Subject.Organization, ",") {
+		return fmt.Errorf("organization %v differs from the one of the A0 %v", drRoot.Subject.Organization, root.Subject.Organization)
+	}
+	return nil
+}
+
+func drillKeyError(caDir string, passphrase string) error {
+	if err := gofer.Perform("A0:CheckKey", caDir, opensslPassin(passphrase)); err != nil {
+		return errors.New("the key does not unlock with the root passphrase")
+	}
+	return nil
+}
+
+func drillCrossCertificateError(intermediate *Intermediate, drRoots *x509.CertPool) error {
+	if !intermediate.CrossSigned {
+		return errors.New("A1 is not cross signed, see privki dr enable")
+	}
+	cert, err := ReadCertificate(filepath.Join(intermediate.Dir, "intermed-ca.cert.pem"))
+	if err != nil {
+		return err
+	}
+	crossCert, err := ReadCertificate(filepath.Join(intermediate.Dir, "intermed-ca.dr.cert.pem"))
+	if err != nil {
+		return err
+	}
+	if !bytes.Equal(cert.RawSubjectPublicKeyInfo, crossCert.RawSubjectPublicKeyInfo) {
+		return errors.New("cross certificate is for another key than the A1 certificate")
+	}
+	if cert.MaxPathLen != crossCert.MaxPathLen || strings.Join(cert.PermittedDNSDomains, ",") != strings.Join(crossCert.PermittedDNSDomains, ",") {
+		return errors.New("cross certificate path length or name constraints differ from the A1 certificate")
+	}
+	if _, err := crossCert.Verify(x509.VerifyOptions{Roots: drRoots, KeyUsages: []x509.ExtKeyUsage{x509.ExtKeyUsageAny}}); err != nil {
+		return err
+	}
+	return nil
+}
+
+func drillBundleError(intermediate *Intermediate, drRoots *x509.CertPool) error {
+	if !intermediate.CrossSigned {
+		return errors.New("no DR chain bundle, the A1 is not cross signed")
+	}
+	bundle, err := ReadCertificates(filepath.Join(intermediate.Dir, "intermed-ca-chain-bundle.dr.cert.pem"))
+	if err != nil {
+		return err
+	}
+	cert, err := ReadCertificate(filepath.Join(intermediate.Dir, "intermed-ca.cert.pem"))
+	if err != nil {
+		return err
+	}
+	if !bytes.Equal(bundle[0].RawSubjectPublicKeyInfo, cert.RawSubjectPublicKeyInfo) {
+		return errors.New("bundle does not start with this CA")
+	}
+	intermediates := x509.NewCertPool()
+	for _, bundled := range bundle[1:] {
+		intermediates.AddCert(bundled)
+	}
+	if _, err := bundle[0].Verify(x509.VerifyOptions{Roots: drRoots, Intermediates: intermediates, KeyUsages: []x509.ExtKeyUsage{x509.ExtKeyUsageAny}}); err != nil {
+		return err
+	}
+	return nil
+}
+
+func drillConfigError(configFile string, expected []byte, oid string) error {
+	config, err := ioutil.ReadFile(configFile)
+	if err != nil {
+		return err
+	}
+	settings := signingSettings(config, oid)
+	expectedSettings := signingSettings(expected, oid)
+	for _, setting := range expectedSettings.keys {
+		if settings.values[setting] != expectedSettings.values[setting] {
+			return fmt.Errorf("%v is %q, the reset config has %q", setting, settings.values[setting], expectedSettings.values[setting])
+		}
+	}
+	for _, setting := range settings.keys {
+		if _, ok := expectedSettings.values[setting]; !ok {
+			return fmt.Errorf("%v is %q, the reset config does not set it", setting, settings.values[setting])
+		}
+	}
+	return nil
+}
+
+// configSettings are the settings of a Root CA config keyed by section and name, in
+// the order of the config
+type configSettings struct {
+	keys   []string
+	values map[string]string
+}
+
+// signingSettings picks out of a Root CA config what an A1 signing sets: the class OID,
+// the subject, the path length and the name constraints
+func signingSettings(config []byte, oid string) configSettings {
+	settings := configSettings{values: map[string]string{}}
+	section := ""
+	for _, line := range strings.Split(string(config), "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		if strings.HasPrefix(line, "[") {
+			section = strings.TrimSpace(strings.Trim(line, "[]"))
+			continue
+		}
+		parts := strings.SplitN(line, "=", 2)
+		if len(parts) != 2 {
+			continue
+		}
+		name, value := strings.TrimSpace(parts[0]), strings.TrimSpace(parts[1])
+		switch {
+		case name == oid:
+		case section == "distinguished_name" && (name == "organizationName" || name == "commonName"):
+		case section == "intermed-ca_ext" && (name == "basicConstraints" || name == "nameConstraints"):
+		case section == "name_constraints":
+		default:
+			continue
+		}
+		key := section + " " + name
+		if _, ok := settings.values[key]; !ok {
+			settings.keys = append(settings.keys, key)
+		}
+		settings.values[key] = value
+	}
+	return settings
+}
+
+func drillCRLError(caDir string, root *x509.Certificate) error {
+	crlBytes, err := ReadCRL(caDir)
+	if os.IsNotExist(err) {
+		return errors.New("no CRL was generated")
+	}
+	if err != nil {
+		return err
+	}
+	crl, err := x509.ParseCRL(crlBytes)
+	if err != nil {
+		return err
+	}
+	if err := root.CheckCRLSignature(crl); err != nil {
+		return fmt.Errorf("CRL is not signed by this root: %v", err)
+	}
+	nextUpdate := crl.TBSCertList.NextUpdate
+	if time.Now().Add(crlFreshnessMargin).After(nextUpdate) {
+		return fmt.Errorf("CRL is due for an update on %v", nextUpdate.UTC().Format(time.RFC3339))
+	}
+	return nil
+}
@@ -0,0 +1,335 @@
+package openssl
+
+import (
+	"errors"
+	"fmt"
+	"github.com/chuckpreslar/gofer"
+	log "github.com/sirupsen/logrus"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"sfcert/shell"
+	"strings"
+)
+
+// Export formats understood by Export
+const (
+	FormatPEM        = "pem"
+	FormatDER        = "der"
+	FormatPKCS7      = "pkcs7"
+	FormatPKCS8      = "pkcs8"
+	FormatPKCS12     = "pkcs12"
+	FormatJKS        = "jks"
+	FormatTruststore = "truststore"
+)
+
+// ErrKeytoolNotFound is returned for Java keystore exports when keytool is not installed
+var ErrKeytoolNotFound = errors.New("keytool not found, please make sure a Java runtime is installed and in the search path")
+
+// ExportEntry is a certificate file and the alias it is stored under in keystores
+type ExportEntry struct {
+	Alias string
+	File  string
+}
+
+// ExportRequest describes a single export operation.
+// Certificates lists the subject certificate first, followed by its issuers.
+type ExportRequest struct {
+	Format       string
+	Certificates []ExportEntry
+	// KeyFile is the private key of the first certificate, optional except for pkcs8 and jks
+	KeyFile       string
+	KeyPassphrase string
+	// Passphrase protects pkcs8, pkcs12, jks and truststore output
+	Passphrase string
+	// Encryption selects the pkcs12 algorithms, aes256 (default) or 3des
+	Encryption string
+	OutFile    string
+}
+
+// pkcs12 encryption schemes, 3des is for consumers that predate PBES2 such as Java 8 and older Windows
+var pkcs12Encryptions = map[string]string{
+	"aes256": "-keypbe AES-256-CBC -certpbe AES-256-CBC -macalg sha256 ",
+	"3des":   "-keypbe PBE-SHA1-3DES -certpbe PBE-SHA1-3DES -macalg sha1 ",
+}
+
+// Export related task definitions
+var taskExportDER = gofer.Register(gofer.Task{
+	Namespace:   "Export",
+	Label:       "DER",
+	Description: "Export a certificate in DER encoding",
+	Action: func(arguments ...string) error {
+
+		certificateFile := arguments[0]
+		outFile := arguments[1]
+
+		derCmd := "openssl x509 -in " + shellQuote(certificateFile) + " -outform DER -out " + shellQuote(outFile)
+		shellOutput := execute(derCmd)
+		if shellOutput.CmdError != nil {
+			log.Printf("\nUnable to export %v as DER\n", certificateFile)
+			return shellError(shellOutput)
+		}
+		return nil
+	},
+})
+
+var taskExportPKCS7 = gofer.Register(gofer.Task{
+	Namespace:   "Export",
+	Label:       "PKCS7",
+	Description: "Export a certificate chain as PKCS#7",
+	Action: func(arguments ...string) error {
+
+		bundleFile := arguments[0]
+		outFile := arguments[1]
+
+		pkcs7Cmd := "openssl crl2pkcs7 -nocrl -certfile " + shellQuote(bundleFile) + " -out " + shellQuote(outFile)
+		shellOutput := execute(pkcs7Cmd)
+		if shellOutput.CmdError != nil {
+			log.Printf("\nUnable to export %v as PKCS#7\n", bundleFile)
+			return shellError(shellOutput)
+		}
+		return nil
+	},
+})
+
+var taskExportPKCS8 = gofer.Register(gofer.Task{
+	Namespace:   "Export",
+	Label:       "PKCS8",
+	Description: "Export a private key as encrypted PKCS#8",
+	Action: func(arguments ...string) error {
+
+		keyFile := arguments[0]
+		opensslPassinString := arguments[1]
+		opensslPassoutString := arguments[2]
+		outFile := arguments[3]
+
+		pkcs8Cmd := "umask 077 && openssl pkcs8 -topk8 -v2 aes-256-cbc -v2prf hmacWithSHA256 " + opensslPassinString + opensslPassoutString + "-in " + shellQuote(keyFile) + " -out " + shellQuote(outFile)
+		shellOutput := execute(pkcs8Cmd)
+		if shellOutput.CmdError != nil {
+			log.Printf("\nUnable to export %v as PKCS#8, is this the right key passphrase?\n", keyFile)
+			return shellError(shellOutput)
+		}
+		return nil
+	},
+})
+
+var taskExportPKCS12 = gofer.Register(gofer.Task{
+	Namespace:   "Export",
+	Label:       "PKCS12",
+	Description: "Export a certificate, its chain and optionally its key as PKCS#12",
+	Action: func(arguments ...string) error {
+
+		certificateFile := arguments[0]
+		chainFile := arguments[1]
+		keyFile := arguments[2]
+		alias := arguments[3]
+		encryption := arguments[4]
+		opensslPassinString := arguments[5]
+		opensslPassoutString := arguments[6]
+		outFile := arguments[7]
+
+		pkcs12Cmd := "openssl pkcs12 -export " + encryption + opensslPassoutString + "-in " + shellQuote(certificateFile) + " -name " + shellQuote(alias) + " -out " + shellQuote(outFile)
+		if chainFile != "NA" {
+			pkcs12Cmd += " -certfile " + shellQuote(chainFile)
+		}
+		if keyFile != "NA" {
+			pkcs12Cmd += " " + opensslPassinString + "-inkey " + shellQuote(keyFile)
+		} else {
+			pkcs12Cmd += " -nokeys"
+		}
+		shellOutput := execute(pkcs12Cmd)
+		if shellOutput.CmdError != nil {
+			log.Printf("\nUnable to export %v as PKCS#12, is this the right key passphrase?\n", certificateFile)
+			return shellError(shellOutput)
+		}
+		return nil
+	},
+})
+
+var taskExportJKS = gofer.Register(gofer.Task{
+	Namespace:   "Export",
+	Label:       "JKS",
+	Description: "Convert a PKCS#12 file into a Java keystore",
+	Action: func(arguments ...string) error {
+
+		pkcs12File := arguments[0]
+		storePassphrase := arguments[1]
+		outFile := arguments[2]
+
+		storePassphraseEnv := shell.Secret(storePassphrase)
+		jksCmd := "keytool -importkeystore -noprompt -srckeystore " + shellQuote(pkcs12File) + " -srcstoretype PKCS12 -srcstorepass:env " + storePassphraseEnv +
+			" -destkeystore " + shellQuote(outFile) + " -deststoretype JKS -deststorepass:env " + storePassphraseEnv + " -destkeypass:env " + storePassphraseEnv
+		shellOutput := execute(jksCmd)
+		if shellOutput.CmdError != nil {
+			log.Printf("\nUnable to convert %v into a Java keystore\n", pkcs12File)
+			return shellError(shellOutput)
+		}
+		return nil
+	},
+})
+
+var taskExportTrustedCert = gofer.Register(gofer.Task{
+	Namespace:   "Export",
+	Label:       "TrustedCert",
+	Description: "Add a trusted certificate to a Java truststore",
+	Action: func(arguments ...string) error {
+
+		certificateFile := arguments[0]
+		alias := arguments[1]
+		storePassphrase := arguments[2]
+		outFile := arguments[3]
+
+		trustCmd := "keytool -importcert -noprompt -trustcacerts -alias " + shellQuote(alias) + " -file " + shellQuote(certificateFile) +
+			" -keystore " + shellQuote(outFile) + " -storetype JKS -storepass:env " + shell.Secret(storePassphrase)
+		shellOutput := execute(trustCmd)
+		if shellOutput.CmdError != nil {
+			log.Printf("\nUnable to add %v to truststore %v\n", certificateFile, outFile)
+			return shellError(shellOutput)
+		}
+		return nil
+	},
+})
+
+// CheckKeytool checks if the Java keytool is available on the host machine
+func CheckKeytool() error {
+	if _, err := exec.LookPath("keytool"); err != nil {
+		return ErrKeytoolNotFound
+	}
+	return nil
+}
+
+// Export writes the certificates and key described by request in the requested format
+func Export(request ExportRequest) error {
+	if len(request.Certificates) == 0 {
+		return errors.New("nothing to export")
+	}
+	if request.OutFile == "" {
+		return errors.New("an output file is required")
+	}
+
+	workDir, err := ioutil.TempDir("", "privki-export")
+	if err != nil {
+		return err
+	}
+	defer os.RemoveAll(workDir)
+
+	switch request.Format {
+	case FormatPEM:
+		bundle, err := concatenateCertificates(request.Certificates)
+		if err != nil {
+			return err
+		}
+		return ioutil.WriteFile(request.OutFile, bundle, 0644)
+
+	case FormatDER:
+		// DER holds a single certificate, the chain is left out
+		return gofer.Perform("Export:DER", request.Certificates[0].File, request.OutFile)
+
+	case FormatPKCS7:
+		bundleFile, err := writeBundle(workDir, "bundle.pem", request.Certificates)
+		if err != nil {
+			return err
+		}
+		return gofer.Perform("Export:PKCS7", bundleFile, request.OutFile)
+
+	case FormatPKCS8:
+		// keys only leave the vault encrypted, under a passphrase of their own
+		if request.KeyFile == "" {
+			return errors.New("a pkcs8 export needs a private key")
+		}
+		if len(request.Passphrase) < 6 {
+			return errors.New("PKCS#8 exports need a passphrase of at least 6 characters")
+		}
+		return gofer.Perform("Export:PKCS8", request.KeyFile, opensslPassin(request.KeyPassphrase), opensslPassout(request.Passphrase), request.OutFile)
+
+	case FormatPKCS12:
+		return exportPKCS12(workDir, request, request.OutFile)
+
+	case FormatJKS:
+		if request.KeyFile == "" {
+			return errors.New("a Java keystore needs the private key, use truststore to export certificates only")
+		}
+		if err := CheckKeytool(); err != nil {
+			return err
+		}
+		if len(request.Passphrase) < 6 {
+			return errors.New("Java keystores need a passphrase of at least 6 characters")
+		}
+		pkcs12File := filepath.Join(workDir, "keystore.p12")
+		if err := exportPKCS12(workDir, request, pkcs12File); err != nil {
+			return err
+		}
+		return gofer.Perform("Export:JKS", pkcs12File, request.Passphrase, request.OutFile)
+
+	case FormatTruststore:
+		if err := CheckKeytool(); err != nil {
+			return err
+		}
+		if len(request.Passphrase) < 6 {
+			return errors.New("Java truststores need a passphrase of at least 6 characters")
+		}
+		// keytool adds to an existing store, start from a fresh one
+		if err := os.Remove(request.OutFile); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+		for _, entry := range request.Certificates {
+			if err := gofer.Perform("Export:TrustedCert", entry.File, entry.Alias, request.Passphrase, request.OutFile); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+	return fmt.Errorf("unknown export format %q, use pem, der, pkcs7, pkcs8, pkcs12, jks or truststore", request.Format)
+}
+
+func exportPKCS12(workDir string, request ExportRequest, outFile string) error {
+	encryption := request.Encryption
+	if encryption == "" {
+		encryption = "aes256"
+	}
+	algorithms, found := pkcs12Encryptions[encryption]
+	if !found {
+		return fmt.Errorf("unknown PKCS#12 encryption %q, use aes256 or 3des", encryption)
+	}
+
+	chainFile := "NA"
+	if len(request.Certificates) > 1 {
+		bundleFile, err := writeBundle(workDir, "chain.pem", request.Certificates[1:])
+		if err != nil {
+			return err
+		}
+		chainFile = bundleFile
+	}
+	keyFile := "NA"
+	if request.KeyFile != "" {
+		keyFile = request.KeyFile
+	}
+	return gofer.Perform("Export:PKCS12", request.Certificates[0].File, chainFile, keyFile, request.Certificates[0].Alias, algorithms,
+		opensslPassin(request.KeyPassphrase), opensslPassout(request.Passphrase), outFile)
+}
+
+// writeBundle concatenates the PEM certificates of entries into a file in workDir
+func writeBundle(workDir string, name string, entries []ExportEntry) (string, error) {
+	bundle, err := concatenateCertificates(entries)
+	if err != nil {
+		return "", err
+	}
+	bundleFile := filepath.Join(workDir, name)
+	return bundleFile, ioutil.WriteFile(bundleFile, bundle, 0600)
+}
+
+func concatenateCertificates(entries []ExportEntry) ([]byte, error) {
+	var bundle []byte
+	for _, entry := range entries {
+		certBytes, err := ioutil.ReadFile(entry.File)
+		if err != nil {
+			return nil, err
+		}
+		bundle = append(bundle, certBytes...)
+		if !strings.HasSuffix(string(certBytes), "\n") {
+			bundle = append(bundle, '\n')
+		}
+	}
+	return bundle, nil
+}
@@ -0,0 +1,108 @@
+package openssl
+
+import (
+	"errors"
+	"fmt"
+	log "github.com/sirupsen/logrus"
+	"io/ioutil"
+	"path/filepath"
+	"time"
+)
+
+// CreateIntermediateFromCSR signs an A1 certificate request generated outside the vault,
+// for example in a partner's HSM, with the Root CA (A0) and the DR Root CA when DR is
+// enabled. The A1 gets our intermed-ca_ext extensions, name constraints and class OID,
+// its private key is never generated nor stored by privki. drPassphrase unlocks the DR A0
+// key when it has its own, empty when it shares rootPassphrase.
+func CreateIntermediateFromCSR(csrPEM []byte, nameRestriction string, pathLen int, rootPassphrase string, drPassphrase string) (string, error) {
+	log.Printf("\nCreating Intermediate CA (A1) from a certificate request\n")
+	if err := RequireRootVault(); err != nil {
+		return "", err
+	}
+	pkiPath, err := GetPkiPath()
+	if err != nil {
+		return "", err
+	}
+	rootCertUID, err := GetRootUID()
+	if err != nil {
+		return "", err
+	}
+	settings, err := readVaultSettings()
+	if err != nil {
+		return "", err
+	}
+	if err := checkNameRestriction(nameRestriction); err != nil {
+		return "", err
+	}
+	if pathLen < 0 {
+		return "", errors.New("the A1 path length must be 0 or more")
+	}
+	csr, err := parseCertificateRequest(csrPEM)
+	if err != nil {
+		return "", err
+	}
+	// the Root CA policy requires both, openssl ca would only fail after asking for the passphrase
+	if len(csr.Subject.Organization) == 0 || csr.Subject.CommonName == "" {
+		return "", errors.New("the certificate request subject needs an organization (O) and a common name (CN)")
+	}
+
+	startTime := time.Now().UTC().AddDate(0, 0, -1)
+	id := startTime.Format("20060102150405Z")
+	if _, err := FindIntermediate(pkiPath, rootCertUID, id); err == nil {
+		return "", fmt.Errorf("A1 %v already exists in this vault", id)
+	}
+	transaction, err := signIntermediateRequest(pkiPath, rootCertUID, csrPEM, nameRestriction, pathLen, startTime, settings.oid, true, rootPassphrase, drPassphrase)
+	if err != nil {
+		return "", err
+	}
+	// the A1 is marked rather than told apart by its missing key, a key lost from the vault stays an error
+	if err := ioutil.WriteFile(filepath.Join(transaction.stagingDir, externalKeyFile), []byte(time.Now().UTC().Format(time.RFC3339)+"\n"), 0644); err != nil {
+		transaction.rollback()
+		return "", err
+	}
+	if err := transaction.commit(filepath.Join(pkiPath, rootCertUID+intermediateDirMarker+"-"+id)); err != nil {
+		return "", err
+	}
+	return id, nil
+}
+
+// signIntermediateRequest stages csrPEM as a new A1 and signs it with the Root CA (A0),
+// cross signing it with the DR Root CA when DR is enabled. A non empty classOID is
+// added to the issued certificates, for requests that don't carry our class themselves.
+// The A1 gets pathLen as its pathLenConstraint. The requested extensions of an external
+// request are dropped, only the ones of the Root CA config are signed. The transaction holding the staged
+// certificates is returned for the caller to commit or roll back, it is rolled back on failure.
+func signIntermediateRequest(pkiPath string, rootCertUID string, csrPEM []byte, nameRestriction string, pathLen int, startTime time.Time, classOID string, external bool, rootPassphrase string, drPassphrase string) (*intermediateTransaction, error) {
+	drStatus := DREnabled()
+	if drStatus {
+		var err error
+		if drPassphrase, err = resolveDRPassphrase(rootPassphrase, drPassphrase); err != nil {
+			return nil, err
+		}
+	}
+	transaction, err := beginIntermediate(pkiPath, rootCertUID)
+	if err != nil {
+		return nil, err
+	}
+	if err := ioutil.WriteFile(filepath.Join(transaction.stagingDir, "intermed-ca.req.pem"), csrPEM, 0644); err != nil {
+		transaction.rollback()
+		return nil, err
+	}
+	signing := intermediateSigning{
+		NameRestriction: nameRestriction,
+		PathLen:         pathLen,
+		ClassOID:        classOID,
+		External:        external,
+		StartDate:       startTime.Format("20060102150405Z"),
+		ExpiryDate:      startTime.AddDate(18, 0, 1).Format("20060102150405Z"),
+	}
+	if err := signIntermediate(transaction, RootCADir(pkiPath, rootCertUID), signing, rootPassphrase, false); err != nil {
+		return nil, err
+	}
+	if drStatus {
+		if err := signIntermediate(transaction, DRRootCADir(pkiPath, rootCertUID), signing, drPassphrase, true); err != nil {
+			return nil, err
+		}
+	}
+	return transaction, nil
+}
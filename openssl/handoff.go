@@ -0,0 +1,600 @@
+package openssl
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/hex"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"github.com/chuckpreslar/gofer"
+	log "github.com/sirupsen/logrus"
+	"io"
+	"io/ioutil"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// handoffPackageVersion is bumped whenever the envelope or payload layout changes
+const handoffPackageVersion = 1
+
+// HandoffPackage is the envelope an A1 is handed over to a team in.
+// The payload is a gzipped tar of the A1, encrypted with AES-256-GCM under a
+// random key that is wrapped with RSA-OAEP-SHA256 to the recipient's public key.
+// The Root CA (A0) signs the envelope so the receiving host can tell where it came from.
+type HandoffPackage struct {
+	Version      int    `json:"version"`
+	Intermediate string `json:"a1"`
+	RootUID      string `json:"root_uid"`
+	Created      string `json:"created"`
+	// Recipient is the SHA-256 fingerprint of the recipient's public key
+	Recipient  string `json:"recipient"`
+	WrappedKey []byte `json:"wrapped_key"`
+	Nonce      []byte `json:"nonce"`
+	Payload    []byte `json:"payload"`
+	Signer     string `json:"signer"`
+	Signature  []byte `json:"signature"`
+}
+
+// handoffManifest describes the vault an A1 package belongs to
+type handoffManifest struct {
+	RootUID      string `json:"root_uid"`
+	Intermediate string `json:"a1"`
+	OID          string `json:"oid"`
+	Organization string `json:"organization"`
+	CommonName   string `json:"common_name"`
+}
+
+// files of an A1 that travel in a handoff package, the database is replaced by an empty one
+var handoffFiles = []string{
+	"intermed-ca.cnf",
+	"intermed-ca.cert.pem",
+	"intermed-ca.dr.cert.pem",
+	"intermed-ca-chain-bundle.cert.pem",
+	"intermed-ca-chain-bundle.dr.cert.pem",
+	"private/intermed-ca.key.pem",
+}
+
+// Handoff package related task definitions
+var taskPackageWrapKey = gofer.Register(gofer.Task{
+	Namespace:   "Package",
+	Label:       "WrapKey",
+	Description: "Encrypt a package key to the recipient's public key",
+	Action: func(arguments ...string) error {
+
+		recipientFile := arguments[0]
+		recipientForm := arguments[1]
+		keyFile := arguments[2]
+		wrappedKeyFile := arguments[3]
+
+		wrapCmd := "openssl pkeyutl -encrypt " + recipientForm + " -inkey " + shellQuote(recipientFile) +
+			" -pkeyopt rsa_padding_mode:oaep -pkeyopt rsa_oaep_md:sha256 -pkeyopt rsa_mgf1_md:sha256 -in " + shellQuote(keyFile) + " -out " + shellQuote(wrappedKeyFile)
+		shellOutput := execute(wrapCmd)
+		if shellOutput.CmdError != nil {
+			log.Printf("\nUnable to encrypt the package key to %v\n", recipientFile)
+			return shellError(shellOutput)
+		}
+		return nil
+	},
+})
+
+var taskPackageUnwrapKey = gofer.Register(gofer.Task{
+	Namespace:   "Package",
+	Label:       "UnwrapKey",
+	Description: "Decrypt a package key with the recipient's private key",
+	Action: func(arguments ...string) error {
+
+		recipientKeyFile := arguments[0]
+		opensslPassinString := arguments[1]
+		wrappedKeyFile := arguments[2]
+		keyFile := arguments[3]
+
+		unwrapCmd := "umask 077 && openssl pkeyutl -decrypt " + opensslPassinString + "-inkey " + shellQuote(recipientKeyFile) +
+			" -pkeyopt rsa_padding_mode:oaep -pkeyopt rsa_oaep_md:sha256 -pkeyopt rsa_mgf1_md:sha256 -in " + shellQuote(wrappedKeyFile) + " -out " + shellQuote(keyFile)
+		shellOutput := execute(unwrapCmd)
+		if shellOutput.CmdError != nil {
+			log.Printf("\nUnable to decrypt the package key with %v, is the package addressed to this key?\n", recipientKeyFile)
+			return shellError(shellOutput)
+		}
+		return nil
+	},
+})
+
+var taskPackageSign = gofer.Register(gofer.Task{
+	Namespace:   "Package",
+	Label:       "Sign",
+	Description: "Sign a package or bundle with a CA key",
+	Action: func(arguments ...string) error {
+
+		caKeyFile := arguments[0]
+		opensslPassinString := arguments[1]
+		contentFile := arguments[2]
+		signatureFile := arguments[3]
+
+		signCmd := "openssl dgst -sha256 -sign " + shellQuote(caKeyFile) + " " + opensslPassinString + "-out " + shellQuote(signatureFile) + " " + shellQuote(contentFile)
+		shellOutput := execute(signCmd)
+		if shellOutput.CmdError != nil {
+			log.Printf("\nUnable to sign with %v, is this the right passphrase for it?\n", caKeyFile)
+			return shellError(shellOutput)
+		}
+		return nil
+	},
+})
+
+// PackageIntermediate encrypts an A1 to the public key or certificate in recipientFile
+// and signs the resulting package with the Root CA (A0).
+func PackageIntermediate(pkiPath string, rootCertUID string, intermediate *Intermediate, recipientFile string, rootPassphrase string) (*HandoffPackage, error) {
+	if err := RequireRootVault(); err != nil {
+		return nil, err
+	}
+	recipientPEM, err := ioutil.ReadFile(recipientFile)
+	if err != nil {
+		return nil, err
+	}
+	fingerprint, recipientForm, err := recipientFingerprint(recipientPEM)
+	if err != nil {
+		return nil, err
+	}
+	settings, err := readVaultSettings()
+	if err != nil {
+		return nil, err
+	}
+
+	payload, err := packIntermediate(pkiPath, rootCertUID, intermediate, settings)
+	if err != nil {
+		return nil, err
+	}
+
+	workDir, err := ioutil.TempDir("", "privki-package")
+	if err != nil {
+		return nil, err
+	}
+	defer os.RemoveAll(workDir)
+
+	packageKey := make([]byte, 32)
+	if _, err := rand.Read(packageKey); err != nil {
+		return nil, err
+	}
+	handoff := &HandoffPackage{
+		Version:      handoffPackageVersion,
+		Intermediate: intermediate.ID,
+		RootUID:      rootCertUID,
+		Created:      time.Now().UTC().Format(time.RFC3339),
+		Recipient:    fingerprint,
+		Nonce:        make([]byte, 12),
+	}
+	if _, err := rand.Read(handoff.Nonce); err != nil {
+		return nil, err
+	}
+	gcm, err := newPackageCipher(packageKey)
+	if err != nil {
+		return nil, err
+	}
+	handoff.Payload = gcm.Seal(nil, handoff.Nonce, payload, []byte(handoff.Intermediate+"/"+handoff.RootUID))
+
+	keyFile := filepath.Join(workDir, "package.key")
+	if err := ioutil.WriteFile(keyFile, packageKey, 0600); err != nil {
+		return nil, err
+	}
+	wrappedKeyFile := filepath.Join(workDir, "package.key.wrapped")
+	if err := gofer.Perform("Package:WrapKey", recipientFile, recipientForm, keyFile, wrappedKeyFile); err != nil {
+		return nil, err
+	}
+	if handoff.WrappedKey, err = ioutil.ReadFile(wrappedKeyFile); err != nil {
+		return nil, err
+	}
+
+	rootDir := RootCADir(pkiPath, rootCertUID)
+	signerPEM, err := ioutil.ReadFile(filepath.Join(rootDir, "root-ca.cert.pem"))
+	if err != nil {
+		return nil, err
+	}
+	handoff.Signer = string(signerPEM)
+	if handoff.Signature, err = signContent(workDir, filepath.Join(rootDir, "private", "root-ca.key.pem"), rootPassphrase, handoff.signedContent()); err != nil {
+		return nil, err
+	}
+	return handoff, nil
+}
+
+// signContent signs content with SHA-256 and the PEM private key in keyFile
+func signContent(workDir string, keyFile string, passphrase string, content []byte) ([]byte, error) {
+	contentFile := filepath.Join(workDir, "signed.content")
+	if err := ioutil.WriteFile(contentFile, content, 0600); err != nil {
+		return nil, err
+	}
+	signatureFile := filepath.Join(workDir, "signed.sig")
+	if err := gofer.Perform("Package:Sign", keyFile, opensslPassin(passphrase), contentFile, signatureFile); err != nil {
+		return nil, err
+	}
+	return ioutil.ReadFile(signatureFile)
+}
+
+// UnpackIntermediate verifies a handoff package against the trusted Root CA certificates,
+// decrypts it with the recipient's private key and installs the A1 into the vault of
+// this host, setting up a subordinate vault first if the host does not have one yet.
+func UnpackIntermediate(handoff *HandoffPackage, trusted []*x509.Certificate, recipientKeyFile string, keyPassphrase string) (*Intermediate, error) {
+	signer, err := handoff.verify(trusted)
+	if err != nil {
+		return nil, err
+	}
+
+	workDir, err := ioutil.TempDir("", "privki-unpack")
+	if err != nil {
+		return nil, err
+	}
+	defer os.RemoveAll(workDir)
+
+	wrappedKeyFile := filepath.Join(workDir, "package.key.wrapped")
+	if err := ioutil.WriteFile(wrappedKeyFile, handoff.WrappedKey, 0600); err != nil {
+		return nil, err
+	}
+	keyFile := filepath.Join(workDir, "package.key")
+	if err := gofer.Perform("Package:UnwrapKey", recipientKeyFile, opensslPassin(keyPassphrase), wrappedKeyFile, keyFile); err != nil {
+		return nil, err
+	}
+	packageKey, err := ioutil.ReadFile(keyFile)
+	if err != nil {
+		return nil, err
+	}
+	gcm, err := newPackageCipher(packageKey)
+	if err != nil {
+		return nil, err
+	}
+	payload, err := gcm.Open(nil, handoff.Nonce, handoff.Payload, []byte(handoff.Intermediate+"/"+handoff.RootUID))
+	if err != nil {
+		return nil, errors.New("package payload does not decrypt, it was modified or is addressed to another key")
+	}
+
+	files, err := readPayload(payload)
+	if err != nil {
+		return nil, err
+	}
+	var manifest handoffManifest
+	if err := json.Unmarshal(files["vault.json"], &manifest); err != nil {
+		return nil, fmt.Errorf("package manifest: %v", err)
+	}
+	if manifest.RootUID != handoff.RootUID || manifest.Intermediate != handoff.Intermediate {
+		return nil, errors.New("package manifest does not match its envelope")
+	}
+	if err := verifyPackagedChain(files, signer); err != nil {
+		return nil, err
+	}
+	return installIntermediate(manifest, files)
+}
+
+// ReadHandoffPackage reads a handoff package written by PackageIntermediate
+func ReadHandoffPackage(packageFile string) (*HandoffPackage, error) {
+	packageBytes, err := ioutil.ReadFile(packageFile)
+	if err != nil {
+		return nil, err
+	}
+	handoff := new(HandoffPackage)
+	if err := json.Unmarshal(packageBytes, handoff); err != nil {
+		return nil, fmt.Errorf("%v is not a privki A1 package: %v", packageFile, err)
+	}
+	if handoff.Version != handoffPackageVersion {
+		return nil, fmt.Errorf("unsupported A1 package version %v", handoff.Version)
+	}
+	return handoff, nil
+}
+
+// signedContent is the byte string the Root CA signs, every envelope field but the signature
+func (handoff *HandoffPackage) signedContent() []byte {
+	content := strings.Join([]string{
+		fmt.Sprintf("privki-a1-package-v%d", handoff.Version),
+		handoff.Intermediate,
+		handoff.RootUID,
+		handoff.Created,
+		handoff.Recipient,
+		hex.EncodeToString(handoff.WrappedKey),
+		hex.EncodeToString(handoff.Nonce),
+		hex.EncodeToString(sha256Sum(handoff.Payload)),
+		hex.EncodeToString(sha256Sum([]byte(handoff.Signer))),
+	}, "\n")
+	return []byte(content)
+}
+
+// verify checks the package signature and that it was made by one of the trusted Root CAs
+func (handoff *HandoffPackage) verify(trusted []*x509.Certificate) (*x509.Certificate, error) {
+	signers, err := ParseCertificates([]byte(handoff.Signer))
+	if err != nil {
+		return nil, fmt.Errorf("package signer: %v", err)
+	}
+	signer := signers[0]
+	isTrusted := false
+	for _, root := range trusted {
+		if bytes.Equal(root.Raw, signer.Raw) {
+			isTrusted = true
+		}
+	}
+	if !isTrusted {
+		return nil, fmt.Errorf("package is signed by %v, which is not a trusted Root CA", signer.Subject)
+	}
+	if err := signer.CheckSignature(x509.SHA256WithRSA, handoff.signedContent(), handoff.Signature); err != nil {
+		return nil, fmt.Errorf("package signature does not verify: %v", err)
+	}
+	return signer, nil
+}
+
+// recipientFingerprint returns the SHA-256 fingerprint of an RSA public key or certificate,
+// and the pkeyutl option to read it with.
+func recipientFingerprint(recipientPEM []byte) (string, string, error) {
+	block, _ := pem.Decode(recipientPEM)
+	if block == nil {
+		return "", "", errors.New("recipient is not a PEM public key or certificate")
+	}
+	publicKeyDER := block.Bytes
+	recipientForm := "-pubin"
+	if block.Type == "CERTIFICATE" {
+		cert, err := x509.ParseCertificate(block.Bytes)
+		if err != nil {
+			return "", "", err
+		}
+		publicKeyDER = cert.RawSubjectPublicKeyInfo
+		recipientForm = "-certin"
+	} else if block.Type != "PUBLIC KEY" {
+		return "", "", fmt.Errorf("recipient is a %v, a PUBLIC KEY or CERTIFICATE is needed", block.Type)
+	}
+	publicKey, err := x509.ParsePKIXPublicKey(publicKeyDER)
+	if err != nil {
+		return "", "", err
+	}
+	if _, isRSA := publicKey.(*rsa.PublicKey); !isRSA {
+		return "", "", errors.New("recipient key must be an RSA key")
+	}
+	return hex.EncodeToString(sha256Sum(publicKeyDER)), recipientForm, nil
+}
+
+// packIntermediate builds the gzipped tar payload of an A1 package
+func packIntermediate(pkiPath string, rootCertUID string, intermediate *Intermediate, settings *vaultSettings) ([]byte, error) {
+	manifest, err := json.MarshalIndent(handoffManifest{
+		RootUID:      rootCertUID,
+		Intermediate: intermediate.ID,
+		OID:          settings.oid,
+		Organization: settings.organization,
+		CommonName:   settings.commonName,
+	}, "", "  ")
+	if err != nil {
+		return nil, err
+	}
+	serial := make([]byte, 16)
+	if _, err := rand.Read(serial); err != nil {
+		return nil, err
+	}
+
+	files := map[string][]byte{
+		"vault.json":            manifest,
+		"a1/intermed-ca.index":  {},
+		"a1/intermed-ca.crlnum": []byte("00\n"),
+		"a1/intermed-ca.serial": []byte(hex.EncodeToString(serial) + "\n"),
+	}
+	for _, name := range handoffFiles {
+		content, err := ioutil.ReadFile(filepath.Join(intermediate.Dir, name))
+		if os.IsNotExist(err) {
+			continue
+		} else if err != nil {
+			return nil, err
+		}
+		files["a1/"+name] = content
+	}
+	for name, rootDir := range map[string]string{
+		"roots/root-ca.cert.pem":    RootCADir(pkiPath, rootCertUID),
+		"roots/dr-root-ca.cert.pem": DRRootCADir(pkiPath, rootCertUID),
+	} {
+		content, err := ioutil.ReadFile(filepath.Join(rootDir, "root-ca.cert.pem"))
+		if os.IsNotExist(err) {
+			continue
+		} else if err != nil {
+			return nil, err
+		}
+		files[name] = content
+	}
+
+	var payload bytes.Buffer
+	gzipWriter := gzip.NewWriter(&payload)
+	tarWriter := tar.NewWriter(gzipWriter)
+	for name, content := range files {
+		mode := int64(0644)
+		if strings.HasPrefix(name, "a1/private/") {
+			mode = 0400
+		}
+		if err := tarWriter.WriteHeader(&tar.Header{Name: name, Mode: mode, Size: int64(len(content)), ModTime: time.Now()}); err != nil {
+			return nil, err
+		}
+		if _, err := tarWriter.Write(content); err != nil {
+			return nil, err
+		}
+	}
+	if err := tarWriter.Close(); err != nil {
+		return nil, err
+	}
+	if err := gzipWriter.Close(); err != nil {
+		return nil, err
+	}
+	return payload.Bytes(), nil
+}
+
+// readPayload unpacks a decrypted payload into memory, rejecting paths outside the package
+func readPayload(payload []byte) (map[string][]byte, error) {
+	gzipReader, err := gzip.NewReader(bytes.NewReader(payload))
+	if err != nil {
+		return nil, err
+	}
+	tarReader := tar.NewReader(gzipReader)
+	files := make(map[string][]byte)
+	for {
+		header, err := tarReader.Next()
+		if err == io.EOF {
+			break
+		} else if err != nil {
+			return nil, err
+		}
+		name := path.Clean(header.Name)
+		if path.IsAbs(name) || strings.HasPrefix(name, "..") {
+			return nil, fmt.Errorf("package contains an unsafe path %v", header.Name)
+		}
+		content, err := ioutil.ReadAll(tarReader)
+		if err != nil {
+			return nil, err
+		}
+		files[name] = content
+	}
+	for _, required := range []string{"vault.json", "a1/intermed-ca.cnf", "a1/intermed-ca.cert.pem", "a1/private/intermed-ca.key.pem", "roots/root-ca.cert.pem"} {
+		if _, found := files[required]; !found {
+			return nil, fmt.Errorf("package is missing %v", required)
+		}
+	}
+	return files, nil
+}
+
+// verifyPackagedChain checks that the packaged A1 certificate was issued by the package signer
+func verifyPackagedChain(files map[string][]byte, signer *x509.Certificate) error {
+	rootCerts, err := ParseCertificates(files["roots/root-ca.cert.pem"])
+	if err != nil {
+		return err
+	}
+	if !bytes.Equal(rootCerts[0].Raw, signer.Raw) {
+		return errors.New("packaged Root CA certificate is not the package signer")
+	}
+	intermediateCerts, err := ParseCertificates(files["a1/intermed-ca.cert.pem"])
+	if err != nil {
+		return err
+	}
+	roots := x509.NewCertPool()
+	roots.AddCert(signer)
+	_, err = intermediateCerts[0].Verify(x509.VerifyOptions{Roots: roots, KeyUsages: []x509.ExtKeyUsage{x509.ExtKeyUsageAny}})
+	if err != nil {
+		return fmt.Errorf("packaged A1 certificate does not chain to the package signer: %v", err)
+	}
+	return nil
+}
+
+// installIntermediate writes an unpacked A1 into the vault of this host,
+// initializing a subordinate vault for the package's Root CA if there is none.
+func installIntermediate(manifest handoffManifest, files map[string][]byte) (*Intermediate, error) {
+	pkiPath, err := vaultPathFor(manifest, files)
+	if err != nil {
+		return nil, err
+	}
+
+	intermediateDir := filepath.Join(pkiPath, manifest.RootUID+intermediateDirMarker+"-"+manifest.Intermediate)
+	if _, err := os.Stat(intermediateDir); err == nil {
+		return nil, fmt.Errorf("A1 %v is already present at %v", manifest.Intermediate, intermediateDir)
+	}
+	for _, subDir := range []string{"certreqs", "certs", "crl", "newcerts", "private"} {
+		if err := os.MkdirAll(filepath.Join(intermediateDir, subDir), DefaultDirPerms); err != nil {
+			return nil, err
+		}
+	}
+	if err := os.Chmod(filepath.Join(intermediateDir, "private"), 0700); err != nil {
+		return nil, err
+	}
+	for name, content := range files {
+		if !strings.HasPrefix(name, "a1/") {
+			continue
+		}
+		mode := os.FileMode(0644)
+		if strings.HasPrefix(name, "a1/private/") {
+			mode = 0400
+		}
+		if err := ioutil.WriteFile(filepath.Join(intermediateDir, strings.TrimPrefix(name, "a1/")), content, mode); err != nil {
+			return nil, err
+		}
+	}
+	log.Printf("A1 %v installed at %v", manifest.Intermediate, intermediateDir)
+	return FindIntermediate(pkiPath, manifest.RootUID, manifest.Intermediate)
+}
+
+// vaultPathFor returns the path of the vault on this host for the Root CA of manifest,
+// initializing a subordinate vault from the root certificates in files if there is none.
+func vaultPathFor(manifest handoffManifest, files map[string][]byte) (string, error) {
+	if !VaultInitialized() {
+		pkiPath := GetPkiBaseDir() + manifest.RootUID
+		return pkiPath, initSubordinateVault(manifest, pkiPath, files)
+	}
+	pkiPath, err := GetPkiPath()
+	if err != nil {
+		return "", err
+	}
+	rootCertUID, err := GetRootUID()
+	if err != nil {
+		return "", err
+	}
+	if rootCertUID != manifest.RootUID {
+		return "", fmt.Errorf("this host already holds a vault for root %v, not for root %v", rootCertUID, manifest.RootUID)
+	}
+	return pkiPath, nil
+}
+
+// initSubordinateVault sets up the configuration and Root CA certificates of a vault
+// that only operates A1s handed over from the vault holding the Root CA keys.
+func initSubordinateVault(manifest handoffManifest, pkiPath string, files map[string][]byte) error {
+	if err := gofer.Perform("PKI:createRootUID", manifest.RootUID); err != nil {
+		return err
+	}
+	if err := gofer.Perform("PKI:init", pkiPath); err != nil {
+		return err
+	}
+	if err := gofer.Perform("PKI:SaveMode", VaultModeSubordinate); err != nil {
+		return err
+	}
+	// the Root CA keys stay on the root vault host
+	if err := os.Remove(GetPrimaryRootConfigFile()); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	settings := map[string]string{
+		GetOidConfigFile():           manifest.OID,
+		GetOrgNameConfigFile():       manifest.Organization,
+		GetOrgCommonNameConfigFile(): manifest.CommonName,
+		GetDRStatusConfigFile():      "false",
+	}
+	if _, hasDR := files["roots/dr-root-ca.cert.pem"]; hasDR {
+		settings[GetDRStatusConfigFile()] = "true"
+	}
+	for configFile, value := range settings {
+		if err := ioutil.WriteFile(configFile, []byte(value+"\n"), 0644); err != nil {
+			return err
+		}
+	}
+	for name, rootDir := range map[string]string{
+		"roots/root-ca.cert.pem":    RootCADir(pkiPath, manifest.RootUID),
+		"roots/dr-root-ca.cert.pem": DRRootCADir(pkiPath, manifest.RootUID),
+	} {
+		content, found := files[name]
+		if !found {
+			continue
+		}
+		if err := os.MkdirAll(rootDir, DefaultDirPerms); err != nil {
+			return err
+		}
+		if err := ioutil.WriteFile(filepath.Join(rootDir, "root-ca.cert.pem"), content, 0644); err != nil {
+			return err
+		}
+	}
+	log.Printf("Subordinate vault for root %v initialized at %v", manifest.RootUID, pkiPath)
+	return nil
+}
+
+func newPackageCipher(packageKey []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(packageKey)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+func sha256Sum(content []byte) []byte {
+	sum := sha256.Sum256(content)
+	return sum[:]
+}
@@ -0,0 +1,306 @@
+package openssl
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	log "github.com/sirupsen/logrus"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"sfcert/shell"
+	"strings"
+	"time"
+)
+
+// historyDirName is the git repository recording the public state of the vault, inside
+// the PKI dir. It holds copies of the certificates, CRLs, databases, configs and audit
+// trail, the private keys never enter it.
+const historyDirName = "history"
+
+// historyConfigDir holds the copies of the vault settings in the history
+const historyConfigDir = "config"
+
+// ErrNoHistory is returned when the vault history has no commit yet
+var ErrNoHistory = errors.New("the vault has no history yet, the next command changing the vault records it")
+
+// HistoryEntry is an operation that changed the vault, as recorded in its history
+type HistoryEntry struct {
+	Commit    string    `json:"commit"`
+	Time      time.Time `json:"time"`
+	Operation string    `json:"operation"`
+	Actor     string    `json:"actor"`
+	// Failure is the error of an operation that failed, what it left in the vault is recorded all the same
+	Failure string   `json:"failure,omitempty"`
+	Files   []string `json:"files"`
+}
+
+// recordHistory commits the public state of the vault once operation is done, marked as
+// failed when failure is set, nothing is recorded when it did not change the vault or git
+// is not installed
+func recordHistory(holder LockHolder, failure error) error {
+	if _, err := exec.LookPath("git"); err != nil {
+		log.Debugf("git is not installed, the vault history is not recorded")
+		return nil
+	}
+	pkiPath, err := GetPkiPath()
+	if err != nil || !dirExists(pkiPath) {
+		return nil
+	}
+	historyDir := filepath.Join(pkiPath, historyDirName)
+	if !dirExists(filepath.Join(historyDir, ".git")) {
+		if shellOutput := shell.Execute("git init -q "+shellQuote(historyDir), false, false); shellOutput.CmdError != nil {
+			return shellError(shellOutput)
+		}
+	}
+	if err := syncHistory(pkiPath, historyDir); err != nil {
+		return err
+	}
+
+	shellOutput := shell.Execute("cd "+shellQuote(historyDir)+" && git add -A . && git status --porcelain", false, false)
+	if shellOutput.CmdError != nil {
+		return shellError(shellOutput)
+	}
+	if strings.TrimSpace(shellOutput.Stdout) == "" {
+		return nil
+	}
+	user := strings.SplitN(holder.Actor, "@", 2)[0]
+	subject := historySubject(holder.Operation)
+	trailers := fmt.Sprintf("Operation: %v\nActor: %v\nPid: %d\nStarted: %v", holder.Operation, holder.Actor, holder.PID, holder.Since.Format(time.RFC3339))
+	if failure != nil {
+		subject += " (failed)"
+		trailers += "\nFailure: " + strings.Join(strings.Fields(failure.Error()), " ")
+	}
+	commitCmd := "cd " + shellQuote(historyDir) + " && git -c user.name=" + shellQuote(user) + " -c user.email=" + shellQuote(holder.Actor) +
+		" commit -q --no-verify -m " + shellQuote(subject) + " -m " + shellQuote(trailers)
+	if shellOutput := shell.Execute(commitCmd, false, false); shellOutput.CmdError != nil {
+		return shellError(shellOutput)
+	}
+	return nil
+}
+
+// beginHistory records the state of a vault that has no history yet, before an operation
+// changes it, so that the first operation is recorded with its own changes only
+func beginHistory() error {
+	pkiPath, err := GetPkiPath()
+	if err != nil || !dirExists(pkiPath) || dirExists(filepath.Join(pkiPath, historyDirName, ".git")) {
+		return nil
+	}
+	return recordHistory(LockHolder{PID: os.Getpid(), Actor: localActor(), Operation: "vault state before its history", Since: time.Now().UTC()}, nil)
+}
+
+// historySubject keeps the command of an operation, its flags are in the trailers
+func historySubject(operation string) string {
+	if i := strings.Index(operation, " --"); i > 0 {
+		return operation[:i]
+	}
+	return operation
+}
+
+// syncHistory mirrors the public files of the vault into the history work tree
+func syncHistory(pkiPath string, historyDir string) error {
+	sources := map[string]string{}
+	rootCertUID, _ := GetRootUID()
+	err := filepath.Walk(pkiPath, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		relative, _ := filepath.Rel(pkiPath, path)
+		if info.IsDir() {
+			if relative != "." && !historicDir(relative, rootCertUID) {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		if info.Mode().IsRegular() && historicFile(path) {
+			sources[relative] = path
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	configFiles, err := ioutil.ReadDir(GetPkiConfigDir())
+	if err != nil {
+		return err
+	}
+	// the notify state lives in a dir of its own and stays out of the history
+	for _, configFile := range configFiles {
+		if configFile.Mode().IsRegular() {
+			sources[filepath.Join(historyConfigDir, configFile.Name())] = filepath.Join(GetPkiConfigDir(), configFile.Name())
+		}
+	}
+
+	// files gone from the vault are removed from the history
+	err = filepath.Walk(historyDir, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if info.IsDir() && info.Name() == ".git" {
+			return filepath.SkipDir
+		}
+		relative, _ := filepath.Rel(historyDir, path)
+		if _, found := sources[relative]; !info.IsDir() && !found {
+			return os.Remove(path)
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	for relative, source := range sources {
+		content, err := ioutil.ReadFile(source)
+		if err != nil {
+			return err
+		}
+		// a private key never enters the history, whatever its file name
+		if bytes.Contains(content, []byte("PRIVATE KEY-----")) {
+			continue
+		}
+		target := filepath.Join(historyDir, relative)
+		if current, err := ioutil.ReadFile(target); err == nil && bytes.Equal(current, content) {
+			continue
+		}
+		if err := os.MkdirAll(filepath.Dir(target), DefaultDirPerms); err != nil {
+			return err
+		}
+		if err := ioutil.WriteFile(target, content, 0644); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// historicDir tells whether a dir of the PKI dir is part of the history, key dirs, the
+// encrypted A1 archives and the staging dir of an A1 being created are not
+func historicDir(relative string, rootCertUID string) bool {
+	switch filepath.Base(relative) {
+	case "private", "output", historyDirName, rootCertUID + intermediateDirMarker:
+		return false
+	}
+	return true
+}
+
+func historicFile(path string) bool {
+	name := filepath.Base(path)
+	switch {
+	case name == databaseFileName:
+		return false
+	case strings.HasSuffix(name, ".key"), strings.Contains(name, ".key."), strings.HasSuffix(name, ".p12"):
+		return false
+	case strings.HasSuffix(name, ".tmp"), strings.HasSuffix(name, ".zip"):
+		return false
+	}
+	return true
+}
+
+// ReadHistory returns the last limit operations recorded in the history of the vault at
+// pkiPath, newest first, all of them when limit is 0
+func ReadHistory(pkiPath string, limit int) ([]HistoryEntry, error) {
+	historyDir, err := historyRepository(pkiPath)
+	if err != nil {
+		return nil, err
+	}
+	logCmd := "cd " + shellQuote(historyDir) + " && git log --name-only --format=%x1e%H%x1f%cI%x1f%B%x1f"
+	if limit > 0 {
+		logCmd += fmt.Sprintf(" -n %d", limit)
+	}
+	shellOutput := shell.Execute(logCmd, false, false)
+	if shellOutput.CmdError != nil {
+		return nil, shellError(shellOutput)
+	}
+	var entries []HistoryEntry
+	for _, record := range strings.Split(shellOutput.Stdout, "\x1e") {
+		fields := strings.Split(record, "\x1f")
+		if len(fields) != 4 {
+			continue
+		}
+		entry := HistoryEntry{Commit: fields[0]}
+		entry.Time, _ = time.Parse(time.RFC3339, fields[1])
+		for _, line := range strings.Split(fields[2], "\n") {
+			switch {
+			case strings.HasPrefix(line, "Operation: "):
+				entry.Operation = strings.TrimPrefix(line, "Operation: ")
+			case strings.HasPrefix(line, "Actor: "):
+				entry.Actor = strings.TrimPrefix(line, "Actor: ")
+			case strings.HasPrefix(line, "Failure: "):
+				entry.Failure = strings.TrimPrefix(line, "Failure: ")
+			}
+		}
+		for _, file := range strings.Split(fields[3], "\n") {
+			if file = strings.TrimSpace(file); file != "" {
+				entry.Files = append(entry.Files, file)
+			}
+		}
+		entries = append(entries, entry)
+	}
+	return entries, nil
+}
+
+// DiffHistory returns the changes of the vault at pkiPath between two points of its history,
+// a point is a commit, a date or a time. from defaults to the point before to, to defaults
+// to the latest one. stat summarizes the changed files instead of showing them.
+func DiffHistory(pkiPath string, from string, to string, stat bool) (string, error) {
+	historyDir, err := historyRepository(pkiPath)
+	if err != nil {
+		return "", err
+	}
+	toCommit, err := resolveHistoryPoint(historyDir, to)
+	if err != nil {
+		return "", err
+	}
+	var fromCommit string
+	if from == "" {
+		// the first operation is diffed against the empty tree
+		fromCommit, err = resolveHistoryPoint(historyDir, toCommit+"~1")
+		if err != nil {
+			fromCommit = "4b825dc642cb6eb9a060e54bf8d69288fbee4904"
+		}
+	} else if fromCommit, err = resolveHistoryPoint(historyDir, from); err != nil {
+		return "", err
+	}
+	diffCmd := "cd " + shellQuote(historyDir) + " && git diff --no-color"
+	if stat {
+		diffCmd += " --stat"
+	}
+	shellOutput := shell.Execute(diffCmd+" "+fromCommit+" "+toCommit, false, false)
+	if shellOutput.CmdError != nil {
+		return "", shellError(shellOutput)
+	}
+	return shellOutput.Stdout, nil
+}
+
+func historyRepository(pkiPath string) (string, error) {
+	historyDir := filepath.Join(pkiPath, historyDirName)
+	if !dirExists(filepath.Join(historyDir, ".git")) {
+		return "", ErrNoHistory
+	}
+	if shellOutput := shell.Execute("cd "+shellQuote(historyDir)+" && git rev-parse -q --verify HEAD", false, false); shellOutput.CmdError != nil {
+		return "", ErrNoHistory
+	}
+	return historyDir, nil
+}
+
+// resolveHistoryPoint returns the commit of a point of the history, the last commit made
+// before it for a date or time
+func resolveHistoryPoint(historyDir string, point string) (string, error) {
+	revision := point
+	if point == "" {
+		revision = "HEAD"
+	}
+	for _, layout := range []string{time.RFC3339, "2006-01-02T15:04:05", "2006-01-02"} {
+		if at, err := time.ParseInLocation(layout, point, time.Local); err == nil {
+			shellOutput := shell.Execute("cd "+shellQuote(historyDir)+" && git rev-list -1 --before="+shellQuote(at.Format(time.RFC3339))+" HEAD", false, false)
+			if shellOutput.CmdError != nil || strings.TrimSpace(shellOutput.Stdout) == "" {
+				return "", fmt.Errorf("the vault history has no operation before %v", point)
+			}
+			return strings.TrimSpace(shellOutput.Stdout), nil
+		}
+	}
+	shellOutput := shell.Execute("cd "+shellQuote(historyDir)+" && git rev-parse -q --verify "+shellQuote(revision+"^{commit}"), false, false)
+	if shellOutput.CmdError != nil {
+		return "", fmt.Errorf("%q is not a point of the vault history, use a commit, a date or a time", point)
+	}
+	return strings.TrimSpace(shellOutput.Stdout), nil
+}
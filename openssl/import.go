@@ -0,0 +1,519 @@
+package openssl
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/rand"
+	"crypto/x509"
+	"encoding/asn1"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"github.com/chuckpreslar/gofer"
+	log "github.com/sirupsen/logrus"
+	"io/ioutil"
+	"math/big"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"time"
+)
+
+// oidCRLNumber is the CRL Number extension of RFC 5280
+var oidCRLNumber = asn1.ObjectIdentifier{2, 5, 29, 20}
+
+var hexSerialPattern = regexp.MustCompile(`^[0-9A-Fa-f]+$`)
+
+// ImportRequest describes an existing openssl CA to adopt into the vault
+type ImportRequest struct {
+	CertFile string
+	KeyFile  string
+	// IndexFile is the openssl ca database, an empty database is started without it
+	IndexFile string
+	// SerialFile and CRLNumberFile default to the next numbers after the index and CRL
+	SerialFile    string
+	CRLNumberFile string
+	CRLFile       string
+	// NewcertsDir holds the certificates issued by the CA, named <serial>.pem
+	NewcertsDir string
+	// KeyPassphrase unlocks KeyFile, empty for an unencrypted key
+	KeyPassphrase string
+	// Passphrase protects the key inside the vault
+	Passphrase string
+	// OID is the class OID of a vault adopting a root, it defaults to DefaultOID
+	OID string
+	// RootPassphrase cross signs an imported A1 with the DR Root CA when DR is enabled
+	RootPassphrase string
+	// DRPassphrase replaces RootPassphrase when the DR Root CA key has a passphrase of its own
+	DRPassphrase string
+}
+
+// ImportedCA describes a CA adopted into the vault
+type ImportedCA struct {
+	// Kind is A0 for a root, A1 or A2 for intermediates
+	Kind    string `json:"kind"`
+	ID      string `json:"id,omitempty"`
+	Dir     string `json:"dir"`
+	Subject string `json:"subject"`
+	Entries int    `json:"entries"`
+	// CrossSigned is set when an imported A1 was cross signed by the DR Root CA
+	CrossSigned bool `json:"cross_signed"`
+}
+
+// Import related task definitions
+var taskImportKey = gofer.Register(gofer.Task{
+	Namespace:   "Import",
+	Label:       "Key",
+	Description: "Encrypt an imported CA key under the vault passphrase",
+	Action: func(arguments ...string) error {
+
+		keyFile := arguments[0]
+		opensslPassinString := arguments[1]
+		opensslPassoutString := arguments[2]
+		outFile := arguments[3]
+
+		importKeyCmd := "umask 077 && openssl pkey -in " + shellQuote(keyFile) + " " + opensslPassinString + "-aes256 " + opensslPassoutString + "-out " + shellQuote(outFile) + " && chmod 400 " + shellQuote(outFile)
+		shellOutput := execute(importKeyCmd)
+		if shellOutput.CmdError != nil {
+			log.Printf("\nUnable to read the private key %v, is this the right passphrase for it?\n", keyFile)
+			return shellError(shellOutput)
+		}
+		return nil
+	},
+})
+
+var taskImportPublicKey = gofer.Register(gofer.Task{
+	Namespace:   "Import",
+	Label:       "PublicKey",
+	Description: "Extract the public key of an imported CA key",
+	Action: func(arguments ...string) error {
+
+		keyFile := arguments[0]
+		opensslPassinString := arguments[1]
+		outFile := arguments[2]
+
+		publicKeyCmd := "openssl pkey -in " + shellQuote(keyFile) + " " + opensslPassinString + "-pubout -out " + shellQuote(outFile)
+		shellOutput := execute(publicKeyCmd)
+		if shellOutput.CmdError != nil {
+			log.Printf("\nUnable to extract the public key of %v\n", keyFile)
+			return shellError(shellOutput)
+		}
+		return nil
+	},
+})
+
+var taskImportRequest = gofer.Register(gofer.Task{
+	Namespace:   "Import",
+	Label:       "Request",
+	Description: "Regenerate the certificate request of an imported intermediate, for DR cross signing",
+	Action: func(arguments ...string) error {
+
+		certFile := arguments[0]
+		keyFile := arguments[1]
+		opensslPassinString := arguments[2]
+		outFile := arguments[3]
+
+		requestCmd := "openssl x509 -x509toreq -in " + shellQuote(certFile) + " -signkey " + shellQuote(keyFile) + " " + opensslPassinString + "-out " + shellQuote(outFile)
+		shellOutput := execute(requestCmd)
+		if shellOutput.CmdError != nil {
+			log.Printf("\nUnable to regenerate the certificate request of %v\n", certFile)
+			return shellError(shellOutput)
+		}
+		return nil
+	},
+})
+
+// ImportCA adopts an existing openssl CA into the vault. A self signed certificate becomes
+// the Root CA (A0) of a vault initialized without one, a certificate issued by the A0 or
+// by an A1 becomes an A1 or A2. The key must match the certificate, it is encrypted under
+// request.Passphrase, and the openssl database, serial, CRL number and CRL are taken over.
+func ImportCA(request ImportRequest) (*ImportedCA, error) {
+	log.Printf("\nImporting CA from %v\n", request.CertFile)
+	if err := RequireRootVault(); err != nil {
+		return nil, err
+	}
+	pkiPath, err := GetPkiPath()
+	if err != nil {
+		return nil, err
+	}
+	rootCertUID, err := GetRootUID()
+	if err != nil {
+		return nil, err
+	}
+	if len(request.Passphrase) < 6 {
+		return nil, errors.New("the passphrase of the imported CA must be at least 6 characters")
+	}
+	cert, err := ReadCertificate(request.CertFile)
+	if err != nil {
+		return nil, err
+	}
+	if !cert.BasicConstraintsValid || !cert.IsCA {
+		return nil, fmt.Errorf("%v is not a CA certificate", request.CertFile)
+	}
+	if now := time.Now(); now.After(cert.NotAfter) {
+		return nil, fmt.Errorf("%v expired on %v", request.CertFile, cert.NotAfter.UTC().Format(time.RFC3339))
+	}
+	if len(cert.Subject.Organization) == 0 || cert.Subject.CommonName == "" {
+		return nil, errors.New("the CA subject needs an organization (O) and a common name (CN)")
+	}
+	entries, err := readImportedIndex(request.IndexFile)
+	if err != nil {
+		return nil, err
+	}
+
+	if bytes.Equal(cert.RawIssuer, cert.RawSubject) && cert.CheckSignatureFrom(cert) == nil {
+		return importRootCA(pkiPath, rootCertUID, cert, entries, request)
+	}
+	return importIntermediateCA(pkiPath, rootCertUID, cert, entries, request)
+}
+
+func importRootCA(pkiPath string, rootCertUID string, cert *x509.Certificate, entries int, request ImportRequest) (*ImportedCA, error) {
+	rootDir := RootCADir(pkiPath, rootCertUID)
+	if dirExists(rootDir) {
+		return nil, errors.New("this vault already has a Root CA (A0), adopt a root into a vault initialized with privki init only")
+	}
+	if request.OID == "" {
+		request.OID = DefaultOID
+	}
+	if err := gofer.Perform("A0:Prepare", pkiPath, rootCertUID); err != nil {
+		return nil, err
+	}
+	if err := stageImportedCA(rootDir, "root-ca", cert, request); err != nil {
+		os.RemoveAll(rootDir)
+		return nil, err
+	}
+
+	// the vault settings follow the adopted root, like create A0 records them. They are
+	// saved last, an import that fails before leaves the settings of the vault alone.
+	settings := &vaultSettings{oid: request.OID, organization: cert.Subject.Organization[0], commonName: cert.Subject.CommonName}
+	if err := writeRootConfig(rootDir, settings); err != nil {
+		os.RemoveAll(rootDir)
+		return nil, err
+	}
+	if err := saveVaultSettings(settings); err != nil {
+		os.RemoveAll(rootDir)
+		return nil, err
+	}
+	if _, err := MigrateDatabase(); err != nil {
+		log.Warnf("Unable to create the certificate database, run privki db migrate: %v", err)
+	}
+	return &ImportedCA{Kind: "A0", Dir: rootDir, Subject: cert.Subject.String(), Entries: entries}, nil
+}
+
+// saveVaultSettings records the OID, organization and common name of the vault, the
+// previous settings are restored when one of them can't be saved
+func saveVaultSettings(settings *vaultSettings) error {
+	previous := map[string][]byte{}
+	for _, configFile := range []string{GetOidConfigFile(), GetOrgNameConfigFile(), GetOrgCommonNameConfigFile()} {
+		content, err := ioutil.ReadFile(configFile)
+		if err != nil && !os.IsNotExist(err) {
+			return err
+		}
+		previous[configFile] = content
+	}
+	err := SetCustomOid(settings.oid)
+	if err == nil {
+		err = SetOrganizationName(settings.organization)
+	}
+	if err == nil {
+		err = SetOrganizationCommonName(settings.commonName)
+	}
+	if err == nil {
+		return nil
+	}
+	for configFile, content := range previous {
+		if content == nil {
+			os.Remove(configFile)
+		} else if err := ioutil.WriteFile(configFile, content, 0644); err != nil {
+			log.Errorf("Unable to restore the vault setting %v: %v", configFile, err)
+		}
+	}
+	return err
+}
+
+func importIntermediateCA(pkiPath string, rootCertUID string, cert *x509.Certificate, entries int, request ImportRequest) (*ImportedCA, error) {
+	root, err := ReadCertificate(filepath.Join(RootCADir(pkiPath, rootCertUID), "root-ca.cert.pem"))
+	if err != nil {
+		return nil, fmt.Errorf("an intermediate is imported below the Root CA (A0) of the vault: %v", err)
+	}
+	imported := &ImportedCA{Kind: "A1", Subject: cert.Subject.String(), Entries: entries}
+	var parent *Intermediate
+	if bytes.Equal(cert.RawIssuer, root.RawSubject) && cert.CheckSignatureFrom(root) == nil {
+		// an A1 of the A0
+	} else {
+		intermediates, err := ListIntermediates(pkiPath, rootCertUID)
+		if err != nil {
+			return nil, err
+		}
+		for i := range intermediates {
+			if intermediates[i].Level != 1 {
+				continue
+			}
+			a1, err := ReadCertificate(filepath.Join(intermediates[i].Dir, "intermed-ca.cert.pem"))
+			if err != nil {
+				return nil, err
+			}
+			if bytes.Equal(cert.RawIssuer, a1.RawSubject) && cert.CheckSignatureFrom(a1) == nil {
+				parent = &intermediates[i]
+				imported.Kind = "A2"
+				break
+			}
+		}
+		if parent == nil {
+			return nil, fmt.Errorf("%v is not issued by the Root CA (A0) nor an A1 of this vault, import its root first", cert.Subject)
+		}
+	}
+
+	imported.ID = cert.NotBefore.UTC().Format("20060102150405Z")
+	if _, err := FindIntermediate(pkiPath, rootCertUID, imported.ID); err == nil {
+		return nil, fmt.Errorf("a CA with ID %v already exists in this vault", imported.ID)
+	}
+	marker := intermediateDirMarker
+	if parent != nil {
+		marker = issuingDirMarker
+	}
+	stagingDir := filepath.Join(pkiPath, rootCertUID+marker)
+	if dirExists(stagingDir) {
+		return nil, fmt.Errorf("a CA creation is in progress or was interrupted at %v", stagingDir)
+	}
+	defer os.RemoveAll(stagingDir)
+	for _, dir := range []string{"certreqs", "certs", "crl", "newcerts", "private"} {
+		if err := os.MkdirAll(filepath.Join(stagingDir, dir), 0700); err != nil {
+			return nil, err
+		}
+	}
+	if err := stageImportedCA(stagingDir, "intermed-ca", cert, request); err != nil {
+		return nil, err
+	}
+	settings, err := readVaultSettings()
+	if err != nil {
+		return nil, err
+	}
+	// like A1:BlankConfig, the CA keeps the template configuration with only the OID set
+	if err := writeIntermediateConfig(stagingDir); err != nil {
+		return nil, err
+	}
+	configFile := filepath.Join(stagingDir, "intermed-ca.cnf")
+	config, err := ioutil.ReadFile(configFile)
+	if err != nil {
+		return nil, err
+	}
+	config = []byte(strings.NewReplacer("#customOID", settings.oid, DefaultOID, settings.oid).Replace(string(config)))
+	if err := ioutil.WriteFile(configFile, config, 0644); err != nil {
+		return nil, err
+	}
+	if err := gofer.Perform("Import:Request", request.CertFile, filepath.Join(stagingDir, "private", "intermed-ca.key.pem"), opensslPassin(request.Passphrase), filepath.Join(stagingDir, "intermed-ca.req.pem")); err != nil {
+		return nil, err
+	}
+
+	intermediate := &Intermediate{ID: imported.ID, Dir: stagingDir, Level: 1}
+	if parent != nil {
+		intermediate.Level = 2
+		intermediate.Parent = parent.ID
+		intermediate.CrossSigned = parent.CrossSigned
+		if err := ioutil.WriteFile(filepath.Join(stagingDir, "parent"), []byte(parent.ID+"\n"), 0644); err != nil {
+			return nil, err
+		}
+	}
+	intermediate.NotBefore = cert.NotBefore
+	intermediate.NotAfter = cert.NotAfter
+	intermediate.PathLen = -1
+	if cert.MaxPathLen > 0 || cert.MaxPathLenZero {
+		intermediate.PathLen = cert.MaxPathLen
+	}
+	intermediate.NameRestrict = cert.PermittedDNSDomains
+	if parent == nil && DREnabled() {
+		if request.RootPassphrase == "" && request.DRPassphrase == "" {
+			log.Warnf("A1 %v is not cross signed by the DR Root CA, import it with the root passphrase to cross sign it", imported.ID)
+		} else if drPassphrase, err := resolveDRPassphrase(request.RootPassphrase, request.DRPassphrase); err != nil {
+			return nil, err
+		} else if err := CrossSignIntermediate(pkiPath, rootCertUID, intermediate, drPassphrase); err != nil {
+			return nil, err
+		}
+	}
+	imported.CrossSigned = intermediate.CrossSigned
+
+	bundles := map[bool]string{false: "intermed-ca-chain-bundle.cert.pem", true: "intermed-ca-chain-bundle.dr.cert.pem"}
+	for dr, bundleName := range bundles {
+		if dr && !intermediate.CrossSigned {
+			continue
+		}
+		chain, err := IntermediateChain(pkiPath, rootCertUID, intermediate, dr)
+		if err != nil {
+			return nil, err
+		}
+		if err := ioutil.WriteFile(filepath.Join(stagingDir, bundleName), chain, 0644); err != nil {
+			return nil, err
+		}
+	}
+
+	imported.Dir = filepath.Join(pkiPath, rootCertUID+marker+"-"+imported.ID)
+	if err := os.Rename(stagingDir, imported.Dir); err != nil {
+		return nil, err
+	}
+	syncDatabase(imported.Dir)
+	return imported, nil
+}
+
+// stageImportedCA writes the certificate, encrypted key and openssl state of an imported
+// CA into caDir, named after name (root-ca or intermed-ca)
+func stageImportedCA(caDir string, name string, cert *x509.Certificate, request ImportRequest) error {
+	certBytes, err := ioutil.ReadFile(request.CertFile)
+	if err != nil {
+		return err
+	}
+	if err := ioutil.WriteFile(filepath.Join(caDir, name+".cert.pem"), certBytes, 0644); err != nil {
+		return err
+	}
+	keyFile := filepath.Join(caDir, "private", name+".key.pem")
+	if err := gofer.Perform("Import:Key", request.KeyFile, opensslPassin(request.KeyPassphrase), opensslPassout(request.Passphrase), keyFile); err != nil {
+		return err
+	}
+	publicKeyFile := filepath.Join(caDir, "private", name+".pub.pem")
+	defer os.Remove(publicKeyFile)
+	if err := gofer.Perform("Import:PublicKey", keyFile, opensslPassin(request.Passphrase), publicKeyFile); err != nil {
+		return err
+	}
+	publicKeyPEM, err := ioutil.ReadFile(publicKeyFile)
+	if err != nil {
+		return err
+	}
+	block, _ := pem.Decode(publicKeyPEM)
+	if block == nil || !bytes.Equal(block.Bytes, cert.RawSubjectPublicKeyInfo) {
+		return fmt.Errorf("the key %v does not match the certificate %v", request.KeyFile, request.CertFile)
+	}
+
+	index := []byte{}
+	if request.IndexFile != "" {
+		if index, err = ioutil.ReadFile(request.IndexFile); err != nil {
+			return err
+		}
+	}
+	state := map[string][]byte{
+		name + ".index":      index,
+		name + ".index.attr": []byte("unique_subject = no\n"),
+	}
+	if state[name+".serial"], err = importedSerial(request, index); err != nil {
+		return err
+	}
+	if request.CRLFile != "" {
+		crlPEM, crlNumber, err := importedCRL(request.CRLFile, cert)
+		if err != nil {
+			return err
+		}
+		state[filepath.Join("crl", name+".crl")] = crlPEM
+		state[name+".crlnum"] = []byte(SerialHex(crlNumber.Add(crlNumber, big.NewInt(1))) + "\n")
+	}
+	if request.CRLNumberFile != "" {
+		if state[name+".crlnum"], err = ioutil.ReadFile(request.CRLNumberFile); err != nil {
+			return err
+		}
+	}
+	if state[name+".crlnum"] == nil {
+		state[name+".crlnum"] = []byte("00\n")
+	}
+	for file, content := range state {
+		if err := ioutil.WriteFile(filepath.Join(caDir, file), content, 0644); err != nil {
+			return err
+		}
+	}
+
+	if request.NewcertsDir == "" {
+		return nil
+	}
+	issued, err := filepath.Glob(filepath.Join(request.NewcertsDir, "*.pem"))
+	if err != nil {
+		return err
+	}
+	for _, issuedFile := range issued {
+		issuedBytes, err := ioutil.ReadFile(issuedFile)
+		if err != nil {
+			return err
+		}
+		if err := ioutil.WriteFile(filepath.Join(caDir, "newcerts", strings.ToUpper(strings.TrimSuffix(filepath.Base(issuedFile), ".pem"))+".pem"), issuedBytes, 0644); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// readImportedIndex checks every line of an openssl ca database and returns the number of entries
+func readImportedIndex(indexFile string) (int, error) {
+	if indexFile == "" {
+		return 0, nil
+	}
+	file, err := os.Open(indexFile)
+	if err != nil {
+		return 0, err
+	}
+	defer file.Close()
+
+	entries := 0
+	scanner := bufio.NewScanner(file)
+	for line := 1; scanner.Scan(); line++ {
+		if scanner.Text() == "" {
+			continue
+		}
+		fields := strings.Split(scanner.Text(), "\t")
+		if len(fields) < 6 || !strings.Contains("VRE", fields[0]) || len(fields[0]) != 1 || !hexSerialPattern.MatchString(fields[3]) {
+			return 0, fmt.Errorf("%v line %d is not an openssl ca database entry", indexFile, line)
+		}
+		entries++
+	}
+	return entries, scanner.Err()
+}
+
+// importedSerial returns the serial file content, the one given or the next serial after the index
+func importedSerial(request ImportRequest, index []byte) ([]byte, error) {
+	if request.SerialFile != "" {
+		return ioutil.ReadFile(request.SerialFile)
+	}
+	next := new(big.Int)
+	for _, line := range strings.Split(string(index), "\n") {
+		fields := strings.Split(line, "\t")
+		if len(fields) < 6 {
+			continue
+		}
+		serial, ok := new(big.Int).SetString(fields[3], 16)
+		if ok && serial.Cmp(next) >= 0 {
+			next.Add(serial, big.NewInt(1))
+		}
+	}
+	if next.Sign() == 0 {
+		// like A0:Prepare, a random 128 bit serial to start with
+		random := make([]byte, 16)
+		if _, err := rand.Read(random); err != nil {
+			return nil, err
+		}
+		next.SetBytes(random)
+	}
+	return []byte(SerialHex(next) + "\n"), nil
+}
+
+// importedCRL checks a PEM or DER CRL is signed by the CA and returns it as PEM with its number
+func importedCRL(crlFile string, cert *x509.Certificate) ([]byte, *big.Int, error) {
+	crlBytes, err := ioutil.ReadFile(crlFile)
+	if err != nil {
+		return nil, nil, err
+	}
+	crl, err := x509.ParseCRL(crlBytes)
+	if err != nil {
+		return nil, nil, fmt.Errorf("%v: %v", crlFile, err)
+	}
+	if err := cert.CheckCRLSignature(crl); err != nil {
+		return nil, nil, fmt.Errorf("%v is not signed by the imported CA: %v", crlFile, err)
+	}
+	crlNumber := new(big.Int)
+	for _, extension := range crl.TBSCertList.Extensions {
+		if extension.Id.Equal(oidCRLNumber) {
+			if _, err := asn1.Unmarshal(extension.Value, &crlNumber); err != nil {
+				return nil, nil, fmt.Errorf("%v CRL number: %v", crlFile, err)
+			}
+		}
+	}
+	if block, _ := pem.Decode(crlBytes); block != nil {
+		crlBytes = block.Bytes
+	}
+	return pem.EncodeToMemory(&pem.Block{Type: "X509 CRL", Bytes: crlBytes}), crlNumber, nil
+}
@@ -0,0 +1,352 @@
+package openssl
+
+import (
+	"bufio"
+	"crypto/ecdsa"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"math/big"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+// ErrUnknownCA is returned when a CA identifier does not match any CA in the vault
+var ErrUnknownCA = errors.New("no such certifying authority in this vault")
+
+// ErrUnknownCertificate is returned when a serial is not present in a CA database
+var ErrUnknownCertificate = errors.New("no such certificate in this certifying authority")
+
+// ErrExternalKey is returned when an A1 was signed from a partner CSR and its key is held outside the vault
+var ErrExternalKey = errors.New("the key of this intermediary CA (A1) is held outside the vault, issue from the partner's own CA")
+
+// Intermediate describes an Intermediary CA (A1), or an issuing CA (A2) signed by an A1, found in the vault
+type Intermediate struct {
+	ID           string    `json:"id"`
+	Dir          string    `json:"-"`
+	Subject      string    `json:"subject"`
+	Organization string    `json:"organization"`
+	Serial       string    `json:"serial"`
+	NotBefore    time.Time `json:"not_before"`
+	NotAfter     time.Time `json:"not_after"`
+	NameRestrict []string  `json:"name_restrictions,omitempty"`
+	CrossSigned  bool      `json:"dr_cross_signed"`
+	// KeyAlgorithm is rsa:<bits> or ec:<curve>
+	KeyAlgorithm string `json:"key_algorithm"`
+	// ExternalKey is set for A1s signed from a partner CSR, their key is not in the vault
+	ExternalKey bool `json:"external_key,omitempty"`
+	// Level is 1 for an A1 and 2 for an A2, Parent is the ID of the A1 that signed an A2
+	Level  int    `json:"level"`
+	Parent string `json:"parent,omitempty"`
+	// PathLen is the pathLenConstraint of the CA certificate, -1 when it is not limited
+	PathLen int `json:"path_len"`
+}
+
+// IndexEntry is a single line of an openssl ca database (index) file
+type IndexEntry struct {
+	Status           string     `json:"status"`
+	Expiry           time.Time  `json:"not_after"`
+	Revoked          *time.Time `json:"revoked_at,omitempty"`
+	RevocationReason string     `json:"revocation_reason,omitempty"`
+	// HoldInstruction is set for certificates on hold (certificateHold)
+	HoldInstruction string `json:"hold_instruction,omitempty"`
+	Serial          string `json:"serial"`
+	Subject         string `json:"subject"`
+}
+
+// CertificateInfo is a JSON friendly summary of an x509 certificate
+type CertificateInfo struct {
+	Subject      string    `json:"subject"`
+	Issuer       string    `json:"issuer"`
+	Serial       string    `json:"serial"`
+	NotBefore    time.Time `json:"not_before"`
+	NotAfter     time.Time `json:"not_after"`
+	IsCA         bool      `json:"is_ca"`
+	DNSNames     []string  `json:"dns_names,omitempty"`
+	IPAddresses  []string  `json:"ip_addresses,omitempty"`
+	EmailAddress []string  `json:"email_addresses,omitempty"`
+	NameRestrict []string  `json:"name_restrictions,omitempty"`
+	Status       string    `json:"status,omitempty"`
+	PEM          string    `json:"pem"`
+}
+
+const intermediateDirMarker = "-intermed-ca"
+
+// externalKeyFile marks the dir of an A1 signed from a partner CSR, whose key is held outside the vault
+const externalKeyFile = "external_key"
+
+// issuingDirMarker names the directories of the issuing CAs (A2), like intermediateDirMarker
+const issuingDirMarker = "-issuing-ca"
+
+// Get the directory of the Root CA (A0) inside a PKI repository
+func RootCADir(pkiPath string, rootCertUID string) string {
+	return pkiPath + "/" + rootCertUID + "-root-ca"
+}
+
+// Get the directory of the DR Root CA (DR A0) inside a PKI repository
+func DRRootCADir(pkiPath string, rootCertUID string) string {
+	return pkiPath + "/" + rootCertUID + "-dr-root-ca"
+}
+
+// ListIntermediates scans the PKI repository for Intermediary CAs (A1) and issuing CAs (A2),
+// the ID of each CA is the creation timestamp suffix of its directory.
+func ListIntermediates(pkiPath string, rootCertUID string) ([]Intermediate, error) {
+	entries, err := ioutil.ReadDir(pkiPath)
+	if err != nil {
+		return nil, err
+	}
+
+	var intermediates []Intermediate
+	for _, entry := range entries {
+		level := 0
+		prefix := ""
+		for markerLevel, marker := range []string{intermediateDirMarker, issuingDirMarker} {
+			if strings.HasPrefix(entry.Name(), rootCertUID+marker) {
+				level, prefix = markerLevel+1, rootCertUID+marker
+			}
+		}
+		if !entry.IsDir() || level == 0 {
+			continue
+		}
+		id := strings.TrimPrefix(strings.TrimPrefix(entry.Name(), prefix), "-")
+		if id == "" {
+			// a CA still being created, it gets its ID once signed
+			continue
+		}
+		dir := filepath.Join(pkiPath, entry.Name())
+		cert, err := ReadCertificate(filepath.Join(dir, "intermed-ca.cert.pem"))
+		if err != nil {
+			// half built CA without a signed certificate
+			continue
+		}
+		pathLen := cert.MaxPathLen
+		if pathLen == 0 && !cert.MaxPathLenZero {
+			pathLen = -1
+		}
+		intermediate := Intermediate{
+			ID:           id,
+			Dir:          dir,
+			Subject:      cert.Subject.String(),
+			Organization: strings.Join(cert.Subject.Organization, ","),
+			Serial:       SerialHex(cert.SerialNumber),
+			NotBefore:    cert.NotBefore,
+			NotAfter:     cert.NotAfter,
+			NameRestrict: cert.PermittedDNSDomains,
+			CrossSigned:  fileExists(filepath.Join(dir, "intermed-ca.dr.cert.pem")),
+			ExternalKey:  fileExists(filepath.Join(dir, externalKeyFile)),
+			KeyAlgorithm: keyAlgorithm(cert),
+			Level:        level,
+			PathLen:      pathLen,
+		}
+		if level == 2 {
+			parent, err := ioutil.ReadFile(filepath.Join(dir, "parent"))
+			if err != nil {
+				continue
+			}
+			intermediate.Parent = strings.TrimSpace(string(parent))
+			// an A2 reaches the DR Root CA through the cross signed certificate of its A1
+			intermediate.CrossSigned = fileExists(filepath.Join(pkiPath, rootCertUID+intermediateDirMarker+"-"+intermediate.Parent, "intermed-ca.dr.cert.pem"))
+		}
+		intermediates = append(intermediates, intermediate)
+	}
+	sort.Slice(intermediates, func(i, j int) bool { return intermediates[i].ID < intermediates[j].ID })
+	return intermediates, nil
+}
+
+// FindIntermediate looks up an Intermediary CA (A1) by its ID
+func FindIntermediate(pkiPath string, rootCertUID string, id string) (*Intermediate, error) {
+	intermediates, err := ListIntermediates(pkiPath, rootCertUID)
+	if err != nil {
+		return nil, err
+	}
+	for i := range intermediates {
+		if intermediates[i].ID == id {
+			return &intermediates[i], nil
+		}
+	}
+	return nil, ErrUnknownCA
+}
+
+// keyAlgorithm names the key of a certificate the way A1 key algorithms are given
+func keyAlgorithm(cert *x509.Certificate) string {
+	switch publicKey := cert.PublicKey.(type) {
+	case *rsa.PublicKey:
+		return fmt.Sprintf("rsa:%d", publicKey.N.BitLen())
+	case *ecdsa.PublicKey:
+		return "ec:" + publicKey.Curve.Params().Name
+	}
+	return strings.ToLower(cert.PublicKeyAlgorithm.String())
+}
+
+// ReadCertificate reads the first PEM encoded certificate from a file
+func ReadCertificate(path string) (*x509.Certificate, error) {
+	certs, err := ReadCertificates(path)
+	if err != nil {
+		return nil, err
+	}
+	return certs[0], nil
+}
+
+// ReadCertificates reads every PEM encoded certificate from a file,
+// skipping over any other PEM blocks such as keys.
+func ReadCertificates(path string) ([]*x509.Certificate, error) {
+	pemBytes, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	return ParseCertificates(pemBytes)
+}
+
+// ParseCertificates decodes every PEM certificate block in pemBytes
+func ParseCertificates(pemBytes []byte) ([]*x509.Certificate, error) {
+	var certs []*x509.Certificate
+	for {
+		var block *pem.Block
+		block, pemBytes = pem.Decode(pemBytes)
+		if block == nil {
+			break
+		}
+		if block.Type != "CERTIFICATE" {
+			continue
+		}
+		cert, err := x509.ParseCertificate(block.Bytes)
+		if err != nil {
+			return nil, err
+		}
+		certs = append(certs, cert)
+	}
+	if len(certs) == 0 {
+		return nil, errors.New("no PEM certificate found")
+	}
+	return certs, nil
+}
+
+// DescribeCertificate converts a certificate into its JSON friendly summary
+func DescribeCertificate(cert *x509.Certificate) *CertificateInfo {
+	info := &CertificateInfo{
+		Subject:      cert.Subject.String(),
+		Issuer:       cert.Issuer.String(),
+		Serial:       SerialHex(cert.SerialNumber),
+		NotBefore:    cert.NotBefore,
+		NotAfter:     cert.NotAfter,
+		IsCA:         cert.IsCA,
+		DNSNames:     cert.DNSNames,
+		EmailAddress: cert.EmailAddresses,
+		NameRestrict: cert.PermittedDNSDomains,
+		PEM:          string(pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: cert.Raw})),
+	}
+	for _, ip := range cert.IPAddresses {
+		info.IPAddresses = append(info.IPAddresses, ip.String())
+	}
+	return info
+}
+
+// ReadIndex parses an openssl ca database file into its entries
+func ReadIndex(indexPath string) ([]IndexEntry, error) {
+	indexFile, err := os.Open(indexPath)
+	if err != nil {
+		return nil, err
+	}
+	defer indexFile.Close()
+
+	var entries []IndexEntry
+	scanner := bufio.NewScanner(indexFile)
+	for scanner.Scan() {
+		fields := strings.Split(scanner.Text(), "\t")
+		if len(fields) < 6 {
+			continue
+		}
+		entries = append(entries, parseIndexFields(fields))
+	}
+	return entries, scanner.Err()
+}
+
+// parseIndexFields reads the tab separated fields of an openssl database line
+func parseIndexFields(fields []string) IndexEntry {
+	entry := IndexEntry{
+		Status:  fields[0],
+		Expiry:  parseIndexTime(fields[1]),
+		Serial:  strings.ToUpper(fields[3]),
+		Subject: fields[5],
+	}
+	if fields[2] != "" {
+		revocation := strings.SplitN(fields[2], ",", 3)
+		revoked := parseIndexTime(revocation[0])
+		entry.Revoked = &revoked
+		if len(revocation) > 1 {
+			entry.RevocationReason = revocation[1]
+		}
+		// openssl ca records a hold as holdInstruction,<instruction code>
+		if entry.RevocationReason == "holdInstruction" {
+			entry.RevocationReason = holdReason
+		}
+		if len(revocation) > 2 {
+			entry.HoldInstruction = revocation[2]
+		}
+	}
+	return entry
+}
+
+// FindIndexEntry returns the database entry for serial inside a CA directory
+func FindIndexEntry(caDir string, serial string) (*IndexEntry, error) {
+	entries, err := CertificateEntries(caDir)
+	if err != nil {
+		return nil, err
+	}
+	serial = strings.ToUpper(serial)
+	for i := range entries {
+		if entries[i].Serial == serial {
+			return &entries[i], nil
+		}
+	}
+	return nil, ErrUnknownCertificate
+}
+
+// InspectIssued returns the certificate and database status of a certificate issued by an A1
+func InspectIssued(caDir string, serial string) (*CertificateInfo, error) {
+	entry, err := FindIndexEntry(caDir, serial)
+	if err != nil {
+		return nil, err
+	}
+	cert, err := ReadCertificate(filepath.Join(caDir, "newcerts", entry.Serial+".pem"))
+	if err != nil {
+		return nil, err
+	}
+	info := DescribeCertificate(cert)
+	info.Status = entry.Status
+	return info, nil
+}
+
+// SerialHex formats a certificate serial the way openssl names it in
+// its database and newcerts directory, upper case and of even length.
+func SerialHex(serial *big.Int) string {
+	hexSerial := fmt.Sprintf("%X", serial)
+	if len(hexSerial)%2 == 1 {
+		hexSerial = "0" + hexSerial
+	}
+	return hexSerial
+}
+
+// caIndexFile returns the openssl database file of a CA directory,
+// root CAs and intermediaries use different file names.
+func caIndexFile(caDir string) string {
+	if fileExists(filepath.Join(caDir, "intermed-ca.index")) {
+		return filepath.Join(caDir, "intermed-ca.index")
+	}
+	return filepath.Join(caDir, "root-ca.index")
+}
+
+// openssl writes dates as UTCTime (YYMMDDHHMMSSZ) or GeneralizedTime in its database
+func parseIndexTime(value string) time.Time {
+	if t, err := time.Parse("060102150405Z", value); err == nil {
+		return t
+	}
+	t, _ := time.Parse("20060102150405Z", value)
+	return t
+}
@@ -0,0 +1,468 @@
+package openssl
+
+import (
+	"errors"
+	"fmt"
+	"github.com/chuckpreslar/gofer"
+	log "github.com/sirupsen/logrus"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sfcert/shell"
+	"strconv"
+	"strings"
+)
+
+// IssueRequest describes a leaf certificate to be issued by an Intermediary CA (A1)
+type IssueRequest struct {
+	CommonName         string   `json:"common_name"`
+	Organization       string   `json:"organization,omitempty"`
+	OrganizationalUnit string   `json:"organizational_unit,omitempty"`
+	DNSNames           []string `json:"dns_names,omitempty"`
+	IPAddresses        []string `json:"ip_addresses,omitempty"`
+	Profile            string   `json:"profile,omitempty"`
+	Days               int      `json:"days,omitempty"`
+}
+
+// IssuedCertificate is the result of an issuance or CSR signing operation
+type IssuedCertificate struct {
+	Serial         string `json:"serial"`
+	CertificatePEM string `json:"certificate"`
+	PrivateKeyPEM  string `json:"private_key,omitempty"`
+}
+
+// certificate profiles map onto the extension sections of intermed-ca.cnf
+var certificateProfiles = map[string]string{
+	"server": "server_ext",
+	"client": "client_ext",
+	"user":   "user_ext",
+}
+
+// revocation reasons accepted by openssl ca -crl_reason
+var revocationReasons = map[string]bool{
+	"unspecified":          true,
+	"keyCompromise":        true,
+	"CACompromise":         true,
+	"affiliationChanged":   true,
+	"superseded":           true,
+	"cessationOfOperation": true,
+	holdReason:             true,
+}
+
+// holdReason suspends a certificate until it is released with ReleaseCertificate
+const holdReason = "certificateHold"
+
+// holdInstruction is the hold instruction code of the CRL entries of held certificates
+const holdInstruction = "holdInstructionReject"
+
+// RevocationRequest describes a change of status of a certificate issued by a CA
+type RevocationRequest struct {
+	Serial string
+	Reason string
+	// Comment says why, it is required to put a certificate on hold or release it
+	Comment string
+	// Actor is recorded in the audit trail, the local user and host by default
+	Actor string
+}
+
+const defaultLeafDays = 365
+
+// Leaf certificate related task definitions
+var taskLeafGenerateRequest = gofer.Register(gofer.Task{
+	Namespace:   "Leaf",
+	Label:       "Request",
+	Description: "Generate a private key and CSR for a leaf certificate",
+	Action: func(arguments ...string) error {
+
+		workDir := arguments[0]
+		subject := arguments[1]
+		subjectAltNames := arguments[2]
+
+		opensslReqCmd := "cd " + shellQuote(workDir) + " && openssl req -new -newkey rsa:2048 -nodes -keyout leaf.key.pem -out leaf.req.pem -subj " + shellQuote(subject)
+		if subjectAltNames != "NA" {
+			opensslReqCmd += " -addext " + shellQuote("subjectAltName="+subjectAltNames)
+		}
+		shellOutput := execute(opensslReqCmd)
+		if shellOutput.CmdError != nil {
+			log.Printf("\nUnable to generate leaf key and request in %v\n", workDir)
+			return shellError(shellOutput)
+		}
+		return nil
+	},
+})
+
+var taskLeafSign = gofer.Register(gofer.Task{
+	Namespace:   "Leaf",
+	Label:       "Sign",
+	Description: "Sign a leaf certificate request with an Intermediary CA (A1)",
+	Action: func(arguments ...string) error {
+
+		caDir := arguments[0]
+		requestFile := arguments[1]
+		extensions := arguments[2]
+		days := arguments[3]
+		opensslPassinString := arguments[4]
+		certificateFile := arguments[5]
+
+		signLeafCmd := "cd " + shellQuote(caDir) + " && export OPENSSL_CONF=./" + caConfigName(caDir) + " && openssl ca " + opensslPassinString + "-in " + shellQuote(requestFile) + " -out " + shellQuote(certificateFile) + " -extensions " + extensions + " -days " + days + " -notext -batch"
+		shellOutput := execute(signLeafCmd)
+		if shellOutput.CmdError != nil {
+			log.Printf("\nUnable to sign certificate request with CA at %v, is this the right passphrase?\n", caDir)
+			return shellError(shellOutput)
+		}
+		return nil
+	},
+})
+
+var taskLeafRevoke = gofer.Register(gofer.Task{
+	Namespace:   "Leaf",
+	Label:       "Revoke",
+	Description: "Revoke a certificate issued by a CA",
+	Action: func(arguments ...string) error {
+
+		caDir := arguments[0]
+		certificateFile := arguments[1]
+		reason := arguments[2]
+		opensslPassinString := arguments[3]
+
+		crlReason := "-crl_reason " + reason
+		if reason == holdReason {
+			crlReason += " -crl_hold " + holdInstruction
+		}
+		revokeCmd := "cd " + shellQuote(caDir) + " && export OPENSSL_CONF=./" + caConfigName(caDir) + " && openssl ca " + opensslPassinString + "-revoke " + shellQuote(certificateFile) + " " + crlReason + " -batch"
+		shellOutput := execute(revokeCmd)
+		if shellOutput.CmdError != nil {
+			log.Printf("\nUnable to revoke %v with CA at %v\n", certificateFile, caDir)
+			return shellError(shellOutput)
+		}
+		return nil
+	},
+})
+
+var taskCAGenerateCRL = gofer.Register(gofer.Task{
+	Namespace:   "CA",
+	Label:       "GenCRL",
+	Description: "Generate the Certificate Revocation List of a CA",
+	Action: func(arguments ...string) error {
+
+		caDir := arguments[0]
+		opensslPassinString := arguments[1]
+		crlPeriod := arguments[2]
+
+		crlFile := "crl/" + strings.TrimSuffix(caConfigName(caDir), ".cnf") + ".crl"
+		genCRLCmd := "cd " + shellQuote(caDir) + " && export OPENSSL_CONF=./" + caConfigName(caDir) + " && openssl ca " + opensslPassinString + "-gencrl " + crlPeriod + "-out " + crlFile + ".tmp -batch && mv " + crlFile + ".tmp " + crlFile
+		shellOutput := execute(genCRLCmd)
+		if shellOutput.CmdError != nil {
+			log.Printf("\nRevocation List generation error for CA at %v\n", caDir)
+			return shellError(shellOutput)
+		}
+		return nil
+	},
+})
+
+// IssueCertificate generates a new key pair and a certificate signed by the CA at caDir.
+// The private key is returned to the caller and is not retained in the vault.
+func IssueCertificate(caDir string, request IssueRequest, passphrase string) (*IssuedCertificate, error) {
+	if request.CommonName == "" {
+		return nil, errors.New("a common name is required")
+	}
+	extensions, profile, err := resolveProfile(filepath.Dir(caDir), request.Profile)
+	if err != nil {
+		return nil, err
+	}
+	if profile != nil {
+		if request.Days == 0 {
+			request.Days = profile.Days
+		}
+		if request.Organization == "" {
+			request.Organization = profile.Organization
+		}
+		if request.OrganizationalUnit == "" {
+			request.OrganizationalUnit = profile.OrganizationalUnit
+		}
+	}
+
+	workDir, err := ioutil.TempDir("", "privki-leaf")
+	if err != nil {
+		return nil, err
+	}
+	defer os.RemoveAll(workDir)
+
+	subject := "/CN=" + escapeSubject(request.CommonName)
+	if request.OrganizationalUnit != "" {
+		subject = "/OU=" + escapeSubject(request.OrganizationalUnit) + subject
+	}
+	if request.Organization != "" {
+		subject = "/O=" + escapeSubject(request.Organization) + subject
+	}
+	var subjectAltNames []string
+	for _, dnsName := range request.DNSNames {
+		subjectAltNames = append(subjectAltNames, "DNS:"+dnsName)
+	}
+	for _, ipAddress := range request.IPAddresses {
+		subjectAltNames = append(subjectAltNames, "IP:"+ipAddress)
+	}
+	altNames := "NA"
+	if len(subjectAltNames) > 0 {
+		altNames = strings.Join(subjectAltNames, ",")
+	}
+
+	if err := gofer.Perform("Leaf:Request", workDir, subject, altNames); err != nil {
+		return nil, err
+	}
+	issued, err := signRequest(caDir, filepath.Join(workDir, "leaf.req.pem"), extensions, request.Days, passphrase, workDir)
+	if err != nil {
+		return nil, err
+	}
+	keyBytes, err := ioutil.ReadFile(filepath.Join(workDir, "leaf.key.pem"))
+	if err != nil {
+		return nil, err
+	}
+	issued.PrivateKeyPEM = string(keyBytes)
+	return issued, nil
+}
+
+// SignCertificateRequest signs a PEM encoded CSR with the CA at caDir
+func SignCertificateRequest(caDir string, csrPEM []byte, profile string, days int, passphrase string) (*IssuedCertificate, error) {
+	extensions, namedProfile, err := resolveProfile(filepath.Dir(caDir), profile)
+	if err != nil {
+		return nil, err
+	}
+	if namedProfile != nil && days == 0 {
+		days = namedProfile.Days
+	}
+	workDir, err := ioutil.TempDir("", "privki-csr")
+	if err != nil {
+		return nil, err
+	}
+	defer os.RemoveAll(workDir)
+
+	requestFile := filepath.Join(workDir, "leaf.req.pem")
+	if err := ioutil.WriteFile(requestFile, csrPEM, 0600); err != nil {
+		return nil, err
+	}
+	return signRequest(caDir, requestFile, extensions, days, passphrase, workDir)
+}
+
+// RevokeCertificate revokes a certificate by serial and regenerates the CA's CRL. The
+// certificateHold reason puts it on hold instead, a held certificate can still be revoked
+// for good with another reason. Both are recorded in the audit trail.
+func RevokeCertificate(caDir string, request RevocationRequest, passphrase string) error {
+	reason := request.Reason
+	if reason == "" {
+		reason = "unspecified"
+	}
+	if !revocationReasons[reason] {
+		return fmt.Errorf("unsupported revocation reason %q", reason)
+	}
+	if reason == holdReason && request.Comment == "" {
+		return errors.New("a comment is required to put a certificate on hold")
+	}
+	entry, err := FindIndexEntry(caDir, request.Serial)
+	if err != nil {
+		return err
+	}
+	var heldIndex []byte
+	if entry.Status == "R" {
+		if entry.RevocationReason != holdReason {
+			return fmt.Errorf("certificate %v is already revoked", entry.Serial)
+		}
+		if reason == holdReason {
+			return fmt.Errorf("certificate %v is already on hold", entry.Serial)
+		}
+		// openssl only revokes valid certificates, the hold is released first
+		if heldIndex, err = releaseIndexEntry(caDir, entry.Serial); err != nil {
+			return err
+		}
+	}
+
+	certificateFile := filepath.Join(caDir, "newcerts", entry.Serial+".pem")
+	if err := gofer.Perform("Leaf:Revoke", caDir, certificateFile, reason, opensslPassin(passphrase)); err != nil {
+		if heldIndex != nil {
+			restoreIndex(caDir, heldIndex)
+		}
+		return err
+	}
+	syncDatabase(caDir)
+	if err := GenerateCRL(caDir, passphrase); err != nil {
+		return err
+	}
+	action := "revoke"
+	if reason == holdReason {
+		action = "hold"
+	}
+	return auditStatusChange(caDir, AuditEntry{Action: action, Serial: entry.Serial, Reason: reason, Comment: request.Comment, Actor: request.Actor})
+}
+
+// ReleaseCertificate takes a certificate off hold, it is valid again and leaves the CRL
+func ReleaseCertificate(caDir string, request RevocationRequest, passphrase string) error {
+	if request.Comment == "" {
+		return errors.New("a comment is required to release a certificate from hold")
+	}
+	entry, err := FindIndexEntry(caDir, request.Serial)
+	if err != nil {
+		return err
+	}
+	if entry.Status != "R" || entry.RevocationReason != holdReason {
+		return fmt.Errorf("certificate %v is not on hold", entry.Serial)
+	}
+	heldIndex, err := releaseIndexEntry(caDir, entry.Serial)
+	if err != nil {
+		return err
+	}
+	if err := GenerateCRL(caDir, passphrase); err != nil {
+		// the CRL still lists the certificate, so it stays on hold
+		restoreIndex(caDir, heldIndex)
+		return err
+	}
+	return auditStatusChange(caDir, AuditEntry{Action: "unhold", Serial: entry.Serial, Comment: request.Comment, Actor: request.Actor})
+}
+
+// releaseIndexEntry marks a held certificate valid in the index of the CA, and returns the former index
+func releaseIndexEntry(caDir string, serial string) ([]byte, error) {
+	indexFile := filepath.Join(caDir, strings.TrimSuffix(caConfigName(caDir), ".cnf")+".index")
+	index, err := ioutil.ReadFile(indexFile)
+	if err != nil {
+		return nil, err
+	}
+	lines := strings.Split(string(index), "\n")
+	for i, line := range lines {
+		fields := strings.Split(line, "\t")
+		if len(fields) < 6 || !strings.EqualFold(fields[3], serial) {
+			continue
+		}
+		fields[0] = "V"
+		fields[2] = ""
+		lines[i] = strings.Join(fields, "\t")
+	}
+	if err := ioutil.WriteFile(indexFile+".tmp", []byte(strings.Join(lines, "\n")), 0644); err != nil {
+		return nil, err
+	}
+	if err := os.Rename(indexFile+".tmp", indexFile); err != nil {
+		return nil, err
+	}
+	syncDatabase(caDir)
+	return index, nil
+}
+
+func restoreIndex(caDir string, index []byte) {
+	indexFile := filepath.Join(caDir, strings.TrimSuffix(caConfigName(caDir), ".cnf")+".index")
+	if err := ioutil.WriteFile(indexFile, index, 0644); err != nil {
+		log.Errorf("Unable to restore %v, the held certificate must be revoked again: %v", indexFile, err)
+		return
+	}
+	syncDatabase(caDir)
+}
+
+func auditStatusChange(caDir string, entry AuditEntry) error {
+	if err := recordAudit(caDir, entry); err != nil {
+		return fmt.Errorf("certificate %v changed status but the audit trail could not be written: %v", entry.Serial, err)
+	}
+	return nil
+}
+
+// GenerateCRL regenerates the Certificate Revocation List of the CA at caDir,
+// valid for the default_crl_days of its configuration
+func GenerateCRL(caDir string, passphrase string) error {
+	if err := gofer.Perform("CA:GenCRL", caDir, opensslPassin(passphrase), ""); err != nil {
+		return err
+	}
+	removeDeltaCRL(caDir)
+	syncDatabase(caDir)
+	return nil
+}
+
+// ReadCRL returns the current PEM encoded CRL of the CA at caDir
+func ReadCRL(caDir string) ([]byte, error) {
+	return ioutil.ReadFile(filepath.Join(caDir, "crl", strings.TrimSuffix(caConfigName(caDir), ".cnf")+".crl"))
+}
+
+// IntermediateChain returns the PEM chain of an A1 or A2 up to the Root CA,
+// or up to the DR Root CA through the A1's cross signed certificate.
+func IntermediateChain(pkiPath string, rootCertUID string, intermediate *Intermediate, dr bool) ([]byte, error) {
+	chainFiles, err := ChainFiles(pkiPath, rootCertUID, intermediate, dr)
+	if err != nil {
+		return nil, err
+	}
+
+	var chain []byte
+	for _, certFile := range chainFiles {
+		certBytes, err := ioutil.ReadFile(certFile)
+		if err != nil {
+			return nil, err
+		}
+		chain = append(chain, certBytes...)
+	}
+	return chain, nil
+}
+
+// ChainFiles returns the certificate files of the chain of an A1 or A2, starting with its
+// own certificate and ending with the Root CA, or the DR Root CA when dr is set.
+func ChainFiles(pkiPath string, rootCertUID string, intermediate *Intermediate, dr bool) ([]string, error) {
+	if dr && !intermediate.CrossSigned {
+		return nil, errors.New("intermediate is not cross signed by a DR Root CA")
+	}
+	if intermediate.Parent != "" {
+		parent, err := FindIntermediate(pkiPath, rootCertUID, intermediate.Parent)
+		if err != nil {
+			return nil, fmt.Errorf("parent A1 %v of %v: %v", intermediate.Parent, intermediate.ID, err)
+		}
+		parentFiles, err := ChainFiles(pkiPath, rootCertUID, parent, dr)
+		if err != nil {
+			return nil, err
+		}
+		return append([]string{filepath.Join(intermediate.Dir, "intermed-ca.cert.pem")}, parentFiles...), nil
+	}
+	if dr {
+		return []string{filepath.Join(intermediate.Dir, "intermed-ca.dr.cert.pem"), filepath.Join(DRRootCADir(pkiPath, rootCertUID), "root-ca.cert.pem")}, nil
+	}
+	return []string{filepath.Join(intermediate.Dir, "intermed-ca.cert.pem"), filepath.Join(RootCADir(pkiPath, rootCertUID), "root-ca.cert.pem")}, nil
+}
+
+// signRequest signs requestFile and files the result under the CA's certs directory
+func signRequest(caDir string, requestFile string, extensions string, days int, passphrase string, workDir string) (*IssuedCertificate, error) {
+	if days <= 0 {
+		days = defaultLeafDays
+	}
+	certificateFile := filepath.Join(workDir, "leaf.cert.pem")
+	if err := gofer.Perform("Leaf:Sign", caDir, requestFile, extensions, strconv.Itoa(days), opensslPassin(passphrase), certificateFile); err != nil {
+		return nil, err
+	}
+	syncDatabase(caDir)
+	cert, err := ReadCertificate(certificateFile)
+	if err != nil {
+		return nil, err
+	}
+	certBytes, err := ioutil.ReadFile(certificateFile)
+	if err != nil {
+		return nil, err
+	}
+	serial := SerialHex(cert.SerialNumber)
+	if err := ioutil.WriteFile(filepath.Join(caDir, "certs", serial+".cert.pem"), certBytes, 0644); err != nil {
+		return nil, err
+	}
+	return &IssuedCertificate{Serial: serial, CertificatePEM: string(certBytes)}, nil
+}
+
+// caConfigName returns the openssl configuration file name of a CA directory
+func caConfigName(caDir string) string {
+	if fileExists(filepath.Join(caDir, "intermed-ca.cnf")) {
+		return "intermed-ca.cnf"
+	}
+	return "root-ca.cnf"
+}
+
+// opensslPassin hands passphrase to openssl through its environment, never its command line
+func opensslPassin(passphrase string) string {
+	return "-passin env:" + shell.Secret(passphrase) + " "
+}
+
+// shellQuote wraps value in single quotes so it reaches openssl as a single argument
+func shellQuote(value string) string {
+	return "'" + strings.Replace(value, "'", `'"'"'`, -1) + "'"
+}
+
+// escapeSubject escapes the separators openssl uses in -subj values
+func escapeSubject(value string) string {
+	return strings.NewReplacer("\\", "\\\\", "/", "\\/", "=", "\\=", "+", "\\+").Replace(value)
+}
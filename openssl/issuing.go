@@ -0,0 +1,277 @@
+package openssl
+
+import (
+	"errors"
+	"fmt"
+	"github.com/chuckpreslar/gofer"
+	log "github.com/sirupsen/logrus"
+	"io/ioutil"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// issuingCAYears is the validity of an A2, capped by the expiry of its A1
+const issuingCAYears = 10
+
+// dnsDomainPattern keeps name restrictions from breaking out of the extension file
+var dnsDomainPattern = regexp.MustCompile(`^\.?[A-Za-z0-9-]+(\.[A-Za-z0-9-]+)*$`)
+
+// Issuing CA (A2) related task definitions
+var taskIssuingCACreateA2 = gofer.Register(gofer.Task{
+	Namespace:   "A2",
+	Label:       "Create",
+	Description: "Generate an issuing CA (A2) key and request for its Intermediary CA (A1)",
+	Action: func(arguments ...string) error {
+
+		issuingDir := arguments[0]
+		orgName := arguments[1]
+		oid := arguments[2]
+		opensslPassoutString := arguments[3]
+		opensslPassinString := arguments[4]
+
+		prepareCmd := "mkdir -p " + shellQuote(issuingDir) + "/{certreqs,certs,crl,newcerts,private} && cd " + shellQuote(issuingDir) +
+			" && chmod 700 private && touch intermed-ca.index && echo 00 > intermed-ca.crlnum && openssl rand -hex 16 > intermed-ca.serial"
+		shellOutput := execute(prepareCmd)
+		if shellOutput.CmdError != nil {
+			log.Printf("\nUnable to write to folder : %v\n", issuingDir)
+			return shellError(shellOutput)
+		}
+
+		if err := writeRequestConfig(issuingDir, orgName, "A2", oid); err != nil {
+			log.Printf("\nUnable to write config file : %v/intermed-ca.cnf\n", issuingDir)
+			return err
+		}
+
+		requestCmd := "cd " + shellQuote(issuingDir) + " && export OPENSSL_CONF=./intermed-ca.cnf && openssl req " + opensslPassoutString + opensslPassinString + "-new -out intermed-ca.req.pem" +
+			" && cp private/intermed-ca.key private/intermed-ca.key.pem && chmod 400 private/intermed-ca.key private/intermed-ca.key.pem"
+		shellOutput = execute(requestCmd)
+		if shellOutput.CmdError != nil {
+			log.Printf("\nUnable to generate the A2 key and request in %v\n", issuingDir)
+			return shellError(shellOutput)
+		}
+
+		// like A1:BlankConfig, the A2 issues with the template configuration and only the OID set
+		if err := writeIntermediateConfig(issuingDir); err != nil {
+			return err
+		}
+		blankConfigCmd := "cd " + shellQuote(issuingDir) + " && sed -i \"s/#customOID/" + oid + "/g; s/" + DefaultOID + "/" + oid + "/g\" intermed-ca.cnf"
+		shellOutput = execute(blankConfigCmd)
+		if shellOutput.CmdError != nil {
+			log.Printf("\nUnable to save active OID in config at %v/intermed-ca.cnf\n", issuingDir)
+			return shellError(shellOutput)
+		}
+		return nil
+	},
+})
+
+var taskIssuingCASignA2 = gofer.Register(gofer.Task{
+	Namespace:   "A2",
+	Label:       "Sign",
+	Description: "Sign the issuing CA (A2) request with its Intermediary CA (A1)",
+	Action: func(arguments ...string) error {
+
+		intermediateDir := arguments[0]
+		issuingDir := arguments[1]
+		extensionsFile := arguments[2]
+		startDate := arguments[3]
+		expiryDate := arguments[4]
+		opensslPassinString := arguments[5]
+
+		signA2Cmd := "cd " + shellQuote(intermediateDir) + " && export OPENSSL_CONF=./intermed-ca.cnf && openssl ca " + opensslPassinString + "-in " + shellQuote(issuingDir+"/intermed-ca.req.pem") +
+			" -out " + shellQuote(issuingDir+"/intermed-ca.cert.pem") + " -extfile " + shellQuote(extensionsFile) + " -extensions issuing-ca_ext -notext -batch -startdate " + startDate + " -enddate " + expiryDate
+		shellOutput := execute(signA2Cmd)
+		if shellOutput.CmdError != nil {
+			log.Printf("\nUnable to sign the A2 request with the A1 at %v, is this the right passphrase for the A1?\n", intermediateDir)
+			return shellError(shellOutput)
+		}
+		return nil
+	},
+})
+
+// CreateIssuingCA creates an issuing CA (A2) signed by the A1 parentID. The A2 gets pathLen as its
+// pathLenConstraint, which must be below the one of the A1, and its name restrictions must be within
+// the ones of the A1; without name restrictions it inherits the ones of the A1.
+func CreateIssuingCA(parentID string, orgName string, nameRestrictions []string, pathLen int, passphrase string, parentPassphrase string) (_ string, err error) {
+	log.Printf("\nCreating Issuing CA (A2) below A1 %v\n", parentID)
+	pkiPath, err := GetPkiPath()
+	if err != nil {
+		return "", err
+	}
+	rootCertUID, err := GetRootUID()
+	if err != nil {
+		return "", err
+	}
+	settings, err := readVaultSettings()
+	if err != nil {
+		return "", err
+	}
+	if orgName == "" {
+		return "", errors.New("an organization, project or environment name is required for an A2")
+	}
+	if len(passphrase) < 6 {
+		return "", errors.New("the A2 passphrase must be at least 6 characters")
+	}
+	parent, err := FindIntermediate(pkiPath, rootCertUID, parentID)
+	if err != nil {
+		return "", err
+	}
+	if parent.Level != 1 {
+		return "", fmt.Errorf("%v is not an A1, issuing CAs (A2) are signed by A1s", parent.ID)
+	}
+	if parent.ExternalKey {
+		return "", ErrExternalKey
+	}
+	if err := checkIssuingPathLen(parent, pathLen); err != nil {
+		return "", err
+	}
+	nameRestrictions, err = checkIssuingNameRestrictions(parent, nameRestrictions)
+	if err != nil {
+		return "", err
+	}
+
+	startTime := time.Now().UTC().AddDate(0, 0, -1)
+	id := startTime.Format("20060102150405Z")
+	if _, err := FindIntermediate(pkiPath, rootCertUID, id); err == nil {
+		return "", fmt.Errorf("a CA with ID %v already exists in this vault", id)
+	}
+	expiryTime := startTime.AddDate(issuingCAYears, 0, 0)
+	if expiryTime.After(parent.NotAfter) {
+		expiryTime = parent.NotAfter
+	}
+
+	// the A2 is staged, and the A1 database restored when any step after its signing fails
+	transaction, err := beginTransaction(pkiPath, rootCertUID, issuingDirMarker, "A2")
+	if err != nil {
+		return "", err
+	}
+	defer func() {
+		if err != nil {
+			transaction.rollback()
+		}
+	}()
+	stagingDir := transaction.stagingDir
+	if err := gofer.Perform("A2:Create", stagingDir, orgName, settings.oid, opensslPassout(passphrase), opensslPassin(passphrase)); err != nil {
+		return "", err
+	}
+
+	extensionsFile := filepath.Join(transaction.workspace, "issuing-ca.ext")
+	if err := ioutil.WriteFile(extensionsFile, issuingExtensions(settings.oid, pathLen, nameRestrictions), 0600); err != nil {
+		return "", err
+	}
+	if err := transaction.snapshot(parent.Dir); err != nil {
+		return "", err
+	}
+	if err := gofer.Perform("A2:Sign", parent.Dir, stagingDir, extensionsFile, id, expiryTime.Format("20060102150405Z"), opensslPassin(parentPassphrase)); err != nil {
+		return "", err
+	}
+	syncDatabase(parent.Dir)
+
+	// the A1 keeps a copy like for any certificate it issues, so the A2 can be revoked by serial
+	certBytes, err := ioutil.ReadFile(filepath.Join(stagingDir, "intermed-ca.cert.pem"))
+	if err != nil {
+		return "", err
+	}
+	cert, err := ReadCertificate(filepath.Join(stagingDir, "intermed-ca.cert.pem"))
+	if err != nil {
+		return "", err
+	}
+	certCopy := filepath.Join(parent.Dir, "certs", SerialHex(cert.SerialNumber)+".cert.pem")
+	transaction.created = append(transaction.created, certCopy)
+	if err := ioutil.WriteFile(certCopy, certBytes, 0644); err != nil {
+		return "", err
+	}
+	if err := ioutil.WriteFile(filepath.Join(stagingDir, "parent"), []byte(parent.ID+"\n"), 0644); err != nil {
+		return "", err
+	}
+
+	issuing := &Intermediate{ID: id, Dir: stagingDir, Parent: parent.ID, CrossSigned: parent.CrossSigned}
+	bundles := map[bool]string{false: "intermed-ca-chain-bundle.cert.pem", true: "intermed-ca-chain-bundle.dr.cert.pem"}
+	for dr, bundleName := range bundles {
+		if dr && !issuing.CrossSigned {
+			continue
+		}
+		chain, err := IntermediateChain(pkiPath, rootCertUID, issuing, dr)
+		if err != nil {
+			return "", err
+		}
+		if err := ioutil.WriteFile(filepath.Join(stagingDir, bundleName), chain, 0644); err != nil {
+			return "", err
+		}
+	}
+
+	if err := transaction.commit(filepath.Join(pkiPath, rootCertUID+issuingDirMarker+"-"+id)); err != nil {
+		return "", err
+	}
+	return id, nil
+}
+
+// checkIssuingPathLen keeps the pathLenConstraint of an A2 below the one of its A1
+func checkIssuingPathLen(parent *Intermediate, pathLen int) error {
+	if pathLen < 0 {
+		return errors.New("the A2 path length must be 0 or more")
+	}
+	if parent.PathLen == 0 {
+		return fmt.Errorf("A1 %v has a path length of 0 and cannot sign issuing CAs (A2)", parent.ID)
+	}
+	if parent.PathLen > 0 && pathLen >= parent.PathLen {
+		return fmt.Errorf("the A2 path length must be below %v, the path length of A1 %v", parent.PathLen, parent.ID)
+	}
+	return nil
+}
+
+// checkIssuingNameRestrictions returns the name restrictions of an A2, which must each be a
+// domain, or a subdomain, the A1 is restricted to. An empty list inherits the A1 restrictions.
+func checkIssuingNameRestrictions(parent *Intermediate, nameRestrictions []string) ([]string, error) {
+	for _, domain := range nameRestrictions {
+		if !dnsDomainPattern.MatchString(domain) {
+			return nil, fmt.Errorf("invalid name restriction %q", domain)
+		}
+	}
+	if len(parent.NameRestrict) == 0 {
+		return nameRestrictions, nil
+	}
+	if len(nameRestrictions) == 0 {
+		return parent.NameRestrict, nil
+	}
+	for _, domain := range nameRestrictions {
+		if !withinDomains(domain, parent.NameRestrict) {
+			return nil, fmt.Errorf("name restriction %v is not within %v, the restrictions of A1 %v", domain, strings.Join(parent.NameRestrict, ", "), parent.ID)
+		}
+	}
+	return nameRestrictions, nil
+}
+
+// withinDomains reports whether domain equals, or is a subdomain of, one of permitted.
+// A permitted domain with a leading dot only covers its subdomains, as in openssl.
+func withinDomains(domain string, permitted []string) bool {
+	domain = strings.ToLower(domain)
+	for _, parentDomain := range permitted {
+		parentDomain = strings.ToLower(parentDomain)
+		if domain == parentDomain || strings.HasSuffix(domain, "."+strings.TrimPrefix(parentDomain, ".")) {
+			return true
+		}
+	}
+	return false
+}
+
+// issuingExtensions renders the openssl extension file an A1 signs its A2s with
+func issuingExtensions(oid string, pathLen int, nameRestrictions []string) []byte {
+	var extensions strings.Builder
+	extensions.WriteString("[ issuing-ca_ext ]\n")
+	extensions.WriteString("basicConstraints        = critical, CA:true, pathlen:" + strconv.Itoa(pathLen) + "\n")
+	extensions.WriteString("keyUsage                = critical, keyCertSign, cRLSign\n")
+	if len(nameRestrictions) > 0 {
+		var permitted []string
+		for _, domain := range nameRestrictions {
+			permitted = append(permitted, "permitted;DNS:"+domain)
+		}
+		extensions.WriteString("nameConstraints         = critical, " + strings.Join(permitted, ", ") + "\n")
+	}
+	extensions.WriteString("subjectKeyIdentifier    = hash\n")
+	extensions.WriteString("authorityKeyIdentifier  = keyid:always\n")
+	extensions.WriteString(oid + "       = ASN1:UTF8String:Class_A2\n")
+	return []byte(extensions.String())
+}
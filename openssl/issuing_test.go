@@ -0,0 +1,53 @@
+package openssl
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestCheckIssuingNameRestrictions(t *testing.T) {
+	tests := []struct {
+		name     string
+		parent   []string
+		a2       []string
+		expected []string
+		rejected bool
+	}{
+		{"exact match", []string{"example.com"}, []string{"example.com"}, []string{"example.com"}, false},
+		{"subdomain", []string{"example.com"}, []string{"dev.example.com"}, []string{"dev.example.com"}, false},
+		{"deep subdomain", []string{"example.com"}, []string{"a.dev.example.com"}, []string{"a.dev.example.com"}, false},
+		{"case insensitive", []string{"Example.COM"}, []string{"dev.example.com"}, []string{"dev.example.com"}, false},
+		{"one of several parents", []string{"example.com", "example.org"}, []string{"dev.example.org"}, []string{"dev.example.org"}, false},
+		{"leading dot below domain", []string{"example.com"}, []string{".example.com"}, []string{".example.com"}, false},
+		{"leading dot below leading dot", []string{".example.com"}, []string{".example.com"}, []string{".example.com"}, false},
+		{"subdomain below leading dot", []string{".example.com"}, []string{"dev.example.com"}, []string{"dev.example.com"}, false},
+		{"domain itself below leading dot", []string{".example.com"}, []string{"example.com"}, nil, true},
+		{"sibling domain", []string{"example.com"}, []string{"example.org"}, nil, true},
+		{"sibling sharing a suffix", []string{"example.com"}, []string{"badexample.com"}, nil, true},
+		{"parent domain", []string{"dev.example.com"}, []string{"example.com"}, nil, true},
+		{"one of several outside", []string{"example.com"}, []string{"dev.example.com", "example.org"}, nil, true},
+		{"inherit restricted parent", []string{"example.com"}, nil, []string{"example.com"}, false},
+		{"unrestricted parent", nil, []string{"example.org"}, []string{"example.org"}, false},
+		{"unrestricted parent and A2", nil, nil, nil, false},
+		{"invalid domain under unrestricted parent", nil, []string{"example.org\nbasicConstraints = CA:true"}, nil, true},
+		{"invalid domain", []string{"example.com"}, []string{"dev.example.com,DNS:example.org"}, nil, true},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			parent := &Intermediate{ID: "20200722174505Z", NameRestrict: test.parent}
+			restrictions, err := checkIssuingNameRestrictions(parent, test.a2)
+			if test.rejected {
+				if err == nil {
+					t.Fatalf("%v accepted below %v", test.a2, test.parent)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("%v rejected below %v: %v", test.a2, test.parent, err)
+			}
+			if !reflect.DeepEqual(restrictions, test.expected) {
+				t.Errorf("restrictions %v, want %v", restrictions, test.expected)
+			}
+		})
+	}
+}
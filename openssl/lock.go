@@ -0,0 +1,298 @@
+package openssl
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	log "github.com/sirupsen/logrus"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sfcert/shell"
+	"sync"
+	"syscall"
+	"time"
+)
+
+// VaultLockFileName is the advisory lock of the vault, in the privki base dir so that
+// it outlives the PKI dir during a restore
+const VaultLockFileName = "privki.lock"
+
+// LockTimeout is how long a mutating operation waits for another privki process to
+// release the vault, 0 gives up at once
+var LockTimeout = 30 * time.Second
+
+// LockHolder is the process holding the vault lock, recorded in the lock file
+type LockHolder struct {
+	PID int `json:"pid"`
+	// Actor is the user and host of the process
+	Actor     string    `json:"actor"`
+	Operation string    `json:"operation"`
+	Since     time.Time `json:"since"`
+}
+
+func (holder LockHolder) String() string {
+	return fmt.Sprintf("%v (pid %d, %v) since %v", holder.Operation, holder.PID, holder.Actor, holder.Since.Local().Format(time.RFC3339))
+}
+
+// VaultLockedError is returned when the vault stays locked by another process past LockTimeout
+type VaultLockedError struct {
+	Holder *LockHolder
+}
+
+func (err *VaultLockedError) Error() string {
+	if err.Holder == nil {
+		return "the vault is locked by another privki process"
+	}
+	return "the vault is locked by " + err.Holder.String()
+}
+
+// lockOwnerKey is the context key of the operation holding the vault lock
+type lockOwnerKey struct{}
+
+// lockOwner is an operation holding the vault lock, the operations it calls with the
+// context LockVault returned find it there and share the lock instead of waiting
+type lockOwner struct {
+	operation string
+}
+
+// vaultLock is the lock of this process, held by one owner at a time
+var vaultLock struct {
+	sync.Mutex
+	owner   *lockOwner
+	file    *os.File
+	holder  LockHolder
+	holders int
+	// ctx is the context of the owner, it stops the commands run under the lock
+	ctx context.Context
+}
+
+// vaultLockSlot is taken by the owner of the vault lock, the other callers of this
+// process wait for it as the other processes wait for the lock file
+var vaultLockSlot = make(chan struct{}, 1)
+
+// VaultUnlock releases the vault lock taken by LockVault, err points to the error the operation
+// returns. The outermost release records the operation in the vault history, marked as failed
+// when the error is set.
+type VaultUnlock func(err *error)
+
+// errFatalExit is the failure of an operation whose process exits through log.Fatal
+var errFatalExit = errors.New("exited on a fatal error")
+
+// exitHandler registers releaseVaultLock with logrus, once however often the lock is taken
+var exitHandler sync.Once
+
+// LockVault takes the advisory lock of the vault for operation, waiting up to LockTimeout
+// for another operation of this process or another privki process to release it. The
+// operations called with the returned context share the lock, every successful call must
+// be paired with a call of the returned unlock. Cancelling the context of the outermost
+// call stops the openssl commands run while the lock is held. The kernel releases the
+// lock of a process that dies, the record it leaves is stale.
+func LockVault(ctx context.Context, operation string) (context.Context, VaultUnlock, error) {
+	if owner, ok := ctx.Value(lockOwnerKey{}).(*lockOwner); ok {
+		vaultLock.Lock()
+		held := vaultLock.owner == owner
+		if held {
+			vaultLock.holders++
+		}
+		vaultLock.Unlock()
+		if held {
+			return ctx, unlockVault, nil
+		}
+	}
+
+	deadline := time.Now().Add(LockTimeout)
+	if err := waitForVaultLockSlot(ctx, deadline); err != nil {
+		return nil, nil, err
+	}
+	file, err := lockVaultFile(ctx, deadline)
+	if err != nil {
+		<-vaultLockSlot
+		return nil, nil, err
+	}
+	lockFile := file.Name()
+	if stale := readLockHolder(lockFile); stale != nil {
+		log.Warnf("Taking over the stale vault lock of %v, the process ended without releasing it", stale)
+	}
+	holder := LockHolder{PID: os.Getpid(), Actor: localActor(), Operation: operation, Since: time.Now().UTC()}
+	if err := writeLockHolder(file, holder); err != nil {
+		file.Close()
+		<-vaultLockSlot
+		return nil, nil, err
+	}
+	if err := beginHistory(); err != nil {
+		log.Warnf("Unable to start the vault history: %v", err)
+	}
+	owner := &lockOwner{operation: operation}
+	ctx = context.WithValue(ctx, lockOwnerKey{}, owner)
+	vaultLock.Lock()
+	vaultLock.owner = owner
+	vaultLock.file = file
+	vaultLock.holder = holder
+	vaultLock.holders = 1
+	vaultLock.ctx = ctx
+	vaultLock.Unlock()
+	// log.Fatal exits without running deferred unlocks, the handler is registered once per process
+	exitHandler.Do(func() {
+		log.RegisterExitHandler(releaseVaultLock)
+	})
+	return ctx, unlockVault, nil
+}
+
+// waitForVaultLockSlot waits until no other operation of this process holds the vault lock
+func waitForVaultLockSlot(ctx context.Context, deadline time.Time) error {
+	select {
+	case vaultLockSlot <- struct{}{}:
+		return nil
+	default:
+	}
+	log.Printf("The vault is locked by %v, waiting up to %v", describeHolder(currentLockHolder()), LockTimeout)
+	timeout := time.NewTimer(time.Until(deadline))
+	defer timeout.Stop()
+	select {
+	case vaultLockSlot <- struct{}{}:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-timeout.C:
+		return &VaultLockedError{Holder: currentLockHolder()}
+	}
+}
+
+// lockVaultFile takes the lock file of the vault, waiting until deadline for another
+// privki process to release it
+func lockVaultFile(ctx context.Context, deadline time.Time) (*os.File, error) {
+	if err := os.MkdirAll(GetPkiBaseDir(), DefaultDirPerms); err != nil {
+		return nil, err
+	}
+	lockFile := filepath.Join(GetPkiBaseDir(), VaultLockFileName)
+	file, err := os.OpenFile(lockFile, os.O_RDWR|os.O_CREATE, 0644)
+	if err != nil {
+		return nil, err
+	}
+	for waiting := false; ; waiting = true {
+		err := syscall.Flock(int(file.Fd()), syscall.LOCK_EX|syscall.LOCK_NB)
+		if err == nil {
+			return file, nil
+		}
+		if err != syscall.EWOULDBLOCK {
+			file.Close()
+			return nil, fmt.Errorf("unable to lock the vault at %v: %v", lockFile, err)
+		}
+		holder := readLockHolder(lockFile)
+		if !time.Now().Before(deadline) {
+			file.Close()
+			return nil, &VaultLockedError{Holder: holder}
+		}
+		if !waiting {
+			log.Printf("The vault is locked by %v, waiting up to %v", describeHolder(holder), LockTimeout)
+		}
+		select {
+		case <-ctx.Done():
+			file.Close()
+			return nil, ctx.Err()
+		case <-time.After(250 * time.Millisecond):
+		}
+	}
+}
+
+func writeLockHolder(file *os.File, holder LockHolder) error {
+	record, err := json.Marshal(holder)
+	if err != nil {
+		return err
+	}
+	if err := file.Truncate(0); err != nil {
+		return err
+	}
+	_, err = file.WriteAt(append(record, '\n'), 0)
+	return err
+}
+
+// currentLockHolder returns the operation of this process holding the vault lock, nil when
+// it is not held yet
+func currentLockHolder() *LockHolder {
+	vaultLock.Lock()
+	defer vaultLock.Unlock()
+	if vaultLock.holders == 0 {
+		return nil
+	}
+	holder := vaultLock.holder
+	return &holder
+}
+
+func unlockVault(err *error) {
+	vaultLock.Lock()
+	defer vaultLock.Unlock()
+	if vaultLock.holders == 0 {
+		return
+	}
+	vaultLock.holders--
+	if vaultLock.holders == 0 {
+		var failure error
+		if err != nil {
+			failure = *err
+		}
+		closeVaultLock(failure)
+	}
+}
+
+// releaseVaultLock clears the record of this process however many operations hold the lock
+func releaseVaultLock() {
+	vaultLock.Lock()
+	defer vaultLock.Unlock()
+	if vaultLock.holders > 0 {
+		vaultLock.holders = 0
+		closeVaultLock(errFatalExit)
+	}
+}
+
+// closeVaultLock records the operation in the vault history, failed when failure is set,
+// and releases the lock to the next waiting operation
+func closeVaultLock(failure error) {
+	if err := recordHistory(vaultLock.holder, failure); err != nil {
+		log.Warnf("Unable to record %v in the vault history: %v", vaultLock.holder.Operation, err)
+	}
+	if err := vaultLock.file.Truncate(0); err != nil {
+		log.Warnf("Unable to clear the vault lock record: %v", err)
+	}
+	syscall.Flock(int(vaultLock.file.Fd()), syscall.LOCK_UN)
+	vaultLock.file.Close()
+	vaultLock.file = nil
+	vaultLock.owner = nil
+	vaultLock.ctx = nil
+	<-vaultLockSlot
+}
+
+// execute runs a command of the operation holding the vault lock, and stops it when the
+// operation is cancelled. Mutating operations are serialized and the read only ones of the
+// API server run no commands, so the owner is the caller.
+func execute(execCmd string) *shell.ShellOutput {
+	vaultLock.Lock()
+	ctx := vaultLock.ctx
+	vaultLock.Unlock()
+	if ctx == nil {
+		ctx = context.Background()
+	}
+	return shell.ExecuteContext(ctx, execCmd)
+}
+
+// readLockHolder returns the holder recorded in the lock file, nil when there is none
+func readLockHolder(lockFile string) *LockHolder {
+	record, err := ioutil.ReadFile(lockFile)
+	if err != nil || len(record) == 0 {
+		return nil
+	}
+	holder := new(LockHolder)
+	if err := json.Unmarshal(record, holder); err != nil {
+		return nil
+	}
+	return holder
+}
+
+func describeHolder(holder *LockHolder) string {
+	if holder == nil {
+		return "another privki process"
+	}
+	return holder.String()
+}
@@ -0,0 +1,188 @@
+package openssl
+
+import (
+	"context"
+	logtest "github.com/sirupsen/logrus/hooks/test"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"syscall"
+	"testing"
+	"time"
+)
+
+// lockTestHome points HOME at an empty temporary directory, and restores LockTimeout
+func lockTestHome(t *testing.T, timeout time.Duration) {
+	home, err := ioutil.TempDir("", "privki-lock")
+	if err != nil {
+		t.Fatal(err)
+	}
+	previousHome, previousTimeout := os.Getenv("HOME"), LockTimeout
+	os.Setenv("HOME", home)
+	LockTimeout = timeout
+	t.Cleanup(func() {
+		os.Setenv("HOME", previousHome)
+		LockTimeout = previousTimeout
+		os.RemoveAll(home)
+	})
+}
+
+func lockVaultFor(t *testing.T, ctx context.Context, operation string) (context.Context, VaultUnlock) {
+	t.Helper()
+	ctx, unlock, err := LockVault(ctx, operation)
+	if err != nil {
+		t.Fatalf("LockVault(%v): %v", operation, err)
+	}
+	return ctx, unlock
+}
+
+func TestLockVaultReentrant(t *testing.T) {
+	lockTestHome(t, 0)
+	ctx, unlock := lockVaultFor(t, context.Background(), "outer")
+	nestedCtx, nestedUnlock := lockVaultFor(t, ctx, "nested")
+	if nestedCtx != ctx {
+		t.Error("the nested operation was handed another context")
+	}
+	nestedUnlock(nil)
+
+	if _, _, err := LockVault(context.Background(), "unrelated"); err == nil {
+		t.Fatal("an unrelated caller joined the lock of the outer operation")
+	}
+	unlock(nil)
+
+	// the context of a released lock does not hold it any more
+	_, unlock = lockVaultFor(t, context.Background(), "next")
+	if _, _, err := LockVault(ctx, "stale context"); err == nil {
+		t.Fatal("the context of a released lock joined the lock of the next operation")
+	}
+	unlock(nil)
+}
+
+func TestLockVaultWaits(t *testing.T) {
+	lockTestHome(t, 10*time.Second)
+	_, unlock := lockVaultFor(t, context.Background(), "first")
+
+	locked := make(chan VaultUnlock)
+	go func() {
+		_, unlock, err := LockVault(context.Background(), "second")
+		if err != nil {
+			t.Error(err)
+			close(locked)
+			return
+		}
+		locked <- unlock
+	}()
+	select {
+	case <-locked:
+		t.Fatal("the second operation did not wait for the first to release the vault")
+	case <-time.After(300 * time.Millisecond):
+	}
+
+	unlock(nil)
+	select {
+	case unlock, ok := <-locked:
+		if ok {
+			unlock(nil)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("the second operation did not take the vault released by the first")
+	}
+}
+
+func TestLockVaultCancelled(t *testing.T) {
+	lockTestHome(t, 10*time.Second)
+	_, unlock := lockVaultFor(t, context.Background(), "first")
+	defer unlock(nil)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 200*time.Millisecond)
+	defer cancel()
+	if _, _, err := LockVault(ctx, "second"); err != context.DeadlineExceeded {
+		t.Fatalf("LockVault with a cancelled context returned %v, want %v", err, context.DeadlineExceeded)
+	}
+}
+
+func TestLockVaultTimeout(t *testing.T) {
+	lockTestHome(t, 200*time.Millisecond)
+
+	t.Run("held in this process", func(t *testing.T) {
+		_, unlock := lockVaultFor(t, context.Background(), "privki crl generate")
+		defer unlock(nil)
+		started := time.Now()
+		_, _, err := LockVault(context.Background(), "second")
+		lockedErr, ok := err.(*VaultLockedError)
+		if !ok {
+			t.Fatalf("LockVault returned %v, want a VaultLockedError", err)
+		}
+		if lockedErr.Holder == nil || lockedErr.Holder.Operation != "privki crl generate" {
+			t.Errorf("VaultLockedError names holder %v", lockedErr.Holder)
+		}
+		if waited := time.Since(started); waited < LockTimeout {
+			t.Errorf("LockVault gave up after %v, before LockTimeout", waited)
+		}
+	})
+
+	t.Run("held by another process", func(t *testing.T) {
+		if err := os.MkdirAll(GetPkiBaseDir(), DefaultDirPerms); err != nil {
+			t.Fatal(err)
+		}
+		other, err := os.OpenFile(filepath.Join(GetPkiBaseDir(), VaultLockFileName), os.O_RDWR|os.O_CREATE, 0644)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer other.Close()
+		if err := syscall.Flock(int(other.Fd()), syscall.LOCK_EX|syscall.LOCK_NB); err != nil {
+			t.Fatal(err)
+		}
+		if err := writeLockHolder(other, LockHolder{PID: 4242, Actor: "ops@pki-host", Operation: "privki apply", Since: time.Now().UTC()}); err != nil {
+			t.Fatal(err)
+		}
+
+		_, _, err = LockVault(context.Background(), "second")
+		lockedErr, ok := err.(*VaultLockedError)
+		if !ok {
+			t.Fatalf("LockVault returned %v, want a VaultLockedError", err)
+		}
+		if lockedErr.Holder == nil || lockedErr.Holder.PID != 4242 {
+			t.Errorf("VaultLockedError names holder %v, want pid 4242", lockedErr.Holder)
+		}
+		if !strings.Contains(err.Error(), "privki apply") {
+			t.Errorf("error %q does not name the operation holding the vault", err)
+		}
+	})
+}
+
+func TestLockVaultStaleLock(t *testing.T) {
+	lockTestHome(t, 0)
+	if err := os.MkdirAll(GetPkiBaseDir(), DefaultDirPerms); err != nil {
+		t.Fatal(err)
+	}
+	// the record of a process that died holding the lock, the kernel released its flock
+	stale := `{"pid":4242,"actor":"ops@pki-host","operation":"privki create a1","since":"2020-07-22T17:45:05Z"}` + "\n"
+	lockFile := filepath.Join(GetPkiBaseDir(), VaultLockFileName)
+	if err := ioutil.WriteFile(lockFile, []byte(stale), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	hook := logtest.NewGlobal()
+	defer hook.Reset()
+	_, unlock := lockVaultFor(t, context.Background(), "privki crl generate")
+	reported := false
+	for _, entry := range hook.AllEntries() {
+		if strings.Contains(entry.Message, "stale vault lock") && strings.Contains(entry.Message, "privki create a1") {
+			reported = true
+		}
+	}
+	if !reported {
+		t.Error("taking over the stale lock was not reported")
+	}
+
+	holder := readLockHolder(lockFile)
+	if holder == nil || holder.PID != os.Getpid() || holder.Operation != "privki crl generate" {
+		t.Errorf("lock file records %v, want this process", holder)
+	}
+	unlock(nil)
+	if holder := readLockHolder(lockFile); holder != nil {
+		t.Errorf("lock file still records %v after the release", holder)
+	}
+}
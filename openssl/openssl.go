@@ -4,106 +4,152 @@ import (
 	"errors"
 	"fmt"
 	"github.com/chuckpreslar/gofer"
-	"github.com/howeyc/gopass"
+	"github.com/markbates/pkger"
 	"github.com/rs/xid"
 	log "github.com/sirupsen/logrus"
 	"io/ioutil"
+	"os"
 	"os/exec"
+	"path/filepath"
 	"sfcert/shell"
 	"strings"
 	"time"
 )
 
-var drStatus string
-var opensslPassoutString string
-var opensslPassinString string
+// ErrVaultExists is returned when initializing a host that already has a PKI root
+var ErrVaultExists = errors.New("PKI root already exists")
+
+// ErrVaultNotInitialized is returned when the host has no PKI repository yet
+var ErrVaultNotInitialized = errors.New("no PKI repository found, make sure to run privki init first")
+
+// ErrSubordinateVault is returned for operations that need the Root CA (A0) keys on a subordinate vault
+var ErrSubordinateVault = errors.New("this is a subordinate vault without the Root CA (A0) keys, run this on the host holding the Root CA")
+
+// Vault modes recorded in the mode config file
+const (
+	// VaultModeRoot vaults hold the Root CA (A0) keys
+	VaultModeRoot = "root"
+	// VaultModeSubordinate vaults only hold A1s handed over from the root vault, and the public A0 certificates
+	VaultModeSubordinate = "subordinate"
+)
+
+// DefaultA1PathLen lets an A1 sign one level of issuing CAs (A2) below it
+const DefaultA1PathLen = 1
+
+// DefaultA1Years is the validity of an A1 created without a number of days
+const DefaultA1Years = 18
+
+// A1 key algorithms, the empty one keeps the RSA key size of intermed-ca.cnf
+var keyAlgorithms = map[string]string{
+	"rsa:2048": "-newkey rsa:2048 ",
+	"rsa:3072": "-newkey rsa:3072 ",
+	"rsa:4096": "-newkey rsa:4096 ",
+	"ec:P-256": "-newkey ec -pkeyopt ec_paramgen_curve:P-256 ",
+	"ec:P-384": "-newkey ec -pkeyopt ec_paramgen_curve:P-384 ",
+	"ec:P-521": "-newkey ec -pkeyopt ec_paramgen_curve:P-521 ",
+}
+
+// ValidateKeyAlgorithm checks an A1 key algorithm, rsa:<bits> or ec:<curve>
+func ValidateKeyAlgorithm(keyAlgorithm string) error {
+	if _, found := keyAlgorithms[keyAlgorithm]; !found && keyAlgorithm != "" {
+		return fmt.Errorf("unsupported key algorithm %q, use rsa:2048, rsa:3072, rsa:4096, ec:P-256, ec:P-384 or ec:P-521", keyAlgorithm)
+	}
+	return nil
+}
 
 // Public Utility Functions follow
 // Checks if openssl is available on the host machine
-func CheckOpenSSL() {
+func CheckOpenSSL() error {
 	_, opensslError := exec.LookPath("openssl")
-	_, opensslStdout, _ := shell.ShellExecWithChannels("openssl version", false, false)
 	if opensslError != nil {
 		log.Printf("OpenSSL not found on your system. Please make sure OpenSSL 1.1 or later is installed and in the search path. \n")
-		log.Error(opensslError)
+		return opensslError
 	}
-	log.Printf(opensslStdout)
-	if fileExists(GetRootCertUIDConfigFile()) {
+	_, opensslStdout, _ := shell.ShellExecWithChannels("openssl version", false, false)
+	log.Print(opensslStdout)
+	if VaultInitialized() {
 		log.Printf("An existing PKI root was already found at %v. \n I you are sure you do not need this, please delete and try again", GetUserHomeDir()+pkiBaseDefault)
-		log.Panic(errors.New("PKI root already exists"))
+		return ErrVaultExists
 	}
+	return nil
 }
 
 // Checks if the openssl in use supports AES 256 CBC Cipher
-func CheckAES256Cipher() {
+func CheckAES256Cipher() error {
 	aes256Errors, _, _ := shell.ShellExecWithChannels("openssl enc -ciphers | grep aes-256-cbc", false, false)
 	if aes256Errors != nil {
 		log.Printf("Your OpenSSL installation does not support aes-256-cbc cipher.\n\t Please upgrade your OpenSSL Installation and try again.\n\n")
-		log.Error(aes256Errors)
+		return aes256Errors
 	}
+	return nil
 }
 
 // Get active OID in use for Custom Class Definitions
-func GetOid() string {
-	oidBytes, oidReadError := ioutil.ReadFile(GetOidConfigFile())
-	if oidReadError != nil {
-		log.Printf("unable to read your oid settings at %v, make sure to run privki init before creating certs", GetOidConfigFile())
-		log.Fatal(oidReadError)
-	}
-
-	return strings.TrimSuffix(string(oidBytes), "\n")
+func GetOid() (string, error) {
+	return readConfigValue(GetOidConfigFile(), "oid")
 }
 
 // Get the Root Certifying authority's UID
 // for the current PKI
-func GetRootUID() string {
-	rootCertUID, rootCertUIDError := ioutil.ReadFile(GetRootCertUIDConfigFile())
-
-	if rootCertUIDError != nil {
-		log.Printf("unable to find a registered root cert UID at %v", GetRootCertUIDConfigFile())
-		log.Fatal(rootCertUIDError)
-	}
-	return strings.TrimSuffix(string(rootCertUID), "\n")
+func GetRootUID() (string, error) {
+	return readConfigValue(GetRootCertUIDConfigFile(), "root cert UID")
 }
 
 // Get path of active PKI Repository
-func GetPkiPath() string {
-	pkipathbytes, pkiconfigerror := ioutil.ReadFile(GetUserHomeDir() + pkiPathConfigFile)
-
-	if pkiconfigerror != nil {
-		log.Printf("unable to read your pki settings at %v, make sure to run privki initPki before creating certs", GetUserHomeDir()+pkiPathConfigFile)
-		log.Fatal(pkiconfigerror)
+func GetPkiPath() (string, error) {
+	pkiPath, err := readConfigValue(GetPkiPathConfigFile(), "pki")
+	if err == nil && VaultSealed(pkiPath) {
+		return "", ErrVaultSealed
 	}
+	return pkiPath, err
+}
 
-	return strings.TrimSuffix(string(pkipathbytes), "\n")
+// Get the vault wide Organization Name
+func GetOrganizationName() (string, error) {
+	return readConfigValue(GetOrgNameConfigFile(), "organization")
 }
 
-// Get active OID in use for Custom Class Definitions
-func GetOrganizationName() string {
-	organizationBytes, organizationReadError := ioutil.ReadFile(GetOrgNameConfigFile())
-	if organizationReadError != nil {
-		log.Printf("unable to read your organization settings at %v, make sure to run privki init before creating certs", GetOrgNameConfigFile())
-		log.Fatal(organizationReadError)
+// Get the Organization Common Name of the Root CA (A0)
+func GetOrganizationCommonName() (string, error) {
+	return readConfigValue(GetOrgCommonNameConfigFile(), "organization's common name")
+}
+
+// readConfigValue reads a single line setting from the PKI config directory
+func readConfigValue(configFile string, setting string) (string, error) {
+	valueBytes, readError := ioutil.ReadFile(configFile)
+	if readError != nil {
+		log.Printf("unable to read your %v settings at %v, make sure to run privki init before creating certs", setting, configFile)
+		return "", fmt.Errorf("%v: %v", ErrVaultNotInitialized, readError)
 	}
+	return strings.TrimSuffix(string(valueBytes), "\n"), nil
+}
 
-	return strings.TrimSuffix(string(organizationBytes), "\n")
+// vaultSettings holds the vault wide settings substituted into CA configurations
+type vaultSettings struct {
+	oid          string
+	organization string
+	commonName   string
 }
 
-// Get active OID in use for Custom Class Definitions
-func GetOrganizationCommonName() string {
-	commonBytes, commonReadError := ioutil.ReadFile(GetOrgCommonNameConfigFile())
-	if commonReadError != nil {
-		log.Printf("unable to read your organization's common name settings at %v, make sure to run privki init before creating certs", GetOrgCommonNameConfigFile())
-		log.Fatal(commonReadError)
+func readVaultSettings() (*vaultSettings, error) {
+	settings := new(vaultSettings)
+	var err error
+	if settings.oid, err = GetOid(); err != nil {
+		return nil, err
 	}
-
-	return strings.TrimSuffix(string(commonBytes), "\n")
+	if settings.organization, err = GetOrganizationName(); err != nil {
+		return nil, err
+	}
+	if settings.commonName, err = GetOrganizationCommonName(); err != nil {
+		return nil, err
+	}
+	return settings, nil
 }
 
 // Initializes a PKI Repository
 // Please note that at any point in time, there can only be one
 // active repository on a host that acts as a Certifying Authority.
-func InitPki() {
+func InitPki() error {
 
 	rootUID := xid.New().String()
 	current_pki_path := GetUserHomeDir() + pkiBaseDefault + rootUID
@@ -111,127 +157,213 @@ func InitPki() {
 	// Run gofer Task PKI:createRootUID to generate unique root UID
 	createRootUIDErrors := gofer.Perform("PKI:createRootUID", rootUID)
 	if createRootUIDErrors != nil {
-		log.Errorf("Errors occurred in execution of task \"PKI:createRootUID\" :", createRootUIDErrors)
+		log.Errorf("Errors occurred in execution of task \"PKI:createRootUID\" : %v", createRootUIDErrors)
+		return createRootUIDErrors
 	}
 
 	// Run gofer Task PKI:init to init PKI repo/vault
 	initPkiErrors := gofer.Perform("PKI:init", current_pki_path)
 	if initPkiErrors != nil {
-		log.Errorf("Errors occurred in execution of task \"PKI:init\" :", initPkiErrors)
+		log.Errorf("Errors occurred in execution of task \"PKI:init\" : %v", initPkiErrors)
+		return initPkiErrors
 	}
-
+	return gofer.Perform("PKI:SaveMode", VaultModeRoot)
 }
 
 // Generate Self Signed Certificate for Root Certifying Authority
 // and Create the Root Certifying Authority (A0)
 // Using self generated PKI Configuration & random seed UID
-func CreateRootCA(passphrase string) {
+func CreateRootCA(passphrase string) error {
 	log.Printf("Creating Root CA (A0)")
-	pkiPathFromConfig := GetPkiPath()
-	rootCertUID := GetRootUID()
-
-	if passphrase != "NA" && len(passphrase) > 5 {
-		opensslPassoutString = "-passout pass:" + passphrase + " "
-		opensslPassinString = "-passin pass:" + passphrase + " "
-	} else {
-		fmt.Printf("\n\tEnter passphrase for A0 : ")
-		a0Paaaphrase, _ := gopass.GetPasswdMasked()
-		opensslPassoutString = "-passout pass:" + string(a0Paaaphrase) + " "
-		opensslPassinString = "-passin pass:" + string(a0Paaaphrase) + " "
+	if err := RequireRootVault(); err != nil {
+		return err
+	}
+	pkiPathFromConfig, err := GetPkiPath()
+	if err != nil {
+		return err
+	}
+	rootCertUID, err := GetRootUID()
+	if err != nil {
+		return err
 	}
-	fmt.Printf("\n\n\t*************************************\n\tIMPORTANT: Please remember and note this Passphrase somewhere safe. \n\tYou will loose access to  your vault without this passphrase.\n\t*************************************\n")
 
-	taskRootCACreateErrors := gofer.Perform("A0:Create", pkiPathFromConfig, rootCertUID, opensslPassoutString, opensslPassinString)
+	taskRootCACreateErrors := gofer.Perform("A0:Create", pkiPathFromConfig, rootCertUID, opensslPassout(passphrase), opensslPassin(passphrase))
 	if taskRootCACreateErrors != nil {
-		log.Errorf("Errors occurred in execution of task \"A0:Create\" :", taskRootCACreateErrors)
+		log.Errorf("Errors occurred in execution of task \"A0:Create\" : %v", taskRootCACreateErrors)
+		return taskRootCACreateErrors
+	}
+
+	// new vaults keep their certificate database in privki.db from the start
+	if _, err := MigrateDatabase(); err != nil {
+		log.Warnf("Unable to create the certificate database, run privki db migrate: %v", err)
 	}
+	return nil
 }
 
 // Generate Self Signed Certificate for DR Root Certifying Authority
 // and Create the Root DR Certifying Authority (DR A0)
 // Using self generated PKI Configuration & random seed UUID
-func CreateDRRootCA() {
+func CreateDRRootCA(passphrase string) error {
 	log.Printf("\n\nCreating DR Root CA (A0)")
-	pkiPathFromConfig := GetPkiPath()
-	rootCertUID := GetRootUID()
+	if err := RequireRootVault(); err != nil {
+		return err
+	}
+	pkiPathFromConfig, err := GetPkiPath()
+	if err != nil {
+		return err
+	}
+	rootCertUID, err := GetRootUID()
+	if err != nil {
+		return err
+	}
 
 	//Create DR Root CA(A0) Certificate
-	taskDRRootCACreateErrors := gofer.Perform("A0DR:Create", pkiPathFromConfig, rootCertUID, opensslPassoutString, opensslPassinString)
+	taskDRRootCACreateErrors := gofer.Perform("A0DR:Create", pkiPathFromConfig, rootCertUID, opensslPassout(passphrase), opensslPassin(passphrase))
 	if taskDRRootCACreateErrors != nil {
-		log.Errorf("Errors occurred in execution of task \"A0DR:Create\" :", taskDRRootCACreateErrors)
+		log.Errorf("Errors occurred in execution of task \"A0DR:Create\" : %v", taskDRRootCACreateErrors)
+		return taskDRRootCACreateErrors
 	}
 
 	taskDRRootCARecordErrors := gofer.Perform("A0DR:SaveConfig", pkiPathFromConfig, rootCertUID)
 	if taskDRRootCARecordErrors != nil {
-		log.Errorf("Errors occurred in execution of task \"A0DR:SaveConfig\" :", taskDRRootCARecordErrors)
+		log.Errorf("Errors occurred in execution of task \"A0DR:SaveConfig\" : %v", taskDRRootCARecordErrors)
+		return taskDRRootCARecordErrors
+	}
+	syncDatabase(DRRootCADir(pkiPathFromConfig, rootCertUID))
+	return nil
+}
+
+// DREnabled reports whether the vault has a DR Root CA (DR A0)
+func DREnabled() bool {
+	drStatusBytes, drConfigError := ioutil.ReadFile(GetDRStatusConfigFile())
+	if drConfigError != nil {
+		return false
+	}
+	return strings.TrimSuffix(string(drStatusBytes), "\n") == "true"
+}
+
+// RecordBackup records the time of a successful backup of the vault
+func RecordBackup() error {
+	return ioutil.WriteFile(GetLastBackupConfigFile(), []byte(time.Now().UTC().Format(time.RFC3339)+"\n"), 0644)
+}
+
+// LastBackup returns the time of the last backup of the vault, zero when none was recorded
+func LastBackup() (time.Time, error) {
+	lastBackupBytes, err := ioutil.ReadFile(GetLastBackupConfigFile())
+	if os.IsNotExist(err) {
+		return time.Time{}, nil
 	}
+	if err != nil {
+		return time.Time{}, err
+	}
+	return time.Parse(time.RFC3339, strings.TrimSpace(string(lastBackupBytes)))
+}
+
+// VaultMode returns the mode of the vault on this host,
+// vaults created before modes were recorded are root vaults.
+func VaultMode() string {
+	modeBytes, modeConfigError := ioutil.ReadFile(GetModeConfigFile())
+	if modeConfigError != nil {
+		return VaultModeRoot
+	}
+	return strings.TrimSuffix(string(modeBytes), "\n")
+}
+
+// VaultInitialized reports whether this host has a vault, sealed or not
+func VaultInitialized() bool {
+	return fileExists(GetRootCertUIDConfigFile())
+}
+
+// RequireRootVault returns ErrSubordinateVault unless this host holds the Root CA (A0)
+func RequireRootVault() error {
+	if VaultMode() == VaultModeSubordinate {
+		return ErrSubordinateVault
+	}
+	return nil
 }
 
 // Create Root CA (A0) and/or Root DR CA (DR A0) Cross signed Intermediate Certifying authority (A1)
-// Using self generated PKI Configuration & random seed UUID
-func CreateIntermediateCA(nameRestriction string, orgName string, passphrase string, rootPassphrase string) {
+// Using self generated PKI Configuration & random seed UUID.
+// The A1 repository is archived into output/ encrypted with archivePassphrase.
+// The A1 is valid for days, DefaultA1Years when 0, with a keyAlgorithm key.
+// drPassphrase unlocks the DR A0 key when it has its own, empty when it shares rootPassphrase.
+// Returns the ID of the new A1.
+func CreateIntermediateCA(nameRestriction string, orgName string, pathLen int, days int, keyAlgorithm string, passphrase string, rootPassphrase string, drPassphrase string, archivePassphrase string) (string, error) {
 
 	log.Printf("\nCreating Intermediate CA (A1)\n")
-	pkiPathFromConfig := GetPkiPath()
-	rootCertUID := GetRootUID()
+	if err := RequireRootVault(); err != nil {
+		return "", err
+	}
+	pkiPathFromConfig, err := GetPkiPath()
+	if err != nil {
+		return "", err
+	}
+	rootCertUID, err := GetRootUID()
+	if err != nil {
+		return "", err
+	}
 	if orgName == "NA" || orgName == "" {
 		log.Warnf("\nPlease specify an organization or a project name for this Intermediate Certifying Authority")
-		log.Fatalf("\nProgram Exit, try again with suggested corrections\n")
+		return "", errors.New("an organization or project name is required for an A1")
+	}
+	if len(archivePassphrase) < 6 {
+		return "", errors.New("the A1 archive passphrase must be at least 6 characters")
+	}
+	if err := checkNameRestriction(nameRestriction); err != nil {
+		return "", err
+	}
+	if pathLen < 0 {
+		return "", errors.New("the A1 path length must be 0 or more")
+	}
+	if days < 0 {
+		return "", errors.New("the A1 validity must be a positive number of days")
+	}
+	if err := ValidateKeyAlgorithm(keyAlgorithm); err != nil {
+		return "", err
 	}
 
 	// Check if DR is Enabled
-	drStatusBytes, drConfigError := ioutil.ReadFile(GetDRStatusConfigFile())
-	if drConfigError != nil {
-		log.Printf("unable to read your DR settings at %v. Proceeding without DR", GetDRStatusConfigFile())
-		drStatus = "false"
-	} else {
-		drStatus = strings.TrimSuffix(string(drStatusBytes), "\n")
+	drStatus := DREnabled()
+	if !drStatus {
+		log.Printf("DR is not enabled in %v. Proceeding without DR", GetDRStatusConfigFile())
+	} else if drPassphrase, err = resolveDRPassphrase(rootPassphrase, drPassphrase); err != nil {
+		return "", err
 	}
 
-	// Generate Start and Expiry dates for the intermediary (A1)
+	// Generate Start and Expiry dates for the intermediary (A1), the start date is
+	// also its ID, so an A1 created within the same second waits for the next one
 	t := time.Now().UTC()
-	startDate := fmt.Sprintf(t.AddDate(0, 0, -1).Format("20060102150405Z"))
-	expiryDate := fmt.Sprintf(t.AddDate(18, 0, 0).Format("20060102150405Z"))
-
-	var opensslPassoutString, opensslPassinString, opensslA0PassinString string
-
-	if rootPassphrase != "NA" && len(rootPassphrase) > 5 {
-		opensslA0PassinString = "-passin pass:" + string(rootPassphrase) + " "
-	} else {
-		fmt.Printf("\n\tRoot CA (A0) Passphrase: ")
-		a0Paaaphrase, _ := gopass.GetPasswdMasked()
-		opensslA0PassinString = "-passin pass:" + string(a0Paaaphrase) + " "
+	for dirExists(filepath.Join(pkiPathFromConfig, rootCertUID+intermediateDirMarker+"-"+t.AddDate(0, 0, -1).Format("20060102150405Z"))) {
+		time.Sleep(time.Until(t.Truncate(time.Second).Add(time.Second)))
+		t = time.Now().UTC()
 	}
-
-	if passphrase != "NA" && len(passphrase) > 5 {
-		opensslPassoutString = "-passout pass:" + string(passphrase) + " "
-		opensslPassinString = "-passin pass:" + string(passphrase) + " "
-	} else {
-		fmt.Printf("\n\tEnter a new passphrase for this Intermediary CA (A1) \n\tPlease make sure this is different from Root CA (A0):  ")
-		a1Paaaphrase, _ := gopass.GetPasswdMasked()
-		opensslPassoutString = "-passout pass:" + string(a1Paaaphrase) + " "
-		opensslPassinString = "-passin pass:" + string(a1Paaaphrase) + " "
+	startDate := t.AddDate(0, 0, -1).Format("20060102150405Z")
+	expiryDate := t.AddDate(DefaultA1Years, 0, 0).Format("20060102150405Z")
+	if days > 0 {
+		expiryDate = t.AddDate(0, 0, days).Format("20060102150405Z")
 	}
 
-	taskIntermediaryCACreateA1Errors := gofer.Perform("A1:Create", pkiPathFromConfig, rootCertUID, nameRestriction, startDate, expiryDate, opensslPassoutString, opensslPassinString, opensslA0PassinString)
+	// the A1 is staged and signed with ephemeral configs, the Root CA configs are left as they are
+	transaction, err := beginIntermediate(pkiPathFromConfig, rootCertUID)
+	if err != nil {
+		return "", err
+	}
+	taskIntermediaryCACreateA1Errors := gofer.Perform("A1:Create", pkiPathFromConfig, rootCertUID, orgName, opensslPassout(passphrase), opensslPassin(passphrase), keyAlgorithms[keyAlgorithm])
 	if taskIntermediaryCACreateA1Errors != nil {
-		log.Errorf("Errors occurred in execution of task \"A1:Create\" :", taskIntermediaryCACreateA1Errors)
+		log.Errorf("Errors occurred in execution of task \"A1:Create\" : %v", taskIntermediaryCACreateA1Errors)
+		transaction.rollback()
+		return "", taskIntermediaryCACreateA1Errors
 	}
 
-	// DR CROSS SIGNING Only if DR is Enabled.
-	// copy CSR for Root DR CA signing, IF DR is enabled by user
-	if drStatus == "true" {
-		taskIntermediaryCACrossSignA1Errors := gofer.Perform("A1:CrossSign", pkiPathFromConfig, rootCertUID, nameRestriction, startDate, expiryDate, opensslA0PassinString)
-		if taskIntermediaryCACrossSignA1Errors != nil {
-			log.Errorf("Errors occurred in execution of task \"A1:CrossSign\" :", taskIntermediaryCACrossSignA1Errors)
-		}
+	signing := intermediateSigning{NameRestriction: nameRestriction, PathLen: pathLen, StartDate: startDate, ExpiryDate: expiryDate}
+	if err := signIntermediate(transaction, RootCADir(pkiPathFromConfig, rootCertUID), signing, rootPassphrase, false); err != nil {
+		return "", err
 	}
 
-	// If DR is enabled check here and run ,"A1:A0DRConfigReset"
-	if drStatus == "true" {
-		taskDRConfigResetErrors := gofer.Perform("A1:A0DRConfigReset", pkiPathFromConfig, rootCertUID)
-		if taskDRConfigResetErrors != nil {
-			log.Warnf("Errors occurred in execution of task \"A1:A0DRConfigReset\" :", taskDRConfigResetErrors)
+	// DR CROSS SIGNING Only if DR is Enabled.
+	if drStatus {
+		if err := signIntermediate(transaction, DRRootCADir(pkiPathFromConfig, rootCertUID), signing, drPassphrase, true); err != nil {
+			return "", err
 		}
 	}
 
@@ -239,9 +371,83 @@ func CreateIntermediateCA(nameRestriction string, orgName string, passphrase str
 	//   their PKI Repository & the certifications into a single zip file,
 	//   save them in outputs folder and then print it out so that the user
 	//   knows where to look for.
-	taskIntermediaryCAZipoutErrors := gofer.Perform("A1:Zipout", pkiPathFromConfig, rootCertUID, startDate)
+	//   An A1 without its config or archive is not committed.
+	transaction.created = append(transaction.created, filepath.Join(pkiPathFromConfig, "output", rootCertUID+"-intermed-ca-"+startDate+".zip"))
+	taskIntermediaryCAZipoutErrors := gofer.Perform("A1:Zipout", pkiPathFromConfig, rootCertUID, startDate, archivePassphrase)
 	if taskIntermediaryCAZipoutErrors != nil {
-		log.Warnf("Errors occurred in execution of task \"A1:Zipout\" :", taskIntermediaryCAZipoutErrors)
+		log.Errorf("Errors occurred in execution of task \"A1:Zipout\" : %v", taskIntermediaryCAZipoutErrors)
+		transaction.rollback()
+		return "", taskIntermediaryCAZipoutErrors
+	}
+
+	if err := transaction.commit(filepath.Join(pkiPathFromConfig, rootCertUID+intermediateDirMarker+"-"+startDate)); err != nil {
+		return "", err
 	}
+	return startDate, nil
+}
+
+// signIntermediate signs the staged A1 with the Root CA at rootDir and bundles it,
+// the transaction is rolled back on failure
+func signIntermediate(transaction *intermediateTransaction, rootDir string, signing intermediateSigning, rootPassphrase string, dr bool) error {
+	certificateName := "intermed-ca.cert.pem"
+	if dr {
+		certificateName = "intermed-ca.dr.cert.pem"
+	}
+	if err := transaction.sign(rootDir, signing, rootPassphrase, certificateName); err != nil {
+		log.Errorf("Errors occurred in execution of task \"A1:Sign\" : %v", err)
+		transaction.rollback()
+		return err
+	}
+	if err := transaction.bundle(rootDir, dr); err != nil {
+		transaction.rollback()
+		return err
+	}
+	return nil
+}
 
+// renderRootConfig renders the Root CA config of the vault from its template
+func renderRootConfig(settings *vaultSettings) ([]byte, error) {
+	rootConfigResource, err := pkger.Open("/resources/root_ca.cnf")
+	if err != nil {
+		return nil, err
+	}
+	defer rootConfigResource.Close()
+	template, err := ioutil.ReadAll(rootConfigResource)
+	if err != nil {
+		return nil, err
+	}
+	config := strings.NewReplacer(
+		"#customOID", settings.oid,
+		"#OrganizationName", settings.organization,
+		"#OrganizationCommonName", settings.commonName,
+	).Replace(string(template))
+	return []byte(config), nil
+}
+
+// writeRootConfig replaces the config of the Root CA at rootDir with a freshly rendered one,
+// the old config stays in place until the new one is complete
+func writeRootConfig(rootDir string, settings *vaultSettings) error {
+	config, err := renderRootConfig(settings)
+	if err != nil {
+		return err
+	}
+	configFile := filepath.Join(rootDir, "root-ca.cnf")
+	if err := ioutil.WriteFile(configFile+".new", config, 0644); err != nil {
+		os.Remove(configFile + ".new")
+		return err
+	}
+	return os.Rename(configFile+".new", configFile)
+}
+
+// opensslPassout hands passphrase to openssl through its environment, never its command line
+func opensslPassout(passphrase string) string {
+	return "-passout env:" + shell.Secret(passphrase) + " "
+}
+
+// shellError turns a failed shell execution into an error carrying openssl's own message
+func shellError(shellOutput *shell.ShellOutput) error {
+	if shellOutput.CmdError != nil {
+		return shellOutput.CmdError
+	}
+	return errors.New(strings.TrimSpace(shellOutput.Stderr))
 }
@@ -0,0 +1,267 @@
+package openssl
+
+import (
+	"errors"
+	"fmt"
+	"github.com/chuckpreslar/gofer"
+	log "github.com/sirupsen/logrus"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// ErrDRPassphraseRequired is returned when the DR Root CA (DR A0) key has a passphrase of its own
+// and an operation signing with it was only given the root passphrase
+var ErrDRPassphraseRequired = errors.New("the DR Root CA (DR A0) has a passphrase of its own, give it with --dr-passphrase")
+
+// RotateRequest describes the rotation of the passphrase of a CA key
+type RotateRequest struct {
+	// CA is a0, dr-a0 or the ID of an A1 or A2
+	CA            string
+	Passphrase    string
+	NewPassphrase string
+	// ArchivePassphrase encrypts the rebuilt A1 archive in output/, defaults to NewPassphrase
+	ArchivePassphrase string
+}
+
+// PassphraseRotation describes the keys re-encrypted by a passphrase rotation
+type PassphraseRotation struct {
+	Rotated string   `json:"rotated"`
+	CAs     []string `json:"cas"`
+	Keys    []string `json:"keys"`
+	// Archives are the A1 archives in output/ rebuilt with the new key
+	Archives []string `json:"archives,omitempty"`
+	// Removed are cleartext copies of the key left by older privki versions
+	Removed []string `json:"removed,omitempty"`
+	// DRPassphraseDistinct is set when the DR A0 key no longer shares the passphrase of the A0
+	DRPassphraseDistinct bool `json:"dr_passphrase_distinct"`
+}
+
+// rotationTarget is a CA whose keys are re-encrypted
+type rotationTarget struct {
+	name  string
+	caDir string
+	keys  []string
+}
+
+var taskRotateKey = gofer.Register(gofer.Task{
+	Namespace:   "Key",
+	Label:       "Rotate",
+	Description: "Re-encrypt a private key under a new passphrase",
+	Action: func(arguments ...string) error {
+
+		keyFile := arguments[0]
+		rotatedFile := arguments[1]
+		opensslPassinString := arguments[2]
+		opensslPassoutString := arguments[3]
+		opensslNewPassinString := arguments[4]
+
+		rotateCmd := "umask 077 && openssl pkey -in " + shellQuote(keyFile) + " " + opensslPassinString + "-aes256 " + opensslPassoutString + "-out " + shellQuote(rotatedFile) +
+			" && openssl pkey -in " + shellQuote(rotatedFile) + " " + opensslNewPassinString + "-noout"
+		shellOutput := execute(rotateCmd)
+		if shellOutput.CmdError != nil {
+			log.Printf("\nUnable to re-encrypt the private key at %v, is this the right passphrase for it?\n", keyFile)
+			return shellError(shellOutput)
+		}
+		return nil
+	},
+})
+
+// DRPassphraseDistinct reports whether the DR Root CA (DR A0) key has a passphrase of its own
+func DRPassphraseDistinct() bool {
+	value, err := ioutil.ReadFile(GetDRPassphraseConfigFile())
+	return err == nil && strings.TrimSpace(string(value)) == "distinct"
+}
+
+// resolveDRPassphrase returns the passphrase of the DR Root CA key, the root passphrase
+// unless the DR A0 has its own
+func resolveDRPassphrase(rootPassphrase string, drPassphrase string) (string, error) {
+	if drPassphrase != "" {
+		return drPassphrase, nil
+	}
+	if DRPassphraseDistinct() {
+		return "", ErrDRPassphraseRequired
+	}
+	return rootPassphrase, nil
+}
+
+// recordDRPassphrase records whether the DR Root CA key has a passphrase of its own
+func recordDRPassphrase(distinct bool) error {
+	if !distinct {
+		if err := os.Remove(GetDRPassphraseConfigFile()); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+		return nil
+	}
+	return ioutil.WriteFile(GetDRPassphraseConfigFile(), []byte("distinct\n"), 0644)
+}
+
+// RotatePassphrase re-encrypts the private key of a CA under a new passphrase. Every key of
+// the CA is re-encrypted aside first, the current passphrase is wrong or a key fails and
+// none is replaced. Rotating the A0 rotates the DR A0 along while they share a passphrase,
+// rotating the DR A0 alone gives it a passphrase of its own. The A1 archive in output/ holds
+// the key under the former passphrase, it is rebuilt from the A1.
+func RotatePassphrase(request RotateRequest) (*PassphraseRotation, error) {
+	pkiPath, err := GetPkiPath()
+	if err != nil {
+		return nil, err
+	}
+	rootCertUID, err := GetRootUID()
+	if err != nil {
+		return nil, err
+	}
+	if request.Passphrase == request.NewPassphrase {
+		return nil, errors.New("the new passphrase is the current one")
+	}
+	targets, err := rotationTargets(pkiPath, rootCertUID, request.CA)
+	if err != nil {
+		return nil, err
+	}
+	log.Printf("\nRotating the passphrase of %v\n", request.CA)
+
+	rotation := &PassphraseRotation{Rotated: time.Now().UTC().Format(time.RFC3339)}
+	var rotated []string
+	discard := func() {
+		for _, rotatedFile := range rotated {
+			os.Remove(rotatedFile)
+		}
+	}
+	for _, target := range targets {
+		for _, keyFile := range target.keys {
+			rotatedFile := keyFile + ".rotated"
+			rotated = append(rotated, rotatedFile)
+			if err := gofer.Perform("Key:Rotate", keyFile, rotatedFile, opensslPassin(request.Passphrase), opensslPassout(request.NewPassphrase), opensslPassin(request.NewPassphrase)); err != nil {
+				discard()
+				return nil, fmt.Errorf("%v: %v", target.name, err)
+			}
+		}
+	}
+	for _, target := range targets {
+		for _, keyFile := range target.keys {
+			if err := replaceKey(keyFile, keyFile+".rotated"); err != nil {
+				discard()
+				return rotation, err
+			}
+			rotation.Keys = append(rotation.Keys, keyFile)
+		}
+		rotation.CAs = append(rotation.CAs, target.name)
+		if err := finishRotation(pkiPath, rootCertUID, target, request, rotation); err != nil {
+			return rotation, err
+		}
+	}
+
+	if request.CA == "a0" || request.CA == "dr-a0" {
+		distinct := false
+		if DREnabled() && len(targets) == 1 {
+			// the other root shares the new passphrase when it unlocks its key
+			other := DRRootCADir(pkiPath, rootCertUID)
+			if request.CA == "dr-a0" {
+				other = RootCADir(pkiPath, rootCertUID)
+			}
+			distinct = gofer.Perform("A0:CheckKey", other, opensslPassin(request.NewPassphrase)) != nil
+		}
+		if err := recordDRPassphrase(distinct); err != nil {
+			return rotation, err
+		}
+		rotation.DRPassphraseDistinct = distinct
+	}
+	return rotation, nil
+}
+
+// rotationTargets returns the CAs whose keys a rotation of id re-encrypts
+func rotationTargets(pkiPath string, rootCertUID string, id string) ([]rotationTarget, error) {
+	rootTarget := func(name string, caDir string) rotationTarget {
+		return rotationTarget{name: name, caDir: caDir, keys: []string{filepath.Join(caDir, "private", "root-ca.key.pem")}}
+	}
+	switch id {
+	case "a0":
+		if err := RequireRootVault(); err != nil {
+			return nil, err
+		}
+		targets := []rotationTarget{rootTarget("a0", RootCADir(pkiPath, rootCertUID))}
+		if DREnabled() && !DRPassphraseDistinct() {
+			targets = append(targets, rootTarget("dr-a0", DRRootCADir(pkiPath, rootCertUID)))
+		}
+		return targets, nil
+	case "dr-a0":
+		if err := RequireRootVault(); err != nil {
+			return nil, err
+		}
+		if !DREnabled() {
+			return nil, errors.New("DR is not enabled on this vault, there is no DR Root CA key")
+		}
+		return []rotationTarget{rootTarget("dr-a0", DRRootCADir(pkiPath, rootCertUID))}, nil
+	}
+	intermediate, err := FindIntermediate(pkiPath, rootCertUID, id)
+	if err != nil {
+		return nil, err
+	}
+	if intermediate.ExternalKey {
+		return nil, ErrExternalKey
+	}
+	target := rotationTarget{name: id, caDir: intermediate.Dir}
+	// ceremony and CSR A1s keep a copy of their key as intermed-ca.key
+	for _, name := range []string{"intermed-ca.key.pem", "intermed-ca.key"} {
+		if keyFile := filepath.Join(intermediate.Dir, "private", name); fileExists(keyFile) {
+			target.keys = append(target.keys, keyFile)
+		}
+	}
+	if len(target.keys) == 0 {
+		return nil, fmt.Errorf("%v has no private key in the vault", id)
+	}
+	return []rotationTarget{target}, nil
+}
+
+// replaceKey moves a re-encrypted key over the key, with the permissions of the key
+func replaceKey(keyFile string, rotatedFile string) error {
+	info, err := os.Stat(keyFile)
+	if err != nil {
+		return err
+	}
+	if err := os.Chmod(rotatedFile, info.Mode().Perm()); err != nil {
+		return err
+	}
+	return os.Rename(rotatedFile, keyFile)
+}
+
+// finishRotation drops the cleartext key copies of a CA, rebuilds its A1 archive and
+// records the rotation in the audit trail
+func finishRotation(pkiPath string, rootCertUID string, target rotationTarget, request RotateRequest, rotation *PassphraseRotation) error {
+	cleartextKey := filepath.Join(target.caDir, "private", "intermed-ca-pkcs1.key.pem")
+	if fileExists(cleartextKey) {
+		if err := os.Remove(cleartextKey); err != nil {
+			return err
+		}
+		rotation.Removed = append(rotation.Removed, cleartextKey)
+	}
+	archiveFile := filepath.Join(pkiPath, "output", filepath.Base(target.caDir)+".zip")
+	if fileExists(archiveFile) {
+		archivePassphrase := request.ArchivePassphrase
+		if archivePassphrase == "" {
+			archivePassphrase = request.NewPassphrase
+		}
+		if err := encryptedArchive(target.caDir, filepath.Base(target.caDir), archiveFile+".tmp", archivePassphrase); err != nil {
+			os.Remove(archiveFile + ".tmp")
+			return err
+		}
+		if err := os.Rename(archiveFile+".tmp", archiveFile); err != nil {
+			return err
+		}
+		rotation.Archives = append(rotation.Archives, archiveFile)
+	}
+
+	certificateName := "intermed-ca.cert.pem"
+	if target.name == "a0" || target.name == "dr-a0" {
+		certificateName = "root-ca.cert.pem"
+	}
+	entry := AuditEntry{Action: "rotate-passphrase"}
+	if certificate, err := ReadCertificate(filepath.Join(target.caDir, certificateName)); err == nil {
+		entry.Serial = SerialHex(certificate.SerialNumber)
+	}
+	if err := recordAudit(target.caDir, entry); err != nil {
+		log.Warnf("Unable to record the passphrase rotation of %v in the audit trail: %v", target.name, err)
+	}
+	return nil
+}
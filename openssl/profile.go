@@ -0,0 +1,102 @@
+package openssl
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+)
+
+// profilesFileName holds the named leaf profiles of a vault, inside the PKI dir
+const profilesFileName = "profiles.json"
+
+// Profile is a named leaf certificate profile, it picks the extensions of a built-in
+// profile and sets the defaults of the certificates issued with it
+type Profile struct {
+	Name string `json:"name" mapstructure:"-"`
+	// Usage is the built-in profile giving the extensions, server, client or user
+	Usage              string `json:"usage" mapstructure:"usage"`
+	Days               int    `json:"days,omitempty" mapstructure:"days"`
+	Organization       string `json:"organization,omitempty" mapstructure:"organization"`
+	OrganizationalUnit string `json:"organizational_unit,omitempty" mapstructure:"organizational_unit"`
+}
+
+// Validate checks the usage and defaults of a profile
+func (profile Profile) Validate() error {
+	if _, builtIn := certificateProfiles[profile.Name]; builtIn {
+		return fmt.Errorf("profile %q is built-in and cannot be redefined", profile.Name)
+	}
+	if _, found := certificateProfiles[profile.Usage]; !found {
+		return fmt.Errorf("profile %q has usage %q, use server, client or user", profile.Name, profile.Usage)
+	}
+	if profile.Days < 0 {
+		return fmt.Errorf("profile %q has a negative validity", profile.Name)
+	}
+	return nil
+}
+
+// ReadProfiles returns the named leaf profiles of the vault at pkiPath, by name
+func ReadProfiles(pkiPath string) ([]Profile, error) {
+	profilesBytes, err := ioutil.ReadFile(filepath.Join(pkiPath, profilesFileName))
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var profiles []Profile
+	if err := json.Unmarshal(profilesBytes, &profiles); err != nil {
+		return nil, fmt.Errorf("%v: %v", profilesFileName, err)
+	}
+	return profiles, nil
+}
+
+// SaveProfile adds a named leaf profile to the vault at pkiPath, or replaces it
+func SaveProfile(pkiPath string, profile Profile) error {
+	if profile.Name == "" {
+		return errors.New("a profile needs a name")
+	}
+	if err := profile.Validate(); err != nil {
+		return err
+	}
+	profiles, err := ReadProfiles(pkiPath)
+	if err != nil {
+		return err
+	}
+	saved := []Profile{profile}
+	for _, existing := range profiles {
+		if existing.Name != profile.Name {
+			saved = append(saved, existing)
+		}
+	}
+	sort.Slice(saved, func(i, j int) bool { return saved[i].Name < saved[j].Name })
+	profilesBytes, err := json.MarshalIndent(saved, "", "  ")
+	if err != nil {
+		return err
+	}
+	return writeFileAtomic(filepath.Join(pkiPath, profilesFileName), profilesBytes)
+}
+
+// resolveProfile returns the extensions section of a built-in or named profile,
+// along with the named profile whose defaults apply
+func resolveProfile(pkiPath string, name string) (string, *Profile, error) {
+	if name == "" {
+		name = "server"
+	}
+	if extensions, found := certificateProfiles[name]; found {
+		return extensions, nil, nil
+	}
+	profiles, err := ReadProfiles(pkiPath)
+	if err != nil {
+		return "", nil, err
+	}
+	for _, profile := range profiles {
+		if profile.Name == name {
+			return certificateProfiles[profile.Usage], &profile, nil
+		}
+	}
+	return "", nil, fmt.Errorf("unknown certificate profile %q, use server, client, user or a profile of the vault", name)
+}
@@ -0,0 +1,426 @@
+package openssl
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"crypto/rand"
+	"encoding/json"
+	"errors"
+	"fmt"
+	log "github.com/sirupsen/logrus"
+	"golang.org/x/crypto/scrypt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// sealedVaultVersion is bumped whenever the layout of a sealed vault changes
+const sealedVaultVersion = 1
+
+// sealedVaultSuffix names the encrypted form of the PKI dir, next to it in the privki base dir
+const sealedVaultSuffix = ".sealed"
+
+// unsealedKeyFileName keeps the master key of an unlocked vault so that privki lock can
+// seal it again without the passphrase, it is removed when the vault is locked
+const unsealedKeyFileName = "unsealed.key"
+
+// scrypt cost of the master key, 32MB of memory per derivation
+const (
+	sealScryptN = 1 << 15
+	sealScryptR = 8
+	sealScryptP = 1
+)
+
+// ErrVaultSealed is returned when the vault is encrypted and locked
+var ErrVaultSealed = errors.New("the vault is encrypted and locked, run privki unlock first")
+
+// ErrVaultNotEncrypted is returned by operations on the encrypted form of a vault that has none
+var ErrVaultNotEncrypted = errors.New("the vault is not encrypted, run privki lock --encrypt to encrypt it")
+
+// ErrWrongVaultPassphrase is returned when the vault passphrase does not decrypt the sealed vault
+var ErrWrongVaultPassphrase = errors.New("the vault passphrase is wrong or the sealed vault is corrupted")
+
+// sealedVault is the encrypted form of the PKI dir.
+// The payload is a gzipped tar of the PKI dir, encrypted with AES-256-GCM under a master
+// key derived from the vault passphrase with scrypt.
+type sealedVault struct {
+	Version int    `json:"version"`
+	RootUID string `json:"root_uid"`
+	Sealed  string `json:"sealed"`
+	KDF     string `json:"kdf"`
+	N       int    `json:"n"`
+	R       int    `json:"r"`
+	P       int    `json:"p"`
+	Salt    []byte `json:"salt"`
+	Nonce   []byte `json:"nonce"`
+	Payload []byte `json:"payload"`
+}
+
+// sealKey is a master key with the salt it was derived with
+type sealKey struct {
+	RootUID string `json:"root_uid"`
+	Salt    []byte `json:"salt"`
+	Key     []byte `json:"key"`
+}
+
+// SealedVaultFile returns the encrypted form of the PKI dir at pkiPath
+func SealedVaultFile(pkiPath string) string {
+	return filepath.Clean(pkiPath) + sealedVaultSuffix
+}
+
+func unsealedKeyFile() string {
+	return filepath.Join(GetPkiBaseDir(), unsealedKeyFileName)
+}
+
+// VaultEncrypted reports whether the vault has an encrypted form, locked or not
+func VaultEncrypted() bool {
+	pkiPath, err := readConfigValue(GetPkiPathConfigFile(), "pki")
+	return err == nil && fileExists(SealedVaultFile(pkiPath))
+}
+
+// VaultSealed tells whether only the encrypted form of the PKI dir is on disk
+func VaultSealed(pkiPath string) bool {
+	return fileExists(SealedVaultFile(pkiPath)) && !dirExists(pkiPath)
+}
+
+// EncryptVault seals the PKI dir under a new vault passphrase and removes it, turning the
+// encryption of the vault on or changing its passphrase
+func EncryptVault(passphrase string) error {
+	pkiPath, rootCertUID, err := sealSettings()
+	if err != nil {
+		return err
+	}
+	if VaultSealed(pkiPath) {
+		return ErrVaultSealed
+	}
+	salt := make([]byte, 32)
+	if _, err := rand.Read(salt); err != nil {
+		return err
+	}
+	key, err := deriveSealKey(passphrase, salt)
+	if err != nil {
+		return err
+	}
+	if err := sealPkiDir(pkiPath, &sealKey{RootUID: rootCertUID, Salt: salt, Key: key}); err != nil {
+		return err
+	}
+	log.Printf("Vault encrypted at %v, run privki unlock to use it", SealedVaultFile(pkiPath))
+	return nil
+}
+
+// SealVault seals the PKI dir of an unlocked vault with the master key it was unlocked with
+func SealVault() error {
+	pkiPath, rootCertUID, err := sealSettings()
+	if err != nil {
+		return err
+	}
+	if !fileExists(SealedVaultFile(pkiPath)) {
+		return ErrVaultNotEncrypted
+	}
+	if VaultSealed(pkiPath) {
+		log.Printf("The vault is already locked")
+		return nil
+	}
+	key, err := readSealKey(rootCertUID)
+	if err != nil {
+		return err
+	}
+	if err := sealPkiDir(pkiPath, key); err != nil {
+		return err
+	}
+	log.Printf("Vault locked at %v", SealedVaultFile(pkiPath))
+	return nil
+}
+
+// UnsealVault decrypts the sealed vault into the PKI dir, every command then works on it
+// until SealVault. decrypt turns the encryption of the vault off instead.
+func UnsealVault(passphrase string, decrypt bool) error {
+	pkiPath, rootCertUID, err := sealSettings()
+	if err != nil {
+		return err
+	}
+	sealedFile := SealedVaultFile(pkiPath)
+	if !fileExists(sealedFile) {
+		return ErrVaultNotEncrypted
+	}
+	sealed, err := readSealedVault(sealedFile)
+	if err != nil {
+		return err
+	}
+	if sealed.RootUID != rootCertUID {
+		return fmt.Errorf("%v seals the vault %v, not %v", sealedFile, sealed.RootUID, rootCertUID)
+	}
+	key, err := deriveSealKey(passphrase, sealed.Salt)
+	if err != nil {
+		return err
+	}
+	payload, err := sealed.open(key)
+	if err != nil {
+		return err
+	}
+
+	if dirExists(pkiPath) {
+		log.Printf("The vault is already unlocked")
+	} else if err := unpackPkiDir(payload, pkiPath); err != nil {
+		return err
+	}
+	if decrypt {
+		os.Remove(unsealedKeyFile())
+		if err := os.Remove(sealedFile); err != nil {
+			return err
+		}
+		log.Printf("Vault decrypted at %v, it is no longer encrypted at rest", pkiPath)
+		return nil
+	}
+	record, err := json.Marshal(sealKey{RootUID: rootCertUID, Salt: sealed.Salt, Key: key})
+	if err != nil {
+		return err
+	}
+	if err := ioutil.WriteFile(unsealedKeyFile(), record, 0600); err != nil {
+		return err
+	}
+	log.Printf("Vault unlocked at %v, run privki lock when done", pkiPath)
+	return nil
+}
+
+func sealSettings() (string, string, error) {
+	pkiPath, err := readConfigValue(GetPkiPathConfigFile(), "pki")
+	if err != nil {
+		return "", "", err
+	}
+	rootCertUID, err := GetRootUID()
+	if err != nil {
+		return "", "", err
+	}
+	return pkiPath, rootCertUID, nil
+}
+
+func deriveSealKey(passphrase string, salt []byte) ([]byte, error) {
+	return scrypt.Key([]byte(passphrase), salt, sealScryptN, sealScryptR, sealScryptP, 32)
+}
+
+func readSealKey(rootCertUID string) (*sealKey, error) {
+	record, err := ioutil.ReadFile(unsealedKeyFile())
+	if os.IsNotExist(err) {
+		return nil, errors.New("the master key of the unlocked vault is gone, run privki lock --encrypt to seal it under a passphrase")
+	} else if err != nil {
+		return nil, err
+	}
+	key := new(sealKey)
+	if err := json.Unmarshal(record, key); err != nil {
+		return nil, err
+	}
+	if key.RootUID != rootCertUID {
+		return nil, fmt.Errorf("%v holds the master key of the vault %v, not %v", unsealedKeyFile(), key.RootUID, rootCertUID)
+	}
+	return key, nil
+}
+
+func readSealedVault(sealedFile string) (*sealedVault, error) {
+	record, err := ioutil.ReadFile(sealedFile)
+	if err != nil {
+		return nil, err
+	}
+	sealed := new(sealedVault)
+	if err := json.Unmarshal(record, sealed); err != nil {
+		return nil, fmt.Errorf("%v is not a sealed vault: %v", sealedFile, err)
+	}
+	if sealed.Version != sealedVaultVersion || sealed.KDF != "scrypt" {
+		return nil, fmt.Errorf("%v is a sealed vault version %v, this privki reads version %v", sealedFile, sealed.Version, sealedVaultVersion)
+	}
+	return sealed, nil
+}
+
+// open decrypts the payload of the sealed vault, the root UID is authenticated with it
+func (sealed *sealedVault) open(key []byte) ([]byte, error) {
+	if sealed.N != sealScryptN || sealed.R != sealScryptR || sealed.P != sealScryptP {
+		return nil, fmt.Errorf("unsupported scrypt parameters N=%v r=%v p=%v", sealed.N, sealed.R, sealed.P)
+	}
+	gcm, err := newPackageCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	payload, err := gcm.Open(nil, sealed.Nonce, sealed.Payload, []byte(sealed.RootUID))
+	if err != nil {
+		return nil, ErrWrongVaultPassphrase
+	}
+	return payload, nil
+}
+
+// sealPkiDir replaces the PKI dir with its encrypted form. The sealed vault is written
+// aside and read back before the PKI dir is removed, a failure leaves the vault unlocked.
+func sealPkiDir(pkiPath string, key *sealKey) error {
+	payload, err := packPkiDir(pkiPath)
+	if err != nil {
+		return err
+	}
+	sealed := &sealedVault{
+		Version: sealedVaultVersion,
+		RootUID: key.RootUID,
+		Sealed:  time.Now().UTC().Format(time.RFC3339),
+		KDF:     "scrypt",
+		N:       sealScryptN,
+		R:       sealScryptR,
+		P:       sealScryptP,
+		Salt:    key.Salt,
+		Nonce:   make([]byte, 12),
+	}
+	if _, err := rand.Read(sealed.Nonce); err != nil {
+		return err
+	}
+	gcm, err := newPackageCipher(key.Key)
+	if err != nil {
+		return err
+	}
+	sealed.Payload = gcm.Seal(nil, sealed.Nonce, payload, []byte(sealed.RootUID))
+	record, err := json.Marshal(sealed)
+	if err != nil {
+		return err
+	}
+
+	sealedFile := SealedVaultFile(pkiPath)
+	if err := writeFileAtomic(sealedFile, record); err != nil {
+		return err
+	}
+	if err := os.Chmod(sealedFile, 0600); err != nil {
+		return err
+	}
+	written, err := readSealedVault(sealedFile)
+	if err != nil {
+		return err
+	}
+	if unsealed, err := written.open(key.Key); err != nil || !bytes.Equal(unsealed, payload) {
+		return fmt.Errorf("%v does not read back, the vault is left unlocked", sealedFile)
+	}
+	if err := os.RemoveAll(pkiPath); err != nil {
+		return err
+	}
+	if err := os.Remove(unsealedKeyFile()); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	return nil
+}
+
+// packPkiDir returns a gzipped tar of the PKI dir, keeping the permissions of its keys
+func packPkiDir(pkiPath string) ([]byte, error) {
+	var payload bytes.Buffer
+	gzipWriter := gzip.NewWriter(&payload)
+	tarWriter := tar.NewWriter(gzipWriter)
+	err := filepath.Walk(pkiPath, func(file string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		relative, err := filepath.Rel(pkiPath, file)
+		if err != nil || relative == "." {
+			return err
+		}
+		if !info.IsDir() && !info.Mode().IsRegular() {
+			return fmt.Errorf("unable to seal %v, only files and directories can be sealed", file)
+		}
+		header, err := tar.FileInfoHeader(info, "")
+		if err != nil {
+			return err
+		}
+		header.Name = filepath.ToSlash(relative)
+		if err := tarWriter.WriteHeader(header); err != nil {
+			return err
+		}
+		if info.IsDir() {
+			return nil
+		}
+		content, err := os.Open(file)
+		if err != nil {
+			return err
+		}
+		defer content.Close()
+		_, err = io.Copy(tarWriter, content)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	if err := tarWriter.Close(); err != nil {
+		return nil, err
+	}
+	if err := gzipWriter.Close(); err != nil {
+		return nil, err
+	}
+	return payload.Bytes(), nil
+}
+
+// unpackPkiDir restores a packed PKI dir at pkiPath, it is unpacked aside and renamed into place
+func unpackPkiDir(payload []byte, pkiPath string) error {
+	unpackDir := filepath.Clean(pkiPath) + ".unsealing"
+	if err := os.RemoveAll(unpackDir); err != nil {
+		return err
+	}
+	if err := os.Mkdir(unpackDir, 0700); err != nil {
+		return err
+	}
+	gzipReader, err := gzip.NewReader(bytes.NewReader(payload))
+	if err != nil {
+		os.RemoveAll(unpackDir)
+		return err
+	}
+	tarReader := tar.NewReader(gzipReader)
+	// dirs get their permissions once their files are in, a read only dir would refuse them
+	dirModes := map[string]os.FileMode{}
+	for {
+		header, err := tarReader.Next()
+		if err == io.EOF {
+			break
+		} else if err != nil {
+			os.RemoveAll(unpackDir)
+			return err
+		}
+		name := path.Clean(header.Name)
+		if path.IsAbs(name) || strings.HasPrefix(name, "..") {
+			os.RemoveAll(unpackDir)
+			return fmt.Errorf("sealed vault contains an unsafe path %v", header.Name)
+		}
+		target := filepath.Join(unpackDir, filepath.FromSlash(name))
+		mode := os.FileMode(header.Mode).Perm()
+		if header.Typeflag == tar.TypeDir {
+			if err := os.MkdirAll(target, 0700); err != nil {
+				os.RemoveAll(unpackDir)
+				return err
+			}
+			dirModes[target] = mode
+			continue
+		}
+		if err := unpackFile(tarReader, target, mode); err != nil {
+			os.RemoveAll(unpackDir)
+			return err
+		}
+	}
+	for dir, mode := range dirModes {
+		if err := os.Chmod(dir, mode); err != nil {
+			os.RemoveAll(unpackDir)
+			return err
+		}
+	}
+	if err := os.Chmod(unpackDir, 0700); err != nil {
+		return err
+	}
+	return os.Rename(unpackDir, pkiPath)
+}
+
+func unpackFile(content io.Reader, target string, mode os.FileMode) error {
+	if err := os.MkdirAll(filepath.Dir(target), 0700); err != nil {
+		return err
+	}
+	output, err := os.OpenFile(target, os.O_CREATE|os.O_EXCL|os.O_WRONLY, mode)
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(output, content); err != nil {
+		output.Close()
+		return err
+	}
+	return output.Close()
+}
@@ -1,7 +1,6 @@
 package openssl
 
 import (
-	"fmt"
 	"github.com/chuckpreslar/gofer"
 	pkger "github.com/markbates/pkger"
 	log "github.com/sirupsen/logrus"
@@ -22,19 +21,19 @@ var taskCreateRootUID = gofer.Register(gofer.Task{
 
 		createDirCmd := "mkdir -p " + GetPkiConfigDir()
 		shellOutput := new(shell.ShellOutput)
-		shellOutput = shell.Execute(createDirCmd, false, false)
+		shellOutput = execute(createDirCmd)
 
 		//Handle folder permission errors in user home
-		if shellOutput.Stderr != "" {
+		if shellOutput.CmdError != nil {
 			log.Printf("Found previous config : %v\nIf you are sure you do not need this, please delete this folder and retry\n", GetPkiConfigDir())
-			return shellOutput.CmdError
+			return shellError(shellOutput)
 		}
 
 		saveRootUIDCmd := "echo " + rootCertUID + " > " + GetPkiConfigDir() + "/root_cert_uid"
-		shellOutput = shell.Execute(saveRootUIDCmd, false, false)
-		if shellOutput.Stderr != "" {
+		shellOutput = execute(saveRootUIDCmd)
+		if shellOutput.CmdError != nil {
 			log.Printf("\nUnable to write to file : %v/root_cert_uid\n", GetPkiConfigDir())
-			return shellOutput.CmdError
+			return shellError(shellOutput)
 		}
 		return nil
 	},
@@ -51,31 +50,31 @@ var taskInitPki = gofer.Register(gofer.Task{
 		savePkiPathCmd := "echo " + currentPkiPath + " > " + GetPkiPathConfigFile()
 
 		shellOutput := new(shell.ShellOutput)
-		shellOutput = shell.Execute(savePkiPathCmd, false, false)
+		shellOutput = execute(savePkiPathCmd)
 
 		//Handle other write permission or change of permission errors.
-		if shellOutput.Stderr != "" {
+		if shellOutput.CmdError != nil {
 			log.Printf("Unable to write to : %vnPlease check permissions\n", GetPkiPathConfigFile())
-			return shellOutput.CmdError
+			return shellError(shellOutput)
 		}
 
 		//Creating PKI Base Directory
 		createPkiBaseDirectory := "mkdir -p " + currentPkiPath
-		shellOutput = shell.Execute(createPkiBaseDirectory, false, false)
+		shellOutput = execute(createPkiBaseDirectory)
 
 		//Handle errors if unable to create PKI Base
-		if shellOutput.Stderr != "" {
+		if shellOutput.CmdError != nil {
 			log.Printf("Unable to create : %v/sfcert_pki\nPlease check permissions\n", currentPkiPath)
-			return shellOutput.CmdError
+			return shellError(shellOutput)
 		}
 
 		//Creating Other config files.
 		createPrimaryRootConfigCmd := "touch " + GetPrimaryRootConfigFile()
 		//Handle other write permission or change of permission errors.
-		shellOutput = shell.Execute(createPrimaryRootConfigCmd, false, false)
-		if shellOutput.Stderr != "" {
+		shellOutput = execute(createPrimaryRootConfigCmd)
+		if shellOutput.CmdError != nil {
 			log.Printf("\nUnable to write to: %v\nPlease check permissions\n", GetPkiConfigDir())
-			return shellOutput.CmdError
+			return shellError(shellOutput)
 		}
 
 		log.Printf("Successfully initialized new PKI Repository at %v/sfcert_pki\nYou can now proceed to create/add certs to this repo\n", currentPkiPath)
@@ -83,6 +82,23 @@ var taskInitPki = gofer.Register(gofer.Task{
 	},
 })
 
+var taskSaveVaultMode = gofer.Register(gofer.Task{
+	Namespace:   "PKI",
+	Label:       "SaveMode",
+	Description: "Task to record whether the vault holds the Root CA (A0) or is subordinate to it",
+	Action: func(arguments ...string) error {
+
+		vaultMode := arguments[0]
+		saveModeCmd := "echo " + vaultMode + " > " + GetModeConfigFile()
+		shellOutput := execute(saveModeCmd)
+		if shellOutput.CmdError != nil {
+			log.Printf("\nUnable to write to file : %v\n", GetModeConfigFile())
+			return shellError(shellOutput)
+		}
+		return nil
+	},
+})
+
 // Root CA (A0) related task definitions
 var taskRootCAPrepWork = gofer.Register(gofer.Task{
 	Namespace:   "A0",
@@ -90,29 +106,29 @@ var taskRootCAPrepWork = gofer.Register(gofer.Task{
 	Description: "Task to Prepare structure and permissions for Root CA (A0)",
 	Action: func(arguments ...string) error {
 
-		pkiPathFromConfig := GetPkiPath()
-		rootCertUID := GetRootUID()
+		pkiPathFromConfig := arguments[0]
+		rootCertUID := arguments[1]
 		//use pkiPathFromConfig location and root uid as seed information to create the required root certs.
 		createRootCertDirCmd := "mkdir -p " + pkiPathFromConfig + "/" + rootCertUID + "-root-ca/{certreqs,certs,crl,newcerts,private}"
 		setRootCertPermsCmd := "cd " + pkiPathFromConfig + "/" + rootCertUID + "-root-ca/ && chmod 700 private && touch ./root-ca.index && echo 00 > ./root-ca.crlnum"
 		createRandSerialCmd := "cd " + pkiPathFromConfig + "/" + rootCertUID + "-root-ca/ && openssl rand -hex 16 > ./root-ca.serial"
 
 		shellOutput := new(shell.ShellOutput)
-		shellOutput = shell.Execute(createRootCertDirCmd, false, false)
+		shellOutput = execute(createRootCertDirCmd)
 
-		if shellOutput.Stderr != "" {
+		if shellOutput.CmdError != nil {
 			log.Printf("\nUnable to write to folder : %v/%v-root-ca\n", pkiPathFromConfig, rootCertUID)
-			return shellOutput.CmdError
+			return shellError(shellOutput)
 		}
-		shellOutput = shell.Execute(setRootCertPermsCmd, false, false)
-		if shellOutput.Stderr != "" {
+		shellOutput = execute(setRootCertPermsCmd)
+		if shellOutput.CmdError != nil {
 			log.Printf("\nUnable to write to folder : %v/%v-root-ca\n", pkiPathFromConfig, rootCertUID)
-			return shellOutput.CmdError
+			return shellError(shellOutput)
 		}
-		shellOutput = shell.Execute(createRandSerialCmd, false, false)
-		if shellOutput.Stderr != "" {
+		shellOutput = execute(createRandSerialCmd)
+		if shellOutput.CmdError != nil {
 			log.Printf("\nUnable to write to folder : %v/%v-root-ca\n", pkiPathFromConfig, rootCertUID)
-			return shellOutput.CmdError
+			return shellError(shellOutput)
 		}
 		return nil
 	},
@@ -126,7 +142,7 @@ var taskRootSetCustomOID = gofer.Register(gofer.Task{
 		customOID := arguments[0]
 		setCustomOIDCmd := "echo " + customOID + " > " + GetOidConfigFile()
 		shellOutput := new(shell.ShellOutput)
-		shellOutput = shell.Execute(setCustomOIDCmd, false, false)
+		shellOutput = execute(setCustomOIDCmd)
 		return shellOutput.CmdError
 	},
 })
@@ -139,7 +155,7 @@ var taskRootSetOrganizationName = gofer.Register(gofer.Task{
 		organizationName := arguments[0]
 		setOrganizationNameCmd := "echo " + organizationName + " > " + GetOrgNameConfigFile()
 		shellOutput := new(shell.ShellOutput)
-		shellOutput = shell.Execute(setOrganizationNameCmd, false, false)
+		shellOutput = execute(setOrganizationNameCmd)
 		return shellOutput.CmdError
 	},
 })
@@ -152,7 +168,7 @@ var taskRootSetOrganizationCommonName = gofer.Register(gofer.Task{
 		organizationCommonName := arguments[0]
 		setOrganizationCommonNameCmd := "echo " + organizationCommonName + " > " + GetOrgCommonNameConfigFile()
 		shellOutput := new(shell.ShellOutput)
-		shellOutput = shell.Execute(setOrganizationCommonNameCmd, false, false)
+		shellOutput = execute(setOrganizationCommonNameCmd)
 		return shellOutput.CmdError
 	},
 })
@@ -162,6 +178,10 @@ var taskRootCAConfig = gofer.Register(gofer.Task{
 	Label:       "Config",
 	Description: "Task to write Root CA (A0) Configuration",
 	Action: func(arguments ...string) error {
+		settings, err := readVaultSettings()
+		if err != nil {
+			return err
+		}
 
 		pkiPathFromConfig := arguments[0]
 		rootCertUID := arguments[1]
@@ -184,69 +204,69 @@ var taskRootCAConfig = gofer.Register(gofer.Task{
 			return err
 		}
 
-		setOidCmd := "cd " + pkiPathFromConfig + "/" + rootCertUID + "-root-ca/ && sed -i \"s/#customOID/" + GetOid() + "/g\" root-ca.cnf"
+		setOidCmd := "cd " + pkiPathFromConfig + "/" + rootCertUID + "-root-ca/ && sed -i \"s/#customOID/" + settings.oid + "/g\" root-ca.cnf"
 		shellOutput := new(shell.ShellOutput)
-		shellOutput = shell.Execute(setOidCmd, false, false)
+		shellOutput = execute(setOidCmd)
 		if shellOutput.CmdError != nil {
 			log.Printf("\nUnable to save active OID in config at %v/%v-root-ca/root-ca.cnf\n", pkiPathFromConfig, rootCertUID)
-			return shellOutput.CmdError
+			return shellError(shellOutput)
 		}
 
-		setOrganizationNameCmd := "cd " + pkiPathFromConfig + "/" + rootCertUID + "-root-ca/ && sed -i \"s/sample_org Cloud Corporation/" + GetOrganizationName() + "/g\" root-ca.cnf"
-		shellOutput = shell.Execute(setOrganizationNameCmd, false, false)
+		setOrganizationNameCmd := "cd " + pkiPathFromConfig + "/" + rootCertUID + "-root-ca/ && sed -i \"s/sample_org Cloud Corporation/" + settings.organization + "/g\" root-ca.cnf"
+		shellOutput = execute(setOrganizationNameCmd)
 		if shellOutput.CmdError != nil {
 			log.Printf("\nUnable to save Organization Name in config at %v/%v-root-ca/root-ca.cnf\n", pkiPathFromConfig, rootCertUID)
-			return shellOutput.CmdError
+			return shellError(shellOutput)
 		}
 
 		removeSubAltNameCmd := "cd " + pkiPathFromConfig + "/" + rootCertUID + "-root-ca/ && sed -i \"s/subjectAltName/#subjectAltName/g\" root-ca.cnf"
-		shellOutput = shell.Execute(removeSubAltNameCmd, false, false)
+		shellOutput = execute(removeSubAltNameCmd)
 		if shellOutput.CmdError != nil {
 			log.Printf("\nUnable to remove Subject Alt Name requirements from config at %v/%v-root-ca/root-ca.cnf\n", pkiPathFromConfig, rootCertUID)
-			return shellOutput.CmdError
+			return shellError(shellOutput)
 		}
 
-		orgFirstName := strings.Fields(GetOrganizationName())
+		orgFirstName := strings.Fields(settings.organization)
 		ordDomainEncodeCmd := "cd " + pkiPathFromConfig + "/" + rootCertUID + "-root-ca/ && sed -i \"s/sfcc.tech/" + orgFirstName[0] + ".tech/g\" root-ca.cnf"
-		shellOutput = shell.Execute(ordDomainEncodeCmd, false, false)
+		shellOutput = execute(ordDomainEncodeCmd)
 		if shellOutput.CmdError != nil {
 			log.Printf("\nUnable to remove CA Issuers requirements from config at %v/%v-root-ca/root-ca.cnf\n", pkiPathFromConfig, rootCertUID)
-			return shellOutput.CmdError
+			return shellError(shellOutput)
 		}
 
-		setOrganizationCommonNameCmd := "cd " + pkiPathFromConfig + "/" + rootCertUID + "-root-ca/ && sed -i \"s/sample_org Root Certification Authority/" + GetOrganizationCommonName() + "/g\" root-ca.cnf"
-		shellOutput = shell.Execute(setOrganizationCommonNameCmd, false, false)
+		setOrganizationCommonNameCmd := "cd " + pkiPathFromConfig + "/" + rootCertUID + "-root-ca/ && sed -i \"s/sample_org Root Certification Authority/" + settings.commonName + "/g\" root-ca.cnf"
+		shellOutput = execute(setOrganizationCommonNameCmd)
 		if shellOutput.CmdError != nil {
 			log.Printf("\nUnable to save Organization Common Name in config at %v/%v-root-ca/root-ca.cnf\n", pkiPathFromConfig, rootCertUID)
-			return shellOutput.CmdError
+			return shellError(shellOutput)
 		}
 
 		removeExtentionsInRootCmd := "cd " + pkiPathFromConfig + "/" + rootCertUID + "-root-ca/ && sed -i \"s/crl_extensions/#crl_extensions/g\" root-ca.cnf"
-		shellOutput = shell.Execute(removeExtentionsInRootCmd, false, false)
+		shellOutput = execute(removeExtentionsInRootCmd)
 		if shellOutput.CmdError != nil {
 			log.Printf("\nUnable to remove crl extentions in config at %v/%v-root-ca/root-ca.cnf\n", pkiPathFromConfig, rootCertUID)
-			return shellOutput.CmdError
+			return shellError(shellOutput)
 		}
 
 		removeIssuerAltInRootCmd := "cd " + pkiPathFromConfig + "/" + rootCertUID + "-root-ca/ && sed -i \"s/issuerAltName/#issuerAltName/g\" root-ca.cnf"
-		shellOutput = shell.Execute(removeIssuerAltInRootCmd, false, false)
+		shellOutput = execute(removeIssuerAltInRootCmd)
 		if shellOutput.CmdError != nil {
 			log.Printf("\nUnable to remove issuer alternative names in config at %v/%v-root-ca/root-ca.cnf\n", pkiPathFromConfig, rootCertUID)
-			return shellOutput.CmdError
+			return shellError(shellOutput)
 		}
 
 		crlDistIssuerAltInRootCmd := "cd " + pkiPathFromConfig + "/" + rootCertUID + "-root-ca/ && sed -i \"s/crlDistributionPoints/#crlDistributionPoints/g\" root-ca.cnf"
-		shellOutput = shell.Execute(crlDistIssuerAltInRootCmd, false, false)
+		shellOutput = execute(crlDistIssuerAltInRootCmd)
 		if shellOutput.CmdError != nil {
 			log.Printf("\nUnable to crl distribution points in config at %v/%v-root-ca/root-ca.cnf\n", pkiPathFromConfig, rootCertUID)
-			return shellOutput.CmdError
+			return shellError(shellOutput)
 		}
 
 		authInfoAltInRootCmd := "cd " + pkiPathFromConfig + "/" + rootCertUID + "-root-ca/ && sed -i \"s/authorityInfoAccess/#authorityInfoAccess/g\" root-ca.cnf"
-		shellOutput = shell.Execute(authInfoAltInRootCmd, false, false)
+		shellOutput = execute(authInfoAltInRootCmd)
 		if shellOutput.CmdError != nil {
 			log.Printf("\nUnable to Authority Info access in config at %v/%v-root-ca/root-ca.cnf\n", pkiPathFromConfig, rootCertUID)
-			return shellOutput.CmdError
+			return shellError(shellOutput)
 		}
 
 		return nil
@@ -259,47 +279,51 @@ var taskRootCACreate = gofer.Register(gofer.Task{
 	Description:  "Create Root CA (A0)",
 	Dependencies: []string{"A0:Prepare", "A0:Config"},
 	Action: func(arguments ...string) error {
+		settings, err := readVaultSettings()
+		if err != nil {
+			return err
+		}
 
 		pkiPathFromConfig := arguments[0]
 		rootCertUID := arguments[1]
 
 		t := time.Now().UTC()
-		startDate := fmt.Sprintf(t.AddDate(0, 0, -1).Format("20060102150405Z"))
-		expiryDate := fmt.Sprintf(t.AddDate(30, 0, 0).Format("20060102150405Z"))
+		startDate := t.AddDate(0, 0, -1).Format("20060102150405Z")
+		expiryDate := t.AddDate(30, 0, 0).Format("20060102150405Z")
 		opensslPassoutString := arguments[2]
 		opensslPassinString := arguments[3]
 
 		opensslCSRCmd := "cd " + pkiPathFromConfig + "/" + rootCertUID + "-root-ca/ && export OPENSSL_CONF=./root-ca.cnf && openssl req " + opensslPassoutString + opensslPassinString + "-new -out root-ca.req.pem"
 		shellOutput := new(shell.ShellOutput)
-		shellOutput = shell.Execute(opensslCSRCmd, true, false)
+		shellOutput = execute(opensslCSRCmd)
 		if shellOutput.CmdError != nil {
 			log.Printf("\nCSR Generation error for root at location : %v/%v-root-ca\n", pkiPathFromConfig, rootCertUID)
-			return shellOutput.CmdError
+			return shellError(shellOutput)
 		}
 
 		opensslSelfSignA0Cmd := "cd " + pkiPathFromConfig + "/" + rootCertUID + "-root-ca/ && export OPENSSL_CONF=./root-ca.cnf && openssl rand -hex 16 > root-ca.serial && openssl ca " + opensslPassinString + "-selfsign -in root-ca.req.pem -out root-ca.cert.pem -extensions root-ca_ext -batch -startdate " + startDate + " -enddate " + expiryDate
-		shellOutput = shell.Execute(opensslSelfSignA0Cmd, true, true)
+		shellOutput = execute(opensslSelfSignA0Cmd)
 		if shellOutput.CmdError != nil {
 			log.Printf("\nCSR Generation error for root at location : %v/%v-root-ca\n", pkiPathFromConfig, rootCertUID)
-			return shellOutput.CmdError
+			return shellError(shellOutput)
 		}
 
-		caCertName := strings.ReplaceAll(GetOrganizationName()+"_"+GetOrganizationCommonName()+"_"+"RA0_"+rootCertUID+".pem", " ", "-")
+		caCertName := strings.ReplaceAll(settings.organization+"_"+settings.commonName+"_"+"RA0_"+rootCertUID+".pem", " ", "-")
 		selfSignA0CertCopyCmd := "cp " + pkiPathFromConfig + "/" + rootCertUID + "-root-ca/root-ca.cert.pem  " + pkiPathFromConfig + "/" + rootCertUID + "-root-ca/" + caCertName
-		shellOutput = shell.Execute(selfSignA0CertCopyCmd, true, true)
+		shellOutput = execute(selfSignA0CertCopyCmd)
 		if shellOutput.CmdError != nil {
 			log.Printf("\nCSR Generation error for root at location : %v/%v-root-ca\n", pkiPathFromConfig, rootCertUID)
-			return shellOutput.CmdError
+			return shellError(shellOutput)
 		}
 
 		log.Printf("\n\n\t*************************************\n\tRoot Cert: %v/%v-root-ca/%v created!\n\tStart Date : %v, Expiry Date : %v\n\t*************************************\n", pkiPathFromConfig, rootCertUID, caCertName, startDate, expiryDate)
 
 		opensslRevocationCmd := "cd " + pkiPathFromConfig + "/" + rootCertUID + "-root-ca/ && export OPENSSL_CONF=./root-ca.cnf && openssl ca " + opensslPassinString + "-gencrl -out crl/root-ca.crl -batch"
-		fmt.Printf("\n\tRevocation List at %v/%v-root-ca/crl/root-ca.crl\n\n", pkiPathFromConfig, rootCertUID)
-		shellOutput = shell.Execute(opensslRevocationCmd, true, false)
+		log.Printf("\n\tRevocation List at %v/%v-root-ca/crl/root-ca.crl\n\n", pkiPathFromConfig, rootCertUID)
+		shellOutput = execute(opensslRevocationCmd)
 		if shellOutput.CmdError != nil {
 			log.Printf("\nRevocation Generation error for root at location : %v/%v-root-ca\n", pkiPathFromConfig, rootCertUID)
-			return shellOutput.CmdError
+			return shellError(shellOutput)
 		}
 
 		return nil
@@ -313,28 +337,28 @@ var taskDRRootCAPrepWork = gofer.Register(gofer.Task{
 	Description: "Task to Prepare structure and permissions for DR Root CA (A0)",
 	Action: func(arguments ...string) error {
 
-		pkiPathFromConfig := GetPkiPath()
-		rootCertUID := GetRootUID()
+		pkiPathFromConfig := arguments[0]
+		rootCertUID := arguments[1]
 		//use pkiPathFromConfig location and root uid as seed information to create the required root certs.
 		createRootCertDirCmd := "mkdir -p " + pkiPathFromConfig + "/" + rootCertUID + "-dr-root-ca/{certreqs,certs,crl,newcerts,private}"
 		setRootCertPermsCmd := "cd " + pkiPathFromConfig + "/" + rootCertUID + "-dr-root-ca/ && chmod 700 private && touch ./root-ca.index && echo 00 > ./root-ca.crlnum"
 		createRandSerialCmd := "cd " + pkiPathFromConfig + "/" + rootCertUID + "-dr-root-ca/ && openssl rand -hex 16 > ./root-ca.serial"
 
 		shellOutput := new(shell.ShellOutput)
-		shellOutput = shell.Execute(createRootCertDirCmd, false, false)
-		if shellOutput.Stderr != "" {
+		shellOutput = execute(createRootCertDirCmd)
+		if shellOutput.CmdError != nil {
 			log.Printf("\nUnable to write to folder : %v/%v-dr-root-ca\n", pkiPathFromConfig, rootCertUID)
-			return shellOutput.CmdError
+			return shellError(shellOutput)
 		}
-		shellOutput = shell.Execute(setRootCertPermsCmd, false, false)
-		if shellOutput.Stderr != "" {
+		shellOutput = execute(setRootCertPermsCmd)
+		if shellOutput.CmdError != nil {
 			log.Printf("\nUnable to write to folder : %v/%v-dr-root-ca\n", pkiPathFromConfig, rootCertUID)
-			return shellOutput.CmdError
+			return shellError(shellOutput)
 		}
-		shellOutput = shell.Execute(createRandSerialCmd, false, false)
-		if shellOutput.Stderr != "" {
+		shellOutput = execute(createRandSerialCmd)
+		if shellOutput.CmdError != nil {
 			log.Printf("\nUnable to write to folder : %v/%v-dr-root-ca\n", pkiPathFromConfig, rootCertUID)
-			return shellOutput.CmdError
+			return shellError(shellOutput)
 		}
 		return nil
 	},
@@ -345,6 +369,10 @@ var taskDRRootCAConfig = gofer.Register(gofer.Task{
 	Label:       "Config",
 	Description: "Task to write DR Root CA (A0) Configuration",
 	Action: func(arguments ...string) error {
+		settings, err := readVaultSettings()
+		if err != nil {
+			return err
+		}
 		pkiPathFromConfig := arguments[0]
 		rootCertUID := arguments[1]
 
@@ -366,26 +394,26 @@ var taskDRRootCAConfig = gofer.Register(gofer.Task{
 			return err
 		}
 
-		setOidCmd := "cd " + pkiPathFromConfig + "/" + rootCertUID + "-dr-root-ca/ && sed -i \"s/#customOID/" + GetOid() + "/g\" root-ca.cnf"
+		setOidCmd := "cd " + pkiPathFromConfig + "/" + rootCertUID + "-dr-root-ca/ && sed -i \"s/#customOID/" + settings.oid + "/g\" root-ca.cnf"
 		shellOutput := new(shell.ShellOutput)
-		shellOutput = shell.Execute(setOidCmd, false, false)
+		shellOutput = execute(setOidCmd)
 		if shellOutput.CmdError != nil {
 			log.Printf("\nUnable to save active OID in config at %v/%v-dr-root-ca/root-ca.cnf\n", pkiPathFromConfig, rootCertUID)
-			return shellOutput.CmdError
+			return shellError(shellOutput)
 		}
 
-		setOrganizationNameCmd := "cd " + pkiPathFromConfig + "/" + rootCertUID + "-dr-root-ca/ && sed -i \"s/sample_org Cloud Corporation/" + GetOrganizationName() + "/g\" root-ca.cnf"
-		shellOutput = shell.Execute(setOrganizationNameCmd, false, false)
+		setOrganizationNameCmd := "cd " + pkiPathFromConfig + "/" + rootCertUID + "-dr-root-ca/ && sed -i \"s/sample_org Cloud Corporation/" + settings.organization + "/g\" root-ca.cnf"
+		shellOutput = execute(setOrganizationNameCmd)
 		if shellOutput.CmdError != nil {
 			log.Printf("\nUnable to save Organization Name in config at %v/%v-dr-root-ca/root-ca.cnf\n", pkiPathFromConfig, rootCertUID)
-			return shellOutput.CmdError
+			return shellError(shellOutput)
 		}
 
-		setOrganizationCommonNameCmd := "cd " + pkiPathFromConfig + "/" + rootCertUID + "-dr-root-ca/ && sed -i \"s/sample_org Root Certification Authority/" + GetOrganizationCommonName() + "/g\" root-ca.cnf"
-		shellOutput = shell.Execute(setOrganizationCommonNameCmd, false, false)
+		setOrganizationCommonNameCmd := "cd " + pkiPathFromConfig + "/" + rootCertUID + "-dr-root-ca/ && sed -i \"s/sample_org Root Certification Authority/" + settings.commonName + "/g\" root-ca.cnf"
+		shellOutput = execute(setOrganizationCommonNameCmd)
 		if shellOutput.CmdError != nil {
 			log.Printf("\nUnable to save Organization Common Name in config at %v/%v-dr-root-ca/root-ca.cnf\n", pkiPathFromConfig, rootCertUID)
-			return shellOutput.CmdError
+			return shellError(shellOutput)
 		}
 
 		return nil
@@ -398,57 +426,53 @@ var taskDRRootCACreate = gofer.Register(gofer.Task{
 	Description:  "Create DR Root CA (A0)",
 	Dependencies: []string{"A0DR:Prepare", "A0DR:Config"},
 	Action: func(arguments ...string) error {
+		settings, err := readVaultSettings()
+		if err != nil {
+			return err
+		}
 
 		pkiPathFromConfig := arguments[0]
 		rootCertUID := arguments[1]
 
 		t := time.Now().UTC()
-		startDate := fmt.Sprintf(t.AddDate(0, 0, -1).Format("20060102150405Z"))
-		expiryDate := fmt.Sprintf(t.AddDate(30, 0, 0).Format("20060102150405Z"))
-
-		/*
-			fmt.Printf("\n\tEnter passphrase for DR A0 : ")
-			a0Paaaphrase,_ := gopass.GetPasswdMasked()
-			opensslPassoutString := "-passout pass:" + string(a0Paaaphrase) + " "
-			opensslPassinString := "-passin pass:" + string(a0Paaaphrase) + " "
-
-		*/
+		startDate := t.AddDate(0, 0, -1).Format("20060102150405Z")
+		expiryDate := t.AddDate(30, 0, 0).Format("20060102150405Z")
 
 		opensslPassoutString := arguments[2]
 		opensslPassinString := arguments[3]
 
 		opensslCSRCmd := "cd " + pkiPathFromConfig + "/" + rootCertUID + "-dr-root-ca/ && export OPENSSL_CONF=./root-ca.cnf && openssl req " + opensslPassoutString + opensslPassinString + "-new -out root-ca.req.pem"
 		shellOutput := new(shell.ShellOutput)
-		shellOutput = shell.Execute(opensslCSRCmd, true, false)
+		shellOutput = execute(opensslCSRCmd)
 		if shellOutput.CmdError != nil {
 			log.Printf("\nCSR Generation error for Root DR at location : %v/%v-dr-root-ca\n", pkiPathFromConfig, rootCertUID)
-			return shellOutput.CmdError
+			return shellError(shellOutput)
 		}
 
 		opensslSelfSignA0Cmd := "cd " + pkiPathFromConfig + "/" + rootCertUID + "-dr-root-ca/ && export OPENSSL_CONF=./root-ca.cnf && openssl rand -hex 16 > root-ca.serial && openssl ca " + opensslPassinString + "-selfsign -in root-ca.req.pem -out root-ca.cert.pem -extensions root-ca_ext -batch -startdate " + startDate + " -enddate " + expiryDate
 		log.Printf("\nCreating Self Signed DR Root Certificate (A0)\n")
-		shellOutput = shell.Execute(opensslSelfSignA0Cmd, true, true)
+		shellOutput = execute(opensslSelfSignA0Cmd)
 		if shellOutput.CmdError != nil {
 			log.Printf("\nCSR Generation error for root at location : %v/%v-dr-root-ca\n", pkiPathFromConfig, rootCertUID)
-			return shellOutput.CmdError
+			return shellError(shellOutput)
 		}
 
-		caCertName := strings.ReplaceAll(GetOrganizationName()+"_"+GetOrganizationCommonName()+"_"+"RA0_D_"+rootCertUID+".pem", " ", "-")
+		caCertName := strings.ReplaceAll(settings.organization+"_"+settings.commonName+"_"+"RA0_D_"+rootCertUID+".pem", " ", "-")
 		selfSignA0DRCertCopyCmd := "cp " + pkiPathFromConfig + "/" + rootCertUID + "-dr-root-ca/root-ca.cert.pem  " + pkiPathFromConfig + "/" + rootCertUID + "-dr-root-ca/" + caCertName
-		shellOutput = shell.Execute(selfSignA0DRCertCopyCmd, true, true)
+		shellOutput = execute(selfSignA0DRCertCopyCmd)
 		if shellOutput.CmdError != nil {
 			log.Printf("\nCSR Generation error for root at location : %v/%v-root-ca\n", pkiPathFromConfig, rootCertUID)
-			return shellOutput.CmdError
+			return shellError(shellOutput)
 		}
 
 		log.Printf("\n\n\t*************************************\n\tDR Root Cert (A0): %v/%v-dr-root-ca/%v created!\n\tStart Date : %v, Expiry Date : %v\n\t*************************************\n", pkiPathFromConfig, rootCertUID, caCertName, startDate, expiryDate)
 
 		opensslRevocationCmd := "cd " + pkiPathFromConfig + "/" + rootCertUID + "-dr-root-ca/ && export OPENSSL_CONF=./root-ca.cnf && openssl ca " + opensslPassinString + "-gencrl -out crl/root-ca.crl -batch"
-		fmt.Printf("\n\tRevocation List at %v/%v-dr-root-ca/crl/root-ca.crl\n\n", pkiPathFromConfig, rootCertUID)
-		shellOutput = shell.Execute(opensslRevocationCmd, true, false)
+		log.Printf("\n\tRevocation List at %v/%v-dr-root-ca/crl/root-ca.crl\n\n", pkiPathFromConfig, rootCertUID)
+		shellOutput = execute(opensslRevocationCmd)
 		if shellOutput.CmdError != nil {
 			log.Printf("\nRevocation Generation error for root at location : %v/%v-dr-root-ca\n", pkiPathFromConfig, rootCertUID)
-			return shellOutput.CmdError
+			return shellError(shellOutput)
 		}
 
 		return nil
@@ -462,10 +486,10 @@ var taskDRRootCARecord = gofer.Register(gofer.Task{
 	Action: func(arguments ...string) error {
 		saveDRStatusCmd := "touch " + GetDRStatusConfigFile() + " && echo true > " + GetDRStatusConfigFile()
 		shellOutput := new(shell.ShellOutput)
-		shellOutput = shell.Execute(saveDRStatusCmd, false, false)
-		if shellOutput.Stderr != "" {
+		shellOutput = execute(saveDRStatusCmd)
+		if shellOutput.CmdError != nil {
 			log.Printf("\nUnable to write to file : %v\n", GetDRStatusConfigFile())
-			return shellOutput.CmdError
+			return shellError(shellOutput)
 		}
 		return nil
 	},
@@ -484,16 +508,16 @@ var taskIntermediaryCAPrepWork = gofer.Register(gofer.Task{
 		intermediaryPermSetCmd := "cd " + pkiPathFromConfig + "/" + rootCertUID + "-intermed-ca/ && chmod 700 private && touch intermed-ca.index && echo 00 > intermed-ca.crlnum && openssl rand -hex 16 > intermed-ca.serial"
 
 		shellOutput := new(shell.ShellOutput)
-		shellOutput = shell.Execute(intermediaryDirCreateCmd, false, false)
-		if shellOutput.Stderr != "" {
+		shellOutput = execute(intermediaryDirCreateCmd)
+		if shellOutput.CmdError != nil {
 			log.Printf("\nUnable to write to folder : %v/%v-intermed-ca/\n", pkiPathFromConfig, rootCertUID)
-			return shellOutput.CmdError
+			return shellError(shellOutput)
 		}
 
-		shellOutput = shell.Execute(intermediaryPermSetCmd, false, false)
-		if shellOutput.Stderr != "" {
+		shellOutput = execute(intermediaryPermSetCmd)
+		if shellOutput.CmdError != nil {
 			log.Printf("\nUnable to write to folder : %v/%v-intermed-ca/\n", pkiPathFromConfig, rootCertUID)
-			return shellOutput.CmdError
+			return shellError(shellOutput)
 		}
 		return nil
 	},
@@ -505,6 +529,10 @@ var taskIntermediaryCAConfig = gofer.Register(gofer.Task{
 	Description:  "Task to write Intermediary CA (A1) Configuration",
 	Dependencies: []string{"A1:Prepare"},
 	Action: func(arguments ...string) error {
+		settings, err := readVaultSettings()
+		if err != nil {
+			return err
+		}
 
 		pkiPathFromConfig := arguments[0]
 		rootCertUID := arguments[1]
@@ -530,34 +558,34 @@ var taskIntermediaryCAConfig = gofer.Register(gofer.Task{
 
 		setOrgNameCmd := "cd " + pkiPathFromConfig + "/" + rootCertUID + "-intermed-ca/ && sed -i \"s/organizationName        =.*/organizationName        =" + orgName + "/g\" intermed-ca.cnf"
 		shellOutput := new(shell.ShellOutput)
-		shellOutput = shell.Execute(setOrgNameCmd, false, false)
+		shellOutput = execute(setOrgNameCmd)
 
 		setCommonNameCmd := "cd " + pkiPathFromConfig + "/" + rootCertUID + "-intermed-ca/ && sed -i \"s/commonName              =.*/commonName              =A1/g\" intermed-ca.cnf"
-		shellOutput = shell.Execute(setCommonNameCmd, false, false)
+		shellOutput = execute(setCommonNameCmd)
 
 		replaceDomainNameCmd := "cd " + pkiPathFromConfig + "/" + rootCertUID + "-intermed-ca/ && sed -i \"s/sampledom/" + orgName + "/g\" intermed-ca.cnf"
-		shellOutput = shell.Execute(replaceDomainNameCmd, false, false)
+		shellOutput = execute(replaceDomainNameCmd)
 
 		replaceDNSInternalNameCmd := "cd " + pkiPathFromConfig + "/" + rootCertUID + "-intermed-ca/ && sed -i \"s/sfcc.tech/cluster.internal/g\" intermed-ca.cnf"
-		shellOutput = shell.Execute(replaceDNSInternalNameCmd, false, false)
+		shellOutput = execute(replaceDNSInternalNameCmd)
 
-		removeSubAltNameCmd := "cd " + pkiPathFromConfig + "/" + rootCertUID + "-root-ca/ && sed -i \"s/subjectAltName/#subjectAltName/g\" intermed-ca.cnf"
-		shellOutput = shell.Execute(removeSubAltNameCmd, false, false)
+		removeSubAltNameCmd := "cd " + pkiPathFromConfig + "/" + rootCertUID + "-intermed-ca/ && sed -i \"s/subjectAltName/#subjectAltName/g\" intermed-ca.cnf"
+		shellOutput = execute(removeSubAltNameCmd)
 		if shellOutput.CmdError != nil {
-			log.Printf("\nUnable to remove Subject Alt Name requirements from config at %v/%v-root-ca/root-ca.cnf\n", pkiPathFromConfig, rootCertUID)
-			return shellOutput.CmdError
+			log.Printf("\nUnable to remove Subject Alt Name requirements from config at %v/%v-intermed-ca/intermed-ca.cnf\n", pkiPathFromConfig, rootCertUID)
+			return shellError(shellOutput)
 		}
 
-		if shellOutput.Stderr != "" {
+		if shellOutput.CmdError != nil {
 			log.Printf("\nUnable to edit config file : %v/%v-intermed-ca/intermed-ca.cnf\n", pkiPathFromConfig, rootCertUID)
-			return shellOutput.CmdError
+			return shellError(shellOutput)
 		}
 
-		setOidCmd := "cd " + pkiPathFromConfig + "/" + rootCertUID + "-intermed-ca/ && sed -i \"s/#customOID/" + GetOid() + "/g\" intermed-ca.cnf"
-		shellOutput = shell.Execute(setOidCmd, false, false)
-		if shellOutput.Stderr != "" {
+		setOidCmd := "cd " + pkiPathFromConfig + "/" + rootCertUID + "-intermed-ca/ && sed -i \"s/#customOID/" + settings.oid + "/g; s/" + DefaultOID + "/" + settings.oid + "/g\" intermed-ca.cnf"
+		shellOutput = execute(setOidCmd)
+		if shellOutput.CmdError != nil {
 			log.Printf("\nUnable to save active OID in config at %v/%v-intermed-ca/intermed-ca.cnf\n", pkiPathFromConfig, rootCertUID)
-			return shellOutput.CmdError
+			return shellError(shellOutput)
 		}
 
 		return nil
@@ -569,6 +597,10 @@ var taskIntermediaryCABlankConfig = gofer.Register(gofer.Task{
 	Label:       "BlankConfig",
 	Description: "Task to write Intermediary CA (A1) Configuration",
 	Action: func(arguments ...string) error {
+		settings, err := readVaultSettings()
+		if err != nil {
+			return err
+		}
 
 		pkiPathFromConfig := arguments[0]
 		rootCertUID := arguments[1]
@@ -591,116 +623,12 @@ var taskIntermediaryCABlankConfig = gofer.Register(gofer.Task{
 			return err
 		}
 
-		setOidCmd := "cd " + pkiPathFromConfig + "/" + rootCertUID + "-intermed-ca/ && sed -i \"s/#customOID/" + GetOid() + "/g\" intermed-ca.cnf"
-		shellOutput := new(shell.ShellOutput)
-		shellOutput = shell.Execute(setOidCmd, false, false)
-		if shellOutput.Stderr != "" {
-			log.Printf("\nUnable to save active OID in config at %v/%v-intermed-ca/intermed-ca.cnf\n", pkiPathFromConfig, rootCertUID)
-			return shellOutput.CmdError
-		}
-
-		return nil
-	},
-})
-
-var taskResetRootCAConfig = gofer.Register(gofer.Task{
-	Namespace:   "A1",
-	Label:       "A0ConfigReset",
-	Description: "Task to write Intermediary CA (A1) Configuration",
-	Action: func(arguments ...string) error {
-
-		pkiPathFromConfig := arguments[0]
-		rootCertUID := arguments[1]
-		rootConfigFile01 := pkiPathFromConfig + "/" + rootCertUID + "-root-ca/root-ca.cnf"
-		rootConfigResource, err := pkger.Open("/resources/root_ca.cnf")
-		if err != nil {
-			return err
-		}
-		defer rootConfigResource.Close()
-		rootConfigResourceStats, err := rootConfigResource.Stat()
-		if err != nil {
-			return err
-		}
-		readBuffer := make([]byte, rootConfigResourceStats.Size())
-
-		rootConfigResource.Read(readBuffer)
-		err = ioutil.WriteFile(rootConfigFile01, readBuffer, 0644)
-		if err != nil {
-			return err
-		}
-
-		setOidCmd := "cd " + pkiPathFromConfig + "/" + rootCertUID + "-root-ca/ && sed -i \"s/#customOID/" + GetOid() + "/g\" root-ca.cnf"
-		shellOutput := new(shell.ShellOutput)
-		shellOutput = shell.Execute(setOidCmd, false, false)
-		if shellOutput.CmdError != nil {
-			log.Printf("\nUnable to save active OID in config at %v/%v-root-ca/root-ca.cnf\n", pkiPathFromConfig, rootCertUID)
-			return shellOutput.CmdError
-		}
-
-		setOrganizationNameCmd := "cd " + pkiPathFromConfig + "/" + rootCertUID + "-root-ca/ && sed -i \"s/#OrganizationName/" + GetOrganizationName() + "/g\" root-ca.cnf"
-		shellOutput = shell.Execute(setOrganizationNameCmd, false, false)
-		if shellOutput.CmdError != nil {
-			log.Printf("\nUnable to save Organization Name in config at %v/%v-root-ca/root-ca.cnf\n", pkiPathFromConfig, rootCertUID)
-			return shellOutput.CmdError
-		}
-
-		setOrganizationCommonNameCmd := "cd " + pkiPathFromConfig + "/" + rootCertUID + "-root-ca/ && sed -i \"s/#OrganizationCommonName/" + GetOrganizationCommonName() + "/g\" root-ca.cnf"
-		shellOutput = shell.Execute(setOrganizationCommonNameCmd, false, false)
-		if shellOutput.CmdError != nil {
-			log.Printf("\nUnable to save Organization Common Name in config at %v/%v-root-ca/root-ca.cnf\n", pkiPathFromConfig, rootCertUID)
-			return shellOutput.CmdError
-		}
-
-		return nil
-	},
-})
-
-var taskResetDRRootCAConfig = gofer.Register(gofer.Task{
-	Namespace:   "A1",
-	Label:       "A0DRConfigReset",
-	Description: "Task to write Intermediary CA (A1) Configuration",
-	Action: func(arguments ...string) error {
-
-		pkiPathFromConfig := arguments[0]
-		rootCertUID := arguments[1]
-		rootDRConfigFile01 := pkiPathFromConfig + "/" + rootCertUID + "-dr-root-ca/root-ca.cnf"
-		rootConfigResource, err := pkger.Open("/resources/root_ca.cnf")
-		if err != nil {
-			return err
-		}
-		defer rootConfigResource.Close()
-		rootConfigResourceStats, err := rootConfigResource.Stat()
-		if err != nil {
-			return err
-		}
-		readBuffer := make([]byte, rootConfigResourceStats.Size())
-
-		rootConfigResource.Read(readBuffer)
-		rootDRCAConfigFileWritingError := ioutil.WriteFile(rootDRConfigFile01, readBuffer, 0644)
-		if rootDRCAConfigFileWritingError != nil {
-			return rootDRCAConfigFileWritingError
-		}
-
-		setOidCmd := "cd " + pkiPathFromConfig + "/" + rootCertUID + "-dr-root-ca/ && sed -i \"s/#customOID/" + GetOid() + "/g\" root-ca.cnf"
+		setOidCmd := "cd " + pkiPathFromConfig + "/" + rootCertUID + "-intermed-ca/ && sed -i \"s/#customOID/" + settings.oid + "/g; s/" + DefaultOID + "/" + settings.oid + "/g\" intermed-ca.cnf"
 		shellOutput := new(shell.ShellOutput)
-		shellOutput = shell.Execute(setOidCmd, false, false)
+		shellOutput = execute(setOidCmd)
 		if shellOutput.CmdError != nil {
-			log.Printf("\nUnable to save active OID in config at %v/%v-dr-root-ca/root-ca.cnf\n", pkiPathFromConfig, rootCertUID)
-			return shellOutput.CmdError
-		}
-
-		setOrganizationNameCmd := "cd " + pkiPathFromConfig + "/" + rootCertUID + "-dr-root-ca/ && sed -i \"s/#OrganizationName/" + GetOrganizationName() + "/g\" root-ca.cnf"
-		shellOutput = shell.Execute(setOrganizationNameCmd, false, false)
-		if shellOutput.CmdError != nil {
-			log.Printf("\nUnable to save Organization Name in config at %v/%v-dr-root-ca/root-ca.cnf\n", pkiPathFromConfig, rootCertUID)
-			return shellOutput.CmdError
-		}
-
-		setOrganizationCommonNameCmd := "cd " + pkiPathFromConfig + "/" + rootCertUID + "-dr-root-ca/ && sed -i \"s/#OrganizationCommonName/" + GetOrganizationCommonName() + "/g\" root-ca.cnf"
-		shellOutput = shell.Execute(setOrganizationCommonNameCmd, false, false)
-		if shellOutput.CmdError != nil {
-			log.Printf("\nUnable to save Organization Common Name in config at %v/%v-dr-root-ca/root-ca.cnf\n", pkiPathFromConfig, rootCertUID)
-			return shellOutput.CmdError
+			log.Printf("\nUnable to save active OID in config at %v/%v-intermed-ca/intermed-ca.cnf\n", pkiPathFromConfig, rootCertUID)
+			return shellError(shellOutput)
 		}
 
 		return nil
@@ -710,214 +638,65 @@ var taskResetDRRootCAConfig = gofer.Register(gofer.Task{
 var taskIntermediaryCACreateA1 = gofer.Register(gofer.Task{
 	Namespace:    "A1",
 	Label:        "Create",
-	Description:  "Create Intermediary CA (A1)",
+	Description:  "Create the key and request of an Intermediary CA (A1)",
 	Dependencies: []string{"A1:Prepare", "A1:Config"},
 	Action: func(arguments ...string) error {
 
 		pkiPathFromConfig := arguments[0]
 		rootCertUID := arguments[1]
-		nameRestriction := arguments[2]
-		startDate := arguments[3]
-		expiryDate := arguments[4]
-
-		opensslPassoutString := arguments[5]
-		opensslPassinString := arguments[6]
-		opensslA0PassinString := arguments[7]
+		opensslPassoutString := arguments[3]
+		opensslPassinString := arguments[4]
+		newKeyOption := arguments[5]
 
-		opensslReqCmd := "cd " + pkiPathFromConfig + "/" + rootCertUID + "-intermed-ca/ && export OPENSSL_CONF=./intermed-ca.cnf && openssl req " + opensslPassoutString + opensslPassinString + "-new -out intermed-ca.req.pem"
+		opensslReqCmd := "cd " + pkiPathFromConfig + "/" + rootCertUID + "-intermed-ca/ && export OPENSSL_CONF=./intermed-ca.cnf && openssl req " + opensslPassoutString + opensslPassinString + newKeyOption + "-new -out intermed-ca.req.pem"
 		shellOutput := new(shell.ShellOutput)
-		shellOutput = shell.Execute(opensslReqCmd, true, false)
+		shellOutput = execute(opensslReqCmd)
 		if shellOutput.CmdError != nil {
 			log.Printf("\nUnable to write to folder : %v/%v-intermed-ca/\n", pkiPathFromConfig, rootCertUID)
-			return shellOutput.CmdError
+			return shellError(shellOutput)
 		}
 
 		fixNamingCmd := "cd " + pkiPathFromConfig + "/" + rootCertUID + "-intermed-ca/ && cp private/intermed-ca.key private/intermed-ca.key.pem"
 		fixPemPermsCmd := "cd " + pkiPathFromConfig + "/" + rootCertUID + "-intermed-ca/ && chmod 400 private/intermed-ca.key.pem"
 		fixKeyPermsCmd := "cd " + pkiPathFromConfig + "/" + rootCertUID + "-intermed-ca/ && chmod 400 private/intermed-ca.key"
-		shellOutput = shell.Execute(fixNamingCmd, false, false)
+		shellOutput = execute(fixNamingCmd)
 		if shellOutput.CmdError != nil {
-			return shellOutput.CmdError
+			return shellError(shellOutput)
 		}
-		shellOutput = shell.Execute(fixPemPermsCmd, false, false)
+		shellOutput = execute(fixPemPermsCmd)
 		if shellOutput.CmdError != nil {
-			return shellOutput.CmdError
+			return shellError(shellOutput)
 		}
-		shellOutput = shell.Execute(fixKeyPermsCmd, false, false)
+		shellOutput = execute(fixKeyPermsCmd)
 		if shellOutput.CmdError != nil {
-			return shellOutput.CmdError
-		}
-
-		//copy CSR for Root CA signing
-		queueToRootCACmd := "cp " + pkiPathFromConfig + "/" + rootCertUID + "-intermed-ca/intermed-ca.req.pem " + pkiPathFromConfig + "/" + rootCertUID + "-root-ca/certreqs/"
-		shellOutput = shell.Execute(queueToRootCACmd, false, false)
-		if shellOutput.Stderr != "" {
-			log.Printf("\nUnable to write to folder : %v/%v-intermed-ca/\n", pkiPathFromConfig, rootCertUID)
-			log.Fatal(shellOutput.CmdError)
-		}
-
-		if nameRestriction != "NA" {
-			applyNameRestrictionCmd := "cd " + pkiPathFromConfig + "/" + rootCertUID + "-root-ca/ && sed -i \"s/#permitted.DNS.1/permitted.DNS.1 = " + nameRestriction + "/g\" ./root-ca.cnf"
-			shellOutput = shell.Execute(applyNameRestrictionCmd, false, false)
-			if shellOutput.Stderr != "" {
-				log.Printf("\nUnable to write to folder : %v/%v-intermed-ca/\n", pkiPathFromConfig, rootCertUID)
-				log.Fatal(shellOutput.CmdError)
-			}
-		} else {
-			applyNameRestrictionCmd := "cd " + pkiPathFromConfig + "/" + rootCertUID + "-root-ca/ && sed -i \"s/nameConstraints/#nameConstraints/g\" ./root-ca.cnf"
-			shellOutput = shell.Execute(applyNameRestrictionCmd, false, false)
-			if shellOutput.Stderr != "" {
-				log.Printf("\nUnable to write to folder : %v/%v-intermed-ca/\n", pkiPathFromConfig, rootCertUID)
-				log.Fatal(shellOutput.CmdError)
-			}
-		}
-
-		genRandomSerialCmd := "cd " + pkiPathFromConfig + "/" + rootCertUID + "-root-ca/ && export OPENSSL_CONF=./root-ca.cnf && openssl rand -hex 16 > root-ca.serial"
-		shellOutput = shell.Execute(genRandomSerialCmd, false, false)
-		if shellOutput.Stderr != "" {
-			log.Printf("\nUnable to write to folder : %v/%v-intermed-ca/\n", pkiPathFromConfig, rootCertUID)
-			log.Fatal(shellOutput.CmdError)
-		}
-
-		signIntermediaryWithA0Cmd := "cd " + pkiPathFromConfig + "/" + rootCertUID + "-root-ca/ && export OPENSSL_CONF=./root-ca.cnf && openssl ca " + opensslA0PassinString + "-in certreqs/intermed-ca.req.pem -out certs/intermed-ca.cert.pem -extensions intermed-ca_ext -batch -startdate " + startDate + " -enddate " + expiryDate
-		shellOutput = shell.Execute(signIntermediaryWithA0Cmd, true, true)
-		if shellOutput.CmdError != nil {
-			log.Printf("\nUnable to load your private key from, is this the right passphrase for Root CA (A0)?: %v/%v-intermed-ca/\n", pkiPathFromConfig, rootCertUID)
-			log.Fatal(shellOutput.CmdError)
-		}
-
-		saveA0SignedIntermediaryCmd := "cp " + pkiPathFromConfig + "/" + rootCertUID + "-root-ca/certs/intermed-ca.cert.pem " + pkiPathFromConfig + "/" + rootCertUID + "-intermed-ca/intermed-ca.cert.pem"
-		shellOutput = shell.Execute(saveA0SignedIntermediaryCmd, false, false)
-		if shellOutput.Stderr != "" {
-			log.Printf("\nUnable to write to folder : %v/%v-intermed-ca/\n", pkiPathFromConfig, rootCertUID)
-			log.Fatal(shellOutput.CmdError)
-		}
-
-		caCertName := strings.ReplaceAll(GetOrganizationName()+"_"+GetOrganizationCommonName()+"_"+"IA1_"+rootCertUID+".pem", " ", "-")
-		selfSignA1CertCopyCmd := "cp " + pkiPathFromConfig + "/" + rootCertUID + "-intermed-ca/intermed-ca.cert.pem " + pkiPathFromConfig + "/" + rootCertUID + "-intermed-ca/" + caCertName
-		shellOutput = shell.Execute(selfSignA1CertCopyCmd, true, true)
-		if shellOutput.CmdError != nil {
-			log.Printf("\nUnable to write to folder : %v/%v-intermed-ca/\n", pkiPathFromConfig, rootCertUID)
-			log.Fatal(shellOutput.CmdError)
-		}
-
-		convertPrivateKeyCmd := "openssl rsa " + opensslPassinString + " -in " + pkiPathFromConfig + "/" + rootCertUID + "-intermed-ca/private/intermed-ca.key.pem " + " -out " + pkiPathFromConfig + "/" + rootCertUID + "-intermed-ca/private/intermed-ca-pkcs1.key.pem" + " -outform pem "
-		shellOutput = shell.Execute(convertPrivateKeyCmd, false, false)
-		if shellOutput.Stderr != "" {
-			log.Printf("\nUnable to write to folder : %v/%v-intermed-ca/\n", pkiPathFromConfig, rootCertUID)
-			log.Fatal(shellOutput.CmdError)
-		}
-
-		createCAChainBundleCmd := "cat " + pkiPathFromConfig + "/" + rootCertUID + "-intermed-ca/private/intermed-ca-pkcs1.key.pem " + pkiPathFromConfig + "/" + rootCertUID + "-root-ca/certs/intermed-ca.cert.pem  " + pkiPathFromConfig + "/" + rootCertUID + "-root-ca/root-ca.cert.pem > " + pkiPathFromConfig + "/" + rootCertUID + "-intermed-ca/intermed-ca-chain-bundle.cert.pem"
-		shellOutput = shell.Execute(createCAChainBundleCmd, false, false)
-		if shellOutput.Stderr != "" {
-			log.Printf("\nUnable to write to folder : %v/%v-intermed-ca/\n", pkiPathFromConfig, rootCertUID)
-			log.Fatal(shellOutput.CmdError)
-		}
-
-		caChainName := strings.ReplaceAll(GetOrganizationName()+"_"+GetOrganizationCommonName()+"_"+"IA1_"+rootCertUID+"chain-bundle.pem", " ", "-")
-		selfSignA1ChainCopyCmd := "cp " + pkiPathFromConfig + "/" + rootCertUID + "-intermed-ca/intermed-ca-chain-bundle.cert.pem " + pkiPathFromConfig + "/" + rootCertUID + "-intermed-ca/" + caChainName
-		shellOutput = shell.Execute(selfSignA1ChainCopyCmd, true, true)
-		if shellOutput.CmdError != nil {
-			log.Printf("\nUnable to write to folder : %v/%v-intermed-ca/\n", pkiPathFromConfig, rootCertUID)
-			log.Fatal(shellOutput.CmdError)
+			return shellError(shellOutput)
 		}
 
 		return nil
 	},
 })
 
-var taskIntermediaryCACrossSignA1 = gofer.Register(gofer.Task{
+var taskIntermediaryCASignA1 = gofer.Register(gofer.Task{
 	Namespace:   "A1",
-	Label:       "CrossSign",
-	Description: "CrossSign Intermediary CA (A1)",
+	Label:       "Sign",
+	Description: "Sign an Intermediary CA (A1) request with a Root CA and an ephemeral config",
 	Action: func(arguments ...string) error {
+		rootCADir := arguments[0]
+		configFile := arguments[1]
+		requestFile := arguments[2]
+		certificateFile := arguments[3]
+		startDate := arguments[4]
+		expiryDate := arguments[5]
+		opensslA0PassinString := arguments[6]
 
-		pkiPathFromConfig := arguments[0]
-		rootCertUID := arguments[1]
-		nameRestriction := arguments[2]
-		startDate := arguments[3]
-		expiryDate := arguments[4]
-
-		opensslA0PassinString := arguments[5]
-
-		//Handle DR Certificates here, if DR is enabled
-		//copy CSR for Root CA signing
-		coCmd09 := "cp " + pkiPathFromConfig + "/" + rootCertUID + "-intermed-ca/intermed-ca.req.pem " + pkiPathFromConfig + "/" + rootCertUID + "-dr-root-ca/certreqs/"
-		shellOutput := new(shell.ShellOutput)
-		shellOutput = shell.Execute(coCmd09, false, false)
-		if shellOutput.Stderr != "" {
-			log.Printf("\nUnable to write to folder : %v/%v-dr-root-ca/\n", pkiPathFromConfig, rootCertUID)
-			log.Fatal(shellOutput.CmdError)
-		}
-
-		if nameRestriction != "NA" {
-			coCmd10 := "cd " + pkiPathFromConfig + "/" + rootCertUID + "-dr-root-ca/ && sed -i \"s/#permitted.DNS.1/permitted.DNS.1 = " + nameRestriction + "/g\" ./root-ca.cnf"
-			shellOutput = shell.Execute(coCmd10, false, false)
-			if shellOutput.Stderr != "" {
-				log.Printf("\nUnable to write to folder : %v/%v-dr-root-ca/\n", pkiPathFromConfig, rootCertUID)
-				log.Fatal(shellOutput.CmdError)
-			}
-		}
-		coCmd11 := "cd " + pkiPathFromConfig + "/" + rootCertUID + "-dr-root-ca/ && export OPENSSL_CONF=./root-ca.cnf && openssl rand -hex 16 > root-ca.serial"
-		shellOutput = shell.Execute(coCmd11, false, false)
-		if shellOutput.Stderr != "" {
-			log.Printf("\nUnable to write to folder : %v/%v-dr-root-ca/\n", pkiPathFromConfig, rootCertUID)
-			log.Fatal(shellOutput.CmdError)
-		}
-
-		coCmd12 := "cd " + pkiPathFromConfig + "/" + rootCertUID + "-dr-root-ca/ && export OPENSSL_CONF=./root-ca.cnf && openssl ca " + opensslA0PassinString + "-in certreqs/intermed-ca.req.pem -out certs/intermed-ca.cert.pem -extensions intermed-ca_ext -batch -startdate " + startDate + " -enddate " + expiryDate
-		shellOutput = shell.Execute(coCmd12, true, true)
-		if shellOutput.CmdError != nil {
-			log.Printf("\nUnable to write to folder : %v/%v-dr-root-ca/\n", pkiPathFromConfig, rootCertUID)
-			log.Fatal(shellOutput.CmdError)
-		}
-
-		coCmd13 := "cp " + pkiPathFromConfig + "/" + rootCertUID + "-dr-root-ca/certs/intermed-ca.cert.pem " + pkiPathFromConfig + "/" + rootCertUID + "-intermed-ca/intermed-ca.dr.cert.pem"
-		shellOutput = shell.Execute(coCmd13, false, false)
-		if shellOutput.Stderr != "" {
-			log.Printf("\nUnable to write to folder : %v/%v-intermed-ca/\n", pkiPathFromConfig, rootCertUID)
-			log.Fatal(shellOutput.CmdError)
-		}
-
-		caCertName := strings.ReplaceAll(GetOrganizationName()+"_"+GetOrganizationCommonName()+"_"+"IA1_C_"+rootCertUID+".pem", " ", "-")
-		selfSignA1CertCopyCmd := "cp " + pkiPathFromConfig + "/" + rootCertUID + "-intermed-ca/intermed-ca.dr.cert.pem " + pkiPathFromConfig + "/" + rootCertUID + "-intermed-ca/" + caCertName
-		shellOutput = shell.Execute(selfSignA1CertCopyCmd, true, true)
+		// the Root CA database is used in place, its config is not
+		signCmd := "cd " + shellQuote(rootCADir) + " && openssl rand -hex 16 > root-ca.serial && openssl ca -config " + shellQuote(configFile) + " " + opensslA0PassinString + "-in " + shellQuote(requestFile) + " -out " + shellQuote(certificateFile) + " -extensions intermed-ca_ext -batch -startdate " + startDate + " -enddate " + expiryDate
+		shellOutput := execute(signCmd)
 		if shellOutput.CmdError != nil {
-			log.Printf("\nUnable to write to folder : %v/%v-intermed-ca/\n", pkiPathFromConfig, rootCertUID)
-			log.Fatal(shellOutput.CmdError)
+			log.Printf("\nUnable to sign the A1 request with the CA at %v, is this the right passphrase for Root CA (A0)?\n", rootCADir)
+			return shellError(shellOutput)
 		}
-
-		drChainCmd := "cat " + pkiPathFromConfig + "/" + rootCertUID + "-intermed-ca/private/intermed-ca-pkcs1.key.pem " + pkiPathFromConfig + "/" + rootCertUID + "-dr-root-ca/certs/intermed-ca.cert.pem  " + pkiPathFromConfig + "/" + rootCertUID + "-dr-root-ca/root-ca.cert.pem  > " + pkiPathFromConfig + "/" + rootCertUID + "-intermed-ca/intermed-ca-chain-bundle.dr.cert.pem"
-		shellOutput = shell.Execute(drChainCmd, false, false)
-		if shellOutput.Stderr != "" {
-			log.Printf("\nUnable to write to folder : %v/%v-intermed-ca/\n", pkiPathFromConfig, rootCertUID)
-			log.Fatal(shellOutput.CmdError)
-		}
-
-		caChainName := strings.ReplaceAll(GetOrganizationName()+"_"+GetOrganizationCommonName()+"_"+"IA1_C_"+rootCertUID+"chain-bundle.pem", " ", "-")
-		selfSignA1ChainCopyCmd := "cp " + pkiPathFromConfig + "/" + rootCertUID + "-intermed-ca/intermed-ca-chain-bundle.dr.cert.pem " + pkiPathFromConfig + "/" + rootCertUID + "-intermed-ca/" + caChainName
-		shellOutput = shell.Execute(selfSignA1ChainCopyCmd, true, true)
-		if shellOutput.CmdError != nil {
-			log.Printf("\nUnable to write to folder : %v/%v-intermed-ca/\n", pkiPathFromConfig, rootCertUID)
-			log.Fatal(shellOutput.CmdError)
-		}
-
-		rootConfigFile02 := pkiPathFromConfig + "/" + rootCertUID + "-dr-root-ca/root-ca.cnf"
-		rootConfigResource, rootConfigResourceErrors := pkger.Open("/resources/root_ca.cnf")
-		if rootConfigResourceErrors != nil {
-			return rootConfigResourceErrors
-		}
-		defer rootConfigResource.Close()
-		rootConfigResourceStats, rootConfigResourceStatsErrors := rootConfigResource.Stat()
-		if rootConfigResourceStatsErrors != nil {
-			return rootConfigResourceStatsErrors
-		}
-		readBuffer := make([]byte, rootConfigResourceStats.Size())
-
-		rootConfigResource.Read(readBuffer)
-		drRootCaConfigFileWritingError := ioutil.WriteFile(rootConfigFile02, readBuffer, 0644)
-		return drRootCaConfigFileWritingError
+		return nil
 	},
 })
 
@@ -925,30 +704,30 @@ var taskIntermediaryCAZipout = gofer.Register(gofer.Task{
 	Namespace:    "A1",
 	Label:        "Zipout",
 	Description:  "Task to produce unique Intermediary CA (A1) PKI Zip output file",
-	Dependencies: []string{"A1:A0ConfigReset", "A1:BlankConfig"},
+	Dependencies: []string{"A1:BlankConfig"},
 	Action: func(arguments ...string) error {
 
 		pkiPathFromConfig := arguments[0]
 		rootCertUID := arguments[1]
 		startDate := arguments[2]
-		zipCmd01 := "mkdir " + pkiPathFromConfig + "/output "
+		archivePassphrase := arguments[3]
+		zipCmd01 := "mkdir -p " + pkiPathFromConfig + "/output "
 		shellOutput := new(shell.ShellOutput)
-		shellOutput = shell.Execute(zipCmd01, false, false)
-		if shellOutput.Stderr != "" {
+		shellOutput = execute(zipCmd01)
+		if shellOutput.CmdError != nil {
 			log.Printf("\nUnable to write to folder : %v/%v-intermed-ca/\n", pkiPathFromConfig, rootCertUID)
-			log.Fatal(shellOutput.CmdError)
+			return shellError(shellOutput)
 		}
 
-		zipCmd02 := "zip -r " + pkiPathFromConfig + "/output/" + rootCertUID + "-intermed-ca-" + startDate + ".zip " + pkiPathFromConfig + "/" + rootCertUID + "-intermed-ca"
-		shellOutput = shell.Execute(zipCmd02, false, false)
-		if shellOutput.CmdError != nil {
-			log.Warnf("\nUnable to write to folder : %v/%v-intermed-ca/\n", pkiPathFromConfig, rootCertUID)
-			//log.Fatal(zip_cmd02_exec_errors)
+		archiveFile := pkiPathFromConfig + "/output/" + rootCertUID + "-intermed-ca-" + startDate + ".zip"
+		archiveErrors := encryptedArchive(pkiPathFromConfig+"/"+rootCertUID+"-intermed-ca", rootCertUID+"-intermed-ca-"+startDate, archiveFile, archivePassphrase)
+		if archiveErrors != nil {
+			log.Printf("\nUnable to write archive : %v\n", archiveFile)
+			return archiveErrors
 		}
 
 		shell.ShellExecWithChannels("unzip -l "+pkiPathFromConfig+"/output/"+rootCertUID+"-intermed-ca-"+startDate+".zip ", true, false)
-		log.Printf("\n\n\t*************************************\n\tYour Intermediary CA repo with Certificates have been saved as\n\t%v/output/%v-intermed-ca-%v.zip\n\t*************************************\n\n", pkiPathFromConfig, rootCertUID, startDate)
-		shell.ShellExecWithChannels("mv "+pkiPathFromConfig+"/"+rootCertUID+"-intermed-ca "+pkiPathFromConfig+"/"+rootCertUID+"-intermed-ca-"+startDate, false, false)
+		log.Printf("\n\n\t*************************************\n\tYour Intermediary CA repo with Certificates have been saved as\n\t%v/output/%v-intermed-ca-%v.zip\n\tThe archive is AES-256 encrypted with the archive passphrase\n\t*************************************\n\n", pkiPathFromConfig, rootCertUID, startDate)
 
 		return nil
 	},
@@ -0,0 +1,274 @@
+package openssl
+
+import (
+	"bufio"
+	"fmt"
+	"github.com/chuckpreslar/gofer"
+	log "github.com/sirupsen/logrus"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// intermediateSigning describes how a Root CA signs an A1 request
+type intermediateSigning struct {
+	// NameRestriction is the permitted DNS domain of the A1, empty or NA means unrestricted
+	NameRestriction string
+	// PathLen is the pathLenConstraint of the A1, -1 keeps the one of the Root CA config
+	PathLen int
+	// ClassOID is added to the A1 certificate when set, for requests that don't carry our class
+	ClassOID string
+	// External is set for partner CSRs, none of their requested extensions reach the A1 certificate
+	External   bool
+	StartDate  string
+	ExpiryDate string
+}
+
+// intermediateTransaction stages an A1 or A2 in the staging dir of the vault and signs it
+// with ephemeral configs rendered in a private workspace, the Root CA configs are never
+// edited. On failure, rollback restores the databases of the CAs that signed and
+// removes the staging dir.
+type intermediateTransaction struct {
+	pkiPath     string
+	rootCertUID string
+	stagingDir  string
+	workspace   string
+	snapshots   []*caSnapshot
+	// created are files written outside the staging dir, such as the A1 archive,
+	// they are removed on rollback
+	created []string
+}
+
+// beginIntermediate creates the staging dir of a new A1
+func beginIntermediate(pkiPath string, rootCertUID string) (*intermediateTransaction, error) {
+	return beginTransaction(pkiPath, rootCertUID, intermediateDirMarker, "A1")
+}
+
+// beginTransaction creates the staging dir of a new A1 or A2, the vault has a single one
+// per kind so an interrupted creation has to be cleaned up before the next one
+func beginTransaction(pkiPath string, rootCertUID string, dirMarker string, kind string) (*intermediateTransaction, error) {
+	stagingDir := filepath.Join(pkiPath, rootCertUID+dirMarker)
+	if dirExists(stagingDir) {
+		return nil, fmt.Errorf("an %v creation is in progress or was interrupted at %v", kind, stagingDir)
+	}
+	workspace, err := ioutil.TempDir("", "privki-"+strings.ToLower(kind))
+	if err != nil {
+		return nil, err
+	}
+	if err := os.MkdirAll(stagingDir, 0700); err != nil {
+		os.RemoveAll(workspace)
+		return nil, err
+	}
+	return &intermediateTransaction{pkiPath: pkiPath, rootCertUID: rootCertUID, stagingDir: stagingDir, workspace: workspace}, nil
+}
+
+// snapshot keeps the database of the CA at caDir before it signs, rollback restores it
+func (transaction *intermediateTransaction) snapshot(caDir string) error {
+	snapshot, err := snapshotCA(caDir)
+	if err != nil {
+		return err
+	}
+	transaction.snapshots = append(transaction.snapshots, snapshot)
+	return nil
+}
+
+// sign signs the staged request with the Root CA at rootDir into certificateName
+func (transaction *intermediateTransaction) sign(rootDir string, signing intermediateSigning, rootPassphrase string, certificateName string) error {
+	settings, err := readVaultSettings()
+	if err != nil {
+		return err
+	}
+	config, err := intermediateSigningConfig(settings, signing)
+	if err != nil {
+		return err
+	}
+	configFile := filepath.Join(transaction.workspace, filepath.Base(rootDir)+".cnf")
+	if err := ioutil.WriteFile(configFile, config, 0600); err != nil {
+		return err
+	}
+	if err := transaction.snapshot(rootDir); err != nil {
+		return err
+	}
+	if err := gofer.Perform("A1:Sign", rootDir, configFile, filepath.Join(transaction.stagingDir, "intermed-ca.req.pem"),
+		filepath.Join(transaction.stagingDir, certificateName), signing.StartDate, signing.ExpiryDate, opensslPassin(rootPassphrase)); err != nil {
+		return err
+	}
+	syncDatabase(rootDir)
+	return nil
+}
+
+// bundle writes the chain bundle of the staged A1 certificate signed by the Root CA at
+// rootDir, along with the copies named after the vault
+func (transaction *intermediateTransaction) bundle(rootDir string, dr bool) error {
+	settings, err := readVaultSettings()
+	if err != nil {
+		return err
+	}
+	certificateName, bundleName, class := "intermed-ca.cert.pem", "intermed-ca-chain-bundle.cert.pem", "IA1_"
+	if dr {
+		certificateName, bundleName, class = "intermed-ca.dr.cert.pem", "intermed-ca-chain-bundle.dr.cert.pem", "IA1_C_"
+	}
+	certificate, err := ioutil.ReadFile(filepath.Join(transaction.stagingDir, certificateName))
+	if err != nil {
+		return err
+	}
+	rootCertificate, err := ioutil.ReadFile(filepath.Join(rootDir, "root-ca.cert.pem"))
+	if err != nil {
+		return err
+	}
+	// bundles only carry certificates, the A1 key leaves the vault through privki export
+	chain := append(append([]byte{}, certificate...), rootCertificate...)
+	namePrefix := strings.ReplaceAll(settings.organization+"_"+settings.commonName+"_"+class+transaction.rootCertUID, " ", "-")
+	for name, content := range map[string][]byte{
+		namePrefix + ".pem":             certificate,
+		bundleName:                      chain,
+		namePrefix + "chain-bundle.pem": chain,
+	} {
+		if err := ioutil.WriteFile(filepath.Join(transaction.stagingDir, name), content, 0644); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// commit moves the staging dir to intermediateDir, the staging dir is left to the caller
+// when it is empty. The signings were recorded in the certificate database as they happened.
+func (transaction *intermediateTransaction) commit(intermediateDir string) error {
+	if intermediateDir != "" {
+		if err := os.Rename(transaction.stagingDir, intermediateDir); err != nil {
+			transaction.rollback()
+			return err
+		}
+	}
+	return os.RemoveAll(transaction.workspace)
+}
+
+// rollback undoes the signings of the transaction, in the openssl databases and the
+// certificate database, and removes what it staged
+func (transaction *intermediateTransaction) rollback() {
+	for i := len(transaction.snapshots) - 1; i >= 0; i-- {
+		if err := transaction.snapshots[i].restore(); err != nil {
+			log.Warnf("Unable to restore the database of %v: %v", transaction.snapshots[i].caDir, err)
+		}
+		syncDatabase(transaction.snapshots[i].caDir)
+	}
+	for _, created := range transaction.created {
+		os.Remove(created)
+	}
+	os.RemoveAll(transaction.stagingDir)
+	os.RemoveAll(transaction.workspace)
+}
+
+var pathLenPattern = regexp.MustCompile(`pathlen:[0-9]+`)
+
+// checkNameRestriction rejects an A1 name restriction that is not a DNS domain, it is
+// rendered into the Root CA signing config and must not break out of its line
+func checkNameRestriction(nameRestriction string) error {
+	if nameRestriction == "" || nameRestriction == "NA" {
+		return nil
+	}
+	if !dnsDomainPattern.MatchString(nameRestriction) {
+		return fmt.Errorf("invalid name restriction %q", nameRestriction)
+	}
+	return nil
+}
+
+// intermediateSigningConfig renders the Root CA config from its template with the
+// name constraints, path length and class of a single A1 signing
+func intermediateSigningConfig(settings *vaultSettings, signing intermediateSigning) ([]byte, error) {
+	template, err := renderRootConfig(settings)
+	if err != nil {
+		return nil, err
+	}
+	nameRestriction := signing.NameRestriction
+	if nameRestriction == "NA" {
+		nameRestriction = ""
+	}
+	var config strings.Builder
+	section := ""
+	scanner := bufio.NewScanner(strings.NewReader(string(template)))
+	for scanner.Scan() {
+		line := scanner.Text()
+		if strings.HasPrefix(line, "[") {
+			section = strings.TrimSpace(strings.Trim(line, "[]"))
+		}
+		switch {
+		case section == "intermed-ca_ext" && strings.HasPrefix(line, "basicConstraints") && signing.PathLen >= 0:
+			line = pathLenPattern.ReplaceAllString(line, "pathlen:"+strconv.Itoa(signing.PathLen))
+		case section == "intermed-ca_ext" && strings.HasPrefix(line, "nameConstraints") && nameRestriction == "":
+			line = "#" + line
+		case section == "root_ca" && strings.HasPrefix(line, "copy_extensions") && signing.External:
+			line = "copy_extensions         = none"
+		case section == "name_constraints" && line == "#permitted.DNS.1" && nameRestriction != "":
+			line = "permitted.DNS.1 = " + nameRestriction
+		}
+		config.WriteString(line + "\n")
+		if section == "intermed-ca_ext" && strings.HasPrefix(line, "crlDistributionPoints") && signing.ClassOID != "" {
+			config.WriteString(signing.ClassOID + "       = ASN1:UTF8String:Class_A1\n")
+		}
+	}
+	return []byte(config.String()), scanner.Err()
+}
+
+// caSnapshot keeps the openssl database files of a CA, to undo a signing
+type caSnapshot struct {
+	caDir    string
+	files    map[string][]byte
+	newcerts map[string]bool
+}
+
+func snapshotCA(caDir string) (*caSnapshot, error) {
+	snapshot := &caSnapshot{caDir: caDir, files: map[string][]byte{}, newcerts: map[string]bool{}}
+	base := strings.TrimSuffix(caConfigName(caDir), ".cnf")
+	for _, suffix := range []string{".index", ".index.attr", ".serial", ".index.old", ".index.attr.old", ".serial.old"} {
+		content, err := ioutil.ReadFile(filepath.Join(caDir, base+suffix))
+		if os.IsNotExist(err) {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		snapshot.files[base+suffix] = content
+	}
+	newcerts, err := ioutil.ReadDir(filepath.Join(caDir, "newcerts"))
+	if err != nil && !os.IsNotExist(err) {
+		return nil, err
+	}
+	for _, newcert := range newcerts {
+		snapshot.newcerts[newcert.Name()] = true
+	}
+	return snapshot, nil
+}
+
+// restore brings the database files back to the snapshot, removing the ones created since
+func (snapshot *caSnapshot) restore() error {
+	base := strings.TrimSuffix(caConfigName(snapshot.caDir), ".cnf")
+	for _, suffix := range []string{".index", ".index.attr", ".serial", ".index.old", ".index.attr.old", ".serial.old"} {
+		file := filepath.Join(snapshot.caDir, base+suffix)
+		content, found := snapshot.files[base+suffix]
+		if !found {
+			if err := os.Remove(file); err != nil && !os.IsNotExist(err) {
+				return err
+			}
+			continue
+		}
+		if err := ioutil.WriteFile(file, content, 0644); err != nil {
+			return err
+		}
+	}
+	newcerts, err := ioutil.ReadDir(filepath.Join(snapshot.caDir, "newcerts"))
+	if err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	for _, newcert := range newcerts {
+		if !snapshot.newcerts[newcert.Name()] {
+			if err := os.Remove(filepath.Join(snapshot.caDir, "newcerts", newcert.Name())); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
@@ -0,0 +1,108 @@
+package ca
+
+import (
+	"context"
+	"crypto/x509"
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"sfcert/openssl"
+)
+
+// CeremonyRequest is an A1 request carried from the online host to the offline Root CA
+type CeremonyRequest = openssl.CeremonyRequest
+
+// CeremonyResponse carries the signed A1 certificates back to the online host
+type CeremonyResponse = openssl.CeremonyResponse
+
+// CeremonyRequestOptions describes the A1 an online host requests from the offline Root CA
+type CeremonyRequestOptions struct {
+	Organization string
+	// NameRestriction limits the A1 to a DNS domain, empty means unrestricted
+	NameRestriction string
+	// OID defaults to the vault OID of this host, or openssl.DefaultOID without a vault
+	OID        string
+	Passphrase string
+	OutFile    string
+}
+
+// CeremonySignOptions describes how the offline Root CA signs a request bundle
+type CeremonySignOptions struct {
+	RequestFile    string
+	RootPassphrase string
+	// DRPassphrase unlocks the DR Root CA key when it has its own, empty when it shares RootPassphrase
+	DRPassphrase string
+	OutFile      string
+}
+
+// CeremonyImportOptions describes a response bundle imported on the online host
+type CeremonyImportOptions struct {
+	ResponseFile string
+	// Trusted holds the Root CA certificates the response must be signed by
+	Trusted []*x509.Certificate
+}
+
+// RequestIntermediate generates the key of a new A1 on this host, keeps it pending
+// and writes the request bundle for the offline Root CA to options.OutFile.
+func RequestIntermediate(ctx context.Context, options CeremonyRequestOptions) (*CeremonyRequest, error) {
+	if options.OutFile == "" {
+		return nil, errors.New("an output file is required")
+	}
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	request, err := openssl.CreateCeremonyRequest(options.Organization, options.NameRestriction, options.OID, options.Passphrase)
+	if err != nil {
+		return nil, err
+	}
+	return request, writeBundle(options.OutFile, request)
+}
+
+// SignRequest signs a request bundle with the Root CA (A0), and the DR Root CA when
+// DR is enabled, and writes the response bundle to options.OutFile.
+func (vault *Vault) SignRequest(ctx context.Context, options CeremonySignOptions) (_ *CeremonyResponse, err error) {
+	if options.OutFile == "" {
+		return nil, errors.New("an output file is required")
+	}
+	request, err := openssl.ReadCeremonyRequest(options.RequestFile)
+	if err != nil {
+		return nil, err
+	}
+	ctx, unlock, err := vault.lock(ctx, "SignRequest")
+	if err != nil {
+		return nil, err
+	}
+	defer unlock(&err)
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	response, err := openssl.SignCeremonyRequest(request, options.RootPassphrase, options.DRPassphrase)
+	if err != nil {
+		return nil, err
+	}
+	return response, writeBundle(options.OutFile, response)
+}
+
+// ImportResponse completes a pending A1 with the certificates of a response bundle,
+// initializing a subordinate vault when this host has none.
+func ImportResponse(ctx context.Context, options CeremonyImportOptions) (*Intermediate, error) {
+	if len(options.Trusted) == 0 {
+		return nil, errors.New("at least one trusted Root CA certificate is required")
+	}
+	response, err := openssl.ReadCeremonyResponse(options.ResponseFile)
+	if err != nil {
+		return nil, err
+	}
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	return openssl.ImportCeremonyResponse(response, options.Trusted)
+}
+
+func writeBundle(outFile string, bundle interface{}) error {
+	bundleBytes, err := json.MarshalIndent(bundle, "", "  ")
+	if err != nil {
+		return err
+	}
+	return ioutil.WriteFile(outFile, bundleBytes, 0644)
+}
@@ -0,0 +1,98 @@
+package ca_test
+
+import (
+	"context"
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sfcert/internal/vaulttest"
+	"sfcert/pkg/ca"
+	"testing"
+)
+
+func TestCeremony(t *testing.T) {
+	ctx := context.Background()
+	offline := vaulttest.NewRoot(t, true)
+	offlineHome := os.Getenv("HOME")
+	roots, err := offline.Roots()
+	if err != nil {
+		t.Fatal(err)
+	}
+	// the online host has no vault yet, the bundles travel through a directory of their own
+	onlineHome := vaulttest.Home(t)
+	usb, err := ioutil.TempDir("", "privki-usb")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(usb)
+	requestFile, responseFile := filepath.Join(usb, "a1.request.json"), filepath.Join(usb, "a1.response.json")
+
+	request, err := ca.RequestIntermediate(ctx, ca.CeremonyRequestOptions{
+		Organization:    "Online Team",
+		NameRestriction: "online.internal",
+		OID:             vaulttest.ClassOID,
+		Passphrase:      vaulttest.A1Passphrase,
+		OutFile:         requestFile,
+	})
+	if err != nil {
+		t.Fatalf("RequestIntermediate: %v", err)
+	}
+
+	os.Setenv("HOME", offlineHome)
+	t.Run("tampered request", func(t *testing.T) {
+		tampered := *request
+		tampered.NameRestriction = ""
+		tamperedFile := filepath.Join(usb, "tampered.request.json")
+		writeJSON(t, tamperedFile, tampered)
+		if _, err := offline.SignRequest(ctx, ca.CeremonySignOptions{RequestFile: tamperedFile, RootPassphrase: vaulttest.RootPassphrase, OutFile: filepath.Join(usb, "tampered.response.json")}); err == nil {
+			t.Fatal("the Root CA signed a request whose name restriction was removed on the way")
+		}
+	})
+	response, err := offline.SignRequest(ctx, ca.CeremonySignOptions{RequestFile: requestFile, RootPassphrase: vaulttest.RootPassphrase, OutFile: responseFile})
+	if err != nil {
+		t.Fatalf("SignRequest: %v", err)
+	}
+	if response.DRCertificate == "" {
+		t.Error("the A1 was not cross signed by the DR Root CA")
+	}
+	if intermediates, _ := offline.Intermediates(ctx); len(intermediates) != 0 {
+		t.Errorf("the offline vault keeps %d A1s, the A1 belongs to the online host", len(intermediates))
+	}
+
+	os.Setenv("HOME", onlineHome)
+	if _, err := ca.ImportResponse(ctx, ca.CeremonyImportOptions{ResponseFile: responseFile, Trusted: roots[1:]}); err == nil {
+		t.Fatal("a response signed by a Root CA that is not trusted was imported")
+	}
+	intermediate, err := ca.ImportResponse(ctx, ca.CeremonyImportOptions{ResponseFile: responseFile, Trusted: roots})
+	if err != nil {
+		t.Fatalf("ImportResponse: %v", err)
+	}
+	if intermediate.ID != request.Intermediate {
+		t.Errorf("the online host installed A1 %v, want %v", intermediate.ID, request.Intermediate)
+	}
+	online, err := ca.Open()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !online.Subordinate() {
+		t.Error("the online host did not become a subordinate vault")
+	}
+	if _, err := online.Issue(ctx, intermediate.ID, ca.IssueOptions{
+		IssueRequest: ca.IssueRequest{CommonName: "app.online.internal", DNSNames: []string{"app.online.internal"}},
+		Passphrase:   vaulttest.A1Passphrase,
+	}); err != nil {
+		t.Errorf("the A1 of the ceremony does not issue: %v", err)
+	}
+}
+
+func writeJSON(t *testing.T, file string, value interface{}) {
+	t.Helper()
+	content, err := json.Marshal(value)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(file, content, 0644); err != nil {
+		t.Fatal(err)
+	}
+}
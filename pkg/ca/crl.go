@@ -0,0 +1,82 @@
+package ca
+
+import (
+	"context"
+	"sfcert/openssl"
+)
+
+// CRLOptions describes a CRL to generate
+type CRLOptions = openssl.CRLOptions
+
+// CRLInfo describes a CRL of a CA
+type CRLInfo = openssl.CRLInfo
+
+// PublishedCA is a CA of a published CRL directory
+type PublishedCA = openssl.PublishedCA
+
+// GenerateCRL generates a full or delta CRL of a CA, id is an A1 or A2 ID, a0 or dr-a0.
+// passphrase unlocks the key of the CA.
+func (vault *Vault) GenerateCRL(ctx context.Context, id string, passphrase string, options CRLOptions) (_ *CRLInfo, err error) {
+	caDir, err := vault.caDir(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+	if id != "a0" && id != "dr-a0" {
+		intermediate, err := vault.Intermediate(ctx, id)
+		if err != nil {
+			return nil, err
+		}
+		if intermediate.ExternalKey {
+			return nil, ErrExternalKey
+		}
+	}
+	ctx, unlock, err := vault.lock(ctx, "GenerateCRL")
+	if err != nil {
+		return nil, err
+	}
+	defer unlock(&err)
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	return openssl.IssueCRL(caDir, passphrase, options)
+}
+
+// PublishCRLs writes the certificates and CRLs of every CA of the vault, DER and PEM,
+// into a static directory layout for a web server
+func (vault *Vault) PublishCRLs(ctx context.Context, outDir string) ([]PublishedCA, error) {
+	if err := vault.ready(ctx); err != nil {
+		return nil, err
+	}
+	return openssl.PublishCRLs(outDir)
+}
+
+// caDir returns the directory of a CA, id is an A1 or A2 ID, a0 or dr-a0
+func (vault *Vault) caDir(ctx context.Context, id string) (string, error) {
+	if err := vault.ready(ctx); err != nil {
+		return "", err
+	}
+	switch id {
+	case "a0", "dr-a0":
+		if vault.Subordinate() {
+			return "", ErrSubordinateVault
+		}
+		if id == "dr-a0" {
+			return openssl.DRRootCADir(vault.Path, vault.RootUID), nil
+		}
+		return openssl.RootCADir(vault.Path, vault.RootUID), nil
+	}
+	intermediate, err := vault.Intermediate(ctx, id)
+	if err != nil {
+		return "", err
+	}
+	return intermediate.Dir, nil
+}
+
+// CRLInfo describes the current full CRL of a CA, id is an A1 or A2 ID, a0 or dr-a0
+func (vault *Vault) CRLInfo(ctx context.Context, id string) (*CRLInfo, error) {
+	caDir, err := vault.caDir(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+	return openssl.ReadCRLInfo(caDir, false)
+}
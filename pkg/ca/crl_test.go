@@ -0,0 +1,91 @@
+package ca_test
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sfcert/internal/vaulttest"
+	"sfcert/pkg/ca"
+	"strconv"
+	"testing"
+)
+
+func TestDeltaCRL(t *testing.T) {
+	vault, intermediate := vaulttest.New(t)
+	ctx := context.Background()
+	if _, err := vault.GenerateCRL(ctx, intermediate.ID, vaulttest.A1Passphrase, ca.CRLOptions{Delta: true}); err == nil {
+		t.Fatal("a delta CRL was generated without a full CRL")
+	}
+
+	issued, err := vault.Issue(ctx, intermediate.ID, ca.IssueOptions{
+		IssueRequest: ca.IssueRequest{CommonName: "db01.cluster.internal", Profile: "client"},
+		Passphrase:   vaulttest.A1Passphrase,
+	})
+	if err != nil {
+		t.Fatalf("Issue: %v", err)
+	}
+	if err := vault.Revoke(ctx, intermediate.ID, ca.RevokeOptions{Serial: issued.Serial, Reason: "keyCompromise", Passphrase: vaulttest.A1Passphrase}); err != nil {
+		t.Fatalf("Revoke: %v", err)
+	}
+	full, err := vault.CRLInfo(ctx, intermediate.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	delta, err := vault.GenerateCRL(ctx, intermediate.ID, vaulttest.A1Passphrase, ca.CRLOptions{Delta: true, Hours: 6})
+	if err != nil {
+		t.Fatalf("GenerateCRL --delta: %v", err)
+	}
+	if !delta.Delta || delta.BaseNumber != full.Number {
+		t.Errorf("delta CRL has base number %q, want the full CRL number %q", delta.BaseNumber, full.Number)
+	}
+	deltaNumber, _ := strconv.ParseUint(delta.Number, 16, 64)
+	fullNumber, _ := strconv.ParseUint(full.Number, 16, 64)
+	if deltaNumber <= fullNumber || delta.Revoked != 0 {
+		t.Errorf("delta CRL %v lists %d revocations, want none after full CRL %v", delta.Number, delta.Revoked, full.Number)
+	}
+
+	outDir, err := ioutil.TempDir("", "privki-publish")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(outDir)
+	published := publishedCA(t, vault, outDir, intermediate.ID)
+	if published.DeltaCRL != "crl/"+intermediate.ID+".delta.crl" {
+		t.Errorf("index.json lists delta CRL %q", published.DeltaCRL)
+	}
+	for _, file := range []string{published.CRL, published.CRL + ".pem", published.DeltaCRL, published.DeltaCRL + ".pem"} {
+		if _, err := os.Stat(filepath.Join(outDir, file)); err != nil {
+			t.Errorf("%v was not published: %v", file, err)
+		}
+	}
+
+	// a new full CRL supersedes the delta CRL, in the vault and in the published directory
+	if _, err := vault.GenerateCRL(ctx, intermediate.ID, vaulttest.A1Passphrase, ca.CRLOptions{Days: 7}); err != nil {
+		t.Fatalf("GenerateCRL: %v", err)
+	}
+	published = publishedCA(t, vault, outDir, intermediate.ID)
+	if published.DeltaCRL != "" {
+		t.Errorf("index.json still lists the superseded delta CRL %q", published.DeltaCRL)
+	}
+	for _, file := range []string{"crl/" + intermediate.ID + ".delta.crl", "crl/" + intermediate.ID + ".delta.crl.pem"} {
+		if _, err := os.Stat(filepath.Join(outDir, file)); !os.IsNotExist(err) {
+			t.Errorf("the superseded delta CRL %v is still published", file)
+		}
+	}
+}
+
+func publishedCA(t *testing.T, vault *ca.Vault, outDir string, id string) ca.PublishedCA {
+	t.Helper()
+	published, err := vault.PublishCRLs(context.Background(), outDir)
+	if err != nil {
+		t.Fatalf("PublishCRLs: %v", err)
+	}
+	for _, entry := range published {
+		if entry.CA == id {
+			return entry
+		}
+	}
+	t.Fatalf("%v is not published", id)
+	return ca.PublishedCA{}
+}
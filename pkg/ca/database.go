@@ -0,0 +1,52 @@
+package ca
+
+import (
+	"context"
+	"errors"
+	"sfcert/openssl"
+)
+
+// DatabaseCA is a certificate authority of the embedded certificate database
+type DatabaseCA = openssl.DatabaseCA
+
+// MigrateDatabase creates or updates the embedded certificate database of the vault
+// from the openssl index files of every CA. Once migrated, certificate listings and
+// lookups are served from the database, and every openssl ca operation is recorded in it.
+func (vault *Vault) MigrateDatabase(ctx context.Context) (_ []DatabaseCA, err error) {
+	ctx, unlock, err := vault.lock(ctx, "MigrateDatabase")
+	if err != nil {
+		return nil, err
+	}
+	defer unlock(&err)
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	return openssl.MigrateDatabase()
+}
+
+// DatabaseCAs lists the certificate authorities recorded in the embedded database
+func (vault *Vault) DatabaseCAs(ctx context.Context) ([]DatabaseCA, error) {
+	if !openssl.DatabaseEnabled(vault.Path) {
+		return nil, errors.New("the vault has no certificate database yet, see privki db migrate")
+	}
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	return openssl.DatabaseCAs(vault.Path)
+}
+
+// ExportDatabase writes the openssl index, serial and crlnum files of a CA from the
+// embedded database into outDir. id is an A1 or A2 ID, a0 or dr-a0.
+func (vault *Vault) ExportDatabase(ctx context.Context, id string, outDir string) (*DatabaseCA, error) {
+	if !openssl.DatabaseEnabled(vault.Path) {
+		return nil, errors.New("the vault has no certificate database yet, see privki db migrate")
+	}
+	caDir, err := vault.caDir(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	return openssl.ExportDatabase(caDir, outDir)
+}
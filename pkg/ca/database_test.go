@@ -0,0 +1,61 @@
+package ca_test
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"sfcert/internal/vaulttest"
+	"sfcert/openssl"
+	"sfcert/pkg/ca"
+	"testing"
+)
+
+func TestDatabaseRollback(t *testing.T) {
+	vault, intermediate := vaulttest.New(t)
+	ctx := context.Background()
+	if _, err := vault.MigrateDatabase(ctx); err != nil {
+		t.Fatalf("MigrateDatabase: %v", err)
+	}
+	issued, err := vault.Issue(ctx, intermediate.ID, ca.IssueOptions{
+		IssueRequest: ca.IssueRequest{CommonName: "db01.cluster.internal", Profile: "client"},
+		Passphrase:   vaulttest.A1Passphrase,
+	})
+	if err != nil {
+		t.Fatalf("Issue: %v", err)
+	}
+
+	// the A1 signs the A2 and records it, the chain bundle then fails without the Root CA certificate
+	rootCertificate := filepath.Join(openssl.RootCADir(vault.Path, vault.RootUID), "root-ca.cert.pem")
+	if err := os.Rename(rootCertificate, rootCertificate+".aside"); err != nil {
+		t.Fatal(err)
+	}
+	_, err = vault.CreateIssuingCA(ctx, ca.IssuingCAOptions{
+		Parent:           intermediate.ID,
+		Organization:     vaulttest.Organization,
+		Passphrase:       a2Passphrase,
+		ParentPassphrase: vaulttest.A1Passphrase,
+	})
+	if err := os.Rename(rootCertificate+".aside", rootCertificate); err != nil {
+		t.Fatal(err)
+	}
+	if err == nil {
+		t.Fatal("CreateIssuingCA succeeded without the chain bundle of the A2")
+	}
+
+	entries, err := vault.Certificates(ctx, intermediate.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 1 || entries[0].Serial != issued.Serial {
+		t.Errorf("the A1 lists %v after the rolled back A2 signing, want %v only", entries, issued.Serial)
+	}
+	cas, err := vault.DatabaseCAs(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, recorded := range cas {
+		if recorded.Dir == filepath.Base(intermediate.Dir) && recorded.Entries != 1 {
+			t.Errorf("the database holds %d certificates of the A1, want 1", recorded.Entries)
+		}
+	}
+}
@@ -0,0 +1,89 @@
+package ca
+
+import (
+	"context"
+	"crypto/x509"
+	"errors"
+	"sfcert/openssl"
+)
+
+// PromotionReport describes the vault after its DR Root CA was promoted to primary
+type PromotionReport = openssl.PromotionReport
+
+// PromoteOptions describes a failover to the DR Root CA (DR A0)
+type PromoteOptions struct {
+	// RootPassphrase unlocks the DR Root CA key, and the key of a new DR Root CA
+	RootPassphrase string
+	// DRPassphrase replaces RootPassphrase when the DR Root CA key has a passphrase of its own
+	DRPassphrase string
+	// ProvisionDR creates a fresh DR Root CA after the promotion and cross signs the A1s with it
+	ProvisionDR bool
+}
+
+// PromoteDR makes the DR Root CA the primary Root CA of the vault, so that new A1s,
+// revocations and CRLs are signed by it. The former Root CA is retired.
+func (vault *Vault) PromoteDR(ctx context.Context, options PromoteOptions) (_ *PromotionReport, err error) {
+	ctx, unlock, err := vault.lock(ctx, "PromoteDR")
+	if err != nil {
+		return nil, err
+	}
+	defer unlock(&err)
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	return openssl.PromoteDRRoot(options.RootPassphrase, options.DRPassphrase, options.ProvisionDR)
+}
+
+// DREnableReport describes the vault after a DR Root CA was added to it
+type DREnableReport = openssl.DREnableReport
+
+// EnableDR creates a DR Root CA for a vault created without one and cross signs
+// the active A1s with it. rootPassphrase unlocks the A0 and protects the DR A0 key.
+func (vault *Vault) EnableDR(ctx context.Context, rootPassphrase string) (_ *DREnableReport, err error) {
+	ctx, unlock, err := vault.lock(ctx, "EnableDR")
+	if err != nil {
+		return nil, err
+	}
+	defer unlock(&err)
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	return openssl.EnableDR(rootPassphrase)
+}
+
+// DrillReport is a DR drill signed by the Root CA (A0)
+type DrillReport = openssl.DrillReport
+
+// DrillDR checks that the DR Root CA could take over, without modifying the vault,
+// and returns the report signed with the Root CA. rootPassphrase unlocks both root keys,
+// drPassphrase the DR Root CA key when it has its own.
+func (vault *Vault) DrillDR(ctx context.Context, rootPassphrase string, drPassphrase string) (_ *DrillReport, err error) {
+	// a consistent view of the vault, no A1 is being signed meanwhile
+	ctx, unlock, err := vault.lock(ctx, "DrillDR")
+	if err != nil {
+		return nil, err
+	}
+	defer unlock(&err)
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	return openssl.DrillDR(rootPassphrase, drPassphrase)
+}
+
+// VerifyDrillReport reads a DR drill report and checks it is signed by one of the trusted Root CAs
+func VerifyDrillReport(ctx context.Context, reportFile string, trusted []*x509.Certificate) (*DrillReport, error) {
+	if len(trusted) == 0 {
+		return nil, errors.New("at least one trusted Root CA certificate is required")
+	}
+	report, err := openssl.ReadDrillReport(reportFile)
+	if err != nil {
+		return nil, err
+	}
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	if _, err := report.Verify(trusted); err != nil {
+		return nil, err
+	}
+	return report, nil
+}
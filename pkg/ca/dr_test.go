@@ -0,0 +1,234 @@
+package ca_test
+
+import (
+	"context"
+	"crypto/x509"
+	"io/ioutil"
+	"path/filepath"
+	"sfcert/internal/vaulttest"
+	"sfcert/openssl"
+	"sfcert/pkg/ca"
+	"strings"
+	"testing"
+)
+
+// newDRVault initializes a vault with DR and an A1 cross signed by the DR Root CA
+func newDRVault(t *testing.T) (*ca.Vault, *ca.Intermediate) {
+	t.Helper()
+	vault := vaulttest.NewRoot(t, true)
+	intermediate, err := vault.CreateIntermediate(context.Background(), ca.IntermediateOptions{
+		Organization:   vaulttest.Organization,
+		Passphrase:     vaulttest.A1Passphrase,
+		RootPassphrase: vaulttest.RootPassphrase,
+	})
+	if err != nil {
+		t.Fatalf("unable to create the A1: %v", err)
+	}
+	return vault, intermediate
+}
+
+// requireChainsTo fails unless the PEM chain of an A1 verifies up to root
+func requireChainsTo(t *testing.T, chainPEM []byte, root *x509.Certificate) {
+	t.Helper()
+	chain, err := openssl.ParseCertificates(chainPEM)
+	if err != nil {
+		t.Fatal(err)
+	}
+	roots, intermediates := x509.NewCertPool(), x509.NewCertPool()
+	roots.AddCert(root)
+	for _, cert := range chain[1:] {
+		intermediates.AddCert(cert)
+	}
+	if _, err := chain[0].Verify(x509.VerifyOptions{Roots: roots, Intermediates: intermediates, KeyUsages: []x509.ExtKeyUsage{x509.ExtKeyUsageAny}}); err != nil {
+		t.Errorf("%v does not chain to %v: %v", chain[0].Subject, root.Subject, err)
+	}
+}
+
+func TestPromoteDR(t *testing.T) {
+	vault, intermediate := newDRVault(t)
+	ctx := context.Background()
+	roots, err := vault.Roots()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := vault.PromoteDR(ctx, ca.PromoteOptions{RootPassphrase: "not-the-root-passphrase"}); err == nil {
+		t.Fatal("the DR Root CA was promoted with a wrong passphrase")
+	}
+	if unchanged, _ := vault.Roots(); len(unchanged) != 2 || !unchanged[0].Equal(roots[0]) || !vault.DREnabled() {
+		t.Fatal("the failed promotion changed the roots of the vault")
+	}
+
+	report, err := vault.PromoteDR(ctx, ca.PromoteOptions{RootPassphrase: vaulttest.RootPassphrase, ProvisionDR: true})
+	if err != nil {
+		t.Fatalf("PromoteDR: %v", err)
+	}
+	if len(report.Intermediates) != 1 || report.Intermediates[0] != intermediate.ID || len(report.Orphaned) != 0 {
+		t.Errorf("the promotion moved A1s %v, orphaned %v", report.Intermediates, report.Orphaned)
+	}
+	promoted, err := vault.Roots()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !promoted[0].Equal(roots[1]) {
+		t.Fatal("the DR Root CA is not the primary Root CA after the promotion")
+	}
+	if len(promoted) != 2 || promoted[1].Equal(roots[0]) || promoted[1].Equal(roots[1]) || !vault.DREnabled() {
+		t.Fatal("no fresh DR Root CA was provisioned")
+	}
+	if len(report.CrossSigned) != 1 || report.CrossSigned[0] != intermediate.ID {
+		t.Errorf("the fresh DR Root CA cross signed %v", report.CrossSigned)
+	}
+
+	chain, err := vault.Chain(ctx, intermediate.ID, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	requireChainsTo(t, chain, promoted[0])
+	drChain, err := vault.Chain(ctx, intermediate.ID, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	requireChainsTo(t, drChain, promoted[1])
+
+	// the promoted root signs revocations and new A1s
+	if _, err := vault.GenerateCRL(ctx, "a0", vaulttest.RootPassphrase, ca.CRLOptions{}); err != nil {
+		t.Errorf("the promoted root does not sign its CRL: %v", err)
+	}
+	created, err := vault.CreateIntermediate(ctx, ca.IntermediateOptions{
+		Organization:   vaulttest.Organization,
+		Passphrase:     vaulttest.A1Passphrase,
+		RootPassphrase: vaulttest.RootPassphrase,
+	})
+	if err != nil {
+		t.Fatalf("CreateIntermediate after the promotion: %v", err)
+	}
+	if chain, err = vault.Chain(ctx, created.ID, false); err != nil {
+		t.Fatal(err)
+	}
+	requireChainsTo(t, chain, promoted[0])
+}
+
+func TestEnableDR(t *testing.T) {
+	vault, intermediate := vaulttest.New(t)
+	ctx := context.Background()
+
+	if _, err := vault.EnableDR(ctx, "not-the-root-passphrase"); err == nil {
+		t.Fatal("DR was enabled with a wrong root passphrase")
+	}
+	if roots, _ := vault.Roots(); len(roots) != 1 || vault.DREnabled() {
+		t.Fatal("the failed DR enable left a DR Root CA")
+	}
+
+	report, err := vault.EnableDR(ctx, vaulttest.RootPassphrase)
+	if err != nil {
+		t.Fatalf("EnableDR: %v", err)
+	}
+	if len(report.CrossSigned) != 1 || report.CrossSigned[0] != intermediate.ID {
+		t.Errorf("EnableDR cross signed %v, want A1 %v", report.CrossSigned, intermediate.ID)
+	}
+	roots, err := vault.Roots()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(roots) != 2 || !vault.DREnabled() {
+		t.Fatal("the vault has no DR Root CA after EnableDR")
+	}
+	crossSigned, err := vault.Intermediate(ctx, intermediate.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !crossSigned.CrossSigned {
+		t.Error("the A1 is not listed as cross signed")
+	}
+	drChain, err := vault.Chain(ctx, intermediate.ID, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	requireChainsTo(t, drChain, roots[1])
+
+	if _, err := vault.EnableDR(ctx, vaulttest.RootPassphrase); err == nil {
+		t.Error("DR was enabled twice")
+	}
+}
+
+func TestDrillDR(t *testing.T) {
+	vault, intermediate := newDRVault(t)
+	ctx := context.Background()
+	for _, root := range []string{"a0", "dr-a0"} {
+		if _, err := vault.GenerateCRL(ctx, root, vaulttest.RootPassphrase, ca.CRLOptions{Days: 90}); err != nil {
+			t.Fatalf("GenerateCRL %v: %v", root, err)
+		}
+	}
+
+	report, err := vault.DrillDR(ctx, vaulttest.RootPassphrase, "")
+	if err != nil {
+		t.Fatalf("DrillDR: %v", err)
+	}
+	for _, check := range report.Checks {
+		if !check.Passed {
+			t.Errorf("drill check %v of %v failed: %v", check.Check, check.Target, check.Detail)
+		}
+	}
+	if !report.Passed {
+		t.Fatal("the drill of a healthy vault did not pass")
+	}
+	roots, err := vault.Roots()
+	if err != nil {
+		t.Fatal(err)
+	}
+	reportFile := filepath.Join(t.TempDir(), "drill.json")
+	writeJSON(t, reportFile, report)
+	if _, err := ca.VerifyDrillReport(ctx, reportFile, roots[:1]); err != nil {
+		t.Errorf("the drill report does not verify against the A0: %v", err)
+	}
+	report.Passed = !report.Passed
+	writeJSON(t, reportFile, report)
+	if _, err := ca.VerifyDrillReport(ctx, reportFile, roots[:1]); err == nil {
+		t.Error("a drill report altered after signing verifies")
+	}
+
+	// a name constraint left behind by an interrupted signing fails the config check
+	drConfig := filepath.Join(openssl.DRRootCADir(vault.Path, vault.RootUID), "root-ca.cnf")
+	config, err := ioutil.ReadFile(drConfig)
+	if err != nil {
+		t.Fatal(err)
+	}
+	leftover := strings.Replace(string(config), "#permitted.DNS.1", "permitted.DNS.1 = leftover.internal", 1)
+	if err := ioutil.WriteFile(drConfig, []byte(leftover), 0644); err != nil {
+		t.Fatal(err)
+	}
+	requireFailedChecks(t, vault, "config", "DR A0")
+	if err := ioutil.WriteFile(drConfig, config, 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	// a DR chain bundle ending at the A0 does not validate through the DR Root CA
+	bundle, err := ioutil.ReadFile(filepath.Join(intermediate.Dir, "intermed-ca-chain-bundle.cert.pem"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(filepath.Join(intermediate.Dir, "intermed-ca-chain-bundle.dr.cert.pem"), bundle, 0644); err != nil {
+		t.Fatal(err)
+	}
+	requireFailedChecks(t, vault, "dr-chain-bundle", "")
+}
+
+// requireFailedChecks runs a drill and fails unless exactly the checks named check fail,
+// only those of target when it is set
+func requireFailedChecks(t *testing.T, vault *ca.Vault, check string, target string) {
+	t.Helper()
+	report, err := vault.DrillDR(context.Background(), vaulttest.RootPassphrase, "")
+	if err != nil {
+		t.Fatalf("DrillDR: %v", err)
+	}
+	if report.Passed {
+		t.Fatalf("the drill passed with a broken %v", check)
+	}
+	for _, drillCheck := range report.Checks {
+		failing := drillCheck.Check == check && (target == "" || drillCheck.Target == target)
+		if drillCheck.Passed == failing {
+			t.Errorf("drill check %v of %v passed %v: %v", drillCheck.Check, drillCheck.Target, drillCheck.Passed, drillCheck.Detail)
+		}
+	}
+}
@@ -0,0 +1,113 @@
+package ca
+
+import (
+	"context"
+	"errors"
+	"path/filepath"
+	"sfcert/openssl"
+)
+
+// ExportOptions describes an export of an A1, a certificate issued by an A1,
+// or, when Intermediate is empty, of the trust bundle (A0 and DR A0).
+type ExportOptions struct {
+	// Format is one of pem, der, pkcs7, pkcs8, pkcs12, jks or truststore
+	Format       string
+	Intermediate string
+	Serial       string
+	// DR exports the chain up to the DR Root CA through the A1's cross signed certificate
+	DR bool
+	// IncludeKey adds the A1 private key to pkcs12 and jks exports of an A1,
+	// pkcs8 exports always export the key
+	IncludeKey bool
+	// KeyFile is the private key of an issued certificate, for pkcs8, pkcs12 and jks exports
+	KeyFile string
+	// KeyPassphrase unlocks the A1 key, or KeyFile if it is encrypted
+	KeyPassphrase string
+	// Passphrase protects pkcs8, pkcs12, jks and truststore output
+	Passphrase string
+	// Encryption selects the pkcs12 algorithms, aes256 (default) or 3des
+	Encryption string
+	OutFile    string
+}
+
+// Export writes an A1, an issued certificate or the trust bundle in the requested format
+func (vault *Vault) Export(ctx context.Context, options ExportOptions) error {
+	request := openssl.ExportRequest{
+		Format:        options.Format,
+		KeyPassphrase: options.KeyPassphrase,
+		Passphrase:    options.Passphrase,
+		Encryption:    options.Encryption,
+		OutFile:       options.OutFile,
+	}
+	if options.Intermediate == "" {
+		if options.Format == openssl.FormatDER || options.Format == openssl.FormatPKCS8 || options.Format == openssl.FormatJKS {
+			return errors.New("trust bundles can be exported as pem, pkcs7, pkcs12 or truststore")
+		}
+		roots, err := vault.Roots()
+		if err != nil {
+			return err
+		}
+		request.Certificates = append(request.Certificates, openssl.ExportEntry{Alias: "a0", File: filepath.Join(openssl.RootCADir(vault.Path, vault.RootUID), "root-ca.cert.pem")})
+		if len(roots) > 1 {
+			request.Certificates = append(request.Certificates, openssl.ExportEntry{Alias: "dr-a0", File: filepath.Join(openssl.DRRootCADir(vault.Path, vault.RootUID), "root-ca.cert.pem")})
+		}
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		return openssl.Export(request)
+	}
+
+	intermediate, err := vault.Intermediate(ctx, options.Intermediate)
+	if err != nil {
+		return err
+	}
+	chain, err := vault.chainEntries(intermediate, options.DR)
+	if err != nil {
+		return err
+	}
+
+	if options.Serial == "" {
+		request.Certificates = chain
+		if options.IncludeKey || options.Format == openssl.FormatPKCS8 {
+			if intermediate.ExternalKey {
+				return ErrExternalKey
+			}
+			request.KeyFile = filepath.Join(intermediate.Dir, "private", "intermed-ca.key.pem")
+		}
+	} else {
+		entry, err := openssl.FindIndexEntry(intermediate.Dir, options.Serial)
+		if err != nil {
+			return err
+		}
+		leafEntry := openssl.ExportEntry{Alias: entry.Serial, File: filepath.Join(intermediate.Dir, "newcerts", entry.Serial+".pem")}
+		request.Certificates = append([]openssl.ExportEntry{leafEntry}, chain...)
+		request.KeyFile = options.KeyFile
+	}
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	return openssl.Export(request)
+}
+
+// chainEntries names the certificates of the chain of an A1 or A2 up to the Root CA, or the DR Root CA
+func (vault *Vault) chainEntries(intermediate *Intermediate, dr bool) ([]openssl.ExportEntry, error) {
+	chainFiles, err := openssl.ChainFiles(vault.Path, vault.RootUID, intermediate, dr)
+	if err != nil {
+		return nil, err
+	}
+	aliases := []string{"a1-" + intermediate.ID}
+	if intermediate.Parent != "" {
+		aliases = []string{"a2-" + intermediate.ID, "a1-" + intermediate.Parent}
+	}
+	if dr {
+		aliases = append(aliases, "dr-a0")
+	} else {
+		aliases = append(aliases, "a0")
+	}
+
+	var entries []openssl.ExportEntry
+	for i, file := range chainFiles {
+		entries = append(entries, openssl.ExportEntry{Alias: aliases[i], File: file})
+	}
+	return entries, nil
+}
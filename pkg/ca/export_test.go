@@ -0,0 +1,139 @@
+package ca_test
+
+import (
+	"context"
+	"crypto/x509"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"sfcert/internal/vaulttest"
+	"sfcert/openssl"
+	"sfcert/pkg/ca"
+	"strings"
+	"testing"
+)
+
+const exportPassphrase = "export-passphrase"
+
+// opensslOutput runs openssl with args and returns its output
+func opensslOutput(t *testing.T, args ...string) string {
+	t.Helper()
+	output, err := exec.Command("openssl", args...).CombinedOutput()
+	if err != nil {
+		t.Fatalf("openssl %v: %v\n%s", strings.Join(args, " "), err, output)
+	}
+	return string(output)
+}
+
+func TestExport(t *testing.T) {
+	vault, intermediate := vaulttest.New(t)
+	ctx := context.Background()
+	outDir, err := ioutil.TempDir("", "privki-export-test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(outDir)
+
+	t.Run("der", func(t *testing.T) {
+		outFile := filepath.Join(outDir, "a1.der")
+		if err := vault.Export(ctx, ca.ExportOptions{Format: openssl.FormatDER, Intermediate: intermediate.ID, OutFile: outFile}); err != nil {
+			t.Fatal(err)
+		}
+		der, err := ioutil.ReadFile(outFile)
+		if err != nil {
+			t.Fatal(err)
+		}
+		cert, err := x509.ParseCertificate(der)
+		if err != nil {
+			t.Fatalf("the DER export does not parse: %v", err)
+		}
+		if cert.Subject.String() != intermediate.Subject {
+			t.Errorf("the DER export holds %v, want the A1 %v", cert.Subject, intermediate.Subject)
+		}
+	})
+
+	t.Run("pkcs7", func(t *testing.T) {
+		outFile := filepath.Join(outDir, "a1.p7b")
+		if err := vault.Export(ctx, ca.ExportOptions{Format: openssl.FormatPKCS7, Intermediate: intermediate.ID, OutFile: outFile}); err != nil {
+			t.Fatal(err)
+		}
+		certificates := opensslOutput(t, "pkcs7", "-print_certs", "-noout", "-in", outFile)
+		if count := strings.Count(certificates, "subject="); count != 2 {
+			t.Errorf("the PKCS#7 chain holds %d certificates, want the A1 and the A0", count)
+		}
+	})
+
+	t.Run("pkcs12", func(t *testing.T) {
+		outFile := filepath.Join(outDir, "a1.p12")
+		if err := vault.Export(ctx, ca.ExportOptions{
+			Format:        openssl.FormatPKCS12,
+			Intermediate:  intermediate.ID,
+			IncludeKey:    true,
+			KeyPassphrase: vaulttest.A1Passphrase,
+			Passphrase:    exportPassphrase,
+			OutFile:       outFile,
+		}); err != nil {
+			t.Fatal(err)
+		}
+		contents := opensslOutput(t, "pkcs12", "-in", outFile, "-passin", "pass:"+exportPassphrase, "-nodes")
+		if strings.Count(contents, "BEGIN CERTIFICATE") != 2 || !strings.Contains(contents, "BEGIN PRIVATE KEY") {
+			t.Errorf("the PKCS#12 export does not hold the A1 key and chain:\n%v", contents)
+		}
+		if !strings.Contains(contents, "friendlyName: a1-"+intermediate.ID) {
+			t.Errorf("the PKCS#12 export does not name the A1 a1-%v", intermediate.ID)
+		}
+	})
+
+	t.Run("pkcs12 wrong key passphrase", func(t *testing.T) {
+		outFile := filepath.Join(outDir, "wrong.p12")
+		if err := vault.Export(ctx, ca.ExportOptions{
+			Format:        openssl.FormatPKCS12,
+			Intermediate:  intermediate.ID,
+			IncludeKey:    true,
+			KeyPassphrase: "not-the-a1-passphrase",
+			Passphrase:    exportPassphrase,
+			OutFile:       outFile,
+		}); err == nil {
+			t.Error("the A1 key was exported with a wrong passphrase")
+		}
+	})
+
+	t.Run("trust bundle", func(t *testing.T) {
+		outFile := filepath.Join(outDir, "trust.pem")
+		if err := vault.Export(ctx, ca.ExportOptions{Format: openssl.FormatPEM, OutFile: outFile}); err != nil {
+			t.Fatal(err)
+		}
+		bundle, err := ioutil.ReadFile(outFile)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if strings.Count(string(bundle), "BEGIN CERTIFICATE") != 1 || strings.Contains(string(bundle), "PRIVATE KEY") {
+			t.Errorf("the trust bundle of a vault without DR holds:\n%s", bundle)
+		}
+		if err := vault.Export(ctx, ca.ExportOptions{Format: openssl.FormatDER, OutFile: filepath.Join(outDir, "trust.der")}); err == nil {
+			t.Error("a trust bundle was exported as DER")
+		}
+	})
+
+	t.Run("truststore", func(t *testing.T) {
+		outFile := filepath.Join(outDir, "trust.jks")
+		err := vault.Export(ctx, ca.ExportOptions{Format: openssl.FormatTruststore, Passphrase: exportPassphrase, OutFile: outFile})
+		if openssl.CheckKeytool() != nil {
+			if err != openssl.ErrKeytoolNotFound {
+				t.Errorf("a truststore export without keytool returned %v", err)
+			}
+			return
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := os.Stat(outFile); err != nil {
+			t.Error(err)
+		}
+	})
+
+	if err := vault.Export(ctx, ca.ExportOptions{Format: "pfx", Intermediate: intermediate.ID, OutFile: filepath.Join(outDir, "a1.pfx")}); err == nil {
+		t.Error("an unknown format was exported")
+	}
+}
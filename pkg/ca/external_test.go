@@ -0,0 +1,88 @@
+package ca_test
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"os"
+	"path/filepath"
+	"sfcert/internal/vaulttest"
+	"sfcert/openssl"
+	"sfcert/pkg/ca"
+	"testing"
+)
+
+// partnerRequest returns the PEM request of a key kept outside the vault, it asks for
+// extensions of its own that the Root CA must not copy
+func partnerRequest(t *testing.T, subject pkix.Name) []byte {
+	t.Helper()
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	csr, err := x509.CreateCertificateRequest(rand.Reader, &x509.CertificateRequest{
+		Subject:  subject,
+		DNSNames: []string{"anything.example.com"},
+	}, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE REQUEST", Bytes: csr})
+}
+
+func TestCreateIntermediateFromCSR(t *testing.T) {
+	vault := vaulttest.NewRoot(t, true)
+	ctx := context.Background()
+
+	if _, err := vault.CreateIntermediateFromCSR(ctx, partnerRequest(t, pkix.Name{CommonName: "Partner A1"}), "partner.internal", 0, vaulttest.RootPassphrase, ""); err == nil {
+		t.Fatal("a request without an organization was signed")
+	}
+	if intermediates, _ := vault.Intermediates(ctx); len(intermediates) != 0 {
+		t.Fatalf("the rejected request left %d A1s", len(intermediates))
+	}
+
+	csr := partnerRequest(t, pkix.Name{Organization: []string{"Partner"}, CommonName: "Partner A1"})
+	intermediate, err := vault.CreateIntermediateFromCSR(ctx, csr, "partner.internal", 0, vaulttest.RootPassphrase, "")
+	if err != nil {
+		t.Fatalf("CreateIntermediateFromCSR: %v", err)
+	}
+	if !intermediate.ExternalKey {
+		t.Error("the A1 of a partner request is not marked ExternalKey")
+	}
+	if _, err := os.Stat(filepath.Join(intermediate.Dir, "private", "intermed-ca.key.pem")); !os.IsNotExist(err) {
+		t.Error("the vault holds a key for the A1 of a partner request")
+	}
+
+	for _, name := range []string{"intermed-ca.cert.pem", "intermed-ca.dr.cert.pem"} {
+		cert, err := openssl.ReadCertificate(filepath.Join(intermediate.Dir, name))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !cert.IsCA || cert.MaxPathLen != 0 || !cert.MaxPathLenZero {
+			t.Errorf("%v is not a CA with path length 0", name)
+		}
+		if len(cert.PermittedDNSDomains) != 1 || cert.PermittedDNSDomains[0] != "partner.internal" {
+			t.Errorf("%v permits %v, want the name restriction partner.internal", name, cert.PermittedDNSDomains)
+		}
+		if len(cert.DNSNames) != 0 {
+			t.Errorf("%v carries the subject alternative names %v of the request", name, cert.DNSNames)
+		}
+		classed := false
+		for _, extension := range cert.Extensions {
+			classed = classed || extension.Id.String() == vaulttest.ClassOID
+		}
+		if !classed {
+			t.Errorf("%v does not carry the class OID %v", name, vaulttest.ClassOID)
+		}
+	}
+
+	if _, err := vault.Issue(ctx, intermediate.ID, ca.IssueOptions{
+		IssueRequest: ca.IssueRequest{CommonName: "app.partner.internal"},
+		Passphrase:   vaulttest.A1Passphrase,
+	}); err != ca.ErrExternalKey {
+		t.Errorf("Issue with the A1 of a partner request returned %v, want ErrExternalKey", err)
+	}
+}
@@ -0,0 +1,111 @@
+package ca
+
+import (
+	"context"
+	"crypto/x509"
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"sfcert/openssl"
+)
+
+// HandoffPackage is an A1 encrypted to a receiving team and signed by the Root CA (A0)
+type HandoffPackage = openssl.HandoffPackage
+
+// PackageOptions describes an A1 handoff package
+type PackageOptions struct {
+	// Recipient is a PEM RSA public key or certificate of the receiving team
+	Recipient      string
+	RootPassphrase string
+	OutFile        string
+}
+
+// UnpackOptions describes how a handoff package is verified and decrypted
+type UnpackOptions struct {
+	PackageFile string
+	// Trusted holds the Root CA certificates the package signature must chain to
+	Trusted       []*x509.Certificate
+	KeyFile       string
+	KeyPassphrase string
+}
+
+// PackageIntermediate encrypts an A1, its certificates, DR cross certificate,
+// configuration and an empty database to the recipient and signs the package with A0.
+func (vault *Vault) PackageIntermediate(ctx context.Context, id string, options PackageOptions) error {
+	if options.Recipient == "" {
+		return errors.New("a recipient public key or certificate is required")
+	}
+	if options.OutFile == "" {
+		return errors.New("an output file is required")
+	}
+	intermediate, err := vault.Intermediate(ctx, id)
+	if err != nil {
+		return err
+	}
+	if intermediate.ExternalKey {
+		return ErrExternalKey
+	}
+	if intermediate.Parent != "" {
+		return errors.New("only A1s can be handed over, hand over the A1 of an A2 instead")
+	}
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	handoff, err := openssl.PackageIntermediate(vault.Path, vault.RootUID, intermediate, options.Recipient, options.RootPassphrase)
+	if err != nil {
+		return err
+	}
+	packageBytes, err := json.MarshalIndent(handoff, "", "  ")
+	if err != nil {
+		return err
+	}
+	return ioutil.WriteFile(options.OutFile, packageBytes, 0600)
+}
+
+// Unpack verifies and decrypts a handoff package and installs its A1 on this host,
+// initializing a subordinate vault for the package's Root CA when there is none.
+func Unpack(ctx context.Context, options UnpackOptions) (_ *Intermediate, err error) {
+	if len(options.Trusted) == 0 {
+		return nil, errors.New("at least one trusted Root CA certificate is required")
+	}
+	ctx, unlock, err := openssl.LockVault(ctx, "Unpack")
+	if err != nil {
+		return nil, err
+	}
+	defer unlock(&err)
+	handoff, err := openssl.ReadHandoffPackage(options.PackageFile)
+	if err != nil {
+		return nil, err
+	}
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	return openssl.UnpackIntermediate(handoff, options.Trusted, options.KeyFile, options.KeyPassphrase)
+}
+
+// InitSubordinate initializes a subordinate vault on a host without one, from a
+// handoff package. The vault holds the packaged A1 and the public Root CA certificates,
+// it can issue, revoke and publish CRLs but not create A0s or A1s. It returns
+// ErrVaultExists on a host with a vault.
+func InitSubordinate(ctx context.Context, options UnpackOptions) (_ *Vault, _ *Intermediate, err error) {
+	if openssl.VaultInitialized() {
+		return nil, nil, ErrVaultExists
+	}
+	if err := openssl.CheckOpenSSL(); err != nil {
+		return nil, nil, err
+	}
+	ctx, unlock, err := openssl.LockVault(ctx, "InitSubordinate")
+	if err != nil {
+		return nil, nil, err
+	}
+	defer unlock(&err)
+	intermediate, err := Unpack(ctx, options)
+	if err != nil {
+		return nil, nil, err
+	}
+	vault, err := Open()
+	if err != nil {
+		return nil, nil, err
+	}
+	return vault, intermediate, nil
+}
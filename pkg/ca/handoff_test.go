@@ -0,0 +1,224 @@
+package ca_test
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/json"
+	"encoding/pem"
+	"io/ioutil"
+	"math/big"
+	"os"
+	"path/filepath"
+	"sfcert/internal/vaulttest"
+	"sfcert/pkg/ca"
+	"testing"
+	"time"
+)
+
+// handoffTest is an A1 packaged to a recipient key, the test home is empty again
+// so that the package can be unpacked as on the receiving host
+type handoffTest struct {
+	vault         *ca.Vault
+	intermediate  *ca.Intermediate
+	trusted       []*x509.Certificate
+	rootHome      string
+	workDir       string
+	recipientFile string
+	packageFile   string
+	keyFile       string
+}
+
+// writeRSAKey writes a new RSA private key, and its public key when publicFile is set
+func writeRSAKey(t *testing.T, privateFile string, publicFile string) {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	privateDER, err := x509.MarshalPKCS8PrivateKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(privateFile, pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: privateDER}), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if publicFile == "" {
+		return
+	}
+	publicDER, err := x509.MarshalPKIXPublicKey(&key.PublicKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(publicFile, pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: publicDER}), 0644); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func newHandoffTest(t *testing.T) *handoffTest {
+	vault, intermediate := vaulttest.New(t)
+	trusted, err := vault.Roots()
+	if err != nil {
+		t.Fatal(err)
+	}
+	rootHome := os.Getenv("HOME")
+	workDir := vaulttest.Home(t)
+	handoff := &handoffTest{
+		vault:         vault,
+		intermediate:  intermediate,
+		trusted:       trusted,
+		rootHome:      rootHome,
+		workDir:       workDir,
+		recipientFile: filepath.Join(workDir, "recipient.pub.pem"),
+		packageFile:   filepath.Join(workDir, "a1.package"),
+		keyFile:       filepath.Join(workDir, "recipient.key.pem"),
+	}
+	writeRSAKey(t, handoff.keyFile, handoff.recipientFile)
+	handoff.pack(t, func() {
+		if err := vault.PackageIntermediate(context.Background(), intermediate.ID, ca.PackageOptions{
+			Recipient:      handoff.recipientFile,
+			RootPassphrase: vaulttest.RootPassphrase,
+			OutFile:        handoff.packageFile,
+		}); err != nil {
+			t.Fatalf("unable to package the A1: %v", err)
+		}
+	})
+	return handoff
+}
+
+// pack runs packaging steps in the root vault, vaulttest.Home moved HOME away from it
+func (handoff *handoffTest) pack(t *testing.T, steps func()) {
+	os.Setenv("HOME", handoff.rootHome)
+	defer os.Setenv("HOME", handoff.workDir)
+	steps()
+}
+
+func (handoff *handoffTest) unpack(trusted []*x509.Certificate, keyFile string) (*ca.Intermediate, error) {
+	return ca.Unpack(context.Background(), ca.UnpackOptions{
+		PackageFile: handoff.packageFile,
+		Trusted:     trusted,
+		KeyFile:     keyFile,
+	})
+}
+
+// requireNoVault fails the test when a rejected package left a vault behind
+func requireNoVault(t *testing.T) {
+	if _, err := ca.Open(); err == nil {
+		t.Error("a vault was initialized from a rejected package")
+	}
+}
+
+func TestHandoffRoundTrip(t *testing.T) {
+	handoff := newHandoffTest(t)
+	unpacked, err := handoff.unpack(handoff.trusted, handoff.keyFile)
+	if err != nil {
+		t.Fatalf("Unpack: %v", err)
+	}
+	if unpacked.ID != handoff.intermediate.ID {
+		t.Errorf("unpacked A1 %v, packaged %v", unpacked.ID, handoff.intermediate.ID)
+	}
+
+	vault, err := ca.Open()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !vault.Subordinate() {
+		t.Error("the receiving vault is not subordinate")
+	}
+	if _, err := vault.Issue(context.Background(), unpacked.ID, ca.IssueOptions{
+		IssueRequest: ca.IssueRequest{CommonName: "handoff.cluster.internal", Profile: "client"},
+		Passphrase:   vaulttest.A1Passphrase,
+	}); err != nil {
+		t.Errorf("the unpacked A1 does not issue: %v", err)
+	}
+}
+
+func TestHandoffTamperedPayload(t *testing.T) {
+	handoff := newHandoffTest(t)
+	packageBytes, err := ioutil.ReadFile(handoff.packageFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var handoffPackage ca.HandoffPackage
+	if err := json.Unmarshal(packageBytes, &handoffPackage); err != nil {
+		t.Fatal(err)
+	}
+	handoffPackage.Payload[len(handoffPackage.Payload)/2] ^= 0x01
+	if packageBytes, err = json.Marshal(handoffPackage); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(handoff.packageFile, packageBytes, 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := handoff.unpack(handoff.trusted, handoff.keyFile); err == nil {
+		t.Fatal("a package with a modified payload was unpacked")
+	}
+	requireNoVault(t)
+}
+
+func TestHandoffUntrustedSigner(t *testing.T) {
+	handoff := newHandoffTest(t)
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	template := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "Another Root CA"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		IsCA:                  true,
+		BasicConstraintsValid: true,
+		KeyUsage:              x509.KeyUsageCertSign,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	otherRoot, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := handoff.unpack([]*x509.Certificate{otherRoot}, handoff.keyFile); err == nil {
+		t.Fatal("a package signed by an untrusted Root CA was unpacked")
+	}
+	requireNoVault(t)
+}
+
+func TestHandoffWrongRecipientKey(t *testing.T) {
+	handoff := newHandoffTest(t)
+	otherKeyFile := filepath.Join(filepath.Dir(handoff.keyFile), "other.key.pem")
+	writeRSAKey(t, otherKeyFile, "")
+
+	if _, err := handoff.unpack(handoff.trusted, otherKeyFile); err == nil {
+		t.Fatal("a package was unpacked with a key it is not addressed to")
+	}
+	requireNoVault(t)
+}
+
+func TestInitSubordinateExistingVault(t *testing.T) {
+	handoff := newHandoffTest(t)
+	ctx := context.Background()
+	// a vault of another Root CA
+	if _, err := ca.Init(ctx); err != nil {
+		t.Fatal(err)
+	}
+	options := ca.UnpackOptions{
+		PackageFile: handoff.packageFile,
+		Trusted:     handoff.trusted,
+		KeyFile:     handoff.keyFile,
+	}
+	if _, _, err := ca.InitSubordinate(ctx, options); err != ca.ErrVaultExists {
+		t.Fatalf("InitSubordinate on a host with a vault returned %v, want ErrVaultExists", err)
+	}
+	vault, err := ca.Open()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if vault.Subordinate() {
+		t.Error("the existing vault became subordinate")
+	}
+}
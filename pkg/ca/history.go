@@ -0,0 +1,41 @@
+package ca
+
+import (
+	"context"
+	"sfcert/openssl"
+)
+
+// HistoryEntry is an operation that changed the vault, as recorded in its history
+type HistoryEntry = openssl.HistoryEntry
+
+// ErrNoHistory is returned when the vault history has no operation recorded yet
+var ErrNoHistory = openssl.ErrNoHistory
+
+// DiffOptions selects two points of the vault history, a commit, a date or a time
+type DiffOptions struct {
+	// From defaults to the point before To
+	From string
+	// To defaults to the latest operation
+	To string
+	// Stat summarizes the changed files instead of showing them
+	Stat bool
+}
+
+// History returns the last limit operations that changed the vault, newest first,
+// all of them when limit is 0. Every mutating operation commits the public state of the
+// vault, its certificates, CRLs, databases, configs and audit trail, to a git repository
+// in the PKI dir. Private keys are never committed.
+func (vault *Vault) History(ctx context.Context, limit int) ([]HistoryEntry, error) {
+	if err := vault.ready(ctx); err != nil {
+		return nil, err
+	}
+	return openssl.ReadHistory(vault.Path, limit)
+}
+
+// Diff returns the changes of the vault between two points of its history, as a git diff
+func (vault *Vault) Diff(ctx context.Context, options DiffOptions) (string, error) {
+	if err := vault.ready(ctx); err != nil {
+		return "", err
+	}
+	return openssl.DiffHistory(vault.Path, options.From, options.To, options.Stat)
+}
@@ -0,0 +1,105 @@
+package ca_test
+
+import (
+	"context"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"sfcert/internal/vaulttest"
+	"sfcert/pkg/ca"
+	"strings"
+	"testing"
+)
+
+func TestHistory(t *testing.T) {
+	if _, err := exec.LookPath("git"); err != nil {
+		t.Skip("git is not installed")
+	}
+	vault, intermediate := vaulttest.New(t)
+	ctx := context.Background()
+	issued, err := vault.Issue(ctx, intermediate.ID, ca.IssueOptions{
+		IssueRequest: ca.IssueRequest{CommonName: "history.cluster.internal", Profile: "server"},
+		Passphrase:   vaulttest.A1Passphrase,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	entries, err := vault.History(ctx, 0)
+	if err != nil {
+		t.Fatalf("History: %v", err)
+	}
+	var operations []string
+	for _, entry := range entries {
+		operations = append(operations, entry.Operation)
+		if entry.Actor == "" || entry.Failure != "" {
+			t.Errorf("history entry %+v", entry)
+		}
+		// the A1 keys are in the vault, never in its history
+		for _, file := range entry.Files {
+			if strings.Contains(file, "/private/") || strings.Contains(file, ".key") || strings.HasSuffix(file, ".p12") {
+				t.Errorf("%v committed %v", entry.Operation, file)
+			}
+		}
+	}
+	if got := strings.Join(operations, ","); got != "Issue,CreateIntermediate,CreateRoot,vault state before its history" {
+		t.Fatalf("history records %v", got)
+	}
+	if _, err := os.Stat(filepath.Join(intermediate.Dir, "private")); err != nil {
+		t.Fatalf("the A1 has no key dir to leave out of the history: %v", err)
+	}
+	err = filepath.Walk(filepath.Join(vault.Path, "history"), func(path string, info os.FileInfo, err error) error {
+		if err == nil && (info.Name() == "private" || strings.Contains(info.Name(), ".key")) {
+			t.Errorf("the history work tree holds %v", path)
+		}
+		return err
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	latest, err := vault.History(ctx, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(latest) != 1 || latest[0].Commit != entries[0].Commit {
+		t.Fatalf("History(1) returned %+v, want the Issue operation", latest)
+	}
+	diff, err := vault.Diff(ctx, ca.DiffOptions{})
+	if err != nil {
+		t.Fatalf("Diff: %v", err)
+	}
+	if !strings.Contains(diff, issued.Serial) || !strings.Contains(diff, "history.cluster.internal") {
+		t.Errorf("the diff of the Issue operation does not show the certificate:\n%v", diff)
+	}
+	stat, err := vault.Diff(ctx, ca.DiffOptions{From: entries[2].Commit, To: entries[1].Commit, Stat: true})
+	if err != nil {
+		t.Fatalf("Diff: %v", err)
+	}
+	if !strings.Contains(stat, "intermed-ca.cert.pem") || strings.Contains(stat, issued.Serial) {
+		t.Errorf("the stat of the CreateIntermediate operation is\n%v", stat)
+	}
+
+	// an operation that failed without changing the vault is not recorded
+	if _, err := vault.Issue(ctx, intermediate.ID, ca.IssueOptions{
+		IssueRequest: ca.IssueRequest{CommonName: "denied.cluster.internal", Profile: "server"},
+		Passphrase:   "not-the-a1-passphrase",
+	}); err == nil {
+		t.Fatal("a certificate was issued with a wrong passphrase")
+	}
+	if latest, err := vault.History(ctx, 1); err != nil || latest[0].Commit != entries[0].Commit {
+		t.Errorf("the failed Issue was recorded as %+v, %v", latest, err)
+	}
+
+	for _, options := range []ca.DiffOptions{{From: "not-a-commit"}, {To: "2000-01-01"}} {
+		if _, err := vault.Diff(ctx, options); err == nil {
+			t.Errorf("Diff %+v succeeded", options)
+		}
+	}
+	if err := os.RemoveAll(filepath.Join(vault.Path, "history")); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := vault.History(ctx, 0); err != ca.ErrNoHistory {
+		t.Errorf("History of a vault without history returned %v, want ErrNoHistory", err)
+	}
+}
@@ -0,0 +1,27 @@
+package ca
+
+import (
+	"context"
+	"sfcert/openssl"
+)
+
+// ImportRequest describes an existing openssl CA to adopt into the vault
+type ImportRequest = openssl.ImportRequest
+
+// ImportedCA describes a CA adopted into the vault
+type ImportedCA = openssl.ImportedCA
+
+// ImportCA adopts an existing openssl CA, a root into a vault initialized without one,
+// or an intermediate below the A0 or an A1 of the vault. From then on it is operated
+// like a CA created by create A0, create A1 or create A2.
+func (vault *Vault) ImportCA(ctx context.Context, request ImportRequest) (_ *ImportedCA, err error) {
+	ctx, unlock, err := vault.lock(ctx, "ImportCA")
+	if err != nil {
+		return nil, err
+	}
+	defer unlock(&err)
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	return openssl.ImportCA(request)
+}
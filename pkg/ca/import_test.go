@@ -0,0 +1,107 @@
+package ca_test
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"io/ioutil"
+	"math/big"
+	"os"
+	"path/filepath"
+	"sfcert/internal/vaulttest"
+	"sfcert/openssl"
+	"sfcert/pkg/ca"
+	"testing"
+	"time"
+)
+
+const importedPassphrase = "imported-passphrase"
+
+// writeExternalRoot writes the certificate and unencrypted key of a root CA made outside privki
+func writeExternalRoot(t *testing.T, dir string) (string, string) {
+	t.Helper()
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	template := &x509.Certificate{
+		SerialNumber:          big.NewInt(4242),
+		Subject:               pkix.Name{Organization: []string{"Legacy Corp"}, CommonName: "Legacy Root"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().AddDate(5, 0, 0),
+		KeyUsage:              x509.KeyUsageCertSign | x509.KeyUsageCRLSign,
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keyDER, err := x509.MarshalPKCS8PrivateKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	certFile, keyFile := filepath.Join(dir, "legacy-root.cert.pem"), filepath.Join(dir, "legacy-root.key.pem")
+	if err := ioutil.WriteFile(certFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(keyFile, pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: keyDER}), 0600); err != nil {
+		t.Fatal(err)
+	}
+	return certFile, keyFile
+}
+
+func TestImportRootCA(t *testing.T) {
+	home := vaulttest.Home(t)
+	ctx := context.Background()
+	vault, err := ca.Init(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	certFile, keyFile := writeExternalRoot(t, home)
+	request := ca.ImportRequest{CertFile: certFile, KeyFile: keyFile, Passphrase: importedPassphrase, OID: vaulttest.ClassOID}
+
+	t.Run("settings fail", func(t *testing.T) {
+		// the common name can't be saved, the OID and organization saved before it are undone
+		if err := os.MkdirAll(openssl.GetOrgCommonNameConfigFile(), 0700); err != nil {
+			t.Fatal(err)
+		}
+		defer os.Remove(openssl.GetOrgCommonNameConfigFile())
+		if _, err := vault.ImportCA(ctx, request); err == nil {
+			t.Fatal("ImportCA succeeded without the common name of the vault")
+		}
+		for _, configFile := range []string{openssl.GetOidConfigFile(), openssl.GetOrgNameConfigFile()} {
+			if _, err := os.Stat(configFile); !os.IsNotExist(err) {
+				t.Errorf("the failed import left the vault setting %v", configFile)
+			}
+		}
+		if _, err := os.Stat(openssl.RootCADir(vault.Path, vault.RootUID)); !os.IsNotExist(err) {
+			t.Error("the failed import left a Root CA directory")
+		}
+	})
+
+	imported, err := vault.ImportCA(ctx, request)
+	if err != nil {
+		t.Fatalf("ImportCA: %v", err)
+	}
+	if imported.Kind != "A0" {
+		t.Errorf("the self signed certificate was imported as %v", imported.Kind)
+	}
+	settings, err := vault.Settings(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if settings.Organization != "Legacy Corp" || settings.CommonName != "Legacy Root" || settings.CustomOID != vaulttest.ClassOID {
+		t.Errorf("the vault settings %+v do not follow the imported root", settings)
+	}
+	if _, err := vault.CreateIntermediate(ctx, ca.IntermediateOptions{
+		Organization:   "Legacy Corp",
+		Passphrase:     vaulttest.A1Passphrase,
+		RootPassphrase: importedPassphrase,
+	}); err != nil {
+		t.Errorf("the imported root does not sign an A1: %v", err)
+	}
+}
@@ -0,0 +1,124 @@
+package ca_test
+
+import (
+	"bytes"
+	"context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sfcert/internal/vaulttest"
+	"sfcert/openssl"
+	"sfcert/pkg/ca"
+	"testing"
+)
+
+// caState is the content of the CA files a signing may touch, base is root-ca or intermed-ca
+func caState(t *testing.T, caDir string, base string) map[string][]byte {
+	state := map[string][]byte{}
+	for _, name := range []string{base + ".cnf", base + ".index", base + ".serial"} {
+		content, err := ioutil.ReadFile(filepath.Join(caDir, name))
+		if err != nil {
+			t.Fatal(err)
+		}
+		state[name] = content
+	}
+	for _, dir := range []string{"certs", "newcerts"} {
+		files, err := ioutil.ReadDir(filepath.Join(caDir, dir))
+		if err != nil && !os.IsNotExist(err) {
+			t.Fatal(err)
+		}
+		for _, file := range files {
+			state[dir+"/"+file.Name()] = nil
+		}
+	}
+	return state
+}
+
+func requireCAState(t *testing.T, caDir string, base string, before map[string][]byte) {
+	t.Helper()
+	after := caState(t, caDir, base)
+	for name, content := range before {
+		if changed, found := after[name]; !found || !bytes.Equal(changed, content) {
+			t.Errorf("%v of %v changed", name, filepath.Base(caDir))
+		}
+	}
+	for name := range after {
+		if _, found := before[name]; !found {
+			t.Errorf("%v of %v was added", name, filepath.Base(caDir))
+		}
+	}
+}
+
+// requireRolledBack checks that a failed A1 creation left no A1, staging dir or archive behind
+func requireRolledBack(t *testing.T, vault *ca.Vault, intermediates int) {
+	t.Helper()
+	listed, err := vault.Intermediates(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(listed) != intermediates {
+		t.Errorf("the vault lists %d CAs after the failed creation, want %d", len(listed), intermediates)
+	}
+	if _, err := os.Stat(filepath.Join(vault.Path, vault.RootUID+"-intermed-ca")); !os.IsNotExist(err) {
+		t.Error("the staging dir of the failed A1 is left in the vault")
+	}
+	archives, _ := filepath.Glob(filepath.Join(vault.Path, "output", "*.zip"))
+	if len(archives) != intermediates {
+		t.Errorf("output holds %d archives, want %d", len(archives), intermediates)
+	}
+}
+
+func TestCreateIntermediateRollback(t *testing.T) {
+	ctx := context.Background()
+
+	t.Run("archive fails", func(t *testing.T) {
+		vault := vaulttest.NewRoot(t, true)
+		rootDir := openssl.RootCADir(vault.Path, vault.RootUID)
+		drRootDir := openssl.DRRootCADir(vault.Path, vault.RootUID)
+		root, drRoot := caState(t, rootDir, "root-ca"), caState(t, drRootDir, "root-ca")
+		// the archive is written after both Root CAs signed, output/ can't be created
+		if err := ioutil.WriteFile(filepath.Join(vault.Path, "output"), nil, 0644); err != nil {
+			t.Fatal(err)
+		}
+
+		if _, err := vault.CreateIntermediate(ctx, ca.IntermediateOptions{
+			Organization:   vaulttest.Organization,
+			Passphrase:     vaulttest.A1Passphrase,
+			RootPassphrase: vaulttest.RootPassphrase,
+		}); err == nil {
+			t.Fatal("CreateIntermediate succeeded without its archive")
+		}
+		requireCAState(t, rootDir, "root-ca", root)
+		requireCAState(t, drRootDir, "root-ca", drRoot)
+		os.Remove(filepath.Join(vault.Path, "output"))
+		requireRolledBack(t, vault, 0)
+
+		if _, err := vault.CreateIntermediate(ctx, ca.IntermediateOptions{
+			Organization:   vaulttest.Organization,
+			Passphrase:     vaulttest.A1Passphrase,
+			RootPassphrase: vaulttest.RootPassphrase,
+		}); err != nil {
+			t.Fatalf("CreateIntermediate after the rollback: %v", err)
+		}
+	})
+
+	t.Run("DR signing fails", func(t *testing.T) {
+		vault := vaulttest.NewRoot(t, true)
+		rootDir := openssl.RootCADir(vault.Path, vault.RootUID)
+		drRootDir := openssl.DRRootCADir(vault.Path, vault.RootUID)
+		root, drRoot := caState(t, rootDir, "root-ca"), caState(t, drRootDir, "root-ca")
+
+		// the A0 signs, the DR A0 refuses the passphrase
+		if _, err := vault.CreateIntermediate(ctx, ca.IntermediateOptions{
+			Organization:   vaulttest.Organization,
+			Passphrase:     vaulttest.A1Passphrase,
+			RootPassphrase: vaulttest.RootPassphrase,
+			DRPassphrase:   "wrong-dr-passphrase",
+		}); err == nil {
+			t.Fatal("CreateIntermediate succeeded with a wrong DR passphrase")
+		}
+		requireCAState(t, rootDir, "root-ca", root)
+		requireCAState(t, drRootDir, "root-ca", drRoot)
+		requireRolledBack(t, vault, 0)
+	})
+}
@@ -0,0 +1,73 @@
+package ca_test
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"sfcert/internal/vaulttest"
+	"sfcert/openssl"
+	"sfcert/pkg/ca"
+	"testing"
+)
+
+const a2Passphrase = "a2-passphrase"
+
+func TestCreateIssuingCA(t *testing.T) {
+	vault, intermediate := vaulttest.New(t)
+	ctx := context.Background()
+	issuing, err := vault.CreateIssuingCA(ctx, ca.IssuingCAOptions{
+		Parent:           intermediate.ID,
+		Organization:     vaulttest.Organization,
+		NameRestrictions: []string{"cluster.internal"},
+		Passphrase:       a2Passphrase,
+		ParentPassphrase: vaulttest.A1Passphrase,
+	})
+	if err != nil {
+		t.Fatalf("CreateIssuingCA: %v", err)
+	}
+	if issuing.Parent != intermediate.ID || issuing.Level != 2 {
+		t.Errorf("A2 has parent %q and level %d", issuing.Parent, issuing.Level)
+	}
+	if _, err := vault.Issue(ctx, issuing.ID, ca.IssueOptions{
+		IssueRequest: ca.IssueRequest{CommonName: "db01.cluster.internal", DNSNames: []string{"db01.cluster.internal"}},
+		Passphrase:   a2Passphrase,
+	}); err != nil {
+		t.Errorf("the A2 does not issue within its name restriction: %v", err)
+	}
+}
+
+func TestCreateIssuingCARollback(t *testing.T) {
+	vault, intermediate := vaulttest.New(t)
+	ctx := context.Background()
+	before := caState(t, intermediate.Dir, "intermed-ca")
+
+	// the A1 signs the A2, its chain bundle can't be written without the Root CA certificate
+	rootCertificate := filepath.Join(openssl.RootCADir(vault.Path, vault.RootUID), "root-ca.cert.pem")
+	if err := os.Rename(rootCertificate, rootCertificate+".aside"); err != nil {
+		t.Fatal(err)
+	}
+	_, err := vault.CreateIssuingCA(ctx, ca.IssuingCAOptions{
+		Parent:           intermediate.ID,
+		Organization:     vaulttest.Organization,
+		Passphrase:       a2Passphrase,
+		ParentPassphrase: vaulttest.A1Passphrase,
+	})
+	if err := os.Rename(rootCertificate+".aside", rootCertificate); err != nil {
+		t.Fatal(err)
+	}
+	if err == nil {
+		t.Fatal("CreateIssuingCA succeeded without the chain bundle of the A2")
+	}
+
+	requireCAState(t, intermediate.Dir, "intermed-ca", before)
+	listed, err := vault.Intermediates(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(listed) != 1 {
+		t.Errorf("the vault lists %d CAs after the failed A2 creation, want the A1 only", len(listed))
+	}
+	if _, err := os.Stat(filepath.Join(vault.Path, vault.RootUID+"-issuing-ca")); !os.IsNotExist(err) {
+		t.Error("the staging dir of the failed A2 is left in the vault")
+	}
+}
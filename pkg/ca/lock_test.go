@@ -0,0 +1,59 @@
+package ca_test
+
+import (
+	"context"
+	"fmt"
+	"sfcert/internal/vaulttest"
+	"sfcert/pkg/ca"
+	"sync"
+	"testing"
+)
+
+func TestConcurrentVaults(t *testing.T) {
+	_, intermediate := vaulttest.New(t)
+	ctx := context.Background()
+
+	// every goroutine opens a vault of its own, as the handlers of an embedding program would
+	var wait sync.WaitGroup
+	serials := make(chan string, 4)
+	for i := 0; i < cap(serials); i++ {
+		wait.Add(1)
+		go func(i int) {
+			defer wait.Done()
+			vault, err := ca.Open()
+			if err != nil {
+				t.Error(err)
+				return
+			}
+			issued, err := vault.Issue(ctx, intermediate.ID, ca.IssueOptions{
+				IssueRequest: ca.IssueRequest{CommonName: fmt.Sprintf("worker%d.cluster.internal", i), Profile: "client"},
+				Passphrase:   vaulttest.A1Passphrase,
+			})
+			if err != nil {
+				t.Errorf("concurrent Issue: %v", err)
+				return
+			}
+			serials <- issued.Serial
+		}(i)
+	}
+	wait.Wait()
+	close(serials)
+
+	vault, err := ca.Open()
+	if err != nil {
+		t.Fatal(err)
+	}
+	entries, err := vault.Certificates(ctx, intermediate.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	indexed := map[string]bool{}
+	for _, entry := range entries {
+		indexed[entry.Serial] = true
+	}
+	for serial := range serials {
+		if !indexed[serial] {
+			t.Errorf("certificate %v is missing from the A1 database", serial)
+		}
+	}
+}
@@ -0,0 +1,44 @@
+package ca
+
+import (
+	"context"
+	"errors"
+	"sfcert/openssl"
+)
+
+// RotateRequest describes the rotation of the passphrase of a CA key
+type RotateRequest = openssl.RotateRequest
+
+// PassphraseRotation describes the keys re-encrypted by a passphrase rotation
+type PassphraseRotation = openssl.PassphraseRotation
+
+// ErrDRPassphraseRequired is returned by operations signing with a DR Root CA key that has
+// a passphrase of its own when only the root passphrase was given
+var ErrDRPassphraseRequired = openssl.ErrDRPassphraseRequired
+
+// DRPassphraseDistinct reports whether the DR Root CA key has a passphrase of its own, operations
+// signing with it then need it besides the root passphrase
+func (vault *Vault) DRPassphraseDistinct() bool {
+	return openssl.DRPassphraseDistinct()
+}
+
+// RotatePassphrase re-encrypts the private key of a CA, a0, dr-a0 or an A1 or A2 ID, under a
+// new passphrase once the current one is verified. The A0 and DR A0 keys rotate together
+// while they share a passphrase, rotating dr-a0 alone gives the DR A0 its own passphrase.
+func (vault *Vault) RotatePassphrase(ctx context.Context, request RotateRequest) (_ *PassphraseRotation, err error) {
+	if len(request.NewPassphrase) < minPassphraseLength {
+		return nil, errors.New("the new passphrase must be at least 6 characters")
+	}
+	if request.ArchivePassphrase != "" && len(request.ArchivePassphrase) < minPassphraseLength {
+		return nil, errors.New("the A1 archive passphrase must be at least 6 characters")
+	}
+	ctx, unlock, err := vault.lock(ctx, "RotatePassphrase")
+	if err != nil {
+		return nil, err
+	}
+	defer unlock(&err)
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	return openssl.RotatePassphrase(request)
+}
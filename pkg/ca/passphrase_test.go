@@ -0,0 +1,140 @@
+package ca_test
+
+import (
+	"bytes"
+	"context"
+	"github.com/yeka/zip"
+	"io/ioutil"
+	"path/filepath"
+	"sfcert/internal/vaulttest"
+	"sfcert/openssl"
+	"sfcert/pkg/ca"
+	"strings"
+	"testing"
+)
+
+// readKeys returns the content of key files
+func readKeys(t *testing.T, keyFiles ...string) [][]byte {
+	t.Helper()
+	var keys [][]byte
+	for _, keyFile := range keyFiles {
+		key, err := ioutil.ReadFile(keyFile)
+		if err != nil {
+			t.Fatal(err)
+		}
+		keys = append(keys, key)
+	}
+	return keys
+}
+
+// readArchivedKey returns the A1 key stored in an A1 archive, decrypted with password
+func readArchivedKey(t *testing.T, archiveFile string, password string) []byte {
+	t.Helper()
+	archive, err := zip.OpenReader(archiveFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer archive.Close()
+	for _, file := range archive.File {
+		if !strings.HasSuffix(file.Name, "/private/intermed-ca.key.pem") {
+			continue
+		}
+		file.SetPassword(password)
+		reader, err := file.Open()
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer reader.Close()
+		key, err := ioutil.ReadAll(reader)
+		if err != nil {
+			t.Fatalf("the archived key does not decrypt: %v", err)
+		}
+		return key
+	}
+	t.Fatalf("%v holds no A1 key", archiveFile)
+	return nil
+}
+
+func TestRotatePassphrase(t *testing.T) {
+	vault, intermediate := newDRVault(t)
+	ctx := context.Background()
+	rootKeys := []string{
+		filepath.Join(openssl.RootCADir(vault.Path, vault.RootUID), "private", "root-ca.key.pem"),
+		filepath.Join(openssl.DRRootCADir(vault.Path, vault.RootUID), "private", "root-ca.key.pem"),
+	}
+	keys := readKeys(t, rootKeys...)
+
+	for name, request := range map[string]ca.RotateRequest{
+		"wrong passphrase": {CA: "a0", Passphrase: "not-the-root-passphrase", NewPassphrase: "rotated-root"},
+		"short passphrase": {CA: "a0", Passphrase: vaulttest.RootPassphrase, NewPassphrase: "short"},
+		"same passphrase":  {CA: "a0", Passphrase: vaulttest.RootPassphrase, NewPassphrase: vaulttest.RootPassphrase},
+		"unknown CA":       {CA: "20200722174505Z", Passphrase: vaulttest.RootPassphrase, NewPassphrase: "rotated-root"},
+	} {
+		if _, err := vault.RotatePassphrase(ctx, request); err == nil {
+			t.Errorf("%v: the passphrase was rotated", name)
+		}
+	}
+	for i, key := range readKeys(t, rootKeys...) {
+		if !bytes.Equal(key, keys[i]) {
+			t.Fatalf("a failed rotation changed %v", rootKeys[i])
+		}
+	}
+
+	// the A0 and DR A0 share their passphrase and rotate together
+	rotation, err := vault.RotatePassphrase(ctx, ca.RotateRequest{CA: "a0", Passphrase: vaulttest.RootPassphrase, NewPassphrase: "rotated-root"})
+	if err != nil {
+		t.Fatalf("RotatePassphrase a0: %v", err)
+	}
+	if strings.Join(rotation.CAs, ",") != "a0,dr-a0" || rotation.DRPassphraseDistinct || vault.DRPassphraseDistinct() {
+		t.Fatalf("rotating a0 returned %+v", rotation)
+	}
+	for i, key := range readKeys(t, rootKeys...) {
+		if bytes.Equal(key, keys[i]) {
+			t.Errorf("%v was not re-encrypted", rootKeys[i])
+		}
+	}
+	if _, err := vault.CreateIntermediate(ctx, ca.IntermediateOptions{Organization: vaulttest.Organization, Passphrase: vaulttest.A1Passphrase, RootPassphrase: vaulttest.RootPassphrase}); err == nil {
+		t.Fatal("an A1 was signed with the former root passphrase")
+	}
+	if _, err := vault.CreateIntermediate(ctx, ca.IntermediateOptions{Organization: vaulttest.Organization, NameRestriction: "a.vault.test", Passphrase: vaulttest.A1Passphrase, RootPassphrase: "rotated-root"}); err != nil {
+		t.Fatalf("CreateIntermediate with the rotated root passphrase: %v", err)
+	}
+
+	// rotating the DR A0 alone gives it a passphrase of its own
+	rotation, err = vault.RotatePassphrase(ctx, ca.RotateRequest{CA: "dr-a0", Passphrase: "rotated-root", NewPassphrase: "rotated-dr"})
+	if err != nil {
+		t.Fatalf("RotatePassphrase dr-a0: %v", err)
+	}
+	if strings.Join(rotation.CAs, ",") != "dr-a0" || !rotation.DRPassphraseDistinct || !vault.DRPassphraseDistinct() {
+		t.Fatalf("rotating dr-a0 returned %+v", rotation)
+	}
+	options := ca.IntermediateOptions{Organization: vaulttest.Organization, NameRestriction: "b.vault.test", Passphrase: vaulttest.A1Passphrase, RootPassphrase: "rotated-root"}
+	if _, err := vault.CreateIntermediate(ctx, options); err != ca.ErrDRPassphraseRequired {
+		t.Fatalf("CreateIntermediate without the DR passphrase returned %v, want ErrDRPassphraseRequired", err)
+	}
+	options.DRPassphrase = "rotated-dr"
+	if _, err := vault.CreateIntermediate(ctx, options); err != nil {
+		t.Fatalf("CreateIntermediate with the DR passphrase: %v", err)
+	}
+
+	// the A1 archive is rebuilt with the re-encrypted key
+	rotation, err = vault.RotatePassphrase(ctx, ca.RotateRequest{CA: intermediate.ID, Passphrase: vaulttest.A1Passphrase, NewPassphrase: "rotated-a1", ArchivePassphrase: "archive-a1"})
+	if err != nil {
+		t.Fatalf("RotatePassphrase %v: %v", intermediate.ID, err)
+	}
+	if len(rotation.Archives) != 1 {
+		t.Fatalf("rotating the A1 rebuilt the archives %v", rotation.Archives)
+	}
+	key := readKeys(t, filepath.Join(intermediate.Dir, "private", "intermed-ca.key.pem"))[0]
+	if archived := readArchivedKey(t, rotation.Archives[0], "archive-a1"); !bytes.Equal(archived, key) {
+		t.Error("the rebuilt archive does not hold the re-encrypted A1 key")
+	}
+	issue := ca.IssueOptions{IssueRequest: ca.IssueRequest{CommonName: "rotated.cluster.internal", Profile: "server"}, Passphrase: vaulttest.A1Passphrase}
+	if _, err := vault.Issue(ctx, intermediate.ID, issue); err == nil {
+		t.Fatal("a certificate was issued with the former A1 passphrase")
+	}
+	issue.Passphrase = "rotated-a1"
+	if _, err := vault.Issue(ctx, intermediate.ID, issue); err != nil {
+		t.Fatalf("Issue with the rotated A1 passphrase: %v", err)
+	}
+}
@@ -0,0 +1,67 @@
+package ca
+
+import (
+	"context"
+	"errors"
+	"sfcert/openssl"
+)
+
+// Profile is a named leaf certificate profile of the vault
+type Profile = openssl.Profile
+
+// Settings are the vault wide settings recorded when the Root CA (A0) was created
+type Settings struct {
+	Organization string
+	CommonName   string
+	CustomOID    string
+	WithDR       bool
+}
+
+// Settings returns the vault wide settings, the vault must have a Root CA (A0)
+func (vault *Vault) Settings(ctx context.Context) (*Settings, error) {
+	if err := vault.ready(ctx); err != nil {
+		return nil, err
+	}
+	if vault.Subordinate() {
+		return nil, ErrSubordinateVault
+	}
+	if _, err := vault.Roots(); err != nil {
+		return nil, err
+	}
+	settings := &Settings{WithDR: vault.DREnabled()}
+	var err error
+	if settings.Organization, err = openssl.GetOrganizationName(); err != nil {
+		return nil, err
+	}
+	if settings.CommonName, err = openssl.GetOrganizationCommonName(); err != nil {
+		return nil, err
+	}
+	if settings.CustomOID, err = openssl.GetOid(); err != nil {
+		return nil, err
+	}
+	return settings, nil
+}
+
+// Profiles lists the named leaf profiles of the vault
+func (vault *Vault) Profiles(ctx context.Context) ([]Profile, error) {
+	if err := vault.ready(ctx); err != nil {
+		return nil, err
+	}
+	return openssl.ReadProfiles(vault.Path)
+}
+
+// SaveProfile adds a named leaf profile to the vault, or replaces the one with its name
+func (vault *Vault) SaveProfile(ctx context.Context, profile Profile) (err error) {
+	if profile.Name == "" {
+		return errors.New("a profile needs a name")
+	}
+	ctx, unlock, err := vault.lock(ctx, "SaveProfile")
+	if err != nil {
+		return err
+	}
+	defer unlock(&err)
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	return openssl.SaveProfile(vault.Path, profile)
+}
@@ -0,0 +1,66 @@
+package ca
+
+import (
+	"context"
+	"errors"
+	"sfcert/openssl"
+)
+
+var (
+	// ErrVaultSealed is returned by Open and every operation while the vault is encrypted and locked
+	ErrVaultSealed = openssl.ErrVaultSealed
+	// ErrVaultNotEncrypted is returned by Lock and Unlock on a vault that is not encrypted
+	ErrVaultNotEncrypted = openssl.ErrVaultNotEncrypted
+	// ErrWrongVaultPassphrase is returned by Unlock when the passphrase does not decrypt the vault
+	ErrWrongVaultPassphrase = openssl.ErrWrongVaultPassphrase
+)
+
+// Encrypted reports whether the vault of this host is encrypted at rest, locked or not
+func Encrypted() bool {
+	return openssl.VaultEncrypted()
+}
+
+// Encrypt seals the PKI dir of this host under a master key derived from passphrase and
+// removes its plaintext, the vault then has to be unlocked before any other operation.
+// On an unlocked encrypted vault it changes the passphrase.
+func Encrypt(ctx context.Context, passphrase string) (err error) {
+	if len(passphrase) < minPassphraseLength {
+		return errors.New("the vault passphrase must be at least 6 characters")
+	}
+	ctx, unlock, err := openssl.LockVault(ctx, "Encrypt")
+	if err != nil {
+		return err
+	}
+	defer unlock(&err)
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	return openssl.EncryptVault(passphrase)
+}
+
+// Unlock decrypts the sealed vault of this host into its PKI dir, where every operation
+// works on it until Lock. decrypt turns the encryption of the vault off instead.
+func Unlock(ctx context.Context, passphrase string, decrypt bool) (err error) {
+	ctx, unlock, err := openssl.LockVault(ctx, "Unlock")
+	if err != nil {
+		return err
+	}
+	defer unlock(&err)
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	return openssl.UnsealVault(passphrase, decrypt)
+}
+
+// Lock seals the PKI dir of an unlocked vault again and removes its plaintext
+func Lock(ctx context.Context) (err error) {
+	ctx, unlock, err := openssl.LockVault(ctx, "Lock")
+	if err != nil {
+		return err
+	}
+	defer unlock(&err)
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	return openssl.SealVault()
+}
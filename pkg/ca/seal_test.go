@@ -0,0 +1,95 @@
+package ca_test
+
+import (
+	"context"
+	"sfcert/internal/vaulttest"
+	"sfcert/pkg/ca"
+	"testing"
+)
+
+const vaultPassphrase = "vault-passphrase"
+
+func TestSealRoundTrip(t *testing.T) {
+	vault, intermediate := vaulttest.New(t)
+	ctx := context.Background()
+	issued, err := vault.Issue(ctx, intermediate.ID, ca.IssueOptions{
+		IssueRequest: ca.IssueRequest{CommonName: "sealed.cluster.internal", Profile: "client"},
+		Passphrase:   vaulttest.A1Passphrase,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := ca.Encrypt(ctx, vaultPassphrase); err != nil {
+		t.Fatalf("Encrypt: %v", err)
+	}
+	if !ca.Encrypted() {
+		t.Error("the vault is not encrypted")
+	}
+	if _, err := ca.Open(); err != ca.ErrVaultSealed {
+		t.Fatalf("Open on the sealed vault returned %v, want ErrVaultSealed", err)
+	}
+
+	if err := ca.Unlock(ctx, "wrong-passphrase", false); err != ca.ErrWrongVaultPassphrase {
+		t.Fatalf("Unlock with a wrong passphrase returned %v, want ErrWrongVaultPassphrase", err)
+	}
+	if err := ca.Unlock(ctx, vaultPassphrase, false); err != nil {
+		t.Fatalf("Unlock: %v", err)
+	}
+	unlocked, err := ca.Open()
+	if err != nil {
+		t.Fatalf("Open on the unlocked vault: %v", err)
+	}
+	if _, err := unlocked.InspectCertificate(ctx, intermediate.ID, issued.Serial); err != nil {
+		t.Errorf("the certificate issued before sealing is gone: %v", err)
+	}
+
+	if err := ca.Lock(ctx); err != nil {
+		t.Fatalf("Lock: %v", err)
+	}
+	if _, err := ca.Open(); err != ca.ErrVaultSealed {
+		t.Fatalf("Open on the locked vault returned %v, want ErrVaultSealed", err)
+	}
+
+	if err := ca.Unlock(ctx, vaultPassphrase, true); err != nil {
+		t.Fatalf("Unlock to decrypt: %v", err)
+	}
+	if ca.Encrypted() {
+		t.Error("the vault is still encrypted after decrypting it")
+	}
+	if err := ca.Lock(ctx); err != ca.ErrVaultNotEncrypted {
+		t.Errorf("Lock on a decrypted vault returned %v, want ErrVaultNotEncrypted", err)
+	}
+}
+
+func TestSealedVaultOperations(t *testing.T) {
+	vault, intermediate := vaulttest.New(t)
+	ctx := context.Background()
+	if err := ca.Encrypt(ctx, vaultPassphrase); err != nil {
+		t.Fatalf("Encrypt: %v", err)
+	}
+
+	// vault was opened before the seal, as by privki serve
+	if _, err := vault.Intermediates(ctx); err != ca.ErrVaultSealed {
+		t.Errorf("Intermediates returned %v, want ErrVaultSealed", err)
+	}
+	if _, err := vault.Certificates(ctx, intermediate.ID); err != ca.ErrVaultSealed {
+		t.Errorf("Certificates returned %v, want ErrVaultSealed", err)
+	}
+	if _, err := vault.Issue(ctx, intermediate.ID, ca.IssueOptions{
+		IssueRequest: ca.IssueRequest{CommonName: "sealed.cluster.internal", Profile: "client"},
+		Passphrase:   vaulttest.A1Passphrase,
+	}); err != ca.ErrVaultSealed {
+		t.Errorf("Issue returned %v, want ErrVaultSealed", err)
+	}
+	if _, err := vault.CreateIntermediate(ctx, ca.IntermediateOptions{
+		Organization:   vaulttest.Organization,
+		Passphrase:     vaulttest.A1Passphrase,
+		RootPassphrase: vaulttest.RootPassphrase,
+	}); err != ca.ErrVaultSealed {
+		t.Errorf("CreateIntermediate returned %v, want ErrVaultSealed", err)
+	}
+	if _, err := vault.Roots(); err != ca.ErrVaultSealed {
+		t.Errorf("Roots returned %v, want ErrVaultSealed", err)
+	}
+}
@@ -0,0 +1,531 @@
+// Package ca is the importable Go API of privki.
+//
+// A Vault wraps the PKI repository of a host, its Root CA (A0), optional
+// DR Root CA (DR A0) and Intermediary CAs (A1). Nothing in this package
+// prompts for input or exits the process, every failure is returned as
+// an error. Operations take a context which is checked before each step
+// that touches the vault, cancelling it also stops the openssl command a
+// mutating operation is running.
+package ca
+
+import (
+	"context"
+	"crypto/x509"
+	"errors"
+	"path/filepath"
+	"sfcert/openssl"
+	"time"
+)
+
+// Intermediate describes an Intermediary CA (A1) of the vault
+type Intermediate = openssl.Intermediate
+
+// IndexEntry is the database record of a certificate issued by an A1
+type IndexEntry = openssl.IndexEntry
+
+// CertificateInfo is a JSON friendly summary of a certificate
+type CertificateInfo = openssl.CertificateInfo
+
+// IssueRequest describes a leaf certificate to be issued by an A1
+type IssueRequest = openssl.IssueRequest
+
+// IssuedCertificate is the result of an issuance or CSR signing operation
+type IssuedCertificate = openssl.IssuedCertificate
+
+// VaultLockedError is returned when another process keeps the vault locked, it names the holder
+type VaultLockedError = openssl.VaultLockedError
+
+var (
+	// ErrVaultExists is returned by Init and InitSubordinate when the host already has a vault
+	ErrVaultExists = openssl.ErrVaultExists
+	// ErrVaultNotInitialized is returned by Open when the host has no vault
+	ErrVaultNotInitialized = openssl.ErrVaultNotInitialized
+	// ErrUnknownCA is returned when an A1 ID is not part of the vault
+	ErrUnknownCA = openssl.ErrUnknownCA
+	// ErrUnknownCertificate is returned when a serial is not in an A1 database
+	ErrUnknownCertificate = openssl.ErrUnknownCertificate
+	// ErrSubordinateVault is returned by operations that need the Root CA (A0) keys on a subordinate vault
+	ErrSubordinateVault = openssl.ErrSubordinateVault
+	// ErrExternalKey is returned by operations that need the key of an A1 signed from a partner CSR
+	ErrExternalKey = openssl.ErrExternalKey
+)
+
+// minPassphraseLength matches the minimum accepted by the privki command line
+const minPassphraseLength = 6
+
+// Vault is the PKI repository of this host
+type Vault struct {
+	Path    string
+	RootUID string
+}
+
+// lock serializes a mutating operation with the other operations of this process and
+// with the other privki processes through the vault lock. The operations called with
+// the returned context share the lock.
+func (vault *Vault) lock(ctx context.Context, operation string) (context.Context, openssl.VaultUnlock, error) {
+	ctx, unlock, err := openssl.LockVault(ctx, operation)
+	if err != nil {
+		return nil, nil, err
+	}
+	if openssl.VaultSealed(vault.Path) {
+		err := ErrVaultSealed
+		unlock(&err)
+		return nil, nil, err
+	}
+	return ctx, unlock, nil
+}
+
+// ready returns the error of a cancelled ctx, or ErrVaultSealed when the vault was
+// locked after it was opened
+func (vault *Vault) ready(ctx context.Context) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	if openssl.VaultSealed(vault.Path) {
+		return ErrVaultSealed
+	}
+	return nil
+}
+
+// RootOptions configures the creation of the Root CA (A0)
+type RootOptions struct {
+	Organization string
+	CommonName   string
+	// CustomOID defaults to openssl.DefaultOID when empty
+	CustomOID  string
+	Passphrase string
+	// WithDR also creates the DR Root CA (DR A0), protected by the same passphrase
+	WithDR bool
+}
+
+// IntermediateOptions configures the creation of an Intermediary CA (A1)
+type IntermediateOptions struct {
+	Organization string
+	// NameRestriction limits the A1 to a DNS domain, empty means unrestricted
+	NameRestriction string
+	Passphrase      string
+	RootPassphrase  string
+	// DRPassphrase unlocks the DR Root CA key when it has its own, empty when it shares RootPassphrase
+	DRPassphrase string
+	// ArchivePassphrase encrypts the A1 archive in output/, defaults to Passphrase
+	ArchivePassphrase string
+	// PathLen is the pathLenConstraint of the A1, nil keeps openssl.DefaultA1PathLen
+	PathLen *int
+	// Days is the validity of the A1, 0 means openssl.DefaultA1Years
+	Days int
+	// KeyAlgorithm is rsa:<bits> or ec:<curve>, empty keeps the default RSA key
+	KeyAlgorithm string
+}
+
+// IssuingCAOptions configures the creation of an issuing CA (A2) below an A1
+type IssuingCAOptions struct {
+	// Parent is the ID of the A1 that signs the A2
+	Parent       string
+	Organization string
+	// NameRestrictions must be within the ones of the A1, empty inherits them
+	NameRestrictions []string
+	// PathLen is the pathLenConstraint of the A2, 0 lets it only issue end entity certificates
+	PathLen          int
+	Passphrase       string
+	ParentPassphrase string
+}
+
+// IssueOptions describes a leaf certificate and the A1 passphrase to issue it with
+type IssueOptions struct {
+	IssueRequest
+	Passphrase string `json:"passphrase"`
+}
+
+// SignOptions describes a CSR signing operation
+type SignOptions struct {
+	CSR        []byte
+	Profile    string
+	Days       int
+	Passphrase string
+}
+
+// RevokeOptions describes a revocation, Reason defaults to unspecified. The
+// certificateHold reason, and releasing a hold, need a Comment for the audit trail.
+type RevokeOptions struct {
+	Serial     string
+	Reason     string
+	Passphrase string
+	Comment    string
+	// Actor is recorded in the audit trail, the local user and host by default
+	Actor string
+}
+
+// Open returns the vault initialized on this host
+func Open() (*Vault, error) {
+	pkiPath, err := openssl.GetPkiPath()
+	if err != nil {
+		return nil, err
+	}
+	rootUID, err := openssl.GetRootUID()
+	if err != nil {
+		return nil, err
+	}
+	return &Vault{Path: pkiPath, RootUID: rootUID}, nil
+}
+
+// Init checks the host openssl installation and initializes a new, empty vault
+func Init(ctx context.Context) (*Vault, error) {
+	if err := openssl.CheckOpenSSL(); err != nil {
+		return nil, err
+	}
+	if err := openssl.CheckAES256Cipher(); err != nil {
+		return nil, err
+	}
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	if err := openssl.InitPki(); err != nil {
+		return nil, err
+	}
+	return Open()
+}
+
+// Subordinate reports whether the vault only holds A1s handed over from the
+// root vault, without the Root CA (A0) keys.
+func (vault *Vault) Subordinate() bool {
+	return openssl.VaultMode() == openssl.VaultModeSubordinate
+}
+
+// DREnabled reports whether the vault has a DR Root CA (DR A0)
+func (vault *Vault) DREnabled() bool {
+	return openssl.DREnabled()
+}
+
+// CreateRoot records the vault wide settings and creates the Root CA (A0),
+// followed by the DR Root CA (DR A0) when options.WithDR is set.
+func (vault *Vault) CreateRoot(ctx context.Context, options RootOptions) (err error) {
+	if options.Organization == "" {
+		return errors.New("an organization name is required for the Root CA")
+	}
+	if options.CommonName == "" {
+		return errors.New("a common name is required for the Root CA")
+	}
+	if len(options.Passphrase) < minPassphraseLength {
+		return errors.New("the Root CA passphrase must be at least 6 characters")
+	}
+	if options.CustomOID == "" {
+		options.CustomOID = openssl.DefaultOID
+	}
+	if err := openssl.RequireRootVault(); err != nil {
+		return err
+	}
+
+	ctx, unlock, err := vault.lock(ctx, "CreateRoot")
+	if err != nil {
+		return err
+	}
+	defer unlock(&err)
+
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	if err := openssl.SetCustomOid(options.CustomOID); err != nil {
+		return err
+	}
+	if err := openssl.SetOrganizationName(options.Organization); err != nil {
+		return err
+	}
+	if err := openssl.SetOrganizationCommonName(options.CommonName); err != nil {
+		return err
+	}
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	if err := openssl.CreateRootCA(options.Passphrase); err != nil {
+		return err
+	}
+	if !options.WithDR {
+		return nil
+	}
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	return openssl.CreateDRRootCA(options.Passphrase)
+}
+
+// CreateIntermediate creates a new Intermediary CA (A1) signed by the Root CA,
+// and cross signed by the DR Root CA when DR is enabled.
+func (vault *Vault) CreateIntermediate(ctx context.Context, options IntermediateOptions) (*Intermediate, error) {
+	if len(options.Passphrase) < minPassphraseLength {
+		return nil, errors.New("the A1 passphrase must be at least 6 characters")
+	}
+	nameRestriction := options.NameRestriction
+	if nameRestriction == "" {
+		nameRestriction = "NA"
+	}
+	if options.ArchivePassphrase == "" {
+		options.ArchivePassphrase = options.Passphrase
+	}
+	pathLen := openssl.DefaultA1PathLen
+	if options.PathLen != nil {
+		pathLen = *options.PathLen
+	}
+
+	ctx, unlock, err := vault.lock(ctx, "CreateIntermediate")
+	if err != nil {
+		return nil, err
+	}
+	if err := ctx.Err(); err != nil {
+		unlock(&err)
+		return nil, err
+	}
+	id, err := openssl.CreateIntermediateCA(nameRestriction, options.Organization, pathLen, options.Days, options.KeyAlgorithm, options.Passphrase, options.RootPassphrase, options.DRPassphrase, options.ArchivePassphrase)
+	unlock(&err)
+	if err != nil {
+		return nil, err
+	}
+	return vault.Intermediate(ctx, id)
+}
+
+// ValidateKeyAlgorithm checks an A1 key algorithm, rsa:<bits> or ec:<curve>
+func ValidateKeyAlgorithm(keyAlgorithm string) error {
+	return openssl.ValidateKeyAlgorithm(keyAlgorithm)
+}
+
+// CreateIntermediateFromCSR signs a PEM A1 certificate request generated outside the vault,
+// the A1 key stays with its owner and the A1 is marked with ExternalKey. drPassphrase unlocks
+// the DR Root CA key when it has its own, empty when it shares rootPassphrase.
+func (vault *Vault) CreateIntermediateFromCSR(ctx context.Context, csr []byte, nameRestriction string, pathLen int, rootPassphrase string, drPassphrase string) (*Intermediate, error) {
+	ctx, unlock, err := vault.lock(ctx, "CreateIntermediateFromCSR")
+	if err != nil {
+		return nil, err
+	}
+	if err := ctx.Err(); err != nil {
+		unlock(&err)
+		return nil, err
+	}
+	id, err := openssl.CreateIntermediateFromCSR(csr, nameRestriction, pathLen, rootPassphrase, drPassphrase)
+	unlock(&err)
+	if err != nil {
+		return nil, err
+	}
+	return vault.Intermediate(ctx, id)
+}
+
+// CreateIssuingCA creates a new issuing CA (A2) signed by an A1 of the vault
+func (vault *Vault) CreateIssuingCA(ctx context.Context, options IssuingCAOptions) (*Intermediate, error) {
+	if len(options.Passphrase) < minPassphraseLength {
+		return nil, errors.New("the A2 passphrase must be at least 6 characters")
+	}
+
+	ctx, unlock, err := vault.lock(ctx, "CreateIssuingCA")
+	if err != nil {
+		return nil, err
+	}
+	if err := ctx.Err(); err != nil {
+		unlock(&err)
+		return nil, err
+	}
+	id, err := openssl.CreateIssuingCA(options.Parent, options.Organization, options.NameRestrictions, options.PathLen, options.Passphrase, options.ParentPassphrase)
+	unlock(&err)
+	if err != nil {
+		return nil, err
+	}
+	return vault.Intermediate(ctx, id)
+}
+
+// Intermediates lists the Intermediary CAs (A1) and issuing CAs (A2) of the vault
+func (vault *Vault) Intermediates(ctx context.Context) ([]Intermediate, error) {
+	if err := vault.ready(ctx); err != nil {
+		return nil, err
+	}
+	return openssl.ListIntermediates(vault.Path, vault.RootUID)
+}
+
+// Intermediate looks up an Intermediary CA (A1) or issuing CA (A2) by its ID
+func (vault *Vault) Intermediate(ctx context.Context, id string) (*Intermediate, error) {
+	if err := vault.ready(ctx); err != nil {
+		return nil, err
+	}
+	return openssl.FindIntermediate(vault.Path, vault.RootUID, id)
+}
+
+// InspectIntermediate returns the certificate details of an A1
+func (vault *Vault) InspectIntermediate(ctx context.Context, id string) (*CertificateInfo, error) {
+	intermediate, err := vault.Intermediate(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+	cert, err := openssl.ReadCertificate(filepath.Join(intermediate.Dir, "intermed-ca.cert.pem"))
+	if err != nil {
+		return nil, err
+	}
+	return openssl.DescribeCertificate(cert), nil
+}
+
+// Certificates lists the database entries of the certificates issued by an A1
+func (vault *Vault) Certificates(ctx context.Context, id string) ([]IndexEntry, error) {
+	intermediate, err := vault.Intermediate(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+	if intermediate.ExternalKey {
+		return nil, ErrExternalKey
+	}
+	return openssl.CertificateEntries(intermediate.Dir)
+}
+
+// InspectCertificate returns the details and status of a certificate issued by an A1
+func (vault *Vault) InspectCertificate(ctx context.Context, id string, serial string) (*CertificateInfo, error) {
+	intermediate, err := vault.Intermediate(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+	return openssl.InspectIssued(intermediate.Dir, serial)
+}
+
+// Issue generates a key pair and a certificate signed by an A1,
+// the private key is returned and not retained in the vault.
+func (vault *Vault) Issue(ctx context.Context, id string, options IssueOptions) (_ *IssuedCertificate, err error) {
+	intermediate, err := vault.Intermediate(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+	if intermediate.ExternalKey {
+		return nil, ErrExternalKey
+	}
+	ctx, unlock, err := vault.lock(ctx, "Issue")
+	if err != nil {
+		return nil, err
+	}
+	defer unlock(&err)
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	return openssl.IssueCertificate(intermediate.Dir, options.IssueRequest, options.Passphrase)
+}
+
+// SignCSR signs a PEM certificate signing request with an A1
+func (vault *Vault) SignCSR(ctx context.Context, id string, options SignOptions) (_ *IssuedCertificate, err error) {
+	intermediate, err := vault.Intermediate(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+	if intermediate.ExternalKey {
+		return nil, ErrExternalKey
+	}
+	ctx, unlock, err := vault.lock(ctx, "SignCSR")
+	if err != nil {
+		return nil, err
+	}
+	defer unlock(&err)
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	return openssl.SignCertificateRequest(intermediate.Dir, options.CSR, options.Profile, options.Days, options.Passphrase)
+}
+
+// Revoke revokes a certificate issued by an A1 and regenerates its CRL
+func (vault *Vault) Revoke(ctx context.Context, id string, options RevokeOptions) (err error) {
+	intermediate, err := vault.Intermediate(ctx, id)
+	if err != nil {
+		return err
+	}
+	if options.Reason == "" {
+		options.Reason = "unspecified"
+	}
+	if intermediate.ExternalKey {
+		return ErrExternalKey
+	}
+	ctx, unlock, err := vault.lock(ctx, "Revoke")
+	if err != nil {
+		return err
+	}
+	defer unlock(&err)
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	return openssl.RevokeCertificate(intermediate.Dir, openssl.RevocationRequest{
+		Serial:  options.Serial,
+		Reason:  options.Reason,
+		Comment: options.Comment,
+		Actor:   options.Actor,
+	}, options.Passphrase)
+}
+
+// Unhold releases a certificate put on hold with the certificateHold reason,
+// it is valid again and its CRL is regenerated. options.Reason is ignored.
+func (vault *Vault) Unhold(ctx context.Context, id string, options RevokeOptions) (err error) {
+	intermediate, err := vault.Intermediate(ctx, id)
+	if err != nil {
+		return err
+	}
+	if intermediate.ExternalKey {
+		return ErrExternalKey
+	}
+	ctx, unlock, err := vault.lock(ctx, "Unhold")
+	if err != nil {
+		return err
+	}
+	defer unlock(&err)
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	return openssl.ReleaseCertificate(intermediate.Dir, openssl.RevocationRequest{
+		Serial:  options.Serial,
+		Comment: options.Comment,
+		Actor:   options.Actor,
+	}, options.Passphrase)
+}
+
+// AuditEntry records who changed the status of a certificate, and why
+type AuditEntry = openssl.AuditEntry
+
+// AuditLog returns the audit trail of the vault, oldest first
+func (vault *Vault) AuditLog(ctx context.Context) ([]AuditEntry, error) {
+	if err := vault.ready(ctx); err != nil {
+		return nil, err
+	}
+	return openssl.ReadAuditLog(vault.Path)
+}
+
+// CRL returns the current PEM encoded revocation list of an A1
+func (vault *Vault) CRL(ctx context.Context, id string) ([]byte, error) {
+	intermediate, err := vault.Intermediate(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+	return openssl.ReadCRL(intermediate.Dir)
+}
+
+// Chain returns the PEM chain of an A1 up to the Root CA,
+// or up to the DR Root CA when dr is set.
+func (vault *Vault) Chain(ctx context.Context, id string, dr bool) ([]byte, error) {
+	intermediate, err := vault.Intermediate(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+	return openssl.IntermediateChain(vault.Path, vault.RootUID, intermediate, dr)
+}
+
+// Roots returns the Root CA certificate, and the DR Root CA certificate if there is one
+func (vault *Vault) Roots() ([]*x509.Certificate, error) {
+	if openssl.VaultSealed(vault.Path) {
+		return nil, ErrVaultSealed
+	}
+	var roots []*x509.Certificate
+	for _, rootDir := range []string{
+		openssl.RootCADir(vault.Path, vault.RootUID),
+		openssl.DRRootCADir(vault.Path, vault.RootUID),
+	} {
+		rootCert, err := openssl.ReadCertificate(filepath.Join(rootDir, "root-ca.cert.pem"))
+		if err != nil {
+			continue
+		}
+		roots = append(roots, rootCert)
+	}
+	if len(roots) == 0 {
+		return nil, errors.New("no Root CA (A0) certificate found in the vault")
+	}
+	return roots, nil
+}
+
+// LastBackup returns the time of the last privki backup, zero when none was recorded
+func (vault *Vault) LastBackup() (time.Time, error) {
+	return openssl.LastBackup()
+}
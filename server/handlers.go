@@ -0,0 +1,294 @@
+package server
+
+import (
+	"encoding/json"
+	log "github.com/sirupsen/logrus"
+	"net/http"
+	"sfcert/pkg/ca"
+	"strings"
+)
+
+// signRequest is the body of POST /v1/intermediates/{id}/sign
+type signRequest struct {
+	CSR        string `json:"csr"`
+	Profile    string `json:"profile"`
+	Days       int    `json:"days"`
+	Passphrase string `json:"passphrase"`
+}
+
+// revokeRequest is the body of POST /v1/intermediates/{id}/certificates/{serial}/revoke
+// and of POST /v1/intermediates/{id}/certificates/{serial}/unhold
+type revokeRequest struct {
+	Reason     string `json:"reason"`
+	Comment    string `json:"comment"`
+	Passphrase string `json:"passphrase"`
+}
+
+// route is the parsed form of a request path
+//
+//	/v1/intermediates
+//	/v1/intermediates/{id}
+//	/v1/intermediates/{id}/{crl|chain|sign|certificates}
+//	/v1/intermediates/{id}/certificates/{serial}
+//	/v1/intermediates/{id}/certificates/{serial}/{revoke|unhold}
+type route struct {
+	intermediateID string
+	resource       string
+	serial         string
+	action         string
+}
+
+func (server *Server) routes() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/v1/intermediates", server.handle)
+	mux.HandleFunc("/v1/intermediates/", server.handle)
+	return mux
+}
+
+func (server *Server) handle(writer http.ResponseWriter, request *http.Request) {
+	segments := strings.Split(strings.Trim(strings.TrimPrefix(request.URL.Path, "/v1/intermediates"), "/"), "/")
+	var current route
+	if segments[0] != "" {
+		current.intermediateID = segments[0]
+	}
+	if len(segments) > 1 {
+		current.resource = segments[1]
+	}
+	if len(segments) > 2 {
+		current.serial = segments[2]
+	}
+	if len(segments) > 3 {
+		current.action = segments[3]
+	}
+	if len(segments) > 4 {
+		writeError(writer, http.StatusNotFound, "not found")
+		return
+	}
+
+	operation, handler := server.dispatch(request.Method, current)
+	if handler == nil {
+		writeError(writer, http.StatusNotFound, "not found")
+		return
+	}
+
+	client := request.TLS.PeerCertificates[0]
+	logger := log.WithFields(log.Fields{
+		"client":    client.Subject.String(),
+		"operation": operation,
+		"a1":        current.intermediateID,
+	})
+	if !server.config.Policy.Allowed(operation, current.intermediateID, client) {
+		logger.Warn("operation denied by policy")
+		writeError(writer, http.StatusForbidden, "operation not permitted for this client certificate")
+		return
+	}
+
+	var intermediate *ca.Intermediate
+	if current.intermediateID != "" {
+		found, err := server.config.Vault.Intermediate(request.Context(), current.intermediateID)
+		if err == ca.ErrUnknownCA {
+			writeError(writer, http.StatusNotFound, err.Error())
+			return
+		} else if err != nil {
+			writeError(writer, failureStatus(err), err.Error())
+			return
+		}
+		intermediate = found
+	}
+
+	logger.Info("operation authorized")
+	handler(writer, request, intermediate, current)
+}
+
+type handlerFunc func(http.ResponseWriter, *http.Request, *ca.Intermediate, route)
+
+// dispatch maps a method and route onto the policy operation and its handler
+func (server *Server) dispatch(method string, current route) (string, handlerFunc) {
+	switch {
+	case current.intermediateID == "" && method == http.MethodGet:
+		return OperationList, server.listIntermediates
+	case current.resource == "" && current.intermediateID != "" && method == http.MethodGet:
+		return OperationInspect, server.inspectIntermediate
+	case current.resource == "crl" && current.serial == "" && method == http.MethodGet:
+		return OperationCRL, server.fetchCRL
+	case current.resource == "chain" && current.serial == "" && method == http.MethodGet:
+		return OperationChain, server.fetchChain
+	case current.resource == "sign" && current.serial == "" && method == http.MethodPost:
+		return OperationSign, server.signCSR
+	case current.resource == "certificates" && current.serial == "" && method == http.MethodGet:
+		return OperationList, server.listCertificates
+	case current.resource == "certificates" && current.serial == "" && method == http.MethodPost:
+		return OperationIssue, server.issueCertificate
+	case current.resource == "certificates" && current.serial != "" && current.action == "" && method == http.MethodGet:
+		return OperationInspect, server.inspectCertificate
+	case current.resource == "certificates" && current.action == "revoke" && method == http.MethodPost:
+		return OperationRevoke, server.revokeCertificate
+	case current.resource == "certificates" && current.action == "unhold" && method == http.MethodPost:
+		return OperationRevoke, server.unholdCertificate
+	}
+	return "", nil
+}
+
+func (server *Server) listIntermediates(writer http.ResponseWriter, request *http.Request, _ *ca.Intermediate, _ route) {
+	intermediates, err := server.config.Vault.Intermediates(request.Context())
+	if err != nil {
+		writeError(writer, http.StatusInternalServerError, err.Error())
+		return
+	}
+	writeJSON(writer, http.StatusOK, map[string]interface{}{"intermediates": intermediates})
+}
+
+func (server *Server) inspectIntermediate(writer http.ResponseWriter, request *http.Request, intermediate *ca.Intermediate, _ route) {
+	info, err := server.config.Vault.InspectIntermediate(request.Context(), intermediate.ID)
+	if err != nil {
+		writeError(writer, http.StatusInternalServerError, err.Error())
+		return
+	}
+	writeJSON(writer, http.StatusOK, map[string]interface{}{
+		"intermediate": intermediate,
+		"certificate":  info,
+	})
+}
+
+func (server *Server) listCertificates(writer http.ResponseWriter, request *http.Request, intermediate *ca.Intermediate, _ route) {
+	entries, err := server.config.Vault.Certificates(request.Context(), intermediate.ID)
+	if err != nil {
+		writeError(writer, http.StatusInternalServerError, err.Error())
+		return
+	}
+	writeJSON(writer, http.StatusOK, map[string]interface{}{"certificates": entries})
+}
+
+func (server *Server) inspectCertificate(writer http.ResponseWriter, request *http.Request, intermediate *ca.Intermediate, current route) {
+	info, err := server.config.Vault.InspectCertificate(request.Context(), intermediate.ID, current.serial)
+	if err == ca.ErrUnknownCertificate {
+		writeError(writer, http.StatusNotFound, err.Error())
+		return
+	} else if err != nil {
+		writeError(writer, http.StatusInternalServerError, err.Error())
+		return
+	}
+	writeJSON(writer, http.StatusOK, info)
+}
+
+func (server *Server) fetchCRL(writer http.ResponseWriter, request *http.Request, intermediate *ca.Intermediate, _ route) {
+	crl, err := server.config.Vault.CRL(request.Context(), intermediate.ID)
+	if err != nil {
+		writeError(writer, http.StatusNotFound, "no CRL has been generated for this A1 yet")
+		return
+	}
+	writeJSON(writer, http.StatusOK, map[string]string{"crl": string(crl)})
+}
+
+func (server *Server) fetchChain(writer http.ResponseWriter, request *http.Request, intermediate *ca.Intermediate, _ route) {
+	dr := request.URL.Query().Get("dr") == "true"
+	chain, err := server.config.Vault.Chain(request.Context(), intermediate.ID, dr)
+	if err != nil {
+		writeError(writer, http.StatusNotFound, err.Error())
+		return
+	}
+	writeJSON(writer, http.StatusOK, map[string]string{"chain": string(chain)})
+}
+
+func (server *Server) issueCertificate(writer http.ResponseWriter, request *http.Request, intermediate *ca.Intermediate, _ route) {
+	var body ca.IssueOptions
+	if err := json.NewDecoder(request.Body).Decode(&body); err != nil {
+		writeError(writer, http.StatusBadRequest, err.Error())
+		return
+	}
+	issued, err := server.config.Vault.Issue(request.Context(), intermediate.ID, body)
+	if err != nil {
+		writeError(writer, failureStatus(err), err.Error())
+		return
+	}
+	writeJSON(writer, http.StatusCreated, issued)
+}
+
+func (server *Server) signCSR(writer http.ResponseWriter, request *http.Request, intermediate *ca.Intermediate, _ route) {
+	var body signRequest
+	if err := json.NewDecoder(request.Body).Decode(&body); err != nil {
+		writeError(writer, http.StatusBadRequest, err.Error())
+		return
+	}
+	issued, err := server.config.Vault.SignCSR(request.Context(), intermediate.ID, ca.SignOptions{
+		CSR:        []byte(body.CSR),
+		Profile:    body.Profile,
+		Days:       body.Days,
+		Passphrase: body.Passphrase,
+	})
+	if err != nil {
+		writeError(writer, failureStatus(err), err.Error())
+		return
+	}
+	writeJSON(writer, http.StatusCreated, issued)
+}
+
+func (server *Server) revokeCertificate(writer http.ResponseWriter, request *http.Request, intermediate *ca.Intermediate, current route) {
+	var body revokeRequest
+	if err := json.NewDecoder(request.Body).Decode(&body); err != nil {
+		writeError(writer, http.StatusBadRequest, err.Error())
+		return
+	}
+	err := server.config.Vault.Revoke(request.Context(), intermediate.ID, ca.RevokeOptions{
+		Serial:     current.serial,
+		Reason:     body.Reason,
+		Passphrase: body.Passphrase,
+		Comment:    body.Comment,
+		Actor:      request.TLS.PeerCertificates[0].Subject.String(),
+	})
+	if err == ca.ErrUnknownCertificate {
+		writeError(writer, http.StatusNotFound, err.Error())
+		return
+	} else if err != nil {
+		writeError(writer, failureStatus(err), err.Error())
+		return
+	}
+	status := "revoked"
+	if body.Reason == "certificateHold" {
+		status = "held"
+	}
+	writeJSON(writer, http.StatusOK, map[string]string{"serial": strings.ToUpper(current.serial), "status": status})
+}
+
+func (server *Server) unholdCertificate(writer http.ResponseWriter, request *http.Request, intermediate *ca.Intermediate, current route) {
+	var body revokeRequest
+	if err := json.NewDecoder(request.Body).Decode(&body); err != nil {
+		writeError(writer, http.StatusBadRequest, err.Error())
+		return
+	}
+	err := server.config.Vault.Unhold(request.Context(), intermediate.ID, ca.RevokeOptions{
+		Serial:     current.serial,
+		Passphrase: body.Passphrase,
+		Comment:    body.Comment,
+		Actor:      request.TLS.PeerCertificates[0].Subject.String(),
+	})
+	if err == ca.ErrUnknownCertificate {
+		writeError(writer, http.StatusNotFound, err.Error())
+		return
+	} else if err != nil {
+		writeError(writer, failureStatus(err), err.Error())
+		return
+	}
+	writeJSON(writer, http.StatusOK, map[string]string{"serial": strings.ToUpper(current.serial), "status": "valid"})
+}
+
+// failureStatus is the status of a failed operation, a vault locked by a privki command
+// is retried later
+func failureStatus(err error) int {
+	if _, locked := err.(*ca.VaultLockedError); locked || err == ca.ErrVaultSealed {
+		return http.StatusServiceUnavailable
+	}
+	return http.StatusUnprocessableEntity
+}
+
+func writeJSON(writer http.ResponseWriter, status int, body interface{}) {
+	writer.Header().Set("Content-Type", "application/json")
+	writer.WriteHeader(status)
+	if err := json.NewEncoder(writer).Encode(body); err != nil {
+		log.Warnf("unable to write response: %v", err)
+	}
+}
+
+func writeError(writer http.ResponseWriter, status int, message string) {
+	writeJSON(writer, status, map[string]string{"error": message})
+}
@@ -0,0 +1,112 @@
+package server
+
+import (
+	"crypto/x509"
+	"errors"
+	"github.com/spf13/viper"
+)
+
+// Operations that can be authorized through the policy file
+const (
+	OperationList    = "list"
+	OperationInspect = "inspect"
+	OperationIssue   = "issue"
+	OperationSign    = "sign"
+	OperationRevoke  = "revoke"
+	OperationCRL     = "crl"
+	OperationChain   = "chain"
+)
+
+// SubjectMatcher matches client certificate subjects, every field that is set must match
+type SubjectMatcher struct {
+	CommonName         string `mapstructure:"cn"`
+	Organization       string `mapstructure:"o"`
+	OrganizationalUnit string `mapstructure:"ou"`
+}
+
+// Rule grants a set of operations to the matching client certificates,
+// optionally limited to a set of Intermediary CA (A1) IDs.
+type Rule struct {
+	Operations    []string         `mapstructure:"operations"`
+	Intermediates []string         `mapstructure:"intermediates"`
+	Subjects      []SubjectMatcher `mapstructure:"subjects"`
+}
+
+// Policy is the ordered list of authorization rules loaded from the policy file
+type Policy struct {
+	Rules []Rule `mapstructure:"authorization"`
+}
+
+// LoadPolicy reads an authorization policy from a yaml or json file
+//
+// example>
+//
+//	authorization:
+//	  - operations: [list, inspect, crl, chain]
+//	    subjects:
+//	      - ou: platform
+//	  - operations: [issue, sign, revoke]
+//	    intermediates: [20200722174505Z]
+//	    subjects:
+//	      - cn: deploy-bot
+//	        ou: platform
+func LoadPolicy(policyFile string) (*Policy, error) {
+	policyReader := viper.New()
+	policyReader.SetConfigFile(policyFile)
+	if err := policyReader.ReadInConfig(); err != nil {
+		return nil, err
+	}
+	policy := new(Policy)
+	if err := policyReader.Unmarshal(policy); err != nil {
+		return nil, err
+	}
+	if len(policy.Rules) == 0 {
+		return nil, errors.New("policy file does not define any authorization rules")
+	}
+	return policy, nil
+}
+
+// Allowed reports whether the client certificate may perform operation on the given A1,
+// intermediateID is empty for operations that do not target a single A1. Those span every
+// A1, so only rules without an intermediates restriction grant them.
+func (policy *Policy) Allowed(operation string, intermediateID string, client *x509.Certificate) bool {
+	for _, rule := range policy.Rules {
+		if !contains(rule.Operations, operation) {
+			continue
+		}
+		if len(rule.Intermediates) > 0 && !contains(rule.Intermediates, intermediateID) {
+			continue
+		}
+		for _, matcher := range rule.Subjects {
+			if matcher.matches(client) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+func (matcher SubjectMatcher) matches(client *x509.Certificate) bool {
+	if matcher.CommonName == "" && matcher.Organization == "" && matcher.OrganizationalUnit == "" {
+		return false
+	}
+	if matcher.CommonName != "" && matcher.CommonName != client.Subject.CommonName {
+		return false
+	}
+	if matcher.Organization != "" && !contains(client.Subject.Organization, matcher.Organization) {
+		return false
+	}
+	if matcher.OrganizationalUnit != "" && !contains(client.Subject.OrganizationalUnit, matcher.OrganizationalUnit) {
+		return false
+	}
+	return true
+}
+
+func contains(values []string, value string) bool {
+	for _, candidate := range values {
+		if candidate == value {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,106 @@
+package server
+
+import (
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func clientCertificate(commonName string, organization string, unit string) *x509.Certificate {
+	return &x509.Certificate{Subject: pkix.Name{
+		CommonName:         commonName,
+		Organization:       []string{organization},
+		OrganizationalUnit: []string{unit},
+	}}
+}
+
+func TestPolicyAllowed(t *testing.T) {
+	policy := &Policy{Rules: []Rule{
+		{
+			Operations: []string{OperationList, OperationInspect},
+			Subjects:   []SubjectMatcher{{OrganizationalUnit: "platform"}},
+		},
+		{
+			Operations:    []string{OperationIssue},
+			Intermediates: []string{"20200722174505Z"},
+			Subjects:      []SubjectMatcher{{CommonName: "deploy-bot", OrganizationalUnit: "platform"}},
+		},
+		{
+			Operations: []string{OperationRevoke},
+			Subjects:   []SubjectMatcher{{}},
+		},
+		{
+			Operations:    []string{OperationList},
+			Intermediates: []string{"20200722174505Z"},
+			Subjects:      []SubjectMatcher{{OrganizationalUnit: "billing"}},
+		},
+	}}
+	deployBot := clientCertificate("deploy-bot", "sample", "platform")
+	reader := clientCertificate("dashboard", "sample", "platform")
+	outsider := clientCertificate("deploy-bot", "sample", "billing")
+
+	tests := []struct {
+		name           string
+		operation      string
+		intermediateID string
+		client         *x509.Certificate
+		allowed        bool
+	}{
+		{"unit granted list", OperationList, "", reader, true},
+		{"unit granted inspect of any A1", OperationInspect, "20200101000000Z", reader, true},
+		{"other unit denied list", OperationList, "", outsider, false},
+		{"issue on listed A1", OperationIssue, "20200722174505Z", deployBot, true},
+		{"issue on other A1 denied", OperationIssue, "20200101000000Z", deployBot, false},
+		{"issue by other common name denied", OperationIssue, "20200722174505Z", reader, false},
+		{"issue by other unit denied", OperationIssue, "20200722174505Z", outsider, false},
+		{"operation not in any rule denied", OperationSign, "20200722174505Z", deployBot, false},
+		{"empty subject matcher never matches", OperationRevoke, "20200722174505Z", deployBot, false},
+		{"scoped list on listed A1", OperationList, "20200722174505Z", outsider, true},
+		{"scoped list denied listing every A1", OperationList, "", outsider, false},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if allowed := policy.Allowed(test.operation, test.intermediateID, test.client); allowed != test.allowed {
+				t.Errorf("Allowed(%v, %q) = %v, want %v", test.operation, test.intermediateID, allowed, test.allowed)
+			}
+		})
+	}
+}
+
+func TestLoadPolicy(t *testing.T) {
+	dir, err := ioutil.TempDir("", "privki-policy")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	policyFile := filepath.Join(dir, "policy.yaml")
+	if err := ioutil.WriteFile(policyFile, []byte(`authorization:
+  - operations: [issue]
+    intermediates: [20200722174505Z]
+    subjects:
+      - cn: deploy-bot
+        o: sample
+        ou: platform
+`), 0600); err != nil {
+		t.Fatal(err)
+	}
+	policy, err := LoadPolicy(policyFile)
+	if err != nil {
+		t.Fatalf("LoadPolicy: %v", err)
+	}
+	if !policy.Allowed(OperationIssue, "20200722174505Z", clientCertificate("deploy-bot", "sample", "platform")) {
+		t.Error("the loaded rule does not grant issue to its subject")
+	}
+
+	emptyFile := filepath.Join(dir, "empty.yaml")
+	if err := ioutil.WriteFile(emptyFile, []byte("authorization: []\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := LoadPolicy(emptyFile); err == nil {
+		t.Error("LoadPolicy accepted a policy without rules")
+	}
+}
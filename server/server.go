@@ -0,0 +1,142 @@
+// Package server exposes vault operations as a JSON HTTP API.
+// Clients authenticate with certificates issued from the vault itself
+// and every operation is authorized against a subject based policy.
+package server
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	log "github.com/sirupsen/logrus"
+	"net"
+	"net/http"
+	"path/filepath"
+	"sfcert/openssl"
+	"sfcert/pkg/ca"
+)
+
+// Config holds everything needed to start the API server
+type Config struct {
+	Listen  string
+	TLSCert string
+	TLSKey  string
+	Vault   *ca.Vault
+	Policy  *Policy
+}
+
+// Server is the mTLS API server for a single PKI vault
+type Server struct {
+	config Config
+}
+
+// New creates an API server for the vault described by config
+func New(config Config) *Server {
+	return &Server{config: config}
+}
+
+// ListenAndServe serves the API until the listener fails or ctx is cancelled. The requests
+// in flight are cancelled with ctx, and waited for before it returns.
+func (server *Server) ListenAndServe(ctx context.Context) error {
+	serverCert, err := tls.LoadX509KeyPair(server.config.TLSCert, server.config.TLSKey)
+	if err != nil {
+		return fmt.Errorf("unable to load server certificate: %v", err)
+	}
+
+	httpServer := &http.Server{
+		Addr:        server.config.Listen,
+		Handler:     server.routes(),
+		TLSConfig:   server.tlsConfig(serverCert),
+		BaseContext: func(net.Listener) context.Context { return ctx },
+	}
+	stopped := make(chan struct{})
+	go func() {
+		<-ctx.Done()
+		httpServer.Shutdown(context.Background())
+		close(stopped)
+	}()
+	log.Printf("privki API listening on %v", server.config.Listen)
+	if err := httpServer.ListenAndServeTLS("", ""); err != http.ErrServerClosed {
+		return err
+	}
+	<-stopped
+	return nil
+}
+
+// tlsConfig requires a client certificate, verified against the vault by verifyClient
+func (server *Server) tlsConfig(serverCert tls.Certificate) *tls.Config {
+	return &tls.Config{
+		MinVersion:   tls.VersionTLS12,
+		Certificates: []tls.Certificate{serverCert},
+		// chain verification happens in verifyClient, so that
+		// A1s created while the server is running are trusted too.
+		ClientAuth:            tls.RequireAnyClientCert,
+		VerifyPeerCertificate: server.verifyClient,
+	}
+}
+
+// verifyClient checks that the client certificate chains up to the vault's
+// Root CA (or DR Root CA) through one of its A1s, and that it is not revoked.
+func (server *Server) verifyClient(rawCerts [][]byte, _ [][]*x509.Certificate) error {
+	if len(rawCerts) == 0 {
+		return errors.New("client certificate required")
+	}
+	client, err := x509.ParseCertificate(rawCerts[0])
+	if err != nil {
+		return err
+	}
+
+	roots := x509.NewCertPool()
+	intermediatePool := x509.NewCertPool()
+	rootCerts, err := server.config.Vault.Roots()
+	if err != nil {
+		return err
+	}
+	for _, rootCert := range rootCerts {
+		roots.AddCert(rootCert)
+	}
+	for _, raw := range rawCerts[1:] {
+		if cert, err := x509.ParseCertificate(raw); err == nil {
+			intermediatePool.AddCert(cert)
+		}
+	}
+
+	intermediates, err := server.config.Vault.Intermediates(context.Background())
+	if err != nil {
+		return err
+	}
+	var issuer *ca.Intermediate
+	for i := range intermediates {
+		for _, certName := range []string{"intermed-ca.cert.pem", "intermed-ca.dr.cert.pem"} {
+			cert, err := openssl.ReadCertificate(filepath.Join(intermediates[i].Dir, certName))
+			if err != nil {
+				continue
+			}
+			intermediatePool.AddCert(cert)
+			if bytes.Equal(cert.SubjectKeyId, client.AuthorityKeyId) {
+				issuer = &intermediates[i]
+			}
+		}
+	}
+
+	if _, err := client.Verify(x509.VerifyOptions{
+		Roots:         roots,
+		Intermediates: intermediatePool,
+		KeyUsages:     []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+	}); err != nil {
+		return err
+	}
+	if issuer == nil {
+		return errors.New("client certificate was not issued by an A1 of this vault")
+	}
+	entry, err := openssl.FindIndexEntry(issuer.Dir, openssl.SerialHex(client.SerialNumber))
+	if err != nil {
+		return err
+	}
+	if entry.Status != "V" {
+		return errors.New("client certificate is not valid in the vault database")
+	}
+	return nil
+}
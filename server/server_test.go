@@ -0,0 +1,198 @@
+package server
+
+import (
+	"bytes"
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/json"
+	"encoding/pem"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"sfcert/internal/vaulttest"
+	"sfcert/pkg/ca"
+	"testing"
+	"time"
+)
+
+// apiTest is an API server for a throwaway vault with a single A1
+type apiTest struct {
+	vault        *ca.Vault
+	intermediate *ca.Intermediate
+	server       *httptest.Server
+	roots        *x509.CertPool
+}
+
+func newAPITest(t *testing.T) *apiTest {
+	vault, intermediate := vaulttest.New(t)
+	issued, err := vault.Issue(context.Background(), intermediate.ID, ca.IssueOptions{
+		IssueRequest: ca.IssueRequest{
+			CommonName:  "localhost",
+			DNSNames:    []string{"localhost"},
+			IPAddresses: []string{"127.0.0.1"},
+			Profile:     "server",
+		},
+		Passphrase: vaulttest.A1Passphrase,
+	})
+	if err != nil {
+		t.Fatalf("unable to issue the server certificate: %v", err)
+	}
+	serverCert, err := tls.X509KeyPair([]byte(issued.CertificatePEM), []byte(issued.PrivateKeyPEM))
+	if err != nil {
+		t.Fatal(err)
+	}
+	chain, err := vault.Chain(context.Background(), intermediate.ID, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	roots := x509.NewCertPool()
+	roots.AppendCertsFromPEM(chain)
+
+	apiServer := New(Config{
+		Vault: vault,
+		Policy: &Policy{Rules: []Rule{{
+			Operations:    []string{OperationIssue},
+			Intermediates: []string{intermediate.ID},
+			Subjects:      []SubjectMatcher{{CommonName: "deploy-bot", OrganizationalUnit: "platform"}},
+		}}},
+	})
+	server := httptest.NewUnstartedServer(apiServer.routes())
+	server.TLS = apiServer.tlsConfig(serverCert)
+	server.StartTLS()
+	t.Cleanup(server.Close)
+	return &apiTest{vault: vault, intermediate: intermediate, server: server, roots: roots}
+}
+
+// clientCertificate issues a client certificate from the A1 of the vault
+func (api *apiTest) clientCertificate(t *testing.T, commonName string, unit string) (tls.Certificate, string) {
+	issued, err := api.vault.Issue(context.Background(), api.intermediate.ID, ca.IssueOptions{
+		IssueRequest: ca.IssueRequest{
+			CommonName:         commonName,
+			OrganizationalUnit: unit,
+			Profile:            "client",
+		},
+		Passphrase: vaulttest.A1Passphrase,
+	})
+	if err != nil {
+		t.Fatalf("unable to issue the client certificate: %v", err)
+	}
+	cert, err := tls.X509KeyPair([]byte(issued.CertificatePEM), []byte(issued.PrivateKeyPEM))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return cert, issued.Serial
+}
+
+// issue requests a client certificate through the API, presenting clientCerts
+func (api *apiTest) issue(clientCerts ...tls.Certificate) (*http.Response, error) {
+	client := &http.Client{Transport: &http.Transport{TLSClientConfig: &tls.Config{
+		RootCAs:      api.roots,
+		Certificates: clientCerts,
+	}}}
+	body, _ := json.Marshal(ca.IssueOptions{
+		IssueRequest: ca.IssueRequest{CommonName: "service.cluster.internal", Profile: "client"},
+		Passphrase:   vaulttest.A1Passphrase,
+	})
+	return client.Post(api.server.URL+"/v1/intermediates/"+api.intermediate.ID+"/certificates", "application/json", bytes.NewReader(body))
+}
+
+// foreignCertificate is a self signed client certificate unknown to the vault
+func foreignCertificate(t *testing.T) tls.Certificate {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: "deploy-bot", OrganizationalUnit: []string{"platform"}},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return tls.Certificate{Certificate: [][]byte{der}, PrivateKey: key}
+}
+
+func TestAPIAuthorization(t *testing.T) {
+	api := newAPITest(t)
+
+	t.Run("client without certificate rejected", func(t *testing.T) {
+		if response, err := api.issue(); err == nil {
+			response.Body.Close()
+			t.Fatalf("request without a client certificate answered %v", response.Status)
+		}
+	})
+
+	t.Run("client certificate from another CA rejected", func(t *testing.T) {
+		if response, err := api.issue(foreignCertificate(t)); err == nil {
+			response.Body.Close()
+			t.Fatalf("request with a foreign client certificate answered %v", response.Status)
+		}
+	})
+
+	t.Run("revoked client certificate rejected", func(t *testing.T) {
+		revoked, serial := api.clientCertificate(t, "deploy-bot", "platform")
+		if err := api.vault.Revoke(context.Background(), api.intermediate.ID, ca.RevokeOptions{
+			Serial:     serial,
+			Reason:     "keyCompromise",
+			Passphrase: vaulttest.A1Passphrase,
+		}); err != nil {
+			t.Fatal(err)
+		}
+		if response, err := api.issue(revoked); err == nil {
+			response.Body.Close()
+			t.Fatalf("request with a revoked client certificate answered %v", response.Status)
+		}
+	})
+
+	t.Run("client outside policy denied", func(t *testing.T) {
+		outsider, _ := api.clientCertificate(t, "deploy-bot", "billing")
+		response, err := api.issue(outsider)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer response.Body.Close()
+		if response.StatusCode != http.StatusForbidden {
+			t.Fatalf("client outside the policy answered %v, want %v", response.Status, http.StatusForbidden)
+		}
+	})
+
+	t.Run("allowed issuance succeeds", func(t *testing.T) {
+		deployBot, _ := api.clientCertificate(t, "deploy-bot", "platform")
+		response, err := api.issue(deployBot)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer response.Body.Close()
+		if response.StatusCode != http.StatusCreated {
+			t.Fatalf("allowed issuance answered %v, want %v", response.Status, http.StatusCreated)
+		}
+		var issued ca.IssuedCertificate
+		if err := json.NewDecoder(response.Body).Decode(&issued); err != nil {
+			t.Fatal(err)
+		}
+		block, _ := pem.Decode([]byte(issued.CertificatePEM))
+		if block == nil {
+			t.Fatal("the response does not hold a PEM certificate")
+		}
+		cert, err := x509.ParseCertificate(block.Bytes)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if cert.Subject.CommonName != "service.cluster.internal" {
+			t.Errorf("issued certificate common name is %q", cert.Subject.CommonName)
+		}
+		if issued.PrivateKeyPEM == "" {
+			t.Error("the response does not hold the private key")
+		}
+	})
+}
@@ -0,0 +1,36 @@
+package shell
+
+import (
+	"encoding/hex"
+	"regexp"
+	"strconv"
+)
+
+// secretPattern matches the placeholders Secret puts in a command
+var secretPattern = regexp.MustCompile(`PRIVKI_SECRET\{([0-9a-f]*)\}`)
+
+// Secret returns a placeholder for value, for openssl -passin env:<placeholder>. Execute
+// replaces it with the name of an environment variable holding value, set for that single
+// command. The value never shows on a command line, in ps or /proc/<pid>/cmdline, and
+// nothing is kept once the command is done.
+func Secret(value string) string {
+	return "PRIVKI_SECRET{" + hex.EncodeToString([]byte(value)) + "}"
+}
+
+// secretEnv replaces the secret placeholders of execCmd with environment variable names,
+// and returns the command with the environment entries holding the secrets
+func secretEnv(execCmd string) (string, []string) {
+	var env []string
+	names := map[string]string{}
+	execCmd = secretPattern.ReplaceAllStringFunc(execCmd, func(placeholder string) string {
+		if name, found := names[placeholder]; found {
+			return name
+		}
+		value, _ := hex.DecodeString(secretPattern.FindStringSubmatch(placeholder)[1])
+		name := "PRIVKI_SECRET_" + strconv.Itoa(len(names)+1)
+		names[placeholder] = name
+		env = append(env, name+"="+string(value))
+		return name
+	})
+	return execCmd, env
+}
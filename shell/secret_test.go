@@ -0,0 +1,47 @@
+package shell
+
+import (
+	"os"
+	"strings"
+	"testing"
+)
+
+func TestSecretEnv(t *testing.T) {
+	root, a1 := `r00t "pass" $HOME`, "a1 pass;phrase"
+	execCmd, env := secretEnv("openssl ca -passin env:" + Secret(root) + " -key " + Secret(a1) + " && openssl pkey -passin env:" + Secret(root))
+
+	if want := "openssl ca -passin env:PRIVKI_SECRET_1 -key PRIVKI_SECRET_2 && openssl pkey -passin env:PRIVKI_SECRET_1"; execCmd != want {
+		t.Errorf("secretEnv returned command %q, want %q", execCmd, want)
+	}
+	for _, secret := range []string{root, a1} {
+		if strings.Contains(execCmd, secret) {
+			t.Errorf("the command %q holds the secret %q", execCmd, secret)
+		}
+	}
+	if len(env) != 2 || env[0] != "PRIVKI_SECRET_1="+root || env[1] != "PRIVKI_SECRET_2="+a1 {
+		t.Errorf("secretEnv returned environment %q", env)
+	}
+
+	if execCmd, env := secretEnv("openssl version"); execCmd != "openssl version" || env != nil {
+		t.Errorf("a command without secrets became %q with environment %q", execCmd, env)
+	}
+}
+
+func TestExecuteSecret(t *testing.T) {
+	if _, err := os.Stat("/proc/self/cmdline"); err != nil {
+		t.Skip("no /proc on this host")
+	}
+	secret := `s3cret 'quoted' $PATH`
+	// the shell prints its own command line, then the secret it reads from its environment
+	output := Execute(`tr '\0' ' ' < /proc/$$/cmdline; printf '\n%s' "$`+Secret(secret)+`"`, true, true)
+	if output.CmdError != nil {
+		t.Fatal(output.CmdError)
+	}
+	lines := strings.SplitN(output.Stdout, "\n", 2)
+	if len(lines) != 2 || lines[1] != secret {
+		t.Fatalf("the command read %q from its environment, want %q", output.Stdout, secret)
+	}
+	if strings.Contains(lines[0], "s3cret") || strings.Contains(lines[0], "PRIVKI_SECRET{") {
+		t.Errorf("the command line %q shows the secret", lines[0])
+	}
+}
@@ -1,24 +1,36 @@
 package shell
 
 import (
-	"os/exec"
+	"context"
+	"fmt"
 	"github.com/go-cmd/cmd"
+	"os"
+	"os/exec"
+	"strings"
 )
 
 type ShellOutput struct {
 	CmdError error
-	Stdout string
-	Stderr string
+	Stdout   string
+	Stderr   string
 }
 
-
 func Execute(execCmd string, stdoutTrue bool, stderrTrue bool) *ShellOutput {
+	return ExecuteContext(context.Background(), execCmd)
+}
+
+// ExecuteContext runs execCmd like Execute, stopping the command if ctx is cancelled
+func ExecuteContext(ctx context.Context, execCmd string) *ShellOutput {
 	sOut := new(ShellOutput)
-	sOut.CmdError,sOut.Stdout,sOut.Stderr = ShellExecWithChannels(execCmd,stdoutTrue,stderrTrue)
+	sOut.CmdError, sOut.Stdout, sOut.Stderr = shellExec(ctx, execCmd)
 	return sOut
 }
 
 func ShellExecWithChannels(execCmd string, stdoutSync bool, stderrSync bool) (error, string, string) {
+	return shellExec(context.Background(), execCmd)
+}
+
+func shellExec(ctx context.Context, execCmd string) (error, string, string) {
 	var preferredShell string
 	_, shellSearch := exec.LookPath("bash")
 	if shellSearch != nil {
@@ -27,35 +39,40 @@ func ShellExecWithChannels(execCmd string, stdoutSync bool, stderrSync bool) (er
 		preferredShell = "bash"
 	}
 
-	var stdout string
-	var stderr string
-
+	execCmd, env := secretEnv(execCmd)
 	shellCmd := cmd.NewCmd(preferredShell, "-c", execCmd)
+	if env != nil {
+		shellCmd.Env = append(os.Environ(), env...)
+	}
 	statusChan := shellCmd.Start()
 
-
+	// Block waiting for command to exit, be stopped, or be killed
+	var finalStatus cmd.Status
 	select {
-	case finalStatus := <-statusChan:
-		// done
-		if finalStatus.Complete {
-			status := shellCmd.Status()
-			stdoutLen := len(status.Stdout)
-			for l := 0; l < stdoutLen; l++ { stdout += status.Stdout[l] }
-			stderrLen := len(status.Stderr)
-			for l := 0; l < stderrLen; l++ { stderr += status.Stderr[l] }
-		}
-	default:
-		// no, still running
+	case finalStatus = <-statusChan:
+	case <-ctx.Done():
+		shellCmd.Stop()
+		<-statusChan
+		return ctx.Err(), "", ""
 	}
 
-	// Block waiting for command to exit, be stopped, or be killed
-	finalStatus := <-statusChan
-	if finalStatus.Exit == 0 {
-		return nil , stdout, stderr
-	} else {
-		return finalStatus.Error , stdout, stderr
+	stdout := strings.Join(finalStatus.Stdout, "\n")
+	stderr := strings.Join(finalStatus.Stderr, "\n")
+	if finalStatus.Error != nil {
+		return finalStatus.Error, stdout, stderr
 	}
-
+	if finalStatus.Exit != 0 {
+		// openssl and friends report their failures through the exit status,
+		// so a non zero exit is an error even when the shell itself started fine.
+		if stderr != "" {
+			return fmt.Errorf("exit status %d: %s", finalStatus.Exit, lastLine(stderr)), stdout, stderr
+		}
+		return fmt.Errorf("exit status %d", finalStatus.Exit), stdout, stderr
+	}
+	return nil, stdout, stderr
 }
 
-
+func lastLine(output string) string {
+	lines := strings.Split(strings.TrimSpace(output), "\n")
+	return lines[len(lines)-1]
+}
@@ -0,0 +1,20 @@
+package shell
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func TestExecuteContextCancel(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	time.AfterFunc(100*time.Millisecond, cancel)
+	started := time.Now()
+	output := ExecuteContext(ctx, "sleep 10")
+	if output.CmdError != context.Canceled {
+		t.Fatalf("cancelled command returned %v, want context.Canceled", output.CmdError)
+	}
+	if elapsed := time.Since(started); elapsed > 5*time.Second {
+		t.Errorf("cancelled command ran for %v", elapsed)
+	}
+}